@@ -0,0 +1,622 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+	"github.com/oneclickvirt/pingtest/pt"
+)
+
+// API run states. A run waits in queued until a concurrency slot frees up;
+// failed means at least one step could not run.
+const (
+	apiRunQueued    = "queued"
+	apiRunRunning   = "running"
+	apiRunSucceeded = "succeeded"
+	apiRunFailed    = "failed"
+	apiRunCanceled  = "canceled"
+)
+
+// API event types, used as the SSE event name.
+const (
+	apiEventQueued       = "run_queued"
+	apiEventStarted      = "run_started"
+	apiEventStepStarted  = "step_started"
+	apiEventResult       = "result"
+	apiEventStepFinished = "step_finished"
+	apiEventFinished     = "run_finished"
+)
+
+// apiTokenEnv supplies the bearer token when -token is not given, so it
+// does not have to appear in the process list.
+const apiTokenEnv = "PINGTEST_API_TOKEN"
+
+// apiRequestLimit caps the body of POST /runs.
+const apiRequestLimit = 1 << 20
+
+// apiRunRequest is the body of POST /runs. The probe settings of a plan step
+// sit at the top level and apply to every mode in modes; steps gives full
+// per-step control like a plan file instead. Targets is an inline custom
+// target list in the -targets file format, since the API does not read
+// files on the server.
+type apiRunRequest struct {
+	model.PlanStep
+	Name     string           `json:"name,omitempty"`
+	Language string           `json:"language,omitempty"`
+	Modes    []string         `json:"modes,omitempty"`
+	Targets  json.RawMessage  `json:"targets,omitempty"`
+	Steps    []model.PlanStep `json:"steps,omitempty"`
+}
+
+// apiEvent is one progress event of a run. IDs count from 1 per run and are
+// the SSE event IDs, so a client can resume with Last-Event-ID.
+type apiEvent struct {
+	ID     int       `json:"id"`
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Step   int       `json:"step,omitempty"`
+	Mode   string    `json:"mode,omitempty"`
+	Status string    `json:"status,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Result any       `json:"result,omitempty"`
+}
+
+// apiRun is one run submitted through the API. Every field below mu is
+// guarded by it; changed is closed and replaced whenever an event is added,
+// waking the event streams.
+type apiRun struct {
+	id      string
+	name    string
+	steps   []stepConfig
+	created time.Time
+	cancel  context.CancelFunc
+
+	mu       sync.Mutex
+	status   string
+	started  time.Time
+	finished time.Time
+	errors   []string
+	result   *pt.ResultEnvelope
+	events   []apiEvent
+	changed  chan struct{}
+}
+
+// apiRunView is the JSON form of a run. Result grows as steps finish.
+type apiRunView struct {
+	ID         string             `json:"id"`
+	Name       string             `json:"name,omitempty"`
+	Status     string             `json:"status"`
+	Modes      []string           `json:"modes"`
+	CreatedAt  time.Time          `json:"created_at"`
+	StartedAt  *time.Time         `json:"started_at,omitempty"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty"`
+	Errors     []string           `json:"errors,omitempty"`
+	Result     *pt.ResultEnvelope `json:"result,omitempty"`
+}
+
+// apiServer implements "pingtest api". Runs execute on the server's context
+// so they outlive the request that created them; at most cap(slots) run at
+// once and at most maxQueued wait for a slot.
+type apiServer struct {
+	ctx       context.Context
+	runner    commandRunner
+	base      stepConfig
+	token     string
+	slots     chan struct{}
+	maxQueued int
+	keep      int
+	handler   http.Handler
+	wg        sync.WaitGroup
+
+	mu    sync.Mutex
+	runs  map[string]*apiRun
+	order []string
+}
+
+// runAPI implements "api": a REST server that runs plans on request and
+// streams their progress until interrupted.
+func runAPI(ctx context.Context, output io.Writer, args []string, runner commandRunner) int {
+	var listen, token string
+	var maxRuns, maxQueued, keep int
+	apiFlag := flag.NewFlagSet("pingtest api", flag.ContinueOnError)
+	apiFlag.SetOutput(output)
+	apiFlag.StringVar(&listen, "listen", "127.0.0.1:9117", "监听地址，如 :9117 表示所有网卡")
+	apiFlag.StringVar(&token, "token", "", "要求请求携带 Authorization: Bearer TOKEN（默认读取环境变量 "+apiTokenEnv+"，为空则不鉴权）")
+	apiFlag.IntVar(&maxRuns, "max-runs", 2, "同时执行的最大运行数")
+	apiFlag.IntVar(&maxQueued, "max-queued", 16, "排队等待的最大运行数，超出时返回 429")
+	apiFlag.IntVar(&keep, "keep", 100, "内存中保留的已结束运行数")
+	apiFlag.BoolVar(&model.EnableLoger, "log", false, "启用日志记录")
+	var limits rateFlags
+	var cache cacheFlags
+	limits.register(apiFlag)
+	cache.register(apiFlag)
+	if _, err := parseWithPositionals(apiFlag, args); err != nil {
+		return exitCannotRun
+	}
+	limiter, err := limits.limiter()
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", err)
+		return exitCannotRun
+	}
+	diskCache, err := cache.newCache()
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", err)
+		return exitCannotRun
+	}
+	runner = runner.with(runClient(limiter, diskCache))
+	if token == "" {
+		token = os.Getenv(apiTokenEnv)
+	}
+	if maxRuns < 1 || maxQueued < 0 || keep < 0 {
+		fmt.Fprintln(output, "错误: -max-runs 必须大于 0，-max-queued 与 -keep 不能为负数")
+		return exitCannotRun
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+		return exitCannotRun
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := newAPIServer(ctx, runner, token, maxRuns, maxQueued, keep)
+	auth := "未启用鉴权"
+	if token != "" {
+		auth = "需要 Bearer 令牌"
+	}
+	fmt.Fprintf(output, "API 服务已启动: http://%s/runs（最多同时 %d 个运行，%s）\n", listener.Addr(), maxRuns, auth)
+	httpServer := &http.Server{Handler: server, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdownCtx)
+	}()
+	if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+		return exitCannotRun
+	}
+	server.wg.Wait()
+	return 0
+}
+
+func newAPIServer(ctx context.Context, runner commandRunner, token string, maxRuns, maxQueued, keep int) *apiServer {
+	server := &apiServer{
+		ctx: ctx, runner: runner, base: defaultStepConfig(""), token: token,
+		slots: make(chan struct{}, maxRuns), maxQueued: maxQueued, keep: keep, runs: map[string]*apiRun{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /runs", server.createRun)
+	mux.HandleFunc("GET /runs", server.listRuns)
+	mux.HandleFunc("GET /runs/{id}", server.getRun)
+	mux.HandleFunc("DELETE /runs/{id}", server.cancelRun)
+	mux.HandleFunc("GET /runs/{id}/events", server.streamEvents)
+	server.handler = mux
+	return server
+}
+
+func (server *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if server.token != "" {
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(server.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="pingtest"`)
+			writeAPIError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+	}
+	server.handler.ServeHTTP(w, r)
+}
+
+// plan turns the request into a plan. china and global in modes expand to
+// their built-in steps. The shared target and ports only reach tcp steps.
+func (request apiRunRequest) plan() (model.Plan, error) {
+	plan := model.Plan{Name: request.Name, Language: request.Language, Steps: request.Steps}
+	modes := request.Modes
+	if request.Mode != "" {
+		modes = append([]string{request.Mode}, modes...)
+	}
+	if len(plan.Steps) > 0 && len(modes) > 0 {
+		return model.Plan{}, errors.New("modes 与 steps 不能同时使用")
+	}
+	for _, mode := range modes {
+		expanded := []string{mode}
+		if builtin, ok := builtinPlans[strings.ToLower(strings.TrimSpace(mode))]; ok {
+			expanded = expanded[:0]
+			for _, step := range builtin.Steps {
+				expanded = append(expanded, step.Mode)
+			}
+		}
+		for _, mode := range expanded {
+			step := request.PlanStep
+			step.Mode = mode
+			if strings.ToLower(strings.TrimSpace(mode)) != model.ModeTCP {
+				step.Target, step.Ports = "", ""
+			}
+			plan.Steps = append(plan.Steps, step)
+		}
+	}
+	for index, step := range plan.Steps {
+		if step.Targets != "" || len(step.Output) > 0 {
+			return model.Plan{}, fmt.Errorf("步骤 %d: API 不读取目标文件也不写输出文件，请改用内联 targets", index+1)
+		}
+	}
+	return plan, nil
+}
+
+// resolve validates the request against the server defaults. Results are
+// always structured, so the format knob is ignored.
+func (server *apiServer) resolve(request apiRunRequest) ([]stepConfig, error) {
+	plan, err := request.plan()
+	if err != nil {
+		return nil, err
+	}
+	base := server.base
+	if len(bytes.TrimSpace(request.Targets)) > 0 {
+		targets, err := model.DecodeCustomTargets(request.Targets, model.CustomTargetFormatJSON)
+		if err != nil {
+			return nil, err
+		}
+		base.TargetsFile, base.CustomTargets = "targets", targets
+	}
+	steps, err := resolvePlan(base, plan)
+	if err != nil {
+		return nil, err
+	}
+	for index := range steps {
+		steps[index].Format, steps[index].Output = model.FormatJSON, nil
+	}
+	return steps, nil
+}
+
+func (server *apiServer) createRun(w http.ResponseWriter, r *http.Request) {
+	var request apiRunRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiRequestLimit))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "decode request: "+err.Error())
+		return
+	}
+	steps, err := server.resolve(request)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, sanitizeErrorText(err.Error()))
+		return
+	}
+	id, err := newAPIRunID()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx, cancel := context.WithCancel(server.ctx)
+	run := &apiRun{id: id, name: request.Name, steps: steps, created: time.Now(), cancel: cancel, status: apiRunQueued, changed: make(chan struct{})}
+	server.mu.Lock()
+	queued := 0
+	for _, other := range server.runs {
+		if other.currentStatus() == apiRunQueued {
+			queued++
+		}
+	}
+	if queued >= server.maxQueued && len(server.slots) == cap(server.slots) {
+		server.mu.Unlock()
+		cancel()
+		writeAPIError(w, http.StatusTooManyRequests, "too many queued runs")
+		return
+	}
+	server.runs[id] = run
+	server.order = append(server.order, id)
+	server.mu.Unlock()
+	run.emit(apiEvent{Type: apiEventQueued, Status: apiRunQueued})
+	server.wg.Add(1)
+	go server.execute(ctx, run)
+	w.Header().Set("Location", "/runs/"+id)
+	writeAPIRun(w, http.StatusAccepted, run)
+}
+
+func (server *apiServer) listRuns(w http.ResponseWriter, _ *http.Request) {
+	server.mu.Lock()
+	runs := make([]*apiRun, 0, len(server.order))
+	for index := len(server.order) - 1; index >= 0; index-- {
+		runs = append(runs, server.runs[server.order[index]])
+	}
+	server.mu.Unlock()
+	views := make([]apiRunView, 0, len(runs))
+	for _, run := range runs {
+		view := run.view()
+		view.Result = nil
+		views = append(views, view)
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]any{"runs": views})
+}
+
+func (server *apiServer) getRun(w http.ResponseWriter, r *http.Request) {
+	if run := server.lookup(w, r); run != nil {
+		writeAPIRun(w, http.StatusOK, run)
+	}
+}
+
+// cancelRun cancels a queued or running run. Runners stop at their next
+// context check; the run reports canceled once its current step returns.
+func (server *apiServer) cancelRun(w http.ResponseWriter, r *http.Request) {
+	run := server.lookup(w, r)
+	if run == nil {
+		return
+	}
+	if status := run.currentStatus(); status != apiRunQueued && status != apiRunRunning {
+		writeAPIError(w, http.StatusConflict, "run already "+status)
+		return
+	}
+	run.cancel()
+	writeAPIRun(w, http.StatusAccepted, run)
+}
+
+// streamEvents sends the run's events as Server-Sent Events, starting after
+// Last-Event-ID when the client resumes, and ends after run_finished.
+func (server *apiServer) streamEvents(w http.ResponseWriter, r *http.Request) {
+	run := server.lookup(w, r)
+	if run == nil {
+		return
+	}
+	next := 0
+	if last, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil && last > 0 {
+		next = last
+	}
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		events, changed, done := run.eventsSince(next)
+		for _, event := range events {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+				return
+			}
+			next = event.ID
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+		if done {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (server *apiServer) lookup(w http.ResponseWriter, r *http.Request) *apiRun {
+	server.mu.Lock()
+	run := server.runs[r.PathValue("id")]
+	server.mu.Unlock()
+	if run == nil {
+		writeAPIError(w, http.StatusNotFound, "run not found")
+	}
+	return run
+}
+
+// execute waits for a slot and runs every step through the structured
+// runners, recording each result as an event and in the run's envelope.
+func (server *apiServer) execute(ctx context.Context, run *apiRun) {
+	defer server.wg.Done()
+	defer run.cancel()
+	select {
+	case server.slots <- struct{}{}:
+		defer func() { <-server.slots }()
+	case <-ctx.Done():
+		run.finish(apiRunCanceled)
+		server.prune()
+		return
+	}
+	run.start(run.steps[0].runConfig(server.runner.rateLimiter()))
+	for index, step := range run.steps {
+		if ctx.Err() != nil {
+			break
+		}
+		number := index + 1
+		run.emit(apiEvent{Type: apiEventStepStarted, Step: number, Mode: step.Mode})
+		section, err := runStructuredStep(ctx, step, server.runner, func(result any) {
+			run.emit(apiEvent{Type: apiEventResult, Step: number, Mode: step.Mode, Result: result})
+		})
+		if err != nil {
+			message := fmt.Sprintf("步骤 %d: %s", number, sanitizeErrorText(err.Error()))
+			run.fail(message)
+			run.emit(apiEvent{Type: apiEventStepFinished, Step: number, Mode: step.Mode, Error: message})
+			continue
+		}
+		run.add(section)
+		run.emit(apiEvent{Type: apiEventStepFinished, Step: number, Mode: step.Mode})
+	}
+	status := apiRunSucceeded
+	switch {
+	case ctx.Err() != nil:
+		status = apiRunCanceled
+	case run.failed():
+		status = apiRunFailed
+	}
+	run.finish(status)
+	server.prune()
+}
+
+// prune forgets the oldest finished runs beyond the keep limit. Queued and
+// running runs are never dropped.
+func (server *apiServer) prune() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	finished := 0
+	for _, id := range server.order {
+		if server.runs[id].isDone() {
+			finished++
+		}
+	}
+	kept := server.order[:0]
+	for _, id := range server.order {
+		if finished > server.keep && server.runs[id].isDone() {
+			delete(server.runs, id)
+			finished--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	server.order = kept
+}
+
+func (run *apiRun) emit(event apiEvent) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.emitLocked(event)
+}
+
+func (run *apiRun) emitLocked(event apiEvent) {
+	event.ID = len(run.events) + 1
+	event.Time = time.Now()
+	run.events = append(run.events, event)
+	close(run.changed)
+	run.changed = make(chan struct{})
+}
+
+// eventsSince returns the events after ID after, a channel closed by the
+// next event, and whether the run has finished so no event will follow.
+func (run *apiRun) eventsSince(after int) ([]apiEvent, <-chan struct{}, bool) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	var events []apiEvent
+	if after < len(run.events) {
+		events = append(events, run.events[after:]...)
+	}
+	return events, run.changed, run.doneLocked()
+}
+
+func (run *apiRun) start(config pt.RunConfig) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.status, run.started = apiRunRunning, time.Now()
+	run.result = pt.NewResultEnvelope(config, run.started)
+	run.emitLocked(apiEvent{Type: apiEventStarted, Status: apiRunRunning})
+}
+
+func (run *apiRun) add(section pt.ReportSection) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.result.Add(section)
+}
+
+func (run *apiRun) fail(message string) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.errors = append(run.errors, message)
+}
+
+func (run *apiRun) failed() bool {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	return len(run.errors) > 0
+}
+
+func (run *apiRun) finish(status string) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.status, run.finished = status, time.Now()
+	if run.result != nil {
+		run.result.FinishedAt = run.finished
+	}
+	run.emitLocked(apiEvent{Type: apiEventFinished, Status: status})
+}
+
+func (run *apiRun) currentStatus() string {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	return run.status
+}
+
+func (run *apiRun) isDone() bool {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	return run.doneLocked()
+}
+
+func (run *apiRun) doneLocked() bool {
+	return run.status != apiRunQueued && run.status != apiRunRunning
+}
+
+// view snapshots the run. The envelope is copied so encoding it does not
+// race with steps still being added.
+func (run *apiRun) view() apiRunView {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	view := apiRunView{ID: run.id, Name: run.name, Status: run.status, CreatedAt: run.created, Errors: append([]string(nil), run.errors...)}
+	for _, step := range run.steps {
+		view.Modes = append(view.Modes, step.Mode)
+	}
+	if !run.started.IsZero() {
+		started := run.started
+		view.StartedAt = &started
+	}
+	if !run.finished.IsZero() {
+		finished := run.finished
+		view.FinishedAt = &finished
+	}
+	if run.result != nil {
+		result := *run.result
+		result.Config.Modes = append([]string{}, result.Config.Modes...)
+		result.Registries = append([]pt.RegistryInfo{}, result.Registries...)
+		result.Results = pt.EnvelopeResults{
+			Ori: append([]pt.LatencyResult(nil), result.Results.Ori...), TGDC: append([]pt.LatencyResult(nil), result.Results.TGDC...),
+			MTProto: append([]pt.TelegramMTProtoResult(nil), result.Results.MTProto...),
+			Web:     append([]pt.LatencyResult(nil), result.Results.Web...), TCP: append([]pt.TCPResult(nil), result.Results.TCP...),
+		}
+		view.Result = &result
+	}
+	return view
+}
+
+func newAPIRunID() (string, error) {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]), nil
+}
+
+func writeAPIRun(w http.ResponseWriter, status int, run *apiRun) {
+	writeAPIJSON(w, status, run.view())
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIJSON(w, status, map[string]string{"error": message})
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(value)
+}
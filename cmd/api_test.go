@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+	"github.com/oneclickvirt/pingtest/pt"
+)
+
+func apiRequest(t *testing.T, server *httptest.Server, method, path, token, body string) (*http.Response, map[string]any) {
+	t.Helper()
+	request, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	decoded := map[string]any{}
+	if err := json.NewDecoder(response.Body).Decode(&decoded); err != nil {
+		t.Fatalf("%s %s: decode response: %v", method, path, err)
+	}
+	return response, decoded
+}
+
+// readAPIEvents reads an SSE stream to its end and returns the event names.
+func readAPIEvents(t *testing.T, server *httptest.Server, id string) []string {
+	t.Helper()
+	response, err := server.Client().Get(server.URL + "/runs/" + id + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("events content type = %q", response.Header.Get("Content-Type"))
+	}
+	var names []string
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		if name, found := strings.CutPrefix(scanner.Text(), "event: "); found {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func TestAPIServerRunsPlansAndStreamsEvents(t *testing.T) {
+	runner, calls := offlineRunner()
+	runner.tcpTargets = func(_ context.Context, config pt.TCPProbeConfig, targets []model.TCPTarget) []pt.TCPResult {
+		*calls = append(*calls, "tcp-targets")
+		results := make([]pt.TCPResult, 0, len(targets))
+		for _, target := range targets {
+			result := pt.TCPResult{Target: target, Attempts: config.Attempts, Successful: config.Attempts}
+			config.OnResult(result)
+			results = append(results, result)
+		}
+		return results
+	}
+	api := newAPIServer(context.Background(), runner, "", 1, 4, 10)
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	response, run := apiRequest(t, server, http.MethodPost, "/runs", "", `{"modes":["tcp","web"],"target":"a.test","ports":"22,443","attempts":2}`)
+	if response.StatusCode != http.StatusAccepted || response.Header.Get("Location") != "/runs/"+run["id"].(string) {
+		t.Fatalf("create = %d %v", response.StatusCode, run)
+	}
+	id := run["id"].(string)
+	events := strings.Join(readAPIEvents(t, server, id), ",")
+	want := "run_queued,run_started,step_started,result,result,step_finished,step_started,result,result,step_finished,run_finished"
+	if events != want {
+		t.Fatalf("events = %s, want %s", events, want)
+	}
+	api.wg.Wait()
+	_, run = apiRequest(t, server, http.MethodGet, "/runs/"+id, "", "")
+	result, _ := run["result"].(map[string]any)
+	if run["status"] != apiRunSucceeded || result == nil {
+		t.Fatalf("run = %v", run)
+	}
+	results := result["results"].(map[string]any)
+	if len(results["tcp"].([]any)) != 2 || len(results["web"].([]any)) != 2 || result["config"].(map[string]any)["attempts"] != float64(2) {
+		t.Fatalf("result = %v", result)
+	}
+	if got := strings.Join(*calls, ","); got != "tcp-targets,website" {
+		t.Fatalf("runner calls = %q", got)
+	}
+	_, list := apiRequest(t, server, http.MethodGet, "/runs", "", "")
+	if runs := list["runs"].([]any); len(runs) != 1 || runs[0].(map[string]any)["result"] != nil {
+		t.Fatalf("list = %v", list)
+	}
+
+	for _, body := range []string{
+		`{"modes":["udp"]}`,
+		`{"modes":["tcp"],"steps":[{"mode":"web"}]}`,
+		`{"steps":[{"mode":"tcp","targets":"/etc/targets.yaml"}]}`,
+		`{"modes":["web"],"output":["/tmp/out.json"]}`,
+		`{"modes":["tcp"],"unknown":1}`,
+		`{}`,
+	} {
+		if response, decoded := apiRequest(t, server, http.MethodPost, "/runs", "", body); response.StatusCode != http.StatusBadRequest || decoded["error"] == "" {
+			t.Errorf("%s: status = %d %v, want 400", body, response.StatusCode, decoded)
+		}
+	}
+	if response, _ := apiRequest(t, server, http.MethodGet, "/runs/missing", "", ""); response.StatusCode != http.StatusNotFound {
+		t.Fatalf("missing run status = %d", response.StatusCode)
+	}
+	if response, _ := apiRequest(t, server, http.MethodDelete, "/runs/"+id, "", ""); response.StatusCode != http.StatusConflict {
+		t.Fatalf("cancel finished run status = %d", response.StatusCode)
+	}
+}
+
+func TestAPIServerLimitsCancelsAndAuthenticates(t *testing.T) {
+	started := make(chan struct{}, 4)
+	runner := commandRunner{
+		tcpTargets: func(ctx context.Context, _ pt.TCPProbeConfig, _ []model.TCPTarget) []pt.TCPResult {
+			started <- struct{}{}
+			<-ctx.Done()
+			return nil
+		},
+	}
+	api := newAPIServer(context.Background(), runner, "secret", 1, 1, 10)
+	server := httptest.NewServer(api)
+	defer server.Close()
+	body := `{"mode":"tcp","target":"a.test,b.test"}`
+
+	if response, decoded := apiRequest(t, server, http.MethodPost, "/runs", "", body); response.StatusCode != http.StatusUnauthorized || response.Header.Get("WWW-Authenticate") == "" {
+		t.Fatalf("unauthenticated = %d %v", response.StatusCode, decoded)
+	}
+	if response, _ := apiRequest(t, server, http.MethodGet, "/runs", "wrong", ""); response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("wrong token status = %d", response.StatusCode)
+	}
+	_, running := apiRequest(t, server, http.MethodPost, "/runs", "secret", body)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("first run did not start")
+	}
+	_, queued := apiRequest(t, server, http.MethodPost, "/runs", "secret", body)
+	if queued["status"] != apiRunQueued {
+		t.Fatalf("second run = %v, want queued", queued)
+	}
+	if response, _ := apiRequest(t, server, http.MethodPost, "/runs", "secret", body); response.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("over queue limit status = %d", response.StatusCode)
+	}
+
+	if response, _ := apiRequest(t, server, http.MethodDelete, "/runs/"+queued["id"].(string), "secret", ""); response.StatusCode != http.StatusAccepted {
+		t.Fatalf("cancel queued status = %d", response.StatusCode)
+	}
+	if response, _ := apiRequest(t, server, http.MethodDelete, "/runs/"+running["id"].(string), "secret", ""); response.StatusCode != http.StatusAccepted {
+		t.Fatalf("cancel running status = %d", response.StatusCode)
+	}
+	api.wg.Wait()
+	for _, run := range []map[string]any{running, queued} {
+		_, run = apiRequest(t, server, http.MethodGet, "/runs/"+run["id"].(string), "secret", "")
+		if run["status"] != apiRunCanceled {
+			t.Fatalf("run = %v, want canceled", run)
+		}
+	}
+	if len(started) != 0 {
+		t.Fatal("canceled queued run still started")
+	}
+}
+
+// blockingTransport holds every request until its context ends.
+type blockingTransport struct {
+	started  chan struct{}
+	requests atomic.Int32
+}
+
+func (transport *blockingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	transport.requests.Add(1)
+	transport.started <- struct{}{}
+	<-request.Context().Done()
+	return nil, request.Context().Err()
+}
+
+func TestAPIServerCancelStopsProductionWebStep(t *testing.T) {
+	transport := &blockingTransport{started: make(chan struct{}, len(model.PopularWebsites)*3)}
+	client := runClient(nil, nil)
+	client.HTTPClient = &http.Client{Transport: transport}
+	api := newAPIServer(context.Background(), clientCommandRunner(client), "", 1, 1, 10)
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	_, run := apiRequest(t, server, http.MethodPost, "/runs", "", `{"mode":"web"}`)
+	select {
+	case <-transport.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("web step sent no request")
+	}
+	if response, _ := apiRequest(t, server, http.MethodDelete, "/runs/"+run["id"].(string), "", ""); response.StatusCode != http.StatusAccepted {
+		t.Fatalf("cancel running status = %d", response.StatusCode)
+	}
+	finished := make(chan struct{})
+	go func() {
+		api.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("canceled web step kept running")
+	}
+	_, run = apiRequest(t, server, http.MethodGet, "/runs/"+run["id"].(string), "", "")
+	if run["status"] != apiRunCanceled {
+		t.Fatalf("run = %v, want canceled", run)
+	}
+	// Only the first batch of websites was in flight; no website sent a
+	// request after the cancel.
+	if requests := int(transport.requests.Load()); requests > 10 {
+		t.Fatalf("canceled web step sent %d requests", requests)
+	}
+}
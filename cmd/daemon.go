@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+	"github.com/oneclickvirt/pingtest/pt"
+)
+
+// daemonNotifyTimeout bounds one notification delivery.
+const daemonNotifyTimeout = 10 * time.Second
+
+// daemonJob is a job of the daemon config with its schedule parsed and its
+// steps resolved.
+type daemonJob struct {
+	name     string
+	schedule model.Schedule
+	steps    []stepConfig
+}
+
+// alertDaemon runs the jobs of "pingtest daemon", evaluates the alert rules
+// after every run and delivers the resulting notifications.
+type alertDaemon struct {
+	output    io.Writer
+	runner    commandRunner
+	jobs      []daemonJob
+	tracker   *pt.AlertTracker
+	notifiers map[string]model.Notifier
+	client    *http.Client
+	mu        sync.Mutex
+}
+
+// runDaemon implements "daemon CONFIG": it runs every job on its schedule
+// until interrupted. -check only validates the config and prints the next
+// run of each job; -once runs every job once and exits.
+func runDaemon(ctx context.Context, output io.Writer, args []string, runner commandRunner) int {
+	var configFile string
+	var check, once bool
+	daemonFlag := flag.NewFlagSet("pingtest daemon", flag.ContinueOnError)
+	daemonFlag.SetOutput(output)
+	daemonFlag.StringVar(&configFile, "config", "", "守护进程配置文件（JSON 或 YAML），也可作为位置参数传入")
+	daemonFlag.BoolVar(&check, "check", false, "仅校验配置并显示各任务的下次运行时间")
+	daemonFlag.BoolVar(&once, "once", false, "立即运行每个任务一次后退出，用于验证告警与通知")
+	daemonFlag.BoolVar(&model.EnableLoger, "log", false, "启用日志记录")
+	var limits rateFlags
+	var cache cacheFlags
+	limits.register(daemonFlag)
+	cache.register(daemonFlag)
+	positionals, err := parseWithPositionals(daemonFlag, args)
+	if err != nil {
+		return exitCannotRun
+	}
+	limiter, err := limits.limiter()
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", err)
+		return exitCannotRun
+	}
+	diskCache, err := cache.newCache()
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", err)
+		return exitCannotRun
+	}
+	runner = runner.with(runClient(limiter, diskCache))
+	if configFile == "" && len(positionals) == 1 {
+		configFile, positionals = positionals[0], nil
+	}
+	if configFile == "" || len(positionals) > 0 {
+		fmt.Fprintln(output, "错误: 用法: pingtest daemon [选项] CONFIG.yaml")
+		return exitCannotRun
+	}
+	config, err := model.LoadDaemonConfig(configFile)
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+		return exitCannotRun
+	}
+	daemon, err := newDaemon(output, runner, config)
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+		return exitCannotRun
+	}
+	switch {
+	case check:
+		now := time.Now()
+		for _, job := range daemon.jobs {
+			fmt.Fprintf(output, "%s: 下次运行 %s（%d 步）\n", job.name, job.schedule.Next(now).Format(time.DateTime), len(job.steps))
+		}
+		fmt.Fprintf(output, "配置有效: %d 个任务，%d 条告警规则，%d 个通知渠道\n", len(config.Jobs), len(config.Alerts), len(config.Notifiers))
+		return 0
+	case once:
+		for _, job := range daemon.jobs {
+			daemon.runJob(ctx, job)
+		}
+		return 0
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Fprintf(output, "守护进程已启动: %d 个任务，%d 条告警规则\n", len(config.Jobs), len(config.Alerts))
+	var wg sync.WaitGroup
+	for _, job := range daemon.jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			daemon.schedule(ctx, job)
+		}()
+	}
+	wg.Wait()
+	return 0
+}
+
+// newDaemon resolves every job against the command-line defaults, so a
+// config error is reported at startup rather than at the first run.
+func newDaemon(output io.Writer, runner commandRunner, config model.DaemonConfig) (*alertDaemon, error) {
+	tracker, err := pt.NewAlertTracker(config.Alerts)
+	if err != nil {
+		return nil, err
+	}
+	daemon := &alertDaemon{
+		output: output, runner: runner, tracker: tracker, notifiers: map[string]model.Notifier{},
+		client: &http.Client{Timeout: daemonNotifyTimeout},
+	}
+	for _, notifier := range config.Notifiers {
+		daemon.notifiers[notifier.Name] = notifier
+	}
+	for _, job := range config.Jobs {
+		schedule, err := model.ParseSchedule(job.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("任务 %s: %w", job.Name, err)
+		}
+		steps, err := resolvePlan(defaultStepConfig(""), job.Plan())
+		if err != nil {
+			return nil, fmt.Errorf("任务 %s: %w", job.Name, err)
+		}
+		daemon.jobs = append(daemon.jobs, daemonJob{name: job.Name, schedule: schedule, steps: steps})
+	}
+	return daemon, nil
+}
+
+// schedule runs job at each activation of its schedule until ctx is
+// canceled. The next activation is computed after a run finishes, so a slow
+// run skips the activations it overlapped instead of piling up.
+func (daemon *alertDaemon) schedule(ctx context.Context, job daemonJob) {
+	for {
+		timer := time.NewTimer(time.Until(job.schedule.Next(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		daemon.runJob(ctx, job)
+	}
+}
+
+// runJob runs every step of job, evaluates the alert rules on the results
+// and sends the notifications of rules that changed state. A run with a
+// failed step or no measured target is not evaluated: its results would
+// pass every rule and resolve alerts during an outage.
+func (daemon *alertDaemon) runJob(ctx context.Context, job daemonJob) {
+	started := time.Now()
+	var sections []pt.ReportSection
+	targets, failedSteps := 0, 0
+	for index, step := range job.steps {
+		if ctx.Err() != nil {
+			return
+		}
+		section, err := runStructuredStep(ctx, step, daemon.runner, func(any) {})
+		if err != nil {
+			daemon.logf("任务 %s: 步骤 %d 失败: %s", job.name, index+1, sanitizeErrorText(err.Error()))
+			failedSteps++
+			continue
+		}
+		sections = append(sections, section)
+		targets += len(section.Latency) + len(section.MTProto) + len(section.TCP)
+	}
+	daemon.logf("任务 %s 完成: %d 个目标，%d 个步骤失败，耗时 %s", job.name, targets, failedSteps, time.Since(started).Round(time.Millisecond))
+	if failedSteps > 0 || targets == 0 {
+		daemon.logf("任务 %s: 结果不完整，跳过告警评估", job.name)
+		return
+	}
+	for _, notification := range daemon.tracker.Evaluate(job.name, sections, time.Now()) {
+		daemon.logf("告警 %s %s: 任务 %s，%s", notification.Alert, notification.Status, job.name, notification.Condition)
+		for _, name := range notification.Notify {
+			notifyCtx, cancel := context.WithTimeout(ctx, daemonNotifyTimeout)
+			err := pt.SendAlert(notifyCtx, daemon.client, daemon.notifiers[name], notification)
+			cancel()
+			if err != nil {
+				daemon.logf("错误: 通知发送失败: %s", sanitizeErrorText(err.Error()))
+			}
+		}
+	}
+}
+
+// logf writes one timestamped line; jobs run concurrently and share the
+// output.
+func (daemon *alertDaemon) logf(format string, args ...any) {
+	daemon.mu.Lock()
+	defer daemon.mu.Unlock()
+	fmt.Fprintf(daemon.output, "%s %s\n", time.Now().Format(time.DateTime), strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
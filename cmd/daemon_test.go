@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/oneclickvirt/pingtest/model"
+	"github.com/oneclickvirt/pingtest/pt"
+)
+
+func TestRunCLIDaemonChecksConfigAndNotifiesOnce(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string]string{}
+	standIn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		defer mu.Unlock()
+		if text, ok := body["text"].(string); ok {
+			received[r.URL.Path] = text
+		} else {
+			received[r.URL.Path], _ = body["status"].(string)
+		}
+	}))
+	defer standIn.Close()
+	config := "jobs:\n" +
+		"  - name: core\n    schedule: \"*/5 * * * *\"\n    steps:\n      - mode: tcp\n        target: fixture.test\n" +
+		"alerts:\n  - name: high-loss\n    when: loss>10\n    notify: [hook, chat]\n" +
+		"notifiers:\n" +
+		"  - name: hook\n    type: webhook\n    url: " + standIn.URL + "/hook\n" +
+		"  - name: chat\n    type: slack\n    url: " + standIn.URL + "/slack\n"
+	path := filepath.Join(t.TempDir(), "daemon.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	runner, calls := offlineRunner()
+
+	var output bytes.Buffer
+	if code := runCLI(context.Background(), []string{"daemon", "-check", path}, &output, runner); code != 0 {
+		t.Fatalf("check exit = %d, output %q", code, output.String())
+	}
+	if !strings.Contains(output.String(), "core: 下次运行") || !strings.Contains(output.String(), "配置有效: 1 个任务，1 条告警规则，2 个通知渠道") || len(*calls) != 0 {
+		t.Fatalf("check output = %q, calls %v", output.String(), *calls)
+	}
+
+	runner.tcp = func(context.Context, pt.TCPProbeConfig, string) ([]pt.TCPResult, error) {
+		*calls = append(*calls, "tcp")
+		return []pt.TCPResult{{Attempts: 4, Successful: 2, LossPercent: 50}}, nil
+	}
+	output.Reset()
+	if code := runCLI(context.Background(), []string{"daemon", "-once", "-config", path}, &output, runner); code != 0 {
+		t.Fatalf("once exit = %d, output %q", code, output.String())
+	}
+	if strings.Join(*calls, ",") != "tcp" || !strings.Contains(output.String(), "任务 core 完成: 1 个目标") || !strings.Contains(output.String(), "告警 high-loss firing") {
+		t.Fatalf("once output = %q, calls %v", output.String(), *calls)
+	}
+	if received["/hook"] != pt.AlertFiring || !strings.HasPrefix(received["/slack"], "[FIRING] high-loss (job core on ") {
+		t.Fatalf("notifications = %v", received)
+	}
+
+	output.Reset()
+	if code := runCLI(context.Background(), []string{"daemon"}, &output, runner); code != exitCannotRun || !strings.Contains(output.String(), "用法") {
+		t.Fatalf("missing config exit = %d, output %q", code, output.String())
+	}
+}
+
+func TestDaemonFailedStepKeepsAlertFiring(t *testing.T) {
+	var mu sync.Mutex
+	var statuses []string
+	standIn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		defer mu.Unlock()
+		status, _ := body["status"].(string)
+		statuses = append(statuses, status)
+	}))
+	defer standIn.Close()
+	config, err := model.DecodeDaemonConfig([]byte("jobs:\n"+
+		"  - name: core\n    schedule: \"*/5 * * * *\"\n    steps:\n      - mode: tcp\n        target: fixture.test\n"+
+		"alerts:\n  - name: high-loss\n    when: loss>10\n    notify: [hook]\n"+
+		"notifiers:\n  - name: hook\n    type: webhook\n    url: "+standIn.URL+"\n"), model.CustomTargetFormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner, _ := offlineRunner()
+	var results []pt.TCPResult
+	var stepErr error
+	runner.tcp = func(context.Context, pt.TCPProbeConfig, string) ([]pt.TCPResult, error) {
+		return results, stepErr
+	}
+	var output bytes.Buffer
+	daemon, err := newDaemon(&output, runner, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results = []pt.TCPResult{{Attempts: 4, Successful: 2, LossPercent: 50}}
+	daemon.runJob(context.Background(), daemon.jobs[0])
+	// During an outage the step fails and measures nothing; that must not
+	// count as a clean run.
+	results, stepErr = nil, errors.New("network unreachable")
+	daemon.runJob(context.Background(), daemon.jobs[0])
+	if strings.Join(statuses, ",") != pt.AlertFiring || !strings.Contains(output.String(), "跳过告警评估") {
+		t.Fatalf("notifications %v, output %q", statuses, output.String())
+	}
+	results, stepErr = []pt.TCPResult{{Attempts: 4, Successful: 4}}, nil
+	daemon.runJob(context.Background(), daemon.jobs[0])
+	if strings.Join(statuses, ",") != pt.AlertFiring+","+pt.AlertResolved {
+		t.Fatalf("notifications after recovery = %v", statuses)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/oneclickvirt/pingtest/model"
+	"github.com/oneclickvirt/pingtest/pt"
+)
+
+// runDiff implements "diff OLD NEW". It exits 1 when a target regressed
+// beyond the thresholds so scripts can gate on it, and 2 when the runs
+// could not be compared.
+func runDiff(output io.Writer, args []string) int {
+	var format, language, file string
+	var all bool
+	thresholds := pt.DefaultDiffThresholds()
+	diffFlag := flag.NewFlagSet("pingtest diff", flag.ContinueOnError)
+	diffFlag.SetOutput(output)
+	diffFlag.StringVar(&format, "format", model.FormatText, "输出格式: text、markdown 或 json")
+	diffFlag.StringVar(&language, "l", "zh", "输出语言: zh 或 en")
+	diffFlag.BoolVar(&all, "all", false, "同时列出没有变化的目标")
+	diffFlag.StringVar(&file, "history-file", "", "按运行 ID 读取时使用的历史记录文件路径")
+	diffFlag.Float64Var(&thresholds.LossPercent, "loss", thresholds.LossPercent, "丢包率上升超过该百分点视为退化")
+	diffFlag.DurationVar(&thresholds.P50, "p50", thresholds.P50, "P50 上升超过该值（且超过 -latency-percent）视为退化")
+	diffFlag.DurationVar(&thresholds.P95, "p95", thresholds.P95, "P95 上升超过该值（且超过 -latency-percent）视为退化")
+	diffFlag.Float64Var(&thresholds.LatencyPercent, "latency-percent", thresholds.LatencyPercent, "延迟上升需同时超过旧值的该百分比")
+	positionals, err := parseWithPositionals(diffFlag, args)
+	if err != nil {
+		return exitCannotRun
+	}
+	format = strings.ToLower(strings.TrimSpace(format))
+	if len(positionals) != 2 {
+		fmt.Fprintln(output, "错误: 用法: pingtest diff [选项] OLD.json NEW.json（也可使用历史记录 ID 或 latest）")
+		return exitCannotRun
+	}
+	if format != model.FormatText && format != model.FormatMarkdown && format != model.FormatJSON {
+		fmt.Fprintln(output, "错误: -format 仅支持 text、markdown 或 json")
+		return exitCannotRun
+	}
+	if thresholds.LossPercent < 0 || thresholds.P50 < 0 || thresholds.P95 < 0 || thresholds.LatencyPercent < 0 {
+		fmt.Fprintln(output, "错误: 阈值不能为负数")
+		return exitCannotRun
+	}
+	runs := make([]pt.ResultEnvelope, 0, 2)
+	for _, name := range positionals {
+		envelope, err := loadDiffRun(name, file)
+		if err != nil {
+			fmt.Fprintf(output, "错误: %s: %s\n", name, sanitizeErrorText(err.Error()))
+			return exitCannotRun
+		}
+		runs = append(runs, envelope)
+	}
+	diff := pt.DiffRuns(runs[0], runs[1], thresholds)
+	targets := diff.Targets
+	if !all {
+		targets = diff.Changed()
+	}
+	switch format {
+	case model.FormatJSON:
+		if !all {
+			diff.Targets = targets
+		}
+		if diff.Targets == nil {
+			diff.Targets = []pt.TargetDiff{}
+		}
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(diff)
+	case model.FormatMarkdown:
+		_, err = io.WriteString(output, pt.DiffTable(targets).Markdown())
+	default:
+		_, err = fmt.Fprintln(output, pt.FormatRunDiff(diff, targets, language))
+	}
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+		return exitCannotRun
+	}
+	if diff.Regressions > 0 {
+		return exitAssertionFailed
+	}
+	return 0
+}
+
+// loadDiffRun reads a saved JSON run. A name that is not an existing file
+// is looked up as a history record ID.
+func loadDiffRun(name, historyFile string) (pt.ResultEnvelope, error) {
+	envelope, err := pt.LoadResultFile(name)
+	if !errors.Is(err, os.ErrNotExist) {
+		return envelope, err
+	}
+	store, storeErr := historyStore(historyFile)
+	if storeErr != nil {
+		return pt.ResultEnvelope{}, err
+	}
+	record, storeErr := store.Get(name)
+	if storeErr != nil {
+		return pt.ResultEnvelope{}, errors.New("既不是结果文件也不是历史记录 ID")
+	}
+	return record.ResultEnvelope, nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/oneclickvirt/pingtest/model"
+	"github.com/oneclickvirt/pingtest/pt"
+)
+
+// historyStore opens the store at path, or at the default location in the
+// user's data directory when path is empty.
+func historyStore(path string) (pt.HistoryStore, error) {
+	if strings.TrimSpace(path) != "" {
+		return pt.HistoryStore{Path: path}, nil
+	}
+	path, err := pt.DefaultHistoryPath()
+	if err != nil {
+		return pt.HistoryStore{}, err
+	}
+	return pt.HistoryStore{Path: path}, nil
+}
+
+// saveHistory appends a finished run, with the use of its cache, to the
+// store and applies retention. Runs in which every step failed have nothing
+// to compare and are skipped.
+func saveHistory(output io.Writer, store pt.HistoryStore, envelope *pt.ResultEnvelope, cache *pt.DiskCache, retention pt.HistoryRetention, format string) int {
+	if len(envelope.Config.Modes) == 0 {
+		return 0
+	}
+	envelope.FinishedAt = time.Now()
+	envelope.Cache = cache.Info()
+	record, err := store.Append(*envelope)
+	if err != nil {
+		fmt.Fprintf(output, "错误: 保存历史记录失败: %s\n", sanitizeErrorText(err.Error()))
+		return exitCannotRun
+	}
+	if _, err := store.Prune(retention, envelope.FinishedAt); err != nil {
+		fmt.Fprintf(output, "错误: 清理历史记录失败: %s\n", sanitizeErrorText(err.Error()))
+		return exitCannotRun
+	}
+	if format == model.FormatText {
+		fmt.Fprintf(output, "历史记录已保存: %s\n", record.ID)
+	}
+	return 0
+}
+
+// runHistory implements the history subcommands: list, show ID and prune.
+func runHistory(output io.Writer, args []string) int {
+	if len(args) == 0 {
+		printHistoryUsage(output)
+		return exitCannotRun
+	}
+	var file, format string
+	var limit, keep int
+	var maxAge time.Duration
+	historyFlag := flag.NewFlagSet("pingtest history "+args[0], flag.ContinueOnError)
+	historyFlag.SetOutput(output)
+	historyFlag.StringVar(&file, "history-file", "", "历史记录文件路径（默认为用户数据目录下的 pingtest/history.jsonl）")
+	switch args[0] {
+	case "list":
+		historyFlag.IntVar(&limit, "n", 20, "仅显示最近 N 次运行，0 表示全部")
+		historyFlag.StringVar(&format, "format", model.FormatText, "输出格式: text、json、csv 或 markdown")
+	case "show":
+		historyFlag.StringVar(&format, "format", model.FormatText, "输出格式: text、json、csv 或 markdown")
+	case "prune":
+		historyFlag.IntVar(&keep, "keep", 0, "最多保留最近 N 次运行，0 表示不限")
+		historyFlag.DurationVar(&maxAge, "max-age", 0, "删除早于该时长的运行，如 720h，0 表示不限")
+	case "-h", "help":
+		printHistoryUsage(output)
+		return 0
+	default:
+		fmt.Fprintf(output, "错误: 未知的 history 子命令 '%s'\n", args[0])
+		printHistoryUsage(output)
+		return exitCannotRun
+	}
+	positionals, err := parseWithPositionals(historyFlag, args[1:])
+	if err != nil {
+		return exitCannotRun
+	}
+	if format != "" && format != model.FormatText && format != model.FormatJSON && format != model.FormatCSV && format != model.FormatMarkdown {
+		fmt.Fprintln(output, "错误: -format 仅支持 text、json、csv 或 markdown")
+		return exitCannotRun
+	}
+	store, err := historyStore(file)
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+		return exitCannotRun
+	}
+	switch args[0] {
+	case "list":
+		if len(positionals) != 0 || limit < 0 {
+			fmt.Fprintln(output, "错误: 用法: pingtest history list [-n N] [-format text|json|csv|markdown]")
+			return exitCannotRun
+		}
+		err = listHistory(output, store, limit, format)
+	case "show":
+		if len(positionals) != 1 {
+			fmt.Fprintln(output, "错误: 用法: pingtest history show [-format text|json|csv|markdown] ID|latest")
+			return exitCannotRun
+		}
+		err = showHistory(output, store, positionals[0], format)
+	case "prune":
+		if len(positionals) != 0 || keep < 0 || maxAge < 0 {
+			fmt.Fprintln(output, "错误: 用法: pingtest history prune [-keep N] [-max-age 720h]")
+			return exitCannotRun
+		}
+		var removed int
+		removed, err = store.Prune(pt.HistoryRetention{MaxRuns: keep, MaxAge: maxAge}, time.Now())
+		if err == nil {
+			fmt.Fprintf(output, "已删除 %d 条历史记录\n", removed)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+		return exitCannotRun
+	}
+	return 0
+}
+
+func printHistoryUsage(output io.Writer) {
+	fmt.Fprintln(output, "用法: pingtest history <子命令> [选项]")
+	fmt.Fprintln(output, "\n子命令:")
+	fmt.Fprintln(output, "  list          列出已保存的运行")
+	fmt.Fprintln(output, "  show ID       显示一次运行的完整结果，ID 为 latest 时显示最近一次")
+	fmt.Fprintln(output, "  prune         按 -keep 与 -max-age 删除旧的运行")
+	fmt.Fprintln(output, "\n运行测试时加 -history 即可保存结果，例如: pingtest -tm tcp -history")
+}
+
+// parseWithPositionals lets flags follow positional arguments, so both
+// "show -format json ID" and "show ID -format json" work.
+func parseWithPositionals(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positionals []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positionals, nil
+		}
+		positionals = append(positionals, args[0])
+		args = args[1:]
+	}
+}
+
+// historyTable summarizes runs one per row, newest first.
+func historyTable(records []pt.HistoryRecord) pt.Table {
+	table := pt.Table{Header: []string{"id", "started_at", "duration", "modes", "host", "targets", "failed"}}
+	for index := len(records) - 1; index >= 0; index-- {
+		record := records[index]
+		total, failed := record.Counts()
+		table.Rows = append(table.Rows, []string{
+			record.ID, record.StartedAt.Local().Format("2006-01-02 15:04:05"),
+			record.FinishedAt.Sub(record.StartedAt).Round(time.Second).String(),
+			strings.Join(record.Config.Modes, ","), record.Host.Hostname,
+			strconv.Itoa(total), strconv.Itoa(failed),
+		})
+	}
+	return table
+}
+
+func listHistory(output io.Writer, store pt.HistoryStore, limit int, format string) error {
+	records, err := store.List()
+	if err != nil {
+		return err
+	}
+	if limit > 0 && len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	switch format {
+	case model.FormatJSON:
+		if records == nil {
+			records = []pt.HistoryRecord{}
+		}
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case model.FormatCSV:
+		text, err := historyTable(records).CSV()
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(output, text)
+		return err
+	case model.FormatMarkdown:
+		_, err = io.WriteString(output, historyTable(records).Markdown())
+		return err
+	}
+	if len(records) == 0 {
+		_, err = fmt.Fprintf(output, "暂无历史记录: %s\n", store.Path)
+		return err
+	}
+	table := historyTable(records)
+	table.Header = []string{"ID", "开始时间", "耗时", "模式", "主机", "目标", "失败"}
+	_, err = io.WriteString(output, formatTextTable(table))
+	return err
+}
+
+// formatTextTable pads every column to its widest cell, matching the
+// fixed-width text grids.
+func formatTextTable(table pt.Table) string {
+	widths := make([]int, len(table.Header))
+	for _, row := range append([][]string{table.Header}, table.Rows...) {
+		for index, cell := range row {
+			widths[index] = max(widths[index], runewidth.StringWidth(cell))
+		}
+	}
+	var output strings.Builder
+	for _, row := range append([][]string{table.Header}, table.Rows...) {
+		line := ""
+		for index, cell := range row {
+			line += cell + strings.Repeat(" ", widths[index]-runewidth.StringWidth(cell)+2)
+		}
+		output.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	return output.String()
+}
+
+func showHistory(output io.Writer, store pt.HistoryStore, id, format string) error {
+	record, err := store.Get(id)
+	if err != nil {
+		return err
+	}
+	sections := record.Sections()
+	if len(sections) == 0 {
+		return errors.New("历史记录中没有结果")
+	}
+	switch format {
+	case model.FormatJSON:
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(record)
+	case model.FormatCSV:
+		for index, section := range sections {
+			text, err := section.Table().CSV()
+			if err != nil {
+				return err
+			}
+			if len(sections) > 1 {
+				if index > 0 {
+					text = "\n" + text
+				}
+				text = "# " + section.Mode + "\n" + text
+			}
+			if _, err := io.WriteString(output, text); err != nil {
+				return err
+			}
+		}
+		return nil
+	case model.FormatMarkdown:
+		for index, section := range sections {
+			text := "### " + section.Mode + "\n\n" + section.Table().Markdown()
+			if index > 0 {
+				text = "\n" + text
+			}
+			if _, err := io.WriteString(output, text); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	fmt.Fprintf(output, "运行 %s  %s  %s/%s  %s\n", record.ID, record.StartedAt.Local().Format("2006-01-02 15:04:05"), record.Host.OS, record.Host.Arch, record.Version)
+	for _, section := range sections {
+		config := stepConfig{
+			Mode: section.Mode, Language: record.Config.Language, PingSort: model.PingSort(record.Config.PingSort),
+			TCPSort: model.TCPSort(record.Config.TCPSort), TCPFormat: pt.TCPTextFormatCompact, TCPDetails: pt.DefaultTCPCompactDetails,
+		}
+		text := "== " + section.Mode + " ==\n" + formatTextSection(config, section)
+		if _, err := io.WriteString(output, indentLegacyOutput(text)+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,13 +2,14 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -19,22 +20,48 @@ import (
 )
 
 type commandRunner struct {
-	ping            func() string
-	pingWithOptions func(pt.PingOptions) string
-	telegram        func() string
-	website         func() string
-	tcp             func(context.Context, pt.TCPProbeConfig, string) ([]pt.TCPResult, error)
+	ping                func() string
+	pingWithOptions     func(pt.PingOptions) string
+	telegram            func() string
+	telegramWithOptions func(pt.TelegramDCOptions) string
+	mtproto             func(context.Context, pt.TelegramDCOptions) string
+	website             func() string
+	tcp                 func(context.Context, pt.TCPProbeConfig, string) ([]pt.TCPResult, error)
+	customPing          func([]model.CustomTarget, pt.PingOptions) string
+	customWebsite       func([]model.CustomTarget) string
+	customTCP           func(context.Context, pt.TCPProbeConfig, []model.CustomTarget) []pt.TCPResult
+	tcpTargets          func(context.Context, pt.TCPProbeConfig, []model.TCPTarget) []pt.TCPResult
+	icmp                func(context.Context, []pt.ICMPTarget, pt.ICMPProbeConfig) []pt.ICMPResult
+	// Structured runners back the non-text formats. Nil custom targets select
+	// the built-in lists.
+	pingResults     func(context.Context, pt.PingOptions, []model.CustomTarget, func(pt.LatencyResult)) []pt.LatencyResult
+	telegramResults func(context.Context, pt.TelegramDCOptions, func(pt.LatencyResult)) []pt.LatencyResult
+	mtprotoResults  func(context.Context, pt.TelegramDCOptions, func(pt.TelegramMTProtoResult)) []pt.TelegramMTProtoResult
+	websiteResults  func(context.Context, []model.CustomTarget, pt.WebsiteOptions, func(pt.LatencyResult)) []pt.LatencyResult
+	// client is the pt.Client the runners probe with and bind rebuilds the
+	// runners on another one; test doubles leave both unset.
+	client *pt.Client
+	bind   func(*pt.Client) commandRunner
 }
 
 func productionCommandRunner() commandRunner {
+	return clientCommandRunner(pt.NewDefaultClient())
+}
+
+// clientCommandRunner runs every mode on client, whose rate limiter also
+// paces the probe configs the steps pass in.
+func clientCommandRunner(client *pt.Client) commandRunner {
 	return commandRunner{
-		ping:            pt.PingTest,
-		pingWithOptions: pt.PingTestWithOptions,
-		telegram:        pt.TelegramDCTest,
-		website:         pt.WebsiteTest,
+		ping:                client.PingTest,
+		pingWithOptions:     client.PingTestWithOptions,
+		telegram:            client.TelegramDCTest,
+		telegramWithOptions: client.TelegramDCTestWithOptions,
+		mtproto:             client.TelegramMTProtoTest,
+		website:             client.WebsiteTest,
 		tcp: func(ctx context.Context, config pt.TCPProbeConfig, target string) ([]pt.TCPResult, error) {
+			config.RateLimiter = client.RateLimiter
 			if strings.TrimSpace(target) == "" {
-				results, _, err := pt.RunLoadedTCPRegistry(ctx, config)
+				results, _, err := client.RunLoadedTCPRegistry(ctx, config)
 				return results, err
 			}
 			parsed, err := parseTCPTarget(target)
@@ -43,9 +70,75 @@ func productionCommandRunner() commandRunner {
 			}
 			return pt.RunTCPProbes(ctx, []model.TCPTarget{parsed}, config), nil
 		},
+		customPing:    client.CustomPingTest,
+		customWebsite: client.CustomWebsiteTest,
+		customTCP: func(ctx context.Context, config pt.TCPProbeConfig, targets []model.CustomTarget) []pt.TCPResult {
+			config.RateLimiter = client.RateLimiter
+			return pt.RunCustomTCPProbes(ctx, targets, config)
+		},
+		tcpTargets: func(ctx context.Context, config pt.TCPProbeConfig, targets []model.TCPTarget) []pt.TCPResult {
+			config.RateLimiter = client.RateLimiter
+			return pt.RunTCPProbes(ctx, targets, config)
+		},
+		icmp: func(ctx context.Context, targets []pt.ICMPTarget, config pt.ICMPProbeConfig) []pt.ICMPResult {
+			config.RateLimiter = client.RateLimiter
+			return pt.RunICMPProbes(ctx, targets, config)
+		},
+		pingResults: func(ctx context.Context, options pt.PingOptions, targets []model.CustomTarget, onResult func(pt.LatencyResult)) []pt.LatencyResult {
+			if targets != nil {
+				return client.CustomPingResults(ctx, targets, options, onResult)
+			}
+			return client.PingResults(ctx, options, onResult)
+		},
+		telegramResults: client.TelegramDCResults,
+		mtprotoResults:  client.TelegramMTProtoResults,
+		websiteResults: func(ctx context.Context, targets []model.CustomTarget, options pt.WebsiteOptions, onResult func(pt.LatencyResult)) []pt.LatencyResult {
+			if targets != nil {
+				return client.CustomWebsiteResultsWithOptions(ctx, targets, options, onResult)
+			}
+			return client.WebsiteResultsWithOptions(ctx, model.PopularWebsites, options, onResult)
+		},
+		client: client,
+		bind:   clientCommandRunner,
 	}
 }
 
+// with returns the runner probing with client. A runner without bind keeps
+// its functions and only records the client for the result envelopes.
+func (runner commandRunner) with(client *pt.Client) commandRunner {
+	if runner.bind != nil {
+		return runner.bind(client)
+	}
+	runner.client = client
+	return runner
+}
+
+// rateLimiter is the limiter of the runner's client; nil when unlimited.
+func (runner commandRunner) rateLimiter() *pt.RateLimiter {
+	if runner.client == nil {
+		return nil
+	}
+	return runner.client.RateLimiter
+}
+
+// cache is the cache of the runner's client; nil when caching is off.
+func (runner commandRunner) cache() *pt.DiskCache {
+	if runner.client == nil {
+		return nil
+	}
+	return runner.client.Cache
+}
+
+// runClient returns the pt.Client of a command: the model-driven settings
+// of the package-level functions plus the command's limiter and cache. The
+// cache counts only the hits of this client, which the result envelopes
+// report.
+func runClient(limiter *pt.RateLimiter, cache *pt.DiskCache) *pt.Client {
+	client := pt.NewDefaultClient()
+	client.RateLimiter, client.Cache = limiter, cache
+	return client
+}
+
 func main() {
 	go func() {
 		http.Get("https://hits.spiritlhl.net/pingtest.svg?action=hit&title=Hits&title_bg=%23555555&count_bg=%230eecf8&edge_flat=false")
@@ -55,21 +148,66 @@ func main() {
 	}
 }
 
+// Exit codes of runCLI. A failed assertion is distinct from a run that could
+// not complete, so pipelines can tell a degraded network from a broken
+// invocation.
+const (
+	exitAssertionFailed = 1
+	exitCannotRun       = 2
+)
+
 func runCLI(ctx context.Context, args []string, output io.Writer, runner commandRunner) int {
-	var showVersion, help, jsonOutput bool
-	var testMode, target, tcpFormat, language, pingSort, pingScope, tcpSort string
-	var attempts, concurrency, tcpDetails int
-	var timeout time.Duration
+	if len(args) > 0 {
+		switch args[0] {
+		case "history":
+			return runHistory(output, args[1:])
+		case "diff":
+			return runDiff(output, args[1:])
+		case "api":
+			return runAPI(ctx, output, args[1:], runner)
+		case "daemon":
+			return runDaemon(ctx, output, args[1:], runner)
+		case "serve":
+			return runServe(ctx, output, args[1:], runner)
+		}
+	}
+	var showVersion, help, jsonOutput, dryRun, recordHistory, progress, interleave bool
+	var targets targetList
+	var testMode, format, htmlReport, historyFile, planFile, ports, targetsFile, tcpFormat, language, pingSort, pingScope, tcpSort, tgKind, tgIP string
+	var attempts, concurrency, tcpDetails, historyKeep int
+	var timeout, historyMaxAge, interval, jitter time.Duration
+	var assertions assertionList
+	var minSuccessRate float64
+	var limits rateFlags
+	var cache cacheFlags
 	pingtestFlag := flag.NewFlagSet("pingtest", flag.ContinueOnError)
 	pingtestFlag.SetOutput(output)
 	pingtestFlag.BoolVar(&help, "h", false, "显示帮助信息")
 	pingtestFlag.BoolVar(&showVersion, "v", false, "显示版本信息")
 	pingtestFlag.BoolVar(&model.EnableLoger, "log", false, "启用日志记录")
-	pingtestFlag.BoolVar(&jsonOutput, "json", false, "TCP 模式输出结构化 JSON")
+	pingtestFlag.BoolVar(&jsonOutput, "json", false, "TCP 模式输出结构化 JSON，等同 -format json")
+	pingtestFlag.StringVar(&format, "format", model.FormatText, "输出格式: text、json、ndjson（逐条流式输出）、csv 或 markdown")
+	pingtestFlag.StringVar(&htmlReport, "html", "", "同时将结果写入独立的 HTML 报告文件（含表格与延迟图表）")
+	pingtestFlag.BoolVar(&recordHistory, "history", false, "将本次运行的完整结果保存到本地历史记录，可用 pingtest history 查看")
+	pingtestFlag.StringVar(&historyFile, "history-file", "", "历史记录文件路径（默认为用户数据目录下的 pingtest/history.jsonl）")
+	pingtestFlag.IntVar(&historyKeep, "history-keep", 100, "保存历史记录后最多保留最近 N 次运行，0 表示不限")
+	pingtestFlag.DurationVar(&historyMaxAge, "history-max-age", 0, "保存历史记录后删除早于该时长的运行，如 720h，0 表示不限")
+	pingtestFlag.Var(&assertions, "fail-if", "结果满足条件时以退出码 1 结束，如 'loss>5'、'p95>200ms category=ai'，可重复")
+	pingtestFlag.Float64Var(&minSuccessRate, "min-success-rate", 0, "所有目标的总体成功率低于该百分比时以退出码 1 结束，如 90")
+	pingtestFlag.StringVar(&planFile, "plan", "", "按 JSON 或 YAML 测试计划文件依次执行多个测试步骤")
+	pingtestFlag.BoolVar(&dryRun, "dry-run", false, "仅校验参数与测试计划并列出步骤，不执行测试")
+	pingtestFlag.BoolVar(&progress, "progress", true, "在终端上显示每个测试步骤的实时进度（完成数、失败数、耗时），输出到管道时自动关闭")
 	pingtestFlag.IntVar(&attempts, "attempts", 3, "TCP 模式每个目标的尝试次数")
 	pingtestFlag.DurationVar(&timeout, "timeout", 5*time.Second, "TCP 模式单次握手超时")
 	pingtestFlag.IntVar(&concurrency, "concurrency", 16, "TCP 模式最大并发数")
-	pingtestFlag.StringVar(&target, "target", "", "TCP 模式仅测试一个 host[:port] 目标")
+	pingtestFlag.DurationVar(&interval, "interval", 0, "TCP 模式同一目标两次尝试之间的间隔，如 200ms")
+	pingtestFlag.DurationVar(&jitter, "jitter", 0, "在 -interval 之上为每次间隔随机增加 0 到该时长")
+	pingtestFlag.BoolVar(&interleave, "interleave", false, "TCP 模式按轮次交错测试：每轮以随机顺序对每个目标各尝试一次")
+	limits.register(pingtestFlag)
+	cache.register(pingtestFlag)
+	pingtestFlag.Var(&targets, "target", "TCP 模式测试指定 host[:port] 目标，可重复或用逗号分隔")
+	pingtestFlag.StringVar(&ports, "ports", "", "TCP 模式对 -target 主机测试多个端口，如 22,80,443,8000-8100")
+	pingtestFlag.StringVar(&targetsFile, "targets", "", "从 JSON、YAML 或 CSV 文件读取自定义目标，替代内置目标列表")
 	// Kept for command-line compatibility with earlier releases. Both values
 	// now render the same complete single-row-per-platform table.
 	pingtestFlag.StringVar(&tcpFormat, "tcp-format", string(pt.TCPTextFormatCompact), "兼容参数: compact 或 full；当前均显示完整平台表格")
@@ -77,128 +215,326 @@ func runCLI(ctx context.Context, args []string, output io.Writer, runner command
 	pingtestFlag.StringVar(&language, "l", "zh", "输出语言与目标范围: zh 或 en")
 	pingtestFlag.StringVar(&pingSort, "ping-sort", string(model.PingSortLatency), "Ping 排序: latency 或 name")
 	pingtestFlag.StringVar(&pingScope, "ping-scope", string(model.PingScopeAuto), "Ping 目标范围: auto、china 或 international")
+	pingtestFlag.StringVar(&tgKind, "tg-kind", "", "Telegram 数据中心类别: production、media、cdn、test 或 all，可用逗号分隔（默认 production）")
+	pingtestFlag.StringVar(&tgIP, "tg-ip", "", "Telegram 数据中心 IP 版本: ipv4、ipv6 或 all（默认 ipv4）")
 	pingtestFlag.StringVar(&tcpSort, "tcp-sort", string(model.TCPSortName), "TCP 平台排序: name 或 latency")
 	pingtestFlag.StringVar(&testMode, "tm", "ori", "测试模式:\n"+
 		"  ori    - 国内三网延迟测试（默认）\n"+
 		"  tgdc   - Telegram 数据中心连通性测试\n"+
+		"  mtproto - Telegram 数据中心 MTProto 可用性测试\n"+
 		"  web    - 流行网站连通性测试\n"+
 		"  tcp    - TCP 握手延迟与可用性测试\n"+
 		"  china  - 国内三网 + TG + 网站全测试\n"+
 		"  global - 全球测试（TG + 网站，不含三网）")
 	if err := pingtestFlag.Parse(args); err != nil {
-		return 2
+		return exitCannotRun
 	}
 	if jsonOutput && testMode != "tcp" {
 		fmt.Fprintln(output, "错误: -json 仅支持 -tm tcp")
-		return 2
+		return exitCannotRun
+	}
+	if jsonOutput {
+		if !strings.EqualFold(strings.TrimSpace(format), model.FormatText) && !strings.EqualFold(strings.TrimSpace(format), model.FormatJSON) {
+			fmt.Fprintln(output, "错误: -json 与 -format 不能同时指定不同格式")
+			return exitCannotRun
+		}
+		format = model.FormatJSON
+	}
+	if help || showVersion {
+		if !jsonOutput {
+			fmt.Fprintln(output, "项目地址:", Blue("https://github.com/oneclickvirt/pingtest"))
+		}
+		if help {
+			printUsage(output, pingtestFlag)
+		} else {
+			fmt.Fprintln(output, model.PingTestVersion)
+		}
+		return 0
 	}
-	language = strings.ToLower(strings.TrimSpace(language))
-	pingOrder := model.PingSort(strings.ToLower(strings.TrimSpace(pingSort)))
-	scope := model.PingScope(strings.ToLower(strings.TrimSpace(pingScope)))
-	tcpOrder := model.TCPSort(strings.ToLower(strings.TrimSpace(tcpSort)))
-	if language != "zh" && language != "en" {
-		fmt.Fprintln(output, "错误: -l 仅支持 zh 或 en")
-		return 2
+	modeSet := false
+	pingtestFlag.Visit(func(f *flag.Flag) { modeSet = modeSet || f.Name == "tm" })
+	if strings.TrimSpace(planFile) != "" && modeSet {
+		fmt.Fprintln(output, "错误: -plan 与 -tm 不能同时使用")
+		return exitCannotRun
 	}
-	if pingOrder != model.PingSortLatency && pingOrder != model.PingSortName {
-		fmt.Fprintln(output, "错误: -ping-sort 仅支持 latency 或 name")
-		return 2
+	if minSuccessRate < 0 || minSuccessRate > 100 {
+		fmt.Fprintln(output, "错误: -min-success-rate 必须在 0 到 100 之间")
+		return exitCannotRun
 	}
-	if scope != model.PingScopeAuto && scope != model.PingScopeChina && scope != model.PingScopeInternational {
-		fmt.Fprintln(output, "错误: -ping-scope 仅支持 auto、china 或 international")
-		return 2
+	if historyKeep < 0 || historyMaxAge < 0 {
+		fmt.Fprintln(output, "错误: -history-keep 与 -history-max-age 不能为负数")
+		return exitCannotRun
 	}
-	if language == "en" && scope == model.PingScopeChina {
-		fmt.Fprintln(output, "错误: 英文模式不测试中国大陆 Ping 目标")
-		return 2
+	limiter, err := limits.limiter()
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", err)
+		return exitCannotRun
 	}
-	if tcpOrder != model.TCPSortName && tcpOrder != model.TCPSortLatency {
-		fmt.Fprintln(output, "错误: -tcp-sort 仅支持 name 或 latency")
-		return 2
+	diskCache, err := cache.newCache()
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", err)
+		return exitCannotRun
 	}
-	if !jsonOutput {
-		fmt.Fprintln(output, "项目地址:", Blue("https://github.com/oneclickvirt/pingtest"))
+	runner = runner.with(runClient(limiter, diskCache))
+	if testMode == "" {
+		testMode = model.ModeOri // 空模式等同默认三网测试
 	}
-
-	if help {
-		fmt.Fprintln(output, "用法: pingtest [选项]")
-		fmt.Fprintln(output, "\n选项:")
-		pingtestFlag.PrintDefaults()
-		fmt.Fprintln(output, "\n示例:")
-		fmt.Fprintln(output, "  pingtest              # 默认模式: 测试国内三网延迟")
-		fmt.Fprintln(output, "  pingtest -tm ori      # 测试国内三网延迟（默认）")
-		fmt.Fprintln(output, "  pingtest -tm tgdc     # 测试 Telegram 数据中心")
-		fmt.Fprintln(output, "  pingtest -tm web      # 测试流行网站连通性")
-		fmt.Fprintln(output, "  pingtest -tm tcp      # 测试合并目标集的 TCP 握手")
-		fmt.Fprintln(output, "  pingtest -tm china    # 测试国内三网 + TG + 网站")
-		fmt.Fprintln(output, "  pingtest -tm global   # 测试 TG + 网站（不含三网）")
-		fmt.Fprintln(output, "  pingtest -log         # 启用详细日志")
-		return 0
+	base := stepConfig{
+		Mode: testMode, Language: language, Target: targets, Ports: ports, TargetsFile: targetsFile,
+		Attempts: attempts, Timeout: timeout, Concurrency: concurrency, Interval: interval, Jitter: jitter, Interleave: interleave,
+		PingScope: model.PingScope(pingScope), PingSort: model.PingSort(pingSort), TCPSort: model.TCPSort(tcpSort),
+		TCPFormat: pt.TCPTextFormat(tcpFormat), TCPDetails: tcpDetails, TGKind: tgKind, TGIP: tgIP, Format: format,
+		Output: []string{model.PlanOutputStdout}, Progress: progress && isTerminal(output),
 	}
-
-	if showVersion {
-		fmt.Fprintln(output, model.PingTestVersion)
-		return 0
+	if strings.TrimSpace(planFile) != "" {
+		base.Mode = "" // 计划文件中每一步自行指定模式
+	}
+	// 校验全局参数并加载 -targets，计划中的每一步在此基础上覆盖
+	base, err = base.validate()
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+		return exitCannotRun
 	}
 
-	// 根据测试模式执行不同的测试
-	var res string
-	runPing := func() string {
-		options := pt.PingOptions{Language: language, Scope: scope, Sort: pingOrder}
-		if runner.pingWithOptions != nil {
-			return runner.pingWithOptions(options)
+	// 单项模式按一步计划执行；china、global 与 -plan 文件共用计划执行器
+	var plan model.Plan
+	switch {
+	case strings.TrimSpace(planFile) != "":
+		loaded, err := model.LoadPlanFile(planFile)
+		if err != nil {
+			fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+			return exitCannotRun
 		}
-		return runner.ping()
-	}
-	switch testMode {
-	case "ori", "": // ori 或空都是默认三网测试
-		res = runPing()
-	case "tgdc":
-		res = runner.telegram()
-	case "web":
-		res = runner.website()
-	case "tcp":
-		format := pt.TCPTextFormat(strings.ToLower(strings.TrimSpace(tcpFormat)))
-		if attempts < 1 || concurrency < 1 || timeout <= 0 || tcpDetails < 1 {
-			fmt.Fprintln(output, "错误: attempts、timeout、concurrency 和 tcp-details 必须大于 0")
-			return 2
+		plan = loaded
+	case builtinPlans[testMode].Steps != nil:
+		if testMode == "china" && strings.EqualFold(strings.TrimSpace(language), "en") {
+			fmt.Fprintln(output, "错误: 英文模式不运行中国大陆目标，请使用 -tm global")
+			return exitCannotRun
 		}
-		if format != pt.TCPTextFormatCompact && format != pt.TCPTextFormatFull {
-			fmt.Fprintln(output, "错误: tcp-format 仅支持 compact 或 full")
-			return 2
+		plan = builtinPlans[testMode]
+		if base.CustomTargets != nil {
+			if plan, err = customBuiltinPlan(plan, base.CustomTargets); err != nil {
+				fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+				return exitCannotRun
+			}
 		}
-		results, err := runner.tcp(ctx, pt.TCPProbeConfig{Attempts: attempts, Timeout: timeout, Concurrency: concurrency}, target)
-		if err != nil {
+	case slices.Contains(model.PlanModes, testMode):
+		plan = model.Plan{Steps: []model.PlanStep{{Mode: testMode}}}
+	default:
+		fmt.Fprintf(output, "错误: 未知的测试模式 '%s'\n", testMode)
+		fmt.Fprintln(output, "支持的模式: ori, tgdc, mtproto, web, tcp, china, global")
+		return exitCannotRun
+	}
+	steps, err := resolvePlan(base, plan)
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+		return exitCannotRun
+	}
+	// 结构化格式的标准输出只包含结果本身，便于管道处理
+	if base.Format == model.FormatText {
+		fmt.Fprintln(output, "项目地址:", Blue("https://github.com/oneclickvirt/pingtest"))
+	}
+	if dryRun {
+		fmt.Fprintln(output, formatPlanDryRun(plan.Name, steps))
+		return 0
+	}
+	var history *pt.ResultEnvelope
+	var store pt.HistoryStore
+	if recordHistory {
+		if store, err = historyStore(historyFile); err != nil {
 			fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
-			return 2
+			return exitCannotRun
 		}
-		if jsonOutput {
-			encoder := json.NewEncoder(output)
-			encoder.SetIndent("", "  ")
-			if err := encoder.Encode(results); err != nil {
-				return 1
+		history = pt.NewResultEnvelope(steps[0].runConfig(runner.rateLimiter()), time.Now())
+	}
+	checkAssertions := len(assertions) > 0 || minSuccessRate > 0
+	var sections []pt.ReportSection
+	var observe func(pt.ReportSection)
+	if history != nil || checkAssertions {
+		observe = func(section pt.ReportSection) {
+			if history != nil {
+				history.Add(section)
 			}
-			return 0
+			sections = append(sections, section)
 		}
-		res = pt.FormatTCPResultsWithOptions(results, pt.TCPFormatOptions{Format: format, MaxDetails: tcpDetails, Sort: tcpOrder, Language: language})
-	case "china":
-		if language == "en" {
-			fmt.Fprintln(output, "错误: 英文模式不运行中国大陆目标，请使用 -tm global")
-			return 2
+	}
+	var exitCode int
+	if strings.TrimSpace(htmlReport) != "" {
+		exitCode = runWithHTMLReport(ctx, output, htmlReport, steps, runner, observe)
+	} else {
+		exitCode = runPlan(ctx, output, steps, runner, observe)
+	}
+	if history != nil {
+		exitCode = max(exitCode, saveHistory(output, store, history, runner.cache(), pt.HistoryRetention{MaxRuns: historyKeep, MaxAge: historyMaxAge}, base.Format))
+	}
+	if checkAssertions {
+		// 无法完成的运行保持退出码 2，断言失败只在结果完整时返回 1
+		failures := pt.CheckAssertions(sections, assertions, minSuccessRate)
+		if len(failures) > 0 {
+			exitCode = max(exitCode, exitAssertionFailed)
+			if base.Format == model.FormatText {
+				fmt.Fprintf(output, "断言失败:\n%s\n", pt.FormatAssertionFailures(failures, 10))
+			}
+		} else if base.Format == model.FormatText {
+			fmt.Fprintln(output, "断言全部通过")
 		}
-		res = strings.Join([]string{runPing(), runner.telegram(), runner.website()}, "\n")
-	case "global":
-		// TG + 网站（不含三网）
-		res1 := runner.telegram()
-		res2 := runner.website()
-		res = res1 + "\n" + res2
-	default:
-		fmt.Fprintf(output, "错误: 未知的测试模式 '%s'\n", testMode)
-		fmt.Fprintln(output, "支持的模式: ori, tgdc, web, tcp, china, global")
-		return 2
 	}
-	fmt.Fprintln(output, indentLegacyOutput(res))
-	return 0
+	return exitCode
+}
+
+func printUsage(output io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintln(output, "用法: pingtest [选项]")
+	fmt.Fprintln(output, "\n选项:")
+	flags.PrintDefaults()
+	fmt.Fprintln(output, "\n示例:")
+	fmt.Fprintln(output, "  pingtest              # 默认模式: 测试国内三网延迟")
+	fmt.Fprintln(output, "  pingtest -tm ori      # 测试国内三网延迟（默认）")
+	fmt.Fprintln(output, "  pingtest -tm tgdc     # 测试 Telegram 数据中心")
+	fmt.Fprintln(output, "  pingtest -tm mtproto  # 测试 Telegram 数据中心 MTProto 握手")
+	fmt.Fprintln(output, "  pingtest -tm web      # 测试流行网站连通性")
+	fmt.Fprintln(output, "  pingtest -tm tcp      # 测试合并目标集的 TCP 握手")
+	fmt.Fprintln(output, "  pingtest -tm china    # 测试国内三网 + TG + 网站")
+	fmt.Fprintln(output, "  pingtest -tm global   # 测试 TG + 网站（不含三网）")
+	fmt.Fprintln(output, "  pingtest -tm tcp -target example.com -ports 22,80,443  # 测试单个主机的多个端口")
+	fmt.Fprintln(output, "  pingtest -tm tcp -targets targets.yaml  # 测试自定义目标文件中的 TCP 目标")
+	fmt.Fprintln(output, "  pingtest -plan plan.yaml -dry-run      # 仅校验测试计划，不执行")
+	fmt.Fprintln(output, "  pingtest -tm web -format markdown      # 输出可直接粘贴的 Markdown 表格")
+	fmt.Fprintln(output, "  pingtest -tm tcp -format ndjson        # 每完成一个目标输出一行 JSON")
+	fmt.Fprintln(output, "  pingtest -tm china -html report.html   # 额外生成 HTML 测试报告")
+	fmt.Fprintln(output, "  pingtest -tm tcp -history              # 保存本次结果到本地历史记录")
+	fmt.Fprintln(output, "  pingtest history list                  # 列出已保存的运行")
+	fmt.Fprintln(output, "  pingtest history show latest           # 查看最近一次运行的结果")
+	fmt.Fprintln(output, "  pingtest diff old.json new.json        # 对比两次运行并标出退化的目标")
+	fmt.Fprintln(output, "  pingtest serve -listen :9116           # Prometheus 指标与 /probe 按需测试")
+	fmt.Fprintln(output, "  pingtest api -listen :9117 -token T    # REST API 远程触发测试并推送进度")
+	fmt.Fprintln(output, "  pingtest daemon daemon.yaml            # 按计划定时测试并发送告警通知")
+	fmt.Fprintln(output, "  pingtest -tm china -rate 20            # 全局限速每秒 20 个包，避免触发机房 IDS")
+	fmt.Fprintln(output, "  pingtest -tm tcp -interleave -interval 1s -jitter 500ms  # 交错采样，避免瞬时抖动集中在单个目标")
+	fmt.Fprintln(output, "  pingtest -tm china -offline            # 只使用缓存或内置的目标列表，不联网下载")
+	fmt.Fprintln(output, "  pingtest -tm tcp -fail-if 'p95>200ms category=ai' -min-success-rate 90  # 作为部署健康检查")
+	fmt.Fprintln(output, "  pingtest -log         # 启用详细日志")
+}
+
+// targetList collects repeated -target flags; each value may also be a
+// comma-separated list.
+type targetList []string
+
+func (list *targetList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *targetList) Set(value string) error {
+	*list = append(*list, splitTargetList(value)...)
+	return nil
+}
+
+// rateFlags holds -rate and -rate-burst, the packet budget shared by every
+// probe of the process.
+type rateFlags struct {
+	rate  float64
+	burst int
+}
+
+func (limits *rateFlags) register(flags *flag.FlagSet) {
+	flags.Float64Var(&limits.rate, "rate", 0, "全局发包速率上限（每秒 ICMP 包、TCP 连接、HTTP 请求与系统 ping 次数之和），0 表示不限")
+	flags.IntVar(&limits.burst, "rate-burst", 0, "-rate 允许的突发数量，默认等于 -rate 向上取整")
+}
+
+// limiter returns the limiter every run of the process shares; nil when
+// -rate is 0.
+func (limits rateFlags) limiter() (*pt.RateLimiter, error) {
+	if limits.rate < 0 || limits.burst < 0 {
+		return nil, errors.New("-rate 与 -rate-burst 不能为负数")
+	}
+	return pt.NewRateLimiter(limits.rate, limits.burst), nil
+}
+
+// cacheFlags holds -offline, -no-cache, -cache-dir and -cache-max-age, the
+// on-disk cache of downloaded target lists and registries.
+type cacheFlags struct {
+	dir      string
+	maxAge   time.Duration
+	offline  bool
+	disabled bool
+}
+
+func (cache *cacheFlags) register(flags *flag.FlagSet) {
+	flags.BoolVar(&cache.offline, "offline", false, "不联网下载目标列表，只使用本地缓存或内置数据")
+	flags.BoolVar(&cache.disabled, "no-cache", false, "不读写目标列表的本地缓存")
+	flags.StringVar(&cache.dir, "cache-dir", "", "目标列表缓存目录（默认为用户缓存目录下的 pingtest）")
+	flags.DurationVar(&cache.maxAge, "cache-max-age", pt.DefaultCacheMaxAge, "缓存的目标列表在该时长内直接使用，超过后向服务器确认是否有更新")
+}
+
+// newCache returns the cache the flags describe; nil when caching is off.
+// Without a usable cache directory the run simply downloads, except when
+// offline.
+func (cache cacheFlags) newCache() (*pt.DiskCache, error) {
+	if cache.offline && cache.disabled {
+		return nil, errors.New("-offline 不能与 -no-cache 同时使用")
+	}
+	if cache.maxAge < 0 {
+		return nil, errors.New("-cache-max-age 不能为负数")
+	}
+	if cache.disabled {
+		return nil, nil
+	}
+	dir := cache.dir
+	if strings.TrimSpace(dir) == "" {
+		defaultDir, err := pt.DefaultCacheDir()
+		if err != nil {
+			if cache.offline {
+				return nil, err
+			}
+			return nil, nil
+		}
+		dir = defaultDir
+	}
+	return pt.NewDiskCache(dir, cache.maxAge, cache.offline), nil
+}
+
+// assertionList collects repeated -fail-if flags, parsed as they are set so
+// a typo fails before any probe runs.
+type assertionList []model.Assertion
+
+func (list *assertionList) String() string {
+	texts := make([]string, 0, len(*list))
+	for _, assertion := range *list {
+		texts = append(texts, assertion.Text)
+	}
+	return strings.Join(texts, "; ")
+}
+
+func (list *assertionList) Set(value string) error {
+	assertion, err := model.ParseAssertion(value)
+	if err != nil {
+		return err
+	}
+	*list = append(*list, assertion)
+	return nil
+}
+
+func splitTargetList(value string) []string {
+	var values []string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			values = append(values, field)
+		}
+	}
+	return values
+}
+
+// parseTCPHost accepts a bare host or IP for -ports; an explicit port would
+// be ambiguous with the port list.
+func parseTCPHost(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if _, _, err := net.SplitHostPort(value); err == nil {
+		return "", fmt.Errorf("使用 -ports 时 -target 不能包含端口: %q", value)
+	}
+	host := strings.Trim(value, "[]")
+	if host == "" {
+		return "", fmt.Errorf("TCP target is empty")
+	}
+	return host, nil
 }
 
 func parseTCPTarget(value string) (model.TCPTarget, error) {
@@ -4,6 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -140,6 +144,34 @@ func TestRunCLIChinaModeRunsAllDocumentedSections(t *testing.T) {
 	}
 }
 
+func TestRunCLIMTProtoModeUsesMTProtoRunner(t *testing.T) {
+	runner, calls := offlineRunner()
+	var output bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"-tm", "mtproto"}, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d, output=%q", exitCode, output.String())
+	}
+	if got := strings.Join(*calls, ","); got != "mtproto" || !strings.Contains(output.String(), "mtproto-result") {
+		t.Fatalf("mtproto dispatch = %q, output=%q", got, output.String())
+	}
+}
+
+func TestRunCLITelegramFilterReachesRunner(t *testing.T) {
+	var got pt.TelegramDCOptions
+	runner := commandRunner{telegramWithOptions: func(options pt.TelegramDCOptions) string { got = options; return "telegram-result" }}
+	var output bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"-tm", "tgdc", "-tg-kind", "production,test", "-tg-ip", "all"}, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d, output=%q", exitCode, output.String())
+	}
+	if got.Filter == nil || strings.Join(got.Filter.Kinds, ",") != "production,test" || len(got.Filter.IPVersions) != 0 {
+		t.Fatalf("unexpected Telegram options: %+v", got)
+	}
+	runner, calls := offlineRunner()
+	output.Reset()
+	if exitCode := runCLI(context.Background(), []string{"-tm", "tgdc", "-tg-kind", "edge"}, &output, runner); exitCode == 0 || len(*calls) != 0 {
+		t.Fatalf("invalid Telegram kind accepted: calls=%v output=%q", *calls, output.String())
+	}
+}
+
 func TestRunCLIRejectsInvalidTCPTextOptionsBeforeRunning(t *testing.T) {
 	for _, args := range [][]string{
 		{"-tm", "tcp", "-tcp-format", "verbose"},
@@ -181,7 +213,26 @@ func offlineRunner() (commandRunner, *[]string) {
 	return commandRunner{
 		ping:     recordText("ping"),
 		telegram: recordText("telegram"),
-		website:  recordText("website"),
+		mtproto: func(context.Context, pt.TelegramDCOptions) string {
+			calls = append(calls, "mtproto")
+			return "mtproto-result"
+		},
+		website: recordText("website"),
+		pingResults: func(_ context.Context, _ pt.PingOptions, _ []model.CustomTarget, onResult func(pt.LatencyResult)) []pt.LatencyResult {
+			calls = append(calls, "ping")
+			return streamLatency(onResult, pt.LatencyResult{Name: "ping-fixture", Host: "192.0.2.1", Protocol: model.ProtocolICMP, Status: pt.LatencyOK, Latency: time.Millisecond})
+		},
+		telegramResults: func(_ context.Context, _ pt.TelegramDCOptions, onResult func(pt.LatencyResult)) []pt.LatencyResult {
+			calls = append(calls, "telegram")
+			return streamLatency(onResult, pt.LatencyResult{Name: "TG-DC1", Host: "192.0.2.2", Location: "MIA USA", Protocol: model.ProtocolICMP, Status: pt.LatencyFailed})
+		},
+		websiteResults: func(_ context.Context, _ []model.CustomTarget, _ pt.WebsiteOptions, onResult func(pt.LatencyResult)) []pt.LatencyResult {
+			calls = append(calls, "website")
+			return streamLatency(onResult,
+				pt.LatencyResult{Name: "Example", Host: "https://example.test", Category: "dev", Protocol: model.ProtocolHTTP, Status: pt.LatencyOK, Latency: 2 * time.Millisecond},
+				pt.LatencyResult{Name: "Pipe|Site", Host: "https://pipe.test", Protocol: model.ProtocolHTTP, Status: pt.LatencyFailed},
+			)
+		},
 		tcp: func(_ context.Context, _ pt.TCPProbeConfig, _ string) ([]pt.TCPResult, error) {
 			calls = append(calls, "tcp")
 			return []pt.TCPResult{{
@@ -199,6 +250,13 @@ func offlineRunner() (commandRunner, *[]string) {
 	}, &calls
 }
 
+func streamLatency(onResult func(pt.LatencyResult), results ...pt.LatencyResult) []pt.LatencyResult {
+	for _, result := range results {
+		onResult(result)
+	}
+	return results
+}
+
 func TestRunCLITCPParametersReachRunnerAndJSONIsClean(t *testing.T) {
 	var gotConfig pt.TCPProbeConfig
 	var gotTarget string
@@ -216,12 +274,15 @@ func TestRunCLITCPParametersReachRunnerAndJSONIsClean(t *testing.T) {
 	if gotConfig.Attempts != 5 || gotConfig.Timeout != 750*time.Millisecond || gotConfig.Concurrency != 7 || gotTarget != "fixture.test:8443" {
 		t.Fatalf("TCP options not forwarded: config=%+v target=%q", gotConfig, gotTarget)
 	}
-	var results []pt.TCPResult
-	if err := json.Unmarshal(output.Bytes(), &results); err != nil {
+	var envelope pt.ResultEnvelope
+	if err := json.Unmarshal(output.Bytes(), &envelope); err != nil {
 		t.Fatalf("stdout is not clean JSON: %v: %q", err, output.String())
 	}
-	if len(results) != 1 || results[0].Attempts != 5 {
-		t.Fatalf("unexpected JSON results: %+v", results)
+	if results := envelope.Results.TCP; len(results) != 1 || results[0].Attempts != 5 {
+		t.Fatalf("unexpected JSON results: %+v", envelope.Results)
+	}
+	if envelope.Schema != pt.ResultSchemaVersion || envelope.Version != model.PingTestVersion || envelope.Config.Attempts != 5 || envelope.Config.Timeout != 750*time.Millisecond || envelope.Config.Concurrency != 7 {
+		t.Fatalf("unexpected envelope metadata: %+v", envelope)
 	}
 }
 
@@ -233,3 +294,628 @@ func TestParseTCPTargetDefaultsPortAndAcceptsIPv6(t *testing.T) {
 		}
 	}
 }
+
+func TestRunCLITargetsFileFeedsEveryProbe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.csv")
+	data := "name,host,port,protocol,category,tags\nGateway,10.0.0.1,,icmp,internal,\nAPI,api.example.test,8443,tcp,internal,prod\nPortal,portal.example.test,,tls,internal,\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var pinged, browsed, dialed []string
+	runner := commandRunner{
+		customPing: func(targets []model.CustomTarget, _ pt.PingOptions) string {
+			for _, target := range model.CustomTargetsByProtocol(targets, model.ProtocolICMP) {
+				pinged = append(pinged, target.Host)
+			}
+			return "custom-ping"
+		},
+		customWebsite: func(targets []model.CustomTarget) string {
+			for _, target := range model.CustomTargetsByProtocol(targets, model.ProtocolHTTP, model.ProtocolTLS) {
+				browsed = append(browsed, target.Website().URL)
+			}
+			return "custom-web"
+		},
+		customTCP: func(_ context.Context, _ pt.TCPProbeConfig, targets []model.CustomTarget) []pt.TCPResult {
+			for _, target := range pt.CustomTCPTargets(targets) {
+				dialed = append(dialed, target.Host)
+			}
+			return []pt.TCPResult{{Target: model.TCPTarget{Name: "API", Host: "api.example.test", Port: 8443}, Attempts: 1, Successful: 1}}
+		},
+	}
+	for _, mode := range []string{"ori", "web", "tcp", "china"} {
+		var output bytes.Buffer
+		if exitCode := runCLI(context.Background(), []string{"-tm", mode, "-targets", path}, &output, runner); exitCode != 0 {
+			t.Fatalf("%s exit code = %d, output=%q", mode, exitCode, output.String())
+		}
+	}
+	if strings.Join(pinged, ",") != "10.0.0.1,10.0.0.1" || strings.Join(browsed, ",") != "https://portal.example.test,https://portal.example.test" {
+		t.Fatalf("unexpected ping/web targets: %v %v", pinged, browsed)
+	}
+	if strings.Join(dialed, ",") != "api.example.test,portal.example.test" {
+		t.Fatalf("unexpected TCP targets: %v", dialed)
+	}
+}
+
+func TestRunCLITargetsFileRejectsConflictsAndInvalidFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.json")
+	if err := os.WriteFile(path, []byte(`[{"name":"API","host":"bad host"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-tm", "tcp", "-targets", path},
+		{"-tm", "tcp", "-targets", path, "-target", "example.test"},
+		{"-tm", "tgdc", "-targets", path},
+		{"-tm", "tcp", "-targets", filepath.Join(t.TempDir(), "missing.json")},
+	} {
+		runner, calls := offlineRunner()
+		var output bytes.Buffer
+		if exitCode := runCLI(context.Background(), args, &output, runner); exitCode != 2 {
+			t.Fatalf("%v exit code = %d, want 2", args, exitCode)
+		}
+		if len(*calls) != 0 || !strings.Contains(output.String(), "错误") {
+			t.Fatalf("%v: calls=%v output=%q", args, *calls, output.String())
+		}
+	}
+}
+
+func TestRunCLIPlanRunsStepsInOrderWithOverrides(t *testing.T) {
+	dir := t.TempDir()
+	sink := filepath.Join(dir, "tcp.txt")
+	plan := filepath.Join(dir, "plan.yaml")
+	data := "name: new-vps\nsteps:\n  - mode: web\n  - name: TCP\n    mode: tcp\n    attempts: 5\n    timeout: 2s\n    output: [stdout, " + sink + "]\n  - mode: tgdc\n    tg_kind: media\n"
+	if err := os.WriteFile(plan, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	runner, calls := offlineRunner()
+	var gotConfig pt.TCPProbeConfig
+	runner.tcp = func(_ context.Context, config pt.TCPProbeConfig, _ string) ([]pt.TCPResult, error) {
+		*calls = append(*calls, "tcp")
+		gotConfig = config
+		return []pt.TCPResult{{Target: model.TCPTarget{Name: "fixture"}, Attempts: config.Attempts, Successful: config.Attempts}}, nil
+	}
+	var gotTelegram pt.TelegramDCOptions
+	runner.telegramWithOptions = func(options pt.TelegramDCOptions) string {
+		*calls = append(*calls, "telegram")
+		gotTelegram = options
+		return "telegram-result"
+	}
+	var output bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"-plan", plan, "-concurrency", "4"}, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d, output=%q", exitCode, output.String())
+	}
+	if got := strings.Join(*calls, ","); got != "website,tcp,telegram" {
+		t.Fatalf("plan dispatch = %q", got)
+	}
+	if gotConfig.Attempts != 5 || gotConfig.Timeout != 2*time.Second || gotConfig.Concurrency != 4 {
+		t.Fatalf("step overrides and flag defaults not applied: %+v", gotConfig)
+	}
+	if gotTelegram.Filter == nil || strings.Join(gotTelegram.Filter.Kinds, ",") != "media" {
+		t.Fatalf("Telegram filter not applied: %+v", gotTelegram)
+	}
+	written, err := os.ReadFile(sink)
+	if err != nil || !strings.Contains(string(written), "== TCP ==") || !strings.Contains(string(written), "fixture") || strings.Contains(string(written), "website-result") {
+		t.Fatalf("file sink = %q, %v", written, err)
+	}
+	if !strings.Contains(output.String(), "website-result") || !strings.Contains(output.String(), "fixture") {
+		t.Fatalf("stdout is missing step output: %q", output.String())
+	}
+}
+
+func TestRunCLIPlanDryRunValidatesWithoutProbing(t *testing.T) {
+	dir := t.TempDir()
+	plan := filepath.Join(dir, "plan.json")
+	if err := os.WriteFile(plan, []byte(`{"name":"check","steps":[{"mode":"tcp","attempts":2},{"mode":"tgdc","tg_kind":"all","tg_ip":"ipv6"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	runner, calls := offlineRunner()
+	var output bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"-plan", plan, "-dry-run"}, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d, output=%q", exitCode, output.String())
+	}
+	if len(*calls) != 0 {
+		t.Fatalf("dry run probed: %v", *calls)
+	}
+	for _, want := range []string{"计划 check 校验通过，共 2 步", "1. tcp attempts=2", "2. tgdc kind=all ip=ipv6"} {
+		if !strings.Contains(output.String(), want) {
+			t.Fatalf("dry run output missing %q: %q", want, output.String())
+		}
+	}
+}
+
+func TestRunCLIPlanRejectsInvalidPlans(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("steps:\n  - mode: web\n  - mode: tcp\n    tcp_sort: fastest\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	valid := filepath.Join(dir, "valid.yaml")
+	if err := os.WriteFile(valid, []byte("steps:\n  - mode: web\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-plan", invalid, "-dry-run"}, "步骤 2: -tcp-sort 仅支持"},
+		{[]string{"-plan", valid, "-tm", "tcp"}, "-plan 与 -tm 不能同时使用"},
+		{[]string{"-plan", filepath.Join(dir, "missing.yaml")}, "错误"},
+	} {
+		runner, calls := offlineRunner()
+		var output bytes.Buffer
+		if exitCode := runCLI(context.Background(), test.args, &output, runner); exitCode != 2 {
+			t.Fatalf("%v exit code = %d, want 2", test.args, exitCode)
+		}
+		if len(*calls) != 0 || !strings.Contains(output.String(), test.want) {
+			t.Fatalf("%v: calls=%v output=%q", test.args, *calls, output.String())
+		}
+	}
+}
+
+func TestRunCLIPortsExpandRepeatedTargets(t *testing.T) {
+	var got []model.TCPTarget
+	runner := commandRunner{tcpTargets: func(_ context.Context, config pt.TCPProbeConfig, targets []model.TCPTarget) []pt.TCPResult {
+		got = targets
+		results := make([]pt.TCPResult, 0, len(targets))
+		for _, target := range targets {
+			result := pt.TCPResult{Target: target, Attempts: config.Attempts, ErrorCounts: map[string]int{pt.TCPErrorRefused: config.Attempts}}
+			if target.Port == 22 {
+				result = pt.TCPResult{Target: target, Attempts: config.Attempts, Successful: config.Attempts, Mean: time.Millisecond}
+			}
+			results = append(results, result)
+		}
+		return results
+	}}
+	var output bytes.Buffer
+	args := []string{"-tm", "tcp", "-target", "a.example.test", "-target", "b.example.test", "-ports", "22,8000-8001"}
+	if exitCode := runCLI(context.Background(), args, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d, output=%q", exitCode, output.String())
+	}
+	var names []string
+	for _, target := range got {
+		names = append(names, target.Name)
+	}
+	if strings.Join(names, ",") != "a.example.test:22,a.example.test:8000,a.example.test:8001,b.example.test:22,b.example.test:8000,b.example.test:8001" {
+		t.Fatalf("unexpected expansion: %v", names)
+	}
+	for _, want := range []string{"汇总 目标:6  开放:2  拒绝:4", "ssh", "http-alt"} {
+		if !strings.Contains(output.String(), want) {
+			t.Fatalf("port output missing %q: %q", want, output.String())
+		}
+	}
+}
+
+func TestRunCLIMultipleTargetsWithoutPortsUseTargetRunner(t *testing.T) {
+	var got []model.TCPTarget
+	runner := commandRunner{tcpTargets: func(_ context.Context, _ pt.TCPProbeConfig, targets []model.TCPTarget) []pt.TCPResult {
+		got = targets
+		return []pt.TCPResult{{Target: targets[0], Attempts: 1, Successful: 1}}
+	}}
+	var output bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"-tm", "tcp", "-target", "a.example.test:8443,b.example.test"}, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d, output=%q", exitCode, output.String())
+	}
+	if len(got) != 2 || got[0].Port != 8443 || got[1].Port != 443 {
+		t.Fatalf("unexpected targets: %+v", got)
+	}
+}
+
+func TestRunCLIRejectsInvalidPorts(t *testing.T) {
+	for _, args := range [][]string{
+		{"-tm", "tcp", "-ports", "22"},
+		{"-tm", "tcp", "-target", "example.test:22", "-ports", "80"},
+		{"-tm", "tcp", "-target", "example.test", "-ports", "80-70"},
+		{"-tm", "tcp", "-target", "example.test", "-ports", "1-5000"},
+		{"-tm", "web", "-target", "example.test", "-ports", "80"},
+	} {
+		runner, calls := offlineRunner()
+		var output bytes.Buffer
+		if exitCode := runCLI(context.Background(), args, &output, runner); exitCode != 2 {
+			t.Fatalf("%v exit code = %d, want 2", args, exitCode)
+		}
+		if len(*calls) != 0 || !strings.Contains(output.String(), "错误") {
+			t.Fatalf("%v: calls=%v output=%q", args, *calls, output.String())
+		}
+	}
+}
+
+func TestRunCLIFormatCSVAndMarkdownWithoutBanner(t *testing.T) {
+	runner, calls := offlineRunner()
+	var output bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"-tm", "web", "-format", "csv"}, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d, output=%q", exitCode, output.String())
+	}
+	want := "name,host,port,location,category,protocol,status,latency_ms\n" +
+		"Example,https://example.test,,,dev,http,ok,2.00\n" +
+		"Pipe|Site,https://pipe.test,,,,http,failed,\n"
+	if output.String() != want || strings.Join(*calls, ",") != "website" {
+		t.Fatalf("csv output = %q calls=%v", output.String(), *calls)
+	}
+
+	output.Reset()
+	if exitCode := runCLI(context.Background(), []string{"-tm", "global", "-format", "markdown"}, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d, output=%q", exitCode, output.String())
+	}
+	for _, value := range []string{"### tgdc\n\n| name ", "\n\n### web\n\n| name ", `| Pipe\|Site `, "| TG-DC1 "} {
+		if !strings.Contains(output.String(), value) {
+			t.Errorf("markdown output %q does not contain %q", output.String(), value)
+		}
+	}
+	if strings.Contains(output.String(), "项目地址") {
+		t.Fatalf("markdown output contains the banner: %q", output.String())
+	}
+}
+
+func TestRunCLIFormatNDJSONStreamsOneLinePerResult(t *testing.T) {
+	runner := commandRunner{
+		tcpTargets: func(_ context.Context, config pt.TCPProbeConfig, targets []model.TCPTarget) []pt.TCPResult {
+			results := make([]pt.TCPResult, 0, len(targets))
+			for _, target := range targets {
+				result := pt.TCPResult{Target: target, Attempts: config.Attempts}
+				config.OnResult(result)
+				results = append(results, result)
+			}
+			return results
+		},
+	}
+	var output bytes.Buffer
+	args := []string{"-tm", "tcp", "-format", "ndjson", "-attempts", "2", "-target", "one.test", "-target", "two.test:8443"}
+	if exitCode := runCLI(context.Background(), args, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d, output=%q", exitCode, output.String())
+	}
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("ndjson lines = %q", lines)
+	}
+	for index, host := range []string{"one.test", "two.test"} {
+		var result pt.TCPResult
+		if err := json.Unmarshal([]byte(lines[index]), &result); err != nil || result.Target.Host != host || result.Attempts != 2 {
+			t.Fatalf("line %d = %q (%v)", index+1, lines[index], err)
+		}
+	}
+}
+
+func TestRunCLIFormatJSONCoversEveryMode(t *testing.T) {
+	runner, _ := offlineRunner()
+	var output bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"-tm", "ori", "-format", "json"}, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d, output=%q", exitCode, output.String())
+	}
+	var envelope pt.ResultEnvelope
+	if err := json.Unmarshal(output.Bytes(), &envelope); err != nil || len(envelope.Results.Ori) != 1 || envelope.Results.Ori[0].Latency != time.Millisecond {
+		t.Fatalf("ori JSON = %q (%v)", output.String(), err)
+	}
+}
+
+func TestRunCLIJSONEnvelopeMergesStepsAndRegistries(t *testing.T) {
+	runner, _ := offlineRunner()
+	telegramResults := runner.telegramResults
+	runner.telegramResults = func(ctx context.Context, options pt.TelegramDCOptions, onResult func(pt.LatencyResult)) []pt.LatencyResult {
+		options.OnRegistry(pt.RegistryInfo{Name: pt.RegistryTelegram, Source: "embedded", Metadata: model.RegistryMetadata{Schema: model.TelegramDCRegistrySchema, Count: 5}})
+		return telegramResults(ctx, options, onResult)
+	}
+	var output bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"-tm", "global", "-format", "json", "-l", "en"}, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d, output=%q", exitCode, output.String())
+	}
+	var envelope pt.ResultEnvelope
+	if err := json.Unmarshal(output.Bytes(), &envelope); err != nil {
+		t.Fatalf("stdout is not a single JSON document: %v: %q", err, output.String())
+	}
+	if strings.Join(envelope.Config.Modes, ",") != "tgdc,web" || envelope.Config.Language != "en" {
+		t.Fatalf("unexpected config: %+v", envelope.Config)
+	}
+	if len(envelope.Results.TGDC) != 1 || len(envelope.Results.Web) != 2 || envelope.Results.TCP != nil {
+		t.Fatalf("unexpected results: %+v", envelope.Results)
+	}
+	if len(envelope.Registries) != 1 || envelope.Registries[0].Name != pt.RegistryTelegram || envelope.Registries[0].Metadata.Count != 5 {
+		t.Fatalf("unexpected registries: %+v", envelope.Registries)
+	}
+	if envelope.StartedAt.IsZero() || envelope.FinishedAt.Before(envelope.StartedAt) || envelope.Host.OS == "" {
+		t.Fatalf("unexpected run metadata: %+v %+v", envelope.StartedAt, envelope.Host)
+	}
+}
+
+func TestRunCLIRejectsUnknownOrConflictingFormats(t *testing.T) {
+	for _, args := range [][]string{
+		{"-format", "xml"},
+		{"-tm", "tcp", "-json", "-format", "csv"},
+	} {
+		runner, calls := offlineRunner()
+		var output bytes.Buffer
+		if exitCode := runCLI(context.Background(), args, &output, runner); exitCode != 2 {
+			t.Fatalf("runCLI(%v) exit code = %d", args, exitCode)
+		}
+		if len(*calls) != 0 || !strings.Contains(output.String(), "-format") {
+			t.Fatalf("runCLI(%v): calls=%v output=%q", args, *calls, output.String())
+		}
+	}
+}
+
+func TestRunCLIHTMLReportCollectsEveryStep(t *testing.T) {
+	runner, calls := offlineRunner()
+	path := filepath.Join(t.TempDir(), "report.html")
+	var output bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"-tm", "global", "-html", path}, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d, output=%q", exitCode, output.String())
+	}
+	if got := strings.Join(*calls, ","); got != "telegram,website" {
+		t.Fatalf("dispatch = %q", got)
+	}
+	for _, value := range []string{"TG-DC1 MIA USA", "Example", "9999", "HTML 报告已写入: " + path} {
+		if !strings.Contains(output.String(), value) {
+			t.Errorf("text output %q does not contain %q", output.String(), value)
+		}
+	}
+	html, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"<h2>Telegram 数据中心</h2>", "<h2>网站连通性</h2>", "https://example.test", model.PingTestVersion} {
+		if !strings.Contains(string(html), value) {
+			t.Errorf("report does not contain %q", value)
+		}
+	}
+}
+
+func TestRunCLIHTMLReportRejectsUnwritablePathBeforeProbing(t *testing.T) {
+	runner, calls := offlineRunner()
+	var output bytes.Buffer
+	path := filepath.Join(t.TempDir(), "missing", "report.html")
+	if exitCode := runCLI(context.Background(), []string{"-tm", "web", "-html", path}, &output, runner); exitCode != 2 {
+		t.Fatalf("runCLI exit code = %d", exitCode)
+	}
+	if len(*calls) != 0 || !strings.Contains(output.String(), "错误:") {
+		t.Fatalf("calls=%v output=%q", *calls, output.String())
+	}
+}
+
+func TestRunCLIHistorySavesRunsAndSubcommandsReadThem(t *testing.T) {
+	runner, _ := offlineRunner()
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	for _, args := range [][]string{
+		{"-tm", "global", "-history", "-history-file", path},
+		{"-tm", "tcp", "-format", "csv", "-history", "-history-file", path},
+		{"-tm", "tcp", "-history", "-history-file", path, "-history-keep", "2"},
+	} {
+		var output bytes.Buffer
+		if exitCode := runCLI(context.Background(), args, &output, runner); exitCode != 0 {
+			t.Fatalf("runCLI %v exit code = %d, output=%q", args, exitCode, output.String())
+		}
+		if text := strings.Contains(output.String(), "历史记录已保存: "); text != (args[2] != "-format") {
+			t.Fatalf("save notice for %v: %q", args, output.String())
+		}
+	}
+	records, err := (pt.HistoryStore{Path: path}).List()
+	if err != nil || len(records) != 2 {
+		t.Fatalf("records = %d, %v", len(records), err)
+	}
+	if len(records[0].Results.TCP) != 1 || records[0].Results.Web != nil || strings.Join(records[1].Config.Modes, ",") != "tcp" {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+
+	var list bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"history", "list", "-history-file", path}, &list, runner); exitCode != 0 {
+		t.Fatalf("history list exit code = %d, output=%q", exitCode, list.String())
+	}
+	if lines := strings.Split(strings.TrimSpace(list.String()), "\n"); len(lines) != 3 || !strings.HasPrefix(lines[1], records[1].ID) {
+		t.Fatalf("history list = %q", list.String())
+	}
+
+	var show bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"history", "show", "latest", "-history-file", path, "-format", "json"}, &show, runner); exitCode != 0 {
+		t.Fatalf("history show exit code = %d, output=%q", exitCode, show.String())
+	}
+	var record pt.HistoryRecord
+	if err := json.Unmarshal(show.Bytes(), &record); err != nil || record.ID != records[1].ID || len(record.Results.TCP) != 1 {
+		t.Fatalf("history show = %q (%v)", show.String(), err)
+	}
+	show.Reset()
+	if exitCode := runCLI(context.Background(), []string{"history", "show", "-history-file", path, records[0].ID}, &show, runner); exitCode != 0 || !strings.Contains(show.String(), "fixture") {
+		t.Fatalf("history show text exit code = %d, output=%q", exitCode, show.String())
+	}
+
+	var prune bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"history", "prune", "-history-file", path, "-keep", "1"}, &prune, runner); exitCode != 0 || !strings.Contains(prune.String(), "已删除 1 条") {
+		t.Fatalf("history prune exit code = %d, output=%q", exitCode, prune.String())
+	}
+	var missing bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"history", "show", "-history-file", path, records[0].ID}, &missing, runner); exitCode != 2 || !strings.Contains(missing.String(), "错误:") {
+		t.Fatalf("pruned record exit code = %d, output=%q", exitCode, missing.String())
+	}
+}
+
+func TestRunCLIDiffComparesSavedRunsAndHistoryIDs(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, p50 time.Duration) string {
+		envelope := pt.NewResultEnvelope(pt.RunConfig{Language: "zh"}, time.Now())
+		envelope.Add(pt.ReportSection{Mode: model.ModeTCP, TCP: []pt.TCPResult{{
+			Target: model.TCPTarget{Name: "fixture", Host: "fixture.test", Port: 443}, Attempts: 1, Successful: 1, P50: p50, P95: p50,
+		}}})
+		data, err := json.Marshal(envelope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	oldPath, samePath, slowPath := write("old.json", 10*time.Millisecond), write("same.json", 12*time.Millisecond), write("slow.json", 90*time.Millisecond)
+	runner, _ := offlineRunner()
+
+	var output bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"diff", oldPath, samePath}, &output, runner); exitCode != 0 || !strings.Contains(output.String(), "退化 0") {
+		t.Fatalf("unchanged diff exit code = %d, output=%q", exitCode, output.String())
+	}
+	output.Reset()
+	if exitCode := runCLI(context.Background(), []string{"diff", "-format", "markdown", oldPath, slowPath}, &output, runner); exitCode != 1 {
+		t.Fatalf("regressed diff exit code = %d, output=%q", exitCode, output.String())
+	}
+	if !strings.HasPrefix(output.String(), "| mode") || !strings.Contains(output.String(), "regression: p50,p95") {
+		t.Fatalf("markdown diff = %q", output.String())
+	}
+	output.Reset()
+	if exitCode := runCLI(context.Background(), []string{"diff", oldPath, slowPath, "-p50", "1s", "-p95", "1s"}, &output, runner); exitCode != 0 {
+		t.Fatalf("threshold override exit code = %d, output=%q", exitCode, output.String())
+	}
+
+	historyPath := filepath.Join(dir, "history.jsonl")
+	for range 2 {
+		if exitCode := runCLI(context.Background(), []string{"-tm", "tcp", "-history", "-history-file", historyPath}, io.Discard, runner); exitCode != 0 {
+			t.Fatalf("history run exit code = %d", exitCode)
+		}
+	}
+	records, err := (pt.HistoryStore{Path: historyPath}).List()
+	if err != nil || len(records) != 2 {
+		t.Fatalf("records = %d, %v", len(records), err)
+	}
+	output.Reset()
+	if exitCode := runCLI(context.Background(), []string{"diff", "-history-file", historyPath, "-format", "json", records[0].ID, "latest"}, &output, runner); exitCode != 0 {
+		t.Fatalf("history diff exit code = %d, output=%q", exitCode, output.String())
+	}
+	var diff pt.RunDiff
+	if err := json.Unmarshal(output.Bytes(), &diff); err != nil || diff.Regressions != 0 || diff.Targets == nil {
+		t.Fatalf("history diff = %q (%v)", output.String(), err)
+	}
+	output.Reset()
+	if exitCode := runCLI(context.Background(), []string{"diff", oldPath, filepath.Join(dir, "missing.json"), "-history-file", historyPath}, &output, runner); exitCode != 2 || !strings.Contains(output.String(), "错误:") {
+		t.Fatalf("missing run exit code = %d, output=%q", exitCode, output.String())
+	}
+}
+
+func TestRunCLIAssertionsSetDistinctExitCodes(t *testing.T) {
+	for _, test := range []struct {
+		args     []string
+		exitCode int
+		output   string
+	}{
+		{[]string{"-tm", "tcp", "-fail-if", "p95>5ms"}, 0, "断言全部通过"},
+		{[]string{"-tm", "tcp", "-fail-if", "loss>5", "-fail-if", "p95>0.5ms category=custom,"}, 0, "断言全部通过"},
+		{[]string{"-tm", "tcp", "-fail-if", "p95>0.5ms"}, exitAssertionFailed, "fixture (fixture.test:443) p95=1.00ms"},
+		{[]string{"-tm", "web", "-min-success-rate", "90"}, exitAssertionFailed, "success rate 50.0% < 90% (1/2)"},
+		{[]string{"-tm", "web", "-format", "csv", "-fail-if", "loss>0"}, exitAssertionFailed, "name,host"},
+		{[]string{"-tm", "web", "-fail-if", "jitter>5"}, exitCannotRun, "jitter"},
+		{[]string{"-tm", "web", "-min-success-rate", "120"}, exitCannotRun, "-min-success-rate"},
+	} {
+		runner, _ := offlineRunner()
+		var output bytes.Buffer
+		if exitCode := runCLI(context.Background(), test.args, &output, runner); exitCode != test.exitCode {
+			t.Errorf("%v exit code = %d, want %d, output=%q", test.args, exitCode, test.exitCode, output.String())
+		}
+		if !strings.Contains(output.String(), test.output) {
+			t.Errorf("%v output %q does not contain %q", test.args, output.String(), test.output)
+		}
+		if strings.Contains(strings.Join(test.args, " "), "csv") && strings.Contains(output.String(), "断言") {
+			t.Errorf("structured output mixed with assertion text: %q", output.String())
+		}
+	}
+
+	runner, _ := offlineRunner()
+	runner.tcp = func(context.Context, pt.TCPProbeConfig, string) ([]pt.TCPResult, error) {
+		return nil, os.ErrDeadlineExceeded
+	}
+	var output bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"-tm", "tcp", "-min-success-rate", "90"}, &output, runner); exitCode != exitCannotRun {
+		t.Fatalf("failed run exit code = %d, output=%q", exitCode, output.String())
+	}
+}
+
+func TestRunCLIRateIsReportedAndRejectsNegativeValues(t *testing.T) {
+	runner, _ := offlineRunner()
+	var output bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"-tm", "web", "-format", "json", "-rate", "20", "-rate-burst", "4"}, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d, output=%q", exitCode, output.String())
+	}
+	var envelope pt.ResultEnvelope
+	if err := json.Unmarshal(output.Bytes(), &envelope); err != nil {
+		t.Fatalf("stdout is not a single JSON document: %v: %q", err, output.String())
+	}
+	if envelope.Config.Rate != 20 || envelope.Config.RateBurst != 4 {
+		t.Fatalf("rate not reported: %+v", envelope.Config)
+	}
+	output.Reset()
+	if exitCode := runCLI(context.Background(), []string{"-tm", "web", "-format", "json"}, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d, output=%q", exitCode, output.String())
+	}
+	envelope = pt.ResultEnvelope{}
+	if err := json.Unmarshal(output.Bytes(), &envelope); err != nil || envelope.Config.Rate != 0 {
+		t.Fatalf("rate limit leaked into the next run: %+v, %v", envelope.Config, err)
+	}
+	output.Reset()
+	if exitCode := runCLI(context.Background(), []string{"-tm", "web", "-rate", "-1"}, &output, runner); exitCode != exitCannotRun || !strings.Contains(output.String(), "-rate") {
+		t.Fatalf("negative rate: exit %d, output %q", exitCode, output.String())
+	}
+}
+
+func TestProductionRunnerPacesProbesWithTheRunClient(t *testing.T) {
+	client := runClient(pt.NewRateLimiter(50, 1), nil)
+	runner := productionCommandRunner().with(client)
+	if runner.client != client || runner.rateLimiter() != client.RateLimiter {
+		t.Fatal("runner is not bound to the run client")
+	}
+	started := time.Now()
+	results := runner.tcpTargets(context.Background(), pt.TCPProbeConfig{
+		Attempts: 4,
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			client, server := net.Pipe()
+			_ = server.Close()
+			return client, nil
+		},
+	}, []model.TCPTarget{{Name: "paced", Host: "paced.test", Port: 443}})
+	if len(results) != 1 || results[0].Successful != 4 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if elapsed := time.Since(started); elapsed < 50*time.Millisecond {
+		t.Fatalf("4 dials at 50/s with burst 1 took %v, want about 60ms", elapsed)
+	}
+}
+
+func TestRunCLIOfflineReportsCacheAndRejectsNoCache(t *testing.T) {
+	runner, _ := offlineRunner()
+	dir := t.TempDir()
+	var output bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"-tm", "web", "-format", "json", "-offline", "-cache-dir", dir}, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d, output=%q", exitCode, output.String())
+	}
+	var envelope pt.ResultEnvelope
+	if err := json.Unmarshal(output.Bytes(), &envelope); err != nil {
+		t.Fatalf("stdout is not a single JSON document: %v: %q", err, output.String())
+	}
+	if envelope.Cache == nil || !envelope.Cache.Offline || envelope.Cache.Dir != dir {
+		t.Fatalf("cache not reported: %+v", envelope.Cache)
+	}
+	output.Reset()
+	if exitCode := runCLI(context.Background(), []string{"-tm", "web", "-format", "json", "-no-cache"}, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d, output=%q", exitCode, output.String())
+	}
+	envelope = pt.ResultEnvelope{}
+	if err := json.Unmarshal(output.Bytes(), &envelope); err != nil || envelope.Cache != nil {
+		t.Fatalf("cache leaked into the next run: %+v, %v", envelope.Cache, err)
+	}
+	output.Reset()
+	if exitCode := runCLI(context.Background(), []string{"-tm", "web", "-offline", "-no-cache"}, &output, runner); exitCode != exitCannotRun || !strings.Contains(output.String(), "-no-cache") {
+		t.Fatalf("-offline -no-cache: exit %d, output %q", exitCode, output.String())
+	}
+}
+
+func TestRunCLIPassesIntervalJitterAndInterleave(t *testing.T) {
+	var gotConfig pt.TCPProbeConfig
+	runner, _ := offlineRunner()
+	runner.tcp = func(_ context.Context, config pt.TCPProbeConfig, _ string) ([]pt.TCPResult, error) {
+		gotConfig = config
+		return nil, nil
+	}
+	var output bytes.Buffer
+	args := []string{"-tm", "tcp", "-interval", "200ms", "-jitter", "50ms", "-interleave"}
+	if exitCode := runCLI(context.Background(), args, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d, output=%q", exitCode, output.String())
+	}
+	if gotConfig.Interval != 200*time.Millisecond || gotConfig.Jitter != 50*time.Millisecond || !gotConfig.Interleave {
+		t.Fatalf("scheduling flags did not reach the runner: %+v", gotConfig)
+	}
+	output.Reset()
+	if exitCode := runCLI(context.Background(), []string{"-tm", "tcp", "-jitter", "-1s"}, &output, runner); exitCode != 2 || !strings.Contains(output.String(), "-jitter") {
+		t.Fatalf("negative jitter: exit %d, output %q", exitCode, output.String())
+	}
+}
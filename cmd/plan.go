@@ -0,0 +1,625 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+	"github.com/oneclickvirt/pingtest/pt"
+)
+
+// builtinPlans keeps the historical china and global compositions. They run
+// through the same plan runner as user plans.
+var builtinPlans = map[string]model.Plan{
+	"china":  {Name: "china", Steps: []model.PlanStep{{Mode: model.ModeOri}, {Mode: model.ModeTGDC}, {Mode: model.ModeWeb}}},
+	"global": {Name: "global", Steps: []model.PlanStep{{Mode: model.ModeTGDC}, {Mode: model.ModeWeb}}},
+}
+
+// stepConfig is one resolved test step: a mode plus every knob that affects
+// it. Single-mode runs resolve one step from the flags; plans resolve one per
+// entry and inherit unset values from the flags.
+type stepConfig struct {
+	Name          string
+	Mode          string
+	Language      string
+	Target        []string
+	Ports         string
+	TCPTargets    []model.TCPTarget
+	TargetsFile   string
+	CustomTargets []model.CustomTarget
+	Attempts      int
+	Timeout       time.Duration
+	Concurrency   int
+	Interval      time.Duration
+	Jitter        time.Duration
+	Interleave    bool
+	PingScope     model.PingScope
+	PingSort      model.PingSort
+	TCPSort       model.TCPSort
+	TCPFormat     pt.TCPTextFormat
+	TCPDetails    int
+	TGKind        string
+	TGIP          string
+	Telegram      pt.TelegramDCOptions
+	Format        string
+	Output        []string
+	// Progress draws a live progress line on the terminal while the step
+	// runs; runPlan sets OnProgress for it.
+	Progress   bool
+	OnProgress func(pt.ProgressEvent)
+}
+
+// defaultStepConfig returns the command-line defaults for mode, for the
+// servers that build steps without the main flag set.
+func defaultStepConfig(mode string) stepConfig {
+	return stepConfig{
+		Mode: mode, Language: "zh", Attempts: 3, Timeout: 5 * time.Second, Concurrency: 16,
+		PingScope: model.PingScopeAuto, PingSort: model.PingSortLatency, TCPSort: model.TCPSortName,
+		TCPFormat: pt.TCPTextFormatCompact, TCPDetails: pt.DefaultTCPCompactDetails, Format: model.FormatText,
+		Output: []string{model.PlanOutputStdout},
+	}
+}
+
+// resolveStep applies a plan step's overrides to base and validates the
+// result. Error texts match the single-mode flag errors.
+func resolveStep(base stepConfig, step model.PlanStep) (stepConfig, error) {
+	config := base
+	config.Name = strings.TrimSpace(step.Name)
+	if step.Mode != "" {
+		config.Mode = strings.ToLower(strings.TrimSpace(step.Mode))
+	}
+	if step.Target != "" {
+		config.Target = splitTargetList(step.Target)
+	}
+	if step.Ports != "" {
+		config.Ports = step.Ports
+	}
+	if step.Targets != "" {
+		config.TargetsFile, config.CustomTargets = step.Targets, nil
+	}
+	if step.Attempts > 0 {
+		config.Attempts = step.Attempts
+	}
+	if timeout, err := step.TimeoutDuration(); err != nil {
+		return stepConfig{}, err
+	} else if timeout > 0 {
+		config.Timeout = timeout
+	}
+	if step.Concurrency > 0 {
+		config.Concurrency = step.Concurrency
+	}
+	if step.PingScope != "" {
+		config.PingScope = model.PingScope(step.PingScope)
+	}
+	if step.PingSort != "" {
+		config.PingSort = model.PingSort(step.PingSort)
+	}
+	if step.TCPSort != "" {
+		config.TCPSort = model.TCPSort(step.TCPSort)
+	}
+	if step.TGKind != "" {
+		config.TGKind = step.TGKind
+	}
+	if step.TGIP != "" {
+		config.TGIP = step.TGIP
+	}
+	if step.Format != "" {
+		config.Format = step.Format
+	}
+	config.Output = step.OutputSinks()
+	return config.validate()
+}
+
+func (config stepConfig) validate() (stepConfig, error) {
+	config.Language = strings.ToLower(strings.TrimSpace(config.Language))
+	config.PingSort = model.PingSort(strings.ToLower(strings.TrimSpace(string(config.PingSort))))
+	config.PingScope = model.PingScope(strings.ToLower(strings.TrimSpace(string(config.PingScope))))
+	config.TCPSort = model.TCPSort(strings.ToLower(strings.TrimSpace(string(config.TCPSort))))
+	config.TCPFormat = pt.TCPTextFormat(strings.ToLower(strings.TrimSpace(string(config.TCPFormat))))
+	config.Format = strings.ToLower(strings.TrimSpace(config.Format))
+	if config.Language != "zh" && config.Language != "en" {
+		return stepConfig{}, errors.New("-l 仅支持 zh 或 en")
+	}
+	if !slices.Contains(model.OutputFormats, config.Format) {
+		return stepConfig{}, errors.New("-format 仅支持 text、json、ndjson、csv 或 markdown")
+	}
+	if config.PingSort != model.PingSortLatency && config.PingSort != model.PingSortName {
+		return stepConfig{}, errors.New("-ping-sort 仅支持 latency 或 name")
+	}
+	if config.PingScope != model.PingScopeAuto && config.PingScope != model.PingScopeChina && config.PingScope != model.PingScopeInternational {
+		return stepConfig{}, errors.New("-ping-scope 仅支持 auto、china 或 international")
+	}
+	if config.Language == "en" && config.PingScope == model.PingScopeChina {
+		return stepConfig{}, errors.New("英文模式不测试中国大陆 Ping 目标")
+	}
+	if config.TCPSort != model.TCPSortName && config.TCPSort != model.TCPSortLatency {
+		return stepConfig{}, errors.New("-tcp-sort 仅支持 name 或 latency")
+	}
+	config.Telegram = pt.TelegramDCOptions{Language: config.Language}
+	if strings.TrimSpace(config.TGKind) != "" || strings.TrimSpace(config.TGIP) != "" {
+		kinds, versions := config.TGKind, config.TGIP
+		if strings.TrimSpace(kinds) == "" {
+			kinds = model.TelegramDCKindProduction
+		}
+		if strings.TrimSpace(versions) == "" {
+			versions = "ipv4"
+		}
+		filter, err := model.ParseTelegramDCFilter(kinds, versions)
+		if err != nil {
+			return stepConfig{}, errors.New("-tg-kind 仅支持 production、media、cdn、test 或 all，-tg-ip 仅支持 ipv4、ipv6 或 all")
+		}
+		config.Telegram.Filter = &filter
+	}
+	if strings.TrimSpace(config.Ports) != "" && config.Mode != model.ModeTCP && config.Mode != "" {
+		return stepConfig{}, errors.New("-ports 仅支持 -tm tcp")
+	}
+	if config.Interval < 0 || config.Jitter < 0 {
+		return stepConfig{}, errors.New("-interval 与 -jitter 不能为负数")
+	}
+	if config.Mode == model.ModeTCP {
+		if config.Attempts < 1 || config.Concurrency < 1 || config.Timeout <= 0 || config.TCPDetails < 1 {
+			return stepConfig{}, errors.New("attempts、timeout、concurrency 和 tcp-details 必须大于 0")
+		}
+		if config.TCPFormat != pt.TCPTextFormatCompact && config.TCPFormat != pt.TCPTextFormatFull {
+			return stepConfig{}, errors.New("tcp-format 仅支持 compact 或 full")
+		}
+		targets, err := resolveTCPTargets(config.Target, config.Ports)
+		if err != nil {
+			return stepConfig{}, err
+		}
+		config.TCPTargets = targets
+	}
+	if strings.TrimSpace(config.TargetsFile) == "" {
+		return config, nil
+	}
+	if len(config.Target) > 0 {
+		return stepConfig{}, errors.New("-target 与 -targets 不能同时使用")
+	}
+	if config.Mode == model.ModeTGDC || config.Mode == model.ModeMTProto {
+		return stepConfig{}, errors.New("-targets 不支持 tgdc 与 mtproto 模式")
+	}
+	if config.CustomTargets == nil {
+		loaded, err := model.LoadCustomTargetFile(config.TargetsFile)
+		if err != nil {
+			return stepConfig{}, err
+		}
+		config.CustomTargets = loaded
+	}
+	switch config.Mode {
+	case model.ModeOri:
+		if len(model.CustomTargetsByProtocol(config.CustomTargets, model.ProtocolICMP)) == 0 {
+			return stepConfig{}, errors.New("目标文件中没有 icmp 目标")
+		}
+	case model.ModeWeb:
+		if len(model.CustomTargetsByProtocol(config.CustomTargets, model.ProtocolHTTP, model.ProtocolTLS)) == 0 {
+			return stepConfig{}, errors.New("目标文件中没有 http 或 tls 目标")
+		}
+	case model.ModeTCP:
+		if len(model.CustomTargetsByProtocol(config.CustomTargets, model.ProtocolTCP, model.ProtocolTLS)) == 0 {
+			return stepConfig{}, errors.New("目标文件中没有 tcp 或 tls 目标")
+		}
+	}
+	return config, nil
+}
+
+// resolveTCPTargets expands -target values and -ports. A single target
+// without -ports is left to the tcp runner, which keeps its historical
+// host[:port] handling; several targets or a port list become explicit
+// TCP targets for RunTCPProbes.
+func resolveTCPTargets(values []string, ports string) ([]model.TCPTarget, error) {
+	if strings.TrimSpace(ports) != "" {
+		if len(values) == 0 {
+			return nil, errors.New("-ports 需要配合 -target 使用")
+		}
+		hosts := make([]string, 0, len(values))
+		for _, value := range values {
+			host, err := parseTCPHost(value)
+			if err != nil {
+				return nil, err
+			}
+			hosts = append(hosts, host)
+		}
+		return pt.TCPPortTargetsForHosts(hosts, ports)
+	}
+	if len(values) < 2 {
+		return nil, nil
+	}
+	targets := make([]model.TCPTarget, 0, len(values))
+	for _, value := range values {
+		target, err := parseTCPTarget(value)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// customBuiltinPlan narrows a built-in plan to the sections a -targets file
+// can feed: its icmp entries replace the Ping step and its http/tls entries
+// replace the website step. Telegram steps have no custom equivalent.
+func customBuiltinPlan(plan model.Plan, targets []model.CustomTarget) (model.Plan, error) {
+	steps := []model.PlanStep{}
+	if len(model.CustomTargetsByProtocol(targets, model.ProtocolICMP)) > 0 {
+		steps = append(steps, model.PlanStep{Mode: model.ModeOri})
+	}
+	if len(model.CustomTargetsByProtocol(targets, model.ProtocolHTTP, model.ProtocolTLS)) > 0 {
+		steps = append(steps, model.PlanStep{Mode: model.ModeWeb})
+	}
+	if len(steps) == 0 {
+		return model.Plan{}, errors.New("目标文件中没有 icmp、http 或 tls 目标")
+	}
+	plan.Steps = steps
+	return plan, nil
+}
+
+// resolvePlan resolves every step against base, naming the failing step.
+func resolvePlan(base stepConfig, plan model.Plan) ([]stepConfig, error) {
+	if err := plan.Validate(); err != nil {
+		return nil, err
+	}
+	if plan.Language != "" {
+		base.Language = plan.Language
+	}
+	steps := make([]stepConfig, 0, len(plan.Steps))
+	for index, step := range plan.Steps {
+		config, err := resolveStep(base, step)
+		if err != nil {
+			return nil, fmt.Errorf("步骤 %d: %w", index+1, err)
+		}
+		steps = append(steps, config)
+	}
+	return steps, nil
+}
+
+// executeStep runs one resolved step and returns its rendered output in
+// the step's format. NDJSON results are written to stream as each target
+// completes; JSON results go to collect for the run's envelope. Both return
+// an empty string. When collect is set the step runs through the structured
+// runners and collect receives its results as one section.
+func executeStep(ctx context.Context, config stepConfig, runner commandRunner, stream io.Writer, collect func(pt.ReportSection)) (string, error) {
+	if config.Format == model.FormatText && collect == nil && config.OnProgress == nil {
+		return executeTextStep(ctx, config, runner)
+	}
+	var (
+		mu        sync.Mutex
+		streamErr error
+	)
+	emit := func(result any) {
+		if config.Format != model.FormatNDJSON {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if streamErr == nil {
+			streamErr = json.NewEncoder(stream).Encode(result)
+		}
+	}
+	section, err := runStructuredStep(ctx, config, runner, emit)
+	if err != nil {
+		return "", err
+	}
+	if collect != nil {
+		collect(section)
+	}
+	switch config.Format {
+	case model.FormatText:
+		return formatTextSection(config, section), nil
+	case model.FormatNDJSON:
+		return "", streamErr
+	case model.FormatCSV:
+		return section.Table().CSV()
+	case model.FormatMarkdown:
+		return section.Table().Markdown(), nil
+	default:
+		return "", nil
+	}
+}
+
+// runStructuredStep runs a step through the structured runners, passing
+// every result to emit as soon as its target finished.
+func runStructuredStep(ctx context.Context, config stepConfig, runner commandRunner, emit func(any)) (pt.ReportSection, error) {
+	section := pt.ReportSection{Title: config.Name, Mode: config.Mode}
+	emitLatency := func(result pt.LatencyResult) { emit(result) }
+	recordRegistry := func(info pt.RegistryInfo) { section.Registries = append(section.Registries, info) }
+	telegram := config.Telegram
+	telegram.OnRegistry = recordRegistry
+	telegram.OnProgress = config.OnProgress
+	switch config.Mode {
+	case model.ModeOri:
+		options := pt.PingOptions{Language: config.Language, Scope: config.PingScope, Sort: config.PingSort, OnProgress: config.OnProgress}
+		section.Latency = runner.pingResults(ctx, options, config.CustomTargets, emitLatency)
+	case model.ModeTGDC:
+		section.Latency = runner.telegramResults(ctx, telegram, emitLatency)
+	case model.ModeMTProto:
+		section.MTProto = runner.mtprotoResults(ctx, telegram, func(result pt.TelegramMTProtoResult) { emit(result) })
+	case model.ModeWeb:
+		section.Latency = runner.websiteResults(ctx, config.CustomTargets, pt.WebsiteOptions{OnProgress: config.OnProgress}, emitLatency)
+	case model.ModeTCP:
+		probeConfig := config.tcpProbeConfig()
+		probeConfig.OnResult = func(result pt.TCPResult) { emit(result) }
+		probeConfig.OnRegistry = recordRegistry
+		probeConfig.OnProgress = config.OnProgress
+		results, err := runTCPStep(ctx, config, runner, probeConfig)
+		if err != nil {
+			return pt.ReportSection{}, err
+		}
+		section.TCP = results
+	default:
+		return pt.ReportSection{}, fmt.Errorf("未知的测试模式 '%s'", config.Mode)
+	}
+	return section, nil
+}
+
+// formatTextSection renders structured results in the same layout as
+// executeTextStep.
+func formatTextSection(config stepConfig, section pt.ReportSection) string {
+	switch config.Mode {
+	case model.ModeOri:
+		return pt.FormatPingResults(section.Latency, config.PingSort, config.Language)
+	case model.ModeTGDC:
+		return pt.FormatTelegramDCResults(section.Latency, config.Language)
+	case model.ModeMTProto:
+		return pt.FormatTelegramMTProtoResults(section.MTProto, config.Language)
+	case model.ModeWeb:
+		return pt.FormatWebsiteResults(section.Latency)
+	default:
+		return formatTCPText(config, section.TCP)
+	}
+}
+
+// executeTextStep renders a step in the historical fixed-width layout.
+func executeTextStep(ctx context.Context, config stepConfig, runner commandRunner) (string, error) {
+	switch config.Mode {
+	case model.ModeOri:
+		options := pt.PingOptions{Language: config.Language, Scope: config.PingScope, Sort: config.PingSort}
+		if config.CustomTargets != nil {
+			return runner.customPing(config.CustomTargets, options), nil
+		}
+		if runner.pingWithOptions != nil {
+			return runner.pingWithOptions(options), nil
+		}
+		return runner.ping(), nil
+	case model.ModeTGDC:
+		if runner.telegramWithOptions != nil {
+			return runner.telegramWithOptions(config.Telegram), nil
+		}
+		return runner.telegram(), nil
+	case model.ModeMTProto:
+		return runner.mtproto(ctx, config.Telegram), nil
+	case model.ModeWeb:
+		if config.CustomTargets != nil {
+			return runner.customWebsite(config.CustomTargets), nil
+		}
+		return runner.website(), nil
+	case model.ModeTCP:
+		results, err := runTCPStep(ctx, config, runner, config.tcpProbeConfig())
+		if err != nil {
+			return "", err
+		}
+		return formatTCPText(config, results), nil
+	default:
+		return "", fmt.Errorf("未知的测试模式 '%s'", config.Mode)
+	}
+}
+
+func formatTCPText(config stepConfig, results []pt.TCPResult) string {
+	if config.Ports != "" {
+		return pt.FormatTCPPortResults(results, config.Language)
+	}
+	return pt.FormatTCPResultsWithOptions(results, pt.TCPFormatOptions{Format: config.TCPFormat, MaxDetails: config.TCPDetails, Sort: config.TCPSort, Language: config.Language})
+}
+
+func (config stepConfig) tcpProbeConfig() pt.TCPProbeConfig {
+	return pt.TCPProbeConfig{
+		Attempts: config.Attempts, Timeout: config.Timeout, Concurrency: config.Concurrency,
+		Interval: config.Interval, Jitter: config.Jitter, Interleave: config.Interleave,
+	}
+}
+
+// runTCPStep picks the TCP target source: a -targets file, an explicit
+// target list, or the runner's single-target and registry handling.
+func runTCPStep(ctx context.Context, config stepConfig, runner commandRunner, probeConfig pt.TCPProbeConfig) ([]pt.TCPResult, error) {
+	switch {
+	case config.CustomTargets != nil:
+		return runner.customTCP(ctx, probeConfig, config.CustomTargets), nil
+	case config.TCPTargets != nil:
+		return runner.tcpTargets(ctx, probeConfig, config.TCPTargets), nil
+	default:
+		var target string
+		if len(config.Target) > 0 {
+			target = config.Target[0]
+		}
+		return runner.tcp(ctx, probeConfig, target)
+	}
+}
+
+// runPlan executes resolved steps in order and writes each result to the
+// step's sinks. File sinks are truncated once per run and shared by every
+// step that names them. A failing step is reported and the remaining steps
+// still run. Text output keeps the historical indentation; the other
+// formats are written verbatim, except JSON steps, which are gathered into
+// one result envelope per sink and written after the last step. A non-nil
+// observe receives the structured results of every successful step, for the
+// HTML report, the history store and assertions.
+func runPlan(ctx context.Context, output io.Writer, steps []stepConfig, runner commandRunner, observe func(pt.ReportSection)) int {
+	started := time.Now()
+	files := map[string]*os.File{}
+	defer func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}()
+	for _, step := range steps {
+		for _, sink := range step.Output {
+			if sink == model.PlanOutputStdout || files[sink] != nil {
+				continue
+			}
+			file, err := os.OpenFile(sink, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+			if err != nil {
+				fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+				return exitCannotRun
+			}
+			files[sink] = file
+		}
+	}
+	sinkWriter := func(sink string) io.Writer {
+		if sink == model.PlanOutputStdout {
+			return output
+		}
+		return files[sink]
+	}
+	envelopes := map[string]*pt.ResultEnvelope{}
+	var envelopeSinks []string
+	exitCode := 0
+	for index, step := range steps {
+		writers := make([]io.Writer, 0, len(step.Output))
+		for _, sink := range step.Output {
+			writers = append(writers, sinkWriter(sink))
+		}
+		writer := io.MultiWriter(writers...)
+		var collect func(pt.ReportSection)
+		if observe != nil || step.Format == model.FormatJSON {
+			collect = func(section pt.ReportSection) {
+				if observe != nil {
+					observe(section)
+				}
+				if step.Format != model.FormatJSON {
+					return
+				}
+				for _, sink := range step.Output {
+					if envelopes[sink] == nil {
+						envelopes[sink] = pt.NewResultEnvelope(step.runConfig(runner.rateLimiter()), started)
+						envelopeSinks = append(envelopeSinks, sink)
+					}
+					envelopes[sink].Add(section)
+				}
+			}
+		}
+		// NDJSON on the terminal already shows each result as it arrives
+		var progress *progressLine
+		if step.Progress && !(step.Format == model.FormatNDJSON && slices.Contains(step.Output, model.PlanOutputStdout)) {
+			label := step.Name
+			if label == "" {
+				label = step.Mode
+			}
+			progress = newProgressLine(output, label)
+			step.OnProgress = progress.observe
+		}
+		res, err := executeStep(ctx, step, runner, writer, collect)
+		if progress != nil {
+			progress.close()
+		}
+		if err != nil {
+			fmt.Fprintf(output, "错误: 步骤 %d: %s\n", index+1, sanitizeErrorText(err.Error()))
+			exitCode = exitCannotRun
+			continue
+		}
+		switch step.Format {
+		case model.FormatText:
+			if step.Name != "" {
+				res = "== " + step.Name + " ==\n" + res
+			}
+			res = indentLegacyOutput(res) + "\n"
+		case model.FormatMarkdown:
+			if len(steps) > 1 || step.Name != "" {
+				title := step.Name
+				if title == "" {
+					title = step.Mode
+				}
+				res = "### " + title + "\n\n" + res
+			}
+			if index > 0 {
+				res = "\n" + res
+			}
+		}
+		if _, err := io.WriteString(writer, res); err != nil {
+			fmt.Fprintf(output, "错误: 步骤 %d: %s\n", index+1, sanitizeErrorText(err.Error()))
+			exitCode = exitCannotRun
+		}
+	}
+	finished := time.Now()
+	for _, sink := range envelopeSinks {
+		envelopes[sink].FinishedAt = finished
+		envelopes[sink].Cache = runner.cache().Info()
+		encoder := json.NewEncoder(sinkWriter(sink))
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(envelopes[sink]); err != nil {
+			fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+			exitCode = exitCannotRun
+		}
+	}
+	return exitCode
+}
+
+// runConfig reports the step's effective settings and the run's rate limit
+// in the result envelope.
+func (config stepConfig) runConfig(limiter *pt.RateLimiter) pt.RunConfig {
+	rate, burst := limiter.Limit()
+	return pt.RunConfig{
+		Language: config.Language, Attempts: config.Attempts, Timeout: config.Timeout, Concurrency: config.Concurrency,
+		PingScope: string(config.PingScope), PingSort: string(config.PingSort), TCPSort: string(config.TCPSort),
+		Rate: rate, RateBurst: burst,
+	}
+}
+
+// formatPlanDryRun describes resolved steps without running any probe.
+func formatPlanDryRun(name string, steps []stepConfig) string {
+	var output strings.Builder
+	if name == "" {
+		name = "-"
+	}
+	fmt.Fprintf(&output, "计划 %s 校验通过，共 %d 步\n", name, len(steps))
+	for index, step := range steps {
+		fmt.Fprintf(&output, "%d. %s", index+1, step.Mode)
+		if step.Name != "" {
+			fmt.Fprintf(&output, " (%s)", step.Name)
+		}
+		switch step.Mode {
+		case model.ModeTCP:
+			fmt.Fprintf(&output, " attempts=%d timeout=%s concurrency=%d sort=%s", step.Attempts, step.Timeout, step.Concurrency, step.TCPSort)
+			if step.Interval > 0 || step.Jitter > 0 {
+				fmt.Fprintf(&output, " interval=%s jitter=%s", step.Interval, step.Jitter)
+			}
+			if step.Interleave {
+				fmt.Fprint(&output, " interleave")
+			}
+		case model.ModeOri:
+			fmt.Fprintf(&output, " scope=%s sort=%s", step.PingScope, step.PingSort)
+		case model.ModeTGDC, model.ModeMTProto:
+			if filter := step.Telegram.Filter; filter != nil {
+				fmt.Fprintf(&output, " kind=%s ip=%s", dryRunList(filter.Kinds), dryRunList(filter.IPVersions))
+			}
+		}
+		fmt.Fprintf(&output, " language=%s", step.Language)
+		if step.Format != model.FormatText {
+			fmt.Fprintf(&output, " format=%s", step.Format)
+		}
+		if len(step.Target) > 0 {
+			fmt.Fprintf(&output, " target=%s", strings.Join(step.Target, ","))
+		}
+		if step.Ports != "" {
+			fmt.Fprintf(&output, " ports=%s (%d)", step.Ports, len(step.TCPTargets))
+		}
+		if step.CustomTargets != nil {
+			fmt.Fprintf(&output, " targets=%d", len(step.CustomTargets))
+		}
+		fmt.Fprintf(&output, " output=%s\n", strings.Join(step.Output, ","))
+	}
+	return strings.TrimSuffix(output.String(), "\n")
+}
+
+func dryRunList(values []string) string {
+	if len(values) == 0 {
+		return "all"
+	}
+	return strings.Join(values, ",")
+}
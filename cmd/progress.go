@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/pt"
+)
+
+// progressRefresh is how often the progress line is redrawn, so the elapsed
+// time keeps moving while a slow target holds the counters still.
+const progressRefresh = 200 * time.Millisecond
+
+// isTerminal reports whether output is an interactive terminal. Pipes,
+// files and test buffers are not, which keeps their output free of
+// progress lines.
+func isTerminal(output io.Writer) bool {
+	file, ok := output.(*os.File)
+	if !ok || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressLine redraws one status line for a running step: targets done out
+// of the targets known so far, failures and elapsed time. close erases it
+// before the step's table is written.
+type progressLine struct {
+	mu        sync.Mutex
+	output    io.Writer
+	label     string
+	started   time.Time
+	total     int
+	completed int
+	failed    int
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+func newProgressLine(output io.Writer, label string) *progressLine {
+	line := &progressLine{output: output, label: label, started: time.Now(), stop: make(chan struct{}), stopped: make(chan struct{})}
+	line.draw()
+	go func() {
+		defer close(line.stopped)
+		ticker := time.NewTicker(progressRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-line.stop:
+				return
+			case <-ticker.C:
+				line.draw()
+			}
+		}
+	}()
+	return line
+}
+
+// observe is the step's OnProgress callback.
+func (line *progressLine) observe(event pt.ProgressEvent) {
+	line.mu.Lock()
+	defer line.mu.Unlock()
+	line.total, line.completed = event.Total, event.Completed
+	if event.Kind == pt.ProgressTargetFinished && progressFailed(event.Result) {
+		line.failed++
+	}
+}
+
+func (line *progressLine) draw() {
+	line.mu.Lock()
+	defer line.mu.Unlock()
+	fmt.Fprintf(line.output, "\r\033[K%s", formatProgress(line.label, line.completed, line.total, line.failed, time.Since(line.started)))
+}
+
+// close stops the redraws and erases the line.
+func (line *progressLine) close() {
+	close(line.stop)
+	<-line.stopped
+	fmt.Fprint(line.output, "\r\033[K")
+}
+
+func formatProgress(label string, completed, total, failed int, elapsed time.Duration) string {
+	counts := fmt.Sprintf("%d/%d", completed, total)
+	if total == 0 {
+		counts = fmt.Sprintf("%d/?", completed)
+	}
+	return fmt.Sprintf("[%s] %s 完成，失败 %d，已用 %s", label, counts, failed, elapsed.Round(100*time.Millisecond))
+}
+
+// progressFailed reports whether a finished target produced no usable
+// measurement.
+func progressFailed(result any) bool {
+	switch result := result.(type) {
+	case pt.TCPResult:
+		return result.Successful == 0
+	case pt.ICMPResult:
+		return result.Received == 0
+	case pt.TelegramMTProtoResult:
+		return !result.Usable
+	case pt.LatencyResult:
+		return result.Status != pt.LatencyOK
+	case pt.ProbeResult:
+		return result.Successful == 0
+	}
+	return false
+}
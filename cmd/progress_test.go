@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+	"github.com/oneclickvirt/pingtest/pt"
+)
+
+func TestRunPlanDrawsProgressAndKeepsTable(t *testing.T) {
+	runner, _ := offlineRunner()
+	runner.websiteResults = func(_ context.Context, _ []model.CustomTarget, options pt.WebsiteOptions, onResult func(pt.LatencyResult)) []pt.LatencyResult {
+		if options.OnProgress == nil {
+			t.Fatal("website runner did not receive a progress observer")
+		}
+		result := pt.LatencyResult{Name: "Down", Host: "https://down.test", Protocol: model.ProtocolHTTP, Status: pt.LatencyFailed}
+		options.OnProgress(pt.ProgressEvent{Kind: pt.ProgressTargetStarted, Total: 1})
+		options.OnProgress(pt.ProgressEvent{Kind: pt.ProgressTargetFinished, Total: 1, Completed: 1, Result: result})
+		return streamLatency(onResult, result)
+	}
+	step := defaultStepConfig(model.ModeWeb)
+	step.Progress = true
+	var output bytes.Buffer
+	if exitCode := runPlan(context.Background(), &output, []stepConfig{step}, runner, nil); exitCode != 0 {
+		t.Fatalf("runPlan exit code = %d: %s", exitCode, output.String())
+	}
+	text := output.String()
+	if !strings.Contains(text, "\r\033[K[web] 0/?") || !strings.Contains(text, "\r\033[K"+" Down") {
+		t.Fatalf("progress line was not drawn and erased before the table: %q", text)
+	}
+	if !strings.Contains(text, "9999 |") {
+		t.Fatalf("website table missing: %q", text)
+	}
+}
+
+func TestRunCLIKeepsPipedOutputFreeOfProgress(t *testing.T) {
+	runner, _ := offlineRunner()
+	var output bytes.Buffer
+	if exitCode := runCLI(context.Background(), []string{"-tm", "web"}, &output, runner); exitCode != 0 {
+		t.Fatalf("runCLI exit code = %d", exitCode)
+	}
+	if strings.Contains(output.String(), "\r") {
+		t.Fatalf("non-terminal output contains progress: %q", output.String())
+	}
+}
+
+func TestFormatProgressCountsFailures(t *testing.T) {
+	if got := formatProgress("ori", 12, 30, 2, 5340*time.Millisecond); got != "[ori] 12/30 完成，失败 2，已用 5.3s" {
+		t.Fatalf("formatProgress = %q", got)
+	}
+	if !progressFailed(pt.TCPResult{Attempts: 3}) || progressFailed(pt.TelegramMTProtoResult{Usable: true}) {
+		t.Fatal("progressFailed misclassified a result")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+	"github.com/oneclickvirt/pingtest/pt"
+)
+
+// runWithHTMLReport runs steps like runPlan and also writes every successful
+// step to a self-contained HTML report; observe still sees every step. The file is created before the first
+// probe so an unwritable path fails without running any test.
+func runWithHTMLReport(ctx context.Context, output io.Writer, path string, steps []stepConfig, runner commandRunner, observe func(pt.ReportSection)) int {
+	file, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+		return exitCannotRun
+	}
+	defer file.Close()
+	hostname, _ := os.Hostname()
+	report := &pt.Report{
+		Version:  model.PingTestVersion,
+		Language: steps[0].Language,
+		Hostname: hostname,
+		Platform: runtime.GOOS + "/" + runtime.GOARCH,
+		Started:  time.Now(),
+	}
+	exitCode := runPlan(ctx, output, steps, runner, func(section pt.ReportSection) {
+		report.Sections = append(report.Sections, section)
+		if observe != nil {
+			observe(section)
+		}
+	})
+	report.Finished = time.Now()
+	if err := pt.WriteHTMLReport(file, *report); err != nil {
+		fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+		return exitCannotRun
+	}
+	if err := file.Close(); err != nil {
+		fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+		return exitCannotRun
+	}
+	if steps[0].Format == model.FormatText {
+		fmt.Fprintf(output, "HTML 报告已写入: %s\n", path)
+	}
+	return exitCode
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+	"github.com/oneclickvirt/pingtest/pt"
+)
+
+// Modules of the metrics server, for -modules and the module parameter of
+// /probe.
+const (
+	serveModuleTCP  = "tcp"
+	serveModuleICMP = "icmp"
+)
+
+// metricsServer runs the periodic probes of "pingtest serve" and answers
+// /metrics and /probe.
+type metricsServer struct {
+	output      io.Writer
+	runner      commandRunner
+	config      stepConfig
+	modules     []string
+	icmpTargets []pt.ICMPTarget
+	icmpCount   int
+	collector   *pt.MetricsCollector
+}
+
+// runServe implements "serve": an exporter that probes on a fixed interval
+// and serves the accumulated metrics until interrupted.
+func runServe(ctx context.Context, output io.Writer, args []string, runner commandRunner) int {
+	var listen, modules, ports, targetsFile string
+	var targets targetList
+	var attempts, concurrency, icmpCount int
+	var interval, timeout time.Duration
+	serveFlag := flag.NewFlagSet("pingtest serve", flag.ContinueOnError)
+	serveFlag.SetOutput(output)
+	serveFlag.StringVar(&listen, "listen", "127.0.0.1:9116", "监听地址，如 :9116 表示所有网卡")
+	serveFlag.DurationVar(&interval, "interval", time.Minute, "周期测试的间隔")
+	serveFlag.StringVar(&modules, "modules", serveModuleTCP, "周期测试的模块: tcp、icmp，可用逗号分隔")
+	serveFlag.Var(&targets, "target", "TCP 模块测试指定 host[:port] 目标，可重复或用逗号分隔")
+	serveFlag.StringVar(&ports, "ports", "", "TCP 模块对 -target 主机测试多个端口，如 22,80,443")
+	serveFlag.StringVar(&targetsFile, "targets", "", "从 JSON、YAML 或 CSV 文件读取自定义目标，tcp/tls 条目供 TCP 模块、icmp 条目供 ICMP 模块使用")
+	serveFlag.IntVar(&attempts, "attempts", 3, "TCP 模块每个目标的尝试次数")
+	serveFlag.DurationVar(&timeout, "timeout", 5*time.Second, "单次握手或 ICMP 测试的超时")
+	serveFlag.IntVar(&concurrency, "concurrency", 16, "最大并发数")
+	serveFlag.IntVar(&icmpCount, "icmp-count", 3, "ICMP 模块每个目标发送的回显请求数")
+	serveFlag.BoolVar(&model.EnableLoger, "log", false, "启用日志记录")
+	var limits rateFlags
+	var cache cacheFlags
+	limits.register(serveFlag)
+	cache.register(serveFlag)
+	if _, err := parseWithPositionals(serveFlag, args); err != nil {
+		return exitCannotRun
+	}
+	limiter, err := limits.limiter()
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", err)
+		return exitCannotRun
+	}
+	diskCache, err := cache.newCache()
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", err)
+		return exitCannotRun
+	}
+	runner = runner.with(runClient(limiter, diskCache))
+	config := defaultStepConfig(model.ModeTCP)
+	config.Target, config.Ports, config.TargetsFile = targets, ports, targetsFile
+	config.Attempts, config.Timeout, config.Concurrency = attempts, timeout, concurrency
+	server, err := newMetricsServer(output, runner, modules, config, icmpCount)
+	if err == nil && interval <= 0 {
+		err = errors.New("-interval 必须大于 0")
+	}
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+		return exitCannotRun
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+		return exitCannotRun
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Fprintf(output, "指标服务已启动: http://%s/metrics（模块 %s，间隔 %s）\n", listener.Addr(), strings.Join(server.modules, ","), interval)
+	go server.loop(ctx, interval)
+	httpServer := &http.Server{Handler: server, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdownCtx)
+	}()
+	if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(output, "错误: %s\n", sanitizeErrorText(err.Error()))
+		return exitCannotRun
+	}
+	return 0
+}
+
+// newMetricsServer validates the module list and resolves the targets of
+// the periodic runs the same way the tcp and ori modes do.
+func newMetricsServer(output io.Writer, runner commandRunner, modules string, config stepConfig, icmpCount int) (*metricsServer, error) {
+	server := &metricsServer{output: output, runner: runner, icmpCount: icmpCount, collector: pt.NewMetricsCollector()}
+	for _, module := range splitTargetList(strings.ToLower(modules)) {
+		if module != serveModuleTCP && module != serveModuleICMP {
+			return nil, errors.New("-modules 仅支持 tcp 或 icmp")
+		}
+		if !slices.Contains(server.modules, module) {
+			server.modules = append(server.modules, module)
+		}
+	}
+	if len(server.modules) == 0 {
+		return nil, errors.New("-modules 仅支持 tcp 或 icmp")
+	}
+	if icmpCount < 1 {
+		return nil, errors.New("-icmp-count 必须大于 0")
+	}
+	if !slices.Contains(server.modules, serveModuleTCP) {
+		config.Mode = model.ModeOri
+	}
+	config, err := config.validate()
+	if err != nil {
+		return nil, err
+	}
+	server.config = config
+	server.icmpTargets = pt.InternationalICMPTargets()
+	if config.CustomTargets != nil {
+		server.icmpTargets = nil
+		for _, target := range model.CustomTargetsByProtocol(config.CustomTargets, model.ProtocolICMP) {
+			server.icmpTargets = append(server.icmpTargets, pt.ICMPTarget{ID: target.Name, Name: target.Name, Host: target.Host})
+		}
+		if slices.Contains(server.modules, serveModuleICMP) && len(server.icmpTargets) == 0 {
+			return nil, errors.New("目标文件中没有 icmp 目标")
+		}
+	}
+	return server, nil
+}
+
+// loop runs every module once immediately and then on each tick until ctx
+// is canceled. A run still in progress delays the next tick instead of
+// overlapping it.
+func (server *metricsServer) loop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		server.runOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (server *metricsServer) runOnce(ctx context.Context) {
+	for _, module := range server.modules {
+		if ctx.Err() != nil {
+			return
+		}
+		started := time.Now()
+		switch module {
+		case serveModuleTCP:
+			results, err := runTCPStep(ctx, server.config, server.runner, server.config.tcpProbeConfig())
+			if err != nil {
+				fmt.Fprintf(server.output, "错误: 周期 TCP 测试失败: %s\n", sanitizeErrorText(err.Error()))
+				continue
+			}
+			server.collector.ObserveTCP(results, started, time.Since(started))
+		case serveModuleICMP:
+			results := server.runner.icmp(ctx, server.icmpTargets, server.icmpConfig(server.config.Timeout))
+			server.collector.ObserveICMP(results, started, time.Since(started))
+		}
+	}
+}
+
+func (server *metricsServer) icmpConfig(timeout time.Duration) pt.ICMPProbeConfig {
+	return pt.ICMPProbeConfig{Count: server.icmpCount, Timeout: timeout, Concurrency: server.config.Concurrency}
+}
+
+func (server *metricsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/metrics":
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = server.collector.WritePrometheus(w)
+	case "/probe":
+		server.serveProbe(w, r)
+	case "/":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "pingtest %s\n\n/metrics  periodic %s results\n/probe?module=tcp&target=host:443\n/probe?module=icmp&target=host\n",
+			model.PingTestVersion, strings.Join(server.modules, ","))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveProbe answers a blackbox_exporter style scrape: it probes one target
+// now and returns only that probe's metrics. The probe finishes within the
+// scrape timeout Prometheus announces, minus a small margin.
+func (server *metricsServer) serveProbe(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	module := strings.ToLower(query.Get("module"))
+	if module == "" {
+		module = serveModuleTCP
+	}
+	target := strings.TrimSpace(query.Get("target"))
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	timeout := server.config.Timeout
+	if header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); header != "" {
+		if seconds, err := strconv.ParseFloat(header, 64); err == nil && seconds > 0 {
+			budget := time.Duration((seconds - 0.5) * float64(time.Second))
+			budget = max(budget, 100*time.Millisecond)
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, budget)
+			defer cancel()
+			timeout = min(timeout, budget)
+		}
+	}
+	started := time.Now()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	switch module {
+	case serveModuleTCP:
+		parsed, err := parseTCPTarget(target)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		config := server.config.tcpProbeConfig()
+		config.Timeout = timeout
+		results := server.runner.tcpTargets(ctx, config, []model.TCPTarget{parsed})
+		if len(results) == 0 {
+			results = []pt.TCPResult{{Target: parsed}}
+		}
+		_ = pt.WriteTCPProbeMetrics(w, results[0], time.Since(started))
+	case serveModuleICMP:
+		host := strings.Trim(target, "[]")
+		results := server.runner.icmp(ctx, []pt.ICMPTarget{{ID: host, Name: host, Host: host}}, server.icmpConfig(timeout))
+		if len(results) == 0 {
+			results = []pt.ICMPResult{{Target: pt.ICMPTarget{Host: host}}}
+		}
+		_ = pt.WriteICMPProbeMetrics(w, results[0], time.Since(started))
+	default:
+		http.Error(w, fmt.Sprintf("unknown module %q", module), http.StatusBadRequest)
+	}
+}
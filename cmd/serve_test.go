@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+	"github.com/oneclickvirt/pingtest/pt"
+)
+
+func serveTestServer(t *testing.T, modules string) (*metricsServer, *[]string) {
+	t.Helper()
+	runner, calls := offlineRunner()
+	runner.icmp = func(_ context.Context, targets []pt.ICMPTarget, config pt.ICMPProbeConfig) []pt.ICMPResult {
+		*calls = append(*calls, "icmp")
+		results := make([]pt.ICMPResult, 0, len(targets))
+		for _, target := range targets {
+			results = append(results, pt.ICMPResult{Target: target, Status: "ok", Sent: config.Count, Received: config.Count, Mean: 4 * time.Millisecond, P95: 4 * time.Millisecond})
+		}
+		return results
+	}
+	runner.tcpTargets = func(_ context.Context, config pt.TCPProbeConfig, targets []model.TCPTarget) []pt.TCPResult {
+		*calls = append(*calls, "tcp-targets:"+config.Timeout.String())
+		return []pt.TCPResult{{Target: targets[0], Attempts: 1, Successful: 1, P95: 3 * time.Millisecond, Samples: []pt.TCPSample{{Attempt: 1, Duration: 3 * time.Millisecond, Success: true}}}}
+	}
+	server, err := newMetricsServer(io.Discard, runner, modules, stepConfig{
+		Mode: model.ModeTCP, Language: "zh", Attempts: 1, Timeout: 5 * time.Second, Concurrency: 4,
+		PingScope: model.PingScopeAuto, PingSort: model.PingSortLatency, TCPSort: model.TCPSortName,
+		TCPFormat: pt.TCPTextFormatCompact, TCPDetails: pt.DefaultTCPCompactDetails, Format: model.FormatText,
+	}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, calls
+}
+
+func TestMetricsServerExposesPeriodicRuns(t *testing.T) {
+	server, calls := serveTestServer(t, "tcp,icmp,tcp")
+	server.runOnce(context.Background())
+	if got := strings.Join(*calls, ","); got != "tcp,icmp" {
+		t.Fatalf("periodic dispatch = %q", got)
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("metrics response = %d %q", recorder.Code, recorder.Header())
+	}
+	for _, value := range []string{`pingtest_tcp_up{name="fixture",host="fixture.test",port="443",category=""} 1`, `pingtest_icmp_up{id="cloudflare"`, `pingtest_runs_total{module="icmp"} 1`} {
+		if !strings.Contains(recorder.Body.String(), value) {
+			t.Errorf("metrics do not contain %q", value)
+		}
+	}
+}
+
+func TestMetricsServerProbeEndpoint(t *testing.T) {
+	server, calls := serveTestServer(t, "tcp")
+	request := httptest.NewRequest(http.MethodGet, "/probe?target=example.test:8443", nil)
+	request.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "2")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "probe_success 1\n") || !strings.Contains(recorder.Body.String(), "probe_tcp_connect_p95_seconds 0.003\n") {
+		t.Fatalf("tcp probe = %d %q", recorder.Code, recorder.Body.String())
+	}
+	if got := strings.Join(*calls, ","); got != "tcp-targets:1.5s" {
+		t.Fatalf("probe dispatch = %q, want scrape timeout budget", got)
+	}
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/probe?module=icmp&target=192.0.2.1", nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "probe_icmp_packets_received 2\n") {
+		t.Fatalf("icmp probe = %d %q", recorder.Code, recorder.Body.String())
+	}
+	for _, path := range []string{"/probe", "/probe?module=udp&target=a.test", "/probe?target=a.test:99999"} {
+		recorder = httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s status = %d", path, recorder.Code)
+		}
+	}
+}
+
+func TestRunCLIServeRejectsInvalidSettingsBeforeListening(t *testing.T) {
+	for _, args := range [][]string{
+		{"serve", "-modules", "udp"},
+		{"serve", "-interval", "0s"},
+		{"serve", "-icmp-count", "0", "-modules", "icmp"},
+		{"serve", "-listen", "256.0.0.1:http"},
+	} {
+		runner, calls := offlineRunner()
+		var output bytes.Buffer
+		if exitCode := runCLI(context.Background(), args, &output, runner); exitCode != exitCannotRun || len(*calls) != 0 || !strings.Contains(output.String(), "错误:") {
+			t.Errorf("%v exit code = %d, calls=%v, output=%q", args, exitCode, *calls, output.String())
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+// snapshotDiff lists how a candidate snapshot differs from the current one,
+// matching targets by host and port.
+type snapshotDiff struct {
+	Added   []model.TCPTarget
+	Removed []model.TCPTarget
+	Changed [][2]model.TCPTarget
+}
+
+func (diff snapshotDiff) empty() bool {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0
+}
+
+func diffSnapshots(current, candidate []model.TCPTarget) snapshotDiff {
+	var diff snapshotDiff
+	before := make(map[string]model.TCPTarget, len(current))
+	for _, target := range current {
+		before[model.TargetKey(target)] = target
+	}
+	after := make(map[string]struct{}, len(candidate))
+	for _, target := range candidate {
+		key := model.TargetKey(target)
+		after[key] = struct{}{}
+		previous, existed := before[key]
+		switch {
+		case !existed:
+			diff.Added = append(diff.Added, target)
+		case previous != target:
+			diff.Changed = append(diff.Changed, [2]model.TCPTarget{previous, target})
+		}
+	}
+	for _, target := range current {
+		if _, kept := after[model.TargetKey(target)]; !kept {
+			diff.Removed = append(diff.Removed, target)
+		}
+	}
+	return diff
+}
+
+// currentSnapshotTargets reads the snapshot at path. A missing or invalid
+// file counts as empty, since the update replaces it either way.
+func currentSnapshotTargets(path string) ([]model.TCPTarget, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read existing snapshot: %w", err)
+	}
+	normalized, err := model.NormalizeTCPTargetRegistrySnapshot(data, 1)
+	if err != nil {
+		return nil, nil
+	}
+	var targets []model.TCPTarget
+	if err := json.Unmarshal(normalized, &targets); err != nil {
+		return nil, nil
+	}
+	return targets, nil
+}
+
+func (diff snapshotDiff) write(output io.Writer) {
+	for _, target := range diff.Added {
+		fmt.Fprintf(output, "+ %s\n", describeTarget(target))
+	}
+	for _, target := range diff.Removed {
+		fmt.Fprintf(output, "- %s\n", describeTarget(target))
+	}
+	for _, pair := range diff.Changed {
+		before, after := pair[0], pair[1]
+		var changes []string
+		for _, field := range []struct{ name, before, after string }{
+			{"name", before.Name, after.Name},
+			{"category", before.Category, after.Category},
+			{"source", before.Source, after.Source},
+		} {
+			if field.before != field.after {
+				changes = append(changes, fmt.Sprintf("%s %q -> %q", field.name, field.before, field.after))
+			}
+		}
+		fmt.Fprintf(output, "~ %s: %s\n", model.TargetKey(after), strings.Join(changes, ", "))
+	}
+	fmt.Fprintf(output, "%d added, %d removed, %d changed\n", len(diff.Added), len(diff.Removed), len(diff.Changed))
+}
+
+func describeTarget(target model.TCPTarget) string {
+	description := fmt.Sprintf("%s %s", target.Name, model.TargetKey(target))
+	if target.Category != "" {
+		description += " [" + target.Category + "]"
+	}
+	if target.Source != "" {
+		description += " (" + target.Source + ")"
+	}
+	return description
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -13,8 +16,6 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
-	"strconv"
 	"time"
 
 	"github.com/oneclickvirt/pingtest/model"
@@ -22,65 +23,141 @@ import (
 
 const defaultSourceURL = "https://raw.githubusercontent.com/se-tang/TCPbench/main/backend/scripts/run.sh"
 
-var shellStringPattern = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+// signingKeyEnv holds the base64 signing key when -signing-key is not given,
+// which is how the sync workflow passes its secret.
+const signingKeyEnv = "PINGTEST_REGISTRY_SIGNING_KEY"
 
 type updateConfig struct {
-	Source   string
-	Output   string
-	Manifest string
-	Minimum  int
-	Timeout  time.Duration
+	// Sources are merged in order; the first target for a host and port
+	// wins. Categories maps each source kind's categories.
+	Sources    []targetSource
+	Categories categoryMaps
+	Output     string
+	Manifest   string
+	Signature  string
+	Minimum    int
+	Timeout    time.Duration
+	// DryRun prints the changes to Report without writing anything.
+	DryRun bool
+	Report io.Writer
+	// SigningKey signs a rewritten manifest; TrustedKeys must contain its
+	// public half, and default to the keys compiled into pingtest.
+	SigningKey  ed25519.PrivateKey
+	TrustedKeys []ed25519.PublicKey
+	// TelegramManifest, when set, is the Telegram DC registry manifest,
+	// which is signed next to it with the same key.
+	TelegramManifest string
 }
 
 func main() {
-	config := updateConfig{}
-	flag.StringVar(&config.Source, "source", defaultSourceURL, "upstream TCPbench target source")
+	config := updateConfig{Categories: defaultCategoryMaps(), Report: os.Stdout}
+	var sources sourceList
+	var signingKeyPath, generateKeyPath string
+	flag.Var(&sources, "source", "target source KIND=URL-or-file, KIND one of tcpbench, json, csv; or internal for pingtest's website list; repeatable, earlier sources win (default: tcpbench="+defaultSourceURL+")")
+	flag.Var(config.Categories, "category", "category mapping KIND=CATEGORY for uncategorized targets or KIND:FROM=TO; repeatable (default: tcpbench=global)")
+	flag.BoolVar(&config.DryRun, "dry-run", false, "print added, removed and changed targets without writing")
 	flag.StringVar(&config.Output, "output", "model/snapshot/tcp-targets.json", "snapshot output path")
 	flag.StringVar(&config.Manifest, "manifest", "model/snapshot/manifest.json", "snapshot manifest output path")
+	flag.StringVar(&config.Signature, "signature", "", "manifest signature output path (default: manifest path + .sig)")
+	flag.StringVar(&config.TelegramManifest, "telegram-manifest", "model/snapshot/telegram-manifest.json", "Telegram DC manifest to sign into a .sig next to it; empty skips it")
+	flag.StringVar(&signingKeyPath, "signing-key", "", "file holding the base64 ed25519 signing key (default: $"+signingKeyEnv+")")
+	flag.StringVar(&generateKeyPath, "generate-key", "", "write a new signing key to this file, print its public key and exit")
 	flag.IntVar(&config.Minimum, "minimum", 50, "minimum valid targets")
 	flag.DurationVar(&config.Timeout, "timeout", 30*time.Second, "upstream request timeout")
 	flag.Parse()
+	if generateKeyPath != "" {
+		publicKey, err := generateSigningKey(generateKeyPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(publicKey)
+		return
+	}
+	key, err := loadSigningKey(signingKeyPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	config.SigningKey = key
+	config.Sources = sources
+	if len(config.Sources) == 0 {
+		config.Sources = []targetSource{{Kind: sourceTCPBench, Location: defaultSourceURL}}
+	}
 	if err := updateSnapshot(context.Background(), http.DefaultClient, config); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
-func updateSnapshot(ctx context.Context, client *http.Client, config updateConfig) error {
-	if ctx == nil {
-		ctx = context.Background()
+// loadSigningKey reads the key from path, or from signingKeyEnv when path is
+// empty. No key is not an error: an unchanged, validly signed snapshot needs
+// none.
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data := []byte(os.Getenv(signingKeyEnv))
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("read signing key: %w", err)
+		}
 	}
-	if client == nil {
-		client = http.DefaultClient
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
 	}
-	if config.Source == "" || config.Output == "" || config.Minimum < 1 || config.Timeout <= 0 {
-		return errors.New("source, output, minimum, and timeout must be valid")
+	key, err := model.ParseRegistrySigningKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse signing key: %w", err)
 	}
-	requestCtx, cancel := context.WithTimeout(ctx, config.Timeout)
-	defer cancel()
-	request, err := http.NewRequestWithContext(requestCtx, http.MethodGet, config.Source, nil)
+	return key, nil
+}
+
+// generateSigningKey writes a new base64 seed to path, refusing to replace an
+// existing file, and returns the base64 public key to add to pingtest's
+// trusted keys.
+func generateSigningKey(path string) (string, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
-		return errors.New("create target request: invalid source")
+		return "", err
 	}
-	request.Header.Set("Accept", "text/plain")
-	request.Header.Set("User-Agent", "oneclickvirt-pingtest-target-sync/1")
-	response, err := client.Do(request)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
 	if err != nil {
-		return sanitizedFetchError("fetch targets", err)
+		return "", fmt.Errorf("create signing key: %w", err)
 	}
-	defer response.Body.Close()
-	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("fetch targets: HTTP %d", response.StatusCode)
+	if _, err := file.WriteString(base64.StdEncoding.EncodeToString(privateKey.Seed()) + "\n"); err != nil {
+		_ = file.Close()
+		return "", err
 	}
-	source, err := io.ReadAll(io.LimitReader(response.Body, 2<<20))
-	if err != nil {
-		return fmt.Errorf("read targets: %w", err)
+	if err := file.Close(); err != nil {
+		return "", err
 	}
-	targets, err := parseTCPBenchTargets(source)
-	if err != nil {
-		return fmt.Errorf("parse targets: %w", err)
+	return base64.StdEncoding.EncodeToString(publicKey), nil
+}
+
+func updateSnapshot(ctx context.Context, client *http.Client, config updateConfig) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if len(config.Sources) == 0 || config.Output == "" || config.Minimum < 1 || config.Timeout <= 0 {
+		return errors.New("sources, output, minimum, and timeout must be valid")
+	}
+	if config.Categories == nil {
+		config.Categories = defaultCategoryMaps()
 	}
-	raw, err := json.Marshal(targets)
+	lists := make([][]model.TCPTarget, 0, len(config.Sources))
+	for _, source := range config.Sources {
+		targets, err := loadSourceTargets(ctx, client, config.Timeout, source)
+		if err != nil {
+			return err
+		}
+		for index := range targets {
+			targets[index] = config.Categories.apply(source.Kind, targets[index])
+		}
+		lists = append(lists, targets)
+	}
+	raw, err := json.Marshal(mergeSourceTargets(lists))
 	if err != nil {
 		return err
 	}
@@ -88,55 +165,69 @@ func updateSnapshot(ctx context.Context, client *http.Client, config updateConfi
 	if err != nil {
 		return fmt.Errorf("validate targets: %w", err)
 	}
-	return replaceSnapshot(config.Output, config.Manifest, candidate)
+	if err := reportChanges(config, candidate); err != nil {
+		return err
+	}
+	if config.DryRun {
+		return nil
+	}
+	if err := replaceSnapshot(config, candidate); err != nil {
+		return err
+	}
+	if config.TelegramManifest == "" {
+		return nil
+	}
+	return signManifest(config.TelegramManifest, config.SigningKey, config.trustedKeys())
 }
 
-func sanitizedFetchError(action string, err error) error {
-	switch {
-	case errors.Is(err, context.DeadlineExceeded):
-		return fmt.Errorf("%s: request timed out", action)
-	case errors.Is(err, context.Canceled):
-		return fmt.Errorf("%s: request canceled", action)
-	default:
-		return fmt.Errorf("%s: request failed", action)
+// trustedKeys returns TrustedKeys, defaulting to the keys compiled into
+// pingtest.
+func (config updateConfig) trustedKeys() []ed25519.PublicKey {
+	if config.TrustedKeys == nil {
+		return model.TrustedRegistryKeys()
 	}
+	return config.TrustedKeys
 }
 
-func parseTCPBenchTargets(source []byte) ([]model.TCPTarget, error) {
-	names, err := parseShellArray(source, "NAMES")
+// signManifest writes the signature of the manifest at path to path.sig
+// unless a trusted one is already there. The manifest itself is maintained
+// by hand and left as it is.
+func signManifest(path string, key ed25519.PrivateKey, trusted []ed25519.PublicKey) error {
+	manifest, err := os.ReadFile(path)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("read manifest: %w", err)
 	}
-	hosts, err := parseShellArray(source, "HOSTS")
-	if err != nil {
-		return nil, err
+	if signatureMatches(path+".sig", manifest, trusted) {
+		return nil
 	}
-	if len(names) != len(hosts) || len(names) == 0 {
-		return nil, fmt.Errorf("NAMES/HOSTS length mismatch: %d/%d", len(names), len(hosts))
+	if key == nil {
+		return fmt.Errorf("%s must be signed: pass -signing-key or set %s", filepath.Base(path), signingKeyEnv)
 	}
-	targets := make([]model.TCPTarget, len(names))
-	for index := range names {
-		targets[index] = model.TCPTarget{Name: names[index], Host: hosts[index], Port: 443, Category: "global", Source: "tcpbench"}
+	signature := model.SignRegistryManifest(manifest, key)
+	if err := model.VerifyRegistrySignature(manifest, signature, trusted); err != nil {
+		return errors.New("signing key is not one of the trusted registry keys")
 	}
-	return targets, nil
+	return writeAtomicSnapshot(path+".sig", signature)
 }
 
-func parseShellArray(source []byte, name string) ([]string, error) {
-	pattern := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(name) + `=\(([^\n]*)\)$`)
-	match := pattern.FindSubmatch(source)
-	if len(match) != 2 {
-		return nil, fmt.Errorf("%s array not found", name)
+// reportChanges prints the candidate's differences from the current snapshot
+// to config.Report, if any.
+func reportChanges(config updateConfig, candidate []byte) error {
+	if config.Report == nil {
+		return nil
 	}
-	quoted := shellStringPattern.FindAll(match[1], -1)
-	values := make([]string, 0, len(quoted))
-	for _, value := range quoted {
-		decoded, err := strconv.Unquote(string(value))
-		if err != nil {
-			return nil, fmt.Errorf("decode %s value: %w", name, err)
-		}
-		values = append(values, decoded)
+	current, err := currentSnapshotTargets(config.Output)
+	if err != nil {
+		return err
+	}
+	var targets []model.TCPTarget
+	if err := json.Unmarshal(candidate, &targets); err != nil {
+		return err
+	}
+	if diff := diffSnapshots(current, targets); !diff.empty() || config.DryRun {
+		diff.write(config.Report)
 	}
-	return values, nil
+	return nil
 }
 
 type snapshotManifest struct {
@@ -147,7 +238,13 @@ type snapshotManifest struct {
 	GeneratedAt string `json:"generated_at"`
 }
 
-func replaceSnapshot(output, manifestOutput string, candidate []byte) error {
+func replaceSnapshot(config updateConfig, candidate []byte) error {
+	output, manifestOutput := config.Output, config.Manifest
+	signatureOutput := config.Signature
+	if signatureOutput == "" {
+		signatureOutput = manifestOutput + ".sig"
+	}
+	trusted := config.trustedKeys()
 	count, err := snapshotCount(candidate)
 	if err != nil {
 		return err
@@ -162,6 +259,7 @@ func replaceSnapshot(output, manifestOutput string, candidate []byte) error {
 		return err
 	}
 	manifestData = append(manifestData, '\n')
+	writeData := true
 	current, readErr := os.ReadFile(output)
 	if readErr == nil {
 		normalized, normalizeErr := model.NormalizeTCPTargetRegistrySnapshot(current, 1)
@@ -172,19 +270,41 @@ func replaceSnapshot(output, manifestOutput string, candidate []byte) error {
 				return fmt.Errorf("target count dropped from %d to %d", currentCount, candidateCount)
 			}
 			if bytes.Equal(normalized, candidate) && manifestMatches(manifestOutput, candidate, count) {
-				return nil
+				// Keep the existing manifest so its signature stays valid; only
+				// a missing or untrusted signature needs a new one.
+				if manifestData, err = os.ReadFile(manifestOutput); err != nil {
+					return fmt.Errorf("read existing manifest: %w", err)
+				}
+				if signatureMatches(signatureOutput, manifestData, trusted) {
+					return nil
+				}
+				writeData = false
 			}
 		}
 	} else if !errors.Is(readErr, os.ErrNotExist) {
 		return fmt.Errorf("read existing snapshot: %w", readErr)
 	}
-	if err := writeAtomicSnapshot(output, candidate); err != nil {
-		return err
+	if config.SigningKey == nil {
+		return fmt.Errorf("manifest must be signed: pass -signing-key or set %s", signingKeyEnv)
 	}
-	if err := writeAtomicSnapshot(manifestOutput, manifestData); err != nil {
-		return err
+	signature := model.SignRegistryManifest(manifestData, config.SigningKey)
+	if err := model.VerifyRegistrySignature(manifestData, signature, trusted); err != nil {
+		return errors.New("signing key is not one of the trusted registry keys")
 	}
-	return nil
+	if writeData {
+		if err := writeAtomicSnapshot(output, candidate); err != nil {
+			return err
+		}
+		if err := writeAtomicSnapshot(manifestOutput, manifestData); err != nil {
+			return err
+		}
+	}
+	return writeAtomicSnapshot(signatureOutput, signature)
+}
+
+func signatureMatches(path string, manifest []byte, trusted []ed25519.PublicKey) bool {
+	signature, err := os.ReadFile(path)
+	return err == nil && model.VerifyRegistrySignature(manifest, signature, trusted) == nil
 }
 
 func manifestMatches(path string, snapshot []byte, count int) bool {
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"errors"
+	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
 )
 
 type roundTripFunc func(*http.Request) (*http.Response, error)
@@ -32,7 +41,7 @@ func TestUpdateSnapshotRedactsSourceURLFromFetchErrors(t *testing.T) {
 		return nil, errors.New("dial " + source)
 	})}
 	err := updateSnapshot(context.Background(), client, updateConfig{
-		Source: source, Output: t.TempDir() + "/targets.json", Manifest: t.TempDir() + "/manifest.json",
+		Sources: []targetSource{{Kind: sourceTCPBench, Location: source}}, Output: t.TempDir() + "/targets.json", Manifest: t.TempDir() + "/manifest.json",
 		Minimum: 1, Timeout: time.Second,
 	})
 	if err == nil {
@@ -48,3 +57,141 @@ func TestParseTCPBenchTargetsRejectsDrift(t *testing.T) {
 		t.Fatal("mismatched arrays unexpectedly accepted")
 	}
 }
+
+func TestReplaceSnapshotSignsManifest(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	dir := t.TempDir()
+	config := updateConfig{
+		Output: filepath.Join(dir, "tcp-targets.json"), Manifest: filepath.Join(dir, "manifest.json"),
+		TrustedKeys: []ed25519.PublicKey{publicKey},
+	}
+	candidate, err := model.NormalizeTCPTargetRegistrySnapshot([]byte(`[{"name":"One","host":"one.test","port":443}]`), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replaceSnapshot(config, candidate); err == nil {
+		t.Fatal("unsigned manifest unexpectedly written")
+	}
+	_, untrusted, _ := ed25519.GenerateKey(rand.Reader)
+	config.SigningKey = untrusted
+	if err := replaceSnapshot(config, candidate); err == nil {
+		t.Fatal("manifest signed with an untrusted key")
+	}
+	config.SigningKey = privateKey
+	if err := replaceSnapshot(config, candidate); err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ := os.ReadFile(config.Manifest)
+	signature, _ := os.ReadFile(config.Manifest + ".sig")
+	if err := model.VerifyRegistrySignature(manifest, signature, config.TrustedKeys); err != nil {
+		t.Fatal(err)
+	}
+
+	// An unchanged snapshot whose signature went missing is re-signed
+	// without touching the manifest, and needs no key once signed.
+	if err := os.Remove(config.Manifest + ".sig"); err != nil {
+		t.Fatal(err)
+	}
+	if err := replaceSnapshot(config, candidate); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := os.ReadFile(config.Manifest); !bytes.Equal(current, manifest) {
+		t.Fatal("re-signing rewrote the manifest")
+	}
+	config.SigningKey = nil
+	if err := replaceSnapshot(config, candidate); err != nil {
+		t.Fatalf("unchanged signed snapshot: %v", err)
+	}
+}
+
+func TestSignManifestSignsTheTelegramManifestOnce(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	trusted := []ed25519.PublicKey{publicKey}
+	path := filepath.Join(t.TempDir(), "telegram-manifest.json")
+	manifest := []byte(`{"schema":"pingtest.telegram-dcs/v1"}` + "\n")
+	if err := os.WriteFile(path, manifest, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := signManifest(path, nil, trusted); err == nil {
+		t.Fatal("unsigned manifest accepted without a key")
+	}
+	if err := signManifest(path, privateKey, trusted); err != nil {
+		t.Fatal(err)
+	}
+	signature, _ := os.ReadFile(path + ".sig")
+	if err := model.VerifyRegistrySignature(manifest, signature, trusted); err != nil {
+		t.Fatal(err)
+	}
+	if err := signManifest(path, nil, trusted); err != nil {
+		t.Fatalf("signed manifest needs a key again: %v", err)
+	}
+}
+
+func TestParseTargetSourceKeepsBareURLsAsTCPBench(t *testing.T) {
+	for value, want := range map[string]targetSource{
+		"https://example.test/run.sh?token=a=b": {Kind: sourceTCPBench, Location: "https://example.test/run.sh?token=a=b"},
+		"csv=targets.csv":                       {Kind: sourceCSV, Location: "targets.csv"},
+		"internal":                              {Kind: sourceInternal},
+	} {
+		if got, err := parseTargetSource(value); err != nil || got != want {
+			t.Fatalf("parseTargetSource(%q) = %+v, %v", value, got, err)
+		}
+	}
+	if _, err := parseTargetSource("json="); err == nil {
+		t.Fatal("json source without location accepted")
+	}
+}
+
+func TestUpdateSnapshotMergesSourcesAndDryRunPrintsDiff(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "targets.csv")
+	csvData := "name,host,port,category\nShared CSV,shared.test,443,video\nCSV Only,csv.test,8443,video\n"
+	if err := os.WriteFile(csvPath, []byte(csvData), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: roundTripFunc(func(request *http.Request) (*http.Response, error) {
+		body := `NAMES=("Shared" "Bench")` + "\n" + `HOSTS=("Shared.test." "bench.test")` + "\n"
+		if request.URL.Path == "/list.json" {
+			body = `[{"name":"JSON","host":"json.test","category":"ai","extra":true}]`
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: http.Header{}}, nil
+	})}
+	output := filepath.Join(dir, "tcp-targets.json")
+	if err := os.WriteFile(output, []byte(`[{"name":"Bench","host":"bench.test","port":443,"category":"global","source":"tcpbench"},{"name":"Gone","host":"gone.test","port":443}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	categories := defaultCategoryMaps()
+	if err := categories.Set("tcpbench=cloud"); err != nil {
+		t.Fatal(err)
+	}
+	if err := categories.Set("csv:video=streaming"); err != nil {
+		t.Fatal(err)
+	}
+	var report bytes.Buffer
+	err := updateSnapshot(context.Background(), client, updateConfig{
+		Sources: []targetSource{
+			{Kind: sourceTCPBench, Location: "https://example.test/run.sh"},
+			{Kind: sourceJSON, Location: "https://example.test/list.json"},
+			{Kind: sourceCSV, Location: csvPath},
+		},
+		Categories: categories, Output: output, Manifest: filepath.Join(dir, "manifest.json"),
+		Minimum: 1, Timeout: time.Second, DryRun: true, Report: &report,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"+ JSON json.test:443 [ai] (json)",
+		"+ Shared shared.test:443 [cloud] (tcpbench)",
+		"+ CSV Only csv.test:8443 [streaming] (csv)",
+		"- Gone gone.test:443",
+		`~ bench.test:443: category "global" -> "cloud"`,
+		"3 added, 1 removed, 1 changed",
+	}
+	if got := strings.Split(strings.TrimSpace(report.String()), "\n"); !slices.Equal(got, want) {
+		t.Fatalf("dry run report:\n%s", report.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "manifest.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("dry run wrote a manifest")
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+// Source kinds accepted by -source.
+const (
+	sourceTCPBench = "tcpbench"
+	sourceJSON     = "json"
+	sourceCSV      = "csv"
+	sourceInternal = "internal"
+)
+
+var (
+	sourceKinds        = []string{sourceTCPBench, sourceJSON, sourceCSV, sourceInternal}
+	shellStringPattern = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+)
+
+// targetSource is one -source: the adapter kind and the URL or file it reads.
+// The internal source is pingtest's own website list and has no location.
+type targetSource struct {
+	Kind     string
+	Location string
+}
+
+// sourceList collects repeated -source flags.
+type sourceList []targetSource
+
+func (list *sourceList) String() string {
+	values := make([]string, 0, len(*list))
+	for _, source := range *list {
+		values = append(values, source.Kind+"="+source.Location)
+	}
+	return strings.Join(values, ",")
+}
+
+func (list *sourceList) Set(value string) error {
+	source, err := parseTargetSource(value)
+	if err != nil {
+		return err
+	}
+	*list = append(*list, source)
+	return nil
+}
+
+// parseTargetSource reads KIND=LOCATION or "internal". A value without a
+// known kind prefix is a TCPbench run.sh URL, as -source used to take.
+func parseTargetSource(value string) (targetSource, error) {
+	value = strings.TrimSpace(value)
+	if value == sourceInternal {
+		return targetSource{Kind: sourceInternal}, nil
+	}
+	kind, location, found := strings.Cut(value, "=")
+	if !found || !slices.Contains(sourceKinds, kind) {
+		kind, location = sourceTCPBench, value
+	}
+	if kind == sourceInternal {
+		return targetSource{}, errors.New("internal source takes no location")
+	}
+	if location == "" {
+		return targetSource{}, fmt.Errorf("%s source needs a URL or file", kind)
+	}
+	return targetSource{Kind: kind, Location: location}, nil
+}
+
+// categoryMaps maps each source kind's categories onto the registry's. The
+// "" key names the category for targets the source leaves uncategorized.
+type categoryMaps map[string]map[string]string
+
+// defaultCategoryMaps files TCPbench's uncategorized targets as global.
+func defaultCategoryMaps() categoryMaps {
+	return categoryMaps{sourceTCPBench: {"": "global"}}
+}
+
+func (maps categoryMaps) String() string {
+	values := make([]string, 0, len(maps))
+	for kind, mapping := range maps {
+		for from, to := range mapping {
+			if from == "" {
+				values = append(values, kind+"="+to)
+			} else {
+				values = append(values, kind+":"+from+"="+to)
+			}
+		}
+	}
+	slices.Sort(values)
+	return strings.Join(values, ",")
+}
+
+// Set reads KIND=CATEGORY, the category for uncategorized targets, or
+// KIND:FROM=TO, which renames category FROM.
+func (maps categoryMaps) Set(value string) error {
+	left, to, found := strings.Cut(value, "=")
+	kind, from, _ := strings.Cut(left, ":")
+	kind, from, to = strings.TrimSpace(kind), strings.TrimSpace(from), strings.TrimSpace(to)
+	if !found || !slices.Contains(sourceKinds, kind) || to == "" {
+		return fmt.Errorf("category mapping %q must be KIND=CATEGORY or KIND:FROM=TO with KIND one of %s", value, strings.Join(sourceKinds, ", "))
+	}
+	if maps[kind] == nil {
+		maps[kind] = make(map[string]string)
+	}
+	maps[kind][from] = to
+	return nil
+}
+
+func (maps categoryMaps) apply(kind string, target model.TCPTarget) model.TCPTarget {
+	if to, ok := maps[kind][strings.TrimSpace(target.Category)]; ok {
+		target.Category = to
+	}
+	return target
+}
+
+// loadSourceTargets reads and parses one source.
+func loadSourceTargets(ctx context.Context, client *http.Client, timeout time.Duration, source targetSource) ([]model.TCPTarget, error) {
+	if source.Kind == sourceInternal {
+		return model.WebsiteTCPTargets(), nil
+	}
+	data, err := readSource(ctx, client, timeout, source)
+	if err != nil {
+		return nil, err
+	}
+	var targets []model.TCPTarget
+	switch source.Kind {
+	case sourceTCPBench:
+		targets, err = parseTCPBenchTargets(data)
+	case sourceJSON:
+		targets, err = parseJSONTargets(data)
+	case sourceCSV:
+		targets, err = parseCSVTargets(data)
+	default:
+		err = fmt.Errorf("unknown source kind %q", source.Kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s targets: %w", source.Kind, err)
+	}
+	return targets, nil
+}
+
+// readSource downloads an http(s) location or reads a local file. Download
+// errors never include the URL, which may carry a token.
+func readSource(ctx context.Context, client *http.Client, timeout time.Duration, source targetSource) ([]byte, error) {
+	if !strings.HasPrefix(source.Location, "http://") && !strings.HasPrefix(source.Location, "https://") {
+		data, err := os.ReadFile(source.Location)
+		if err != nil {
+			return nil, fmt.Errorf("read %s targets: %w", source.Kind, err)
+		}
+		return data, nil
+	}
+	action := "fetch " + source.Kind + " targets"
+	requestCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(requestCtx, http.MethodGet, source.Location, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid source", action)
+	}
+	request.Header.Set("Accept", "text/plain, application/json, text/csv")
+	request.Header.Set("User-Agent", "oneclickvirt-pingtest-target-sync/1")
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, sanitizedFetchError(action, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: HTTP %d", action, response.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, 2<<20))
+	if err != nil {
+		return nil, fmt.Errorf("read %s targets: %w", source.Kind, err)
+	}
+	return data, nil
+}
+
+func sanitizedFetchError(action string, err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%s: request timed out", action)
+	case errors.Is(err, context.Canceled):
+		return fmt.Errorf("%s: request canceled", action)
+	default:
+		return fmt.Errorf("%s: request failed", action)
+	}
+}
+
+// parseTCPBenchTargets reads the NAMES and HOSTS arrays of TCPbench's run.sh.
+// The entries carry no category; the default mapping files them as global.
+func parseTCPBenchTargets(source []byte) ([]model.TCPTarget, error) {
+	names, err := parseShellArray(source, "NAMES")
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := parseShellArray(source, "HOSTS")
+	if err != nil {
+		return nil, err
+	}
+	if len(names) != len(hosts) || len(names) == 0 {
+		return nil, fmt.Errorf("NAMES/HOSTS length mismatch: %d/%d", len(names), len(hosts))
+	}
+	targets := make([]model.TCPTarget, len(names))
+	for index := range names {
+		targets[index] = model.TCPTarget{Name: names[index], Host: hosts[index], Port: 443, Source: sourceTCPBench}
+	}
+	return targets, nil
+}
+
+func parseShellArray(source []byte, name string) ([]string, error) {
+	pattern := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(name) + `=\(([^\n]*)\)$`)
+	match := pattern.FindSubmatch(source)
+	if len(match) != 2 {
+		return nil, fmt.Errorf("%s array not found", name)
+	}
+	quoted := shellStringPattern.FindAll(match[1], -1)
+	values := make([]string, 0, len(quoted))
+	for _, value := range quoted {
+		decoded, err := strconv.Unquote(string(value))
+		if err != nil {
+			return nil, fmt.Errorf("decode %s value: %w", name, err)
+		}
+		values = append(values, decoded)
+	}
+	return values, nil
+}
+
+// parseJSONTargets reads an array of registry entries. Unknown fields are
+// ignored so lists from other tools can be used as they are.
+func parseJSONTargets(data []byte) ([]model.TCPTarget, error) {
+	var targets []model.TCPTarget
+	if err := json.Unmarshal(data, &targets); err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no targets")
+	}
+	for index := range targets {
+		if strings.TrimSpace(targets[index].Source) == "" {
+			targets[index].Source = sourceJSON
+		}
+	}
+	return targets, nil
+}
+
+// parseCSVTargets reads a CSV whose header names its columns: name and host
+// are required, port (default 443) and category are optional.
+func parseCSVTargets(data []byte) ([]model.TCPTarget, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, errors.New("no targets")
+	}
+	columns := make(map[string]int, len(records[0]))
+	for index, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = index
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("header has no name column")
+	}
+	if _, ok := columns["host"]; !ok {
+		return nil, errors.New("header has no host column")
+	}
+	field := func(record []string, column string) string {
+		if index, ok := columns[column]; ok && index < len(record) {
+			return strings.TrimSpace(record[index])
+		}
+		return ""
+	}
+	targets := make([]model.TCPTarget, 0, len(records)-1)
+	for line, record := range records[1:] {
+		target := model.TCPTarget{Name: field(record, "name"), Host: field(record, "host"), Category: field(record, "category"), Source: sourceCSV}
+		if port := field(record, "port"); port != "" {
+			if target.Port, err = strconv.Atoi(port); err != nil {
+				return nil, fmt.Errorf("line %d: invalid port %q", line+2, port)
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// mergeSourceTargets concatenates the sources' targets in -source order,
+// keeping the first target seen for each host and port. Entries without a
+// name or host are dropped here so they cannot shadow a later valid one.
+func mergeSourceTargets(lists [][]model.TCPTarget) []model.TCPTarget {
+	var merged []model.TCPTarget
+	seen := make(map[string]struct{})
+	for _, targets := range lists {
+		for _, target := range targets {
+			if strings.TrimSpace(target.Name) == "" || strings.TrimSpace(target.Host) == "" {
+				continue
+			}
+			if target.Port == 0 {
+				target.Port = 443
+			}
+			key := model.TargetKey(target)
+			if _, exists := seen[key]; exists {
+				continue
+			}
+			seen[key] = struct{}{}
+			merged = append(merged, target)
+		}
+	}
+	return merged
+}
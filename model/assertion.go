@@ -0,0 +1,141 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Assertion metrics. Loss and success are percentages; the latency metrics
+// are compared in milliseconds.
+const (
+	AssertLoss    = "loss"
+	AssertSuccess = "success"
+	AssertMin     = "min"
+	AssertMean    = "mean"
+	AssertP50     = "p50"
+	AssertP95     = "p95"
+	AssertMax     = "max"
+)
+
+// AssertionMetrics lists the accepted metrics in documentation order.
+var AssertionMetrics = []string{AssertLoss, AssertSuccess, AssertMin, AssertMean, AssertP50, AssertP95, AssertMax}
+
+// AssertionFilterKeys lists the target fields an assertion may be narrowed
+// to.
+var AssertionFilterKeys = []string{"mode", "category", "name", "host"}
+
+// assertionOperators is ordered so two-character operators match first.
+var assertionOperators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// Assertion is one -fail-if condition such as "p95>200ms category=ai". It
+// fails when any target matching every filter satisfies the comparison.
+type Assertion struct {
+	Text     string
+	Metric   string
+	Operator string
+	// Threshold is a percentage for loss and success and milliseconds for
+	// the latency metrics.
+	Threshold float64
+	// Filters maps a key of AssertionFilterKeys to lower-cased alternatives.
+	Filters map[string][]string
+}
+
+// ParseAssertion parses "<metric><op><value> [key=value[,value]...]".
+// Latency values take a Go duration ("200ms", "1.5s") or a bare number of
+// milliseconds; percentages may end with "%". "avg" is accepted for mean.
+func ParseAssertion(text string) (Assertion, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return Assertion{}, errors.New("empty assertion")
+	}
+	assertion := Assertion{Text: strings.Join(fields, " "), Filters: map[string][]string{}}
+	condition := strings.ToLower(fields[0])
+	for _, operator := range assertionOperators {
+		if metric, value, found := strings.Cut(condition, operator); found {
+			assertion.Metric, assertion.Operator = metric, operator
+			threshold, err := parseAssertionValue(metric, value)
+			if err != nil {
+				return Assertion{}, fmt.Errorf("assertion %q: %w", text, err)
+			}
+			assertion.Threshold = threshold
+			break
+		}
+	}
+	if assertion.Operator == "" {
+		return Assertion{}, fmt.Errorf("assertion %q: missing comparison (want one of %s)", text, strings.Join(assertionOperators, " "))
+	}
+	if assertion.Metric == "avg" {
+		assertion.Metric = AssertMean
+	}
+	if !slices.Contains(AssertionMetrics, assertion.Metric) {
+		return Assertion{}, fmt.Errorf("assertion %q: unknown metric %q (want %s)", text, assertion.Metric, strings.Join(AssertionMetrics, ", "))
+	}
+	for _, field := range fields[1:] {
+		key, values, found := strings.Cut(field, "=")
+		key = strings.ToLower(key)
+		if !found || values == "" || !slices.Contains(AssertionFilterKeys, key) {
+			return Assertion{}, fmt.Errorf("assertion %q: invalid filter %q (want %s=value)", text, field, strings.Join(AssertionFilterKeys, "|"))
+		}
+		for _, value := range strings.Split(values, ",") {
+			if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+				assertion.Filters[key] = append(assertion.Filters[key], value)
+			}
+		}
+	}
+	return assertion, nil
+}
+
+func parseAssertionValue(metric, value string) (float64, error) {
+	if value == "" {
+		return 0, errors.New("missing value")
+	}
+	if metric == AssertLoss || metric == AssertSuccess {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return 0, fmt.Errorf("invalid percentage %q", value)
+		}
+		return percent, nil
+	}
+	if milliseconds, err := strconv.ParseFloat(value, 64); err == nil && milliseconds >= 0 {
+		return milliseconds, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return float64(duration) / float64(time.Millisecond), nil
+}
+
+// Compare reports whether value satisfies the assertion's comparison, which
+// means the assertion fails for that target.
+func (assertion Assertion) Compare(value float64) bool {
+	switch assertion.Operator {
+	case ">":
+		return value > assertion.Threshold
+	case ">=":
+		return value >= assertion.Threshold
+	case "<":
+		return value < assertion.Threshold
+	case "<=":
+		return value <= assertion.Threshold
+	case "==":
+		return value == assertion.Threshold
+	default:
+		return value != assertion.Threshold
+	}
+}
+
+// Matches reports whether a target passes every filter of the assertion.
+// fields supplies the target's value for each filter key.
+func (assertion Assertion) Matches(fields map[string]string) bool {
+	for key, values := range assertion.Filters {
+		if !slices.Contains(values, strings.ToLower(fields[key])) {
+			return false
+		}
+	}
+	return true
+}
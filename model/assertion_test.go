@@ -0,0 +1,37 @@
+package model
+
+import "testing"
+
+func TestParseAssertionAcceptsMetricsUnitsAndFilters(t *testing.T) {
+	for text, want := range map[string]Assertion{
+		"loss>5":                            {Metric: AssertLoss, Operator: ">", Threshold: 5},
+		"success<90%":                       {Metric: AssertSuccess, Operator: "<", Threshold: 90},
+		"P95>=200ms":                        {Metric: AssertP95, Operator: ">=", Threshold: 200},
+		"avg>1.5s":                          {Metric: AssertMean, Operator: ">", Threshold: 1500},
+		"max!=250":                          {Metric: AssertMax, Operator: "!=", Threshold: 250},
+		"p50>20ms category=AI,cdn mode=tcp": {Metric: AssertP50, Operator: ">", Threshold: 20},
+	} {
+		got, err := ParseAssertion(text)
+		if err != nil {
+			t.Fatalf("ParseAssertion(%q): %v", text, err)
+		}
+		if got.Metric != want.Metric || got.Operator != want.Operator || got.Threshold != want.Threshold {
+			t.Errorf("ParseAssertion(%q) = %+v", text, got)
+		}
+	}
+	assertion, _ := ParseAssertion("p50>20ms category=AI,cdn mode=tcp")
+	if !assertion.Matches(map[string]string{"category": "ai", "mode": "TCP"}) || assertion.Matches(map[string]string{"category": "dev", "mode": "tcp"}) {
+		t.Fatalf("filters = %+v", assertion.Filters)
+	}
+	if !assertion.Compare(21) || assertion.Compare(20) {
+		t.Fatal("comparison is not strict")
+	}
+}
+
+func TestParseAssertionRejectsInvalidInput(t *testing.T) {
+	for _, text := range []string{"", "loss", "jitter>5", "loss>150", "p95>fast", "p95>-1ms", "loss>5 region=eu", "loss>5 category="} {
+		if _, err := ParseAssertion(text); err == nil {
+			t.Errorf("ParseAssertion(%q) returned no error", text)
+		}
+	}
+}
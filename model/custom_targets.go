@@ -0,0 +1,312 @@
+package model
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 自定义目标支持的探测协议（icmp、tcp 定义于 model.go）
+const (
+	ProtocolHTTP = "http"
+	ProtocolTLS  = "tls"
+)
+
+// customTargetFileLimit bounds user-supplied target files.
+const customTargetFileLimit = 4 << 20
+
+// CustomTarget is one entry of a user-supplied -targets file.
+type CustomTarget struct {
+	Name     string   `json:"name" yaml:"name"`
+	Host     string   `json:"host" yaml:"host"`
+	Port     int      `json:"port,omitempty" yaml:"port,omitempty"`
+	Protocol string   `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	Category string   `json:"category,omitempty" yaml:"category,omitempty"`
+	Tags     []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+// CustomTargetFormat names a supported target file encoding.
+type CustomTargetFormat string
+
+const (
+	CustomTargetFormatAuto CustomTargetFormat = ""
+	CustomTargetFormatJSON CustomTargetFormat = "json"
+	CustomTargetFormatYAML CustomTargetFormat = "yaml"
+	CustomTargetFormatCSV  CustomTargetFormat = "csv"
+)
+
+// customTargetCSVHeader is the required CSV header. Tags are separated by ";".
+var customTargetCSVHeader = []string{"name", "host", "port", "protocol", "category", "tags"}
+
+// LoadCustomTargetFile reads a JSON, YAML or CSV target file. The format is
+// chosen from the file extension and sniffed from the content otherwise.
+func LoadCustomTargetFile(path string) ([]CustomTarget, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open target file: %w", err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, customTargetFileLimit+1))
+	if err != nil {
+		return nil, fmt.Errorf("read target file: %w", err)
+	}
+	if len(data) > customTargetFileLimit {
+		return nil, errors.New("target file is larger than 4 MiB")
+	}
+	return DecodeCustomTargets(data, customTargetFormatForPath(path))
+}
+
+func customTargetFormatForPath(path string) CustomTargetFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return CustomTargetFormatJSON
+	case ".yaml", ".yml":
+		return CustomTargetFormatYAML
+	case ".csv":
+		return CustomTargetFormatCSV
+	default:
+		return CustomTargetFormatAuto
+	}
+}
+
+// DecodeCustomTargets parses and validates target entries. JSON and YAML
+// documents may be a top-level list or an object with a "targets" list.
+// Every entry must pass the TCP registry host, name and port rules; unlike
+// the registry, invalid entries are reported instead of dropped because the
+// file is maintained by the user. Duplicate protocol, host and port
+// combinations keep the first entry.
+func DecodeCustomTargets(data []byte, format CustomTargetFormat) ([]CustomTarget, error) {
+	if format == CustomTargetFormatAuto {
+		format = sniffCustomTargetFormat(data)
+	}
+	var input []CustomTarget
+	var err error
+	switch format {
+	case CustomTargetFormatJSON:
+		input, err = decodeCustomTargetJSON(data)
+	case CustomTargetFormatYAML:
+		input, err = decodeCustomTargetYAML(data)
+	case CustomTargetFormatCSV:
+		input, err = decodeCustomTargetCSV(data)
+	default:
+		return nil, fmt.Errorf("unsupported target file format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode %s target file: %w", format, err)
+	}
+	seen := make(map[string]struct{}, len(input))
+	targets := make([]CustomTarget, 0, len(input))
+	for index, target := range input {
+		target, err := normalizeCustomTarget(target)
+		if err != nil {
+			return nil, fmt.Errorf("target %d: %w", index+1, err)
+		}
+		key := target.Protocol + "/" + targetKey(target.TCPTarget())
+		if _, exists := seen[key]; exists {
+			continue
+		}
+		seen[key] = struct{}{}
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("target file contains no targets")
+	}
+	return targets, nil
+}
+
+func sniffCustomTargetFormat(data []byte) CustomTargetFormat {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		return CustomTargetFormatJSON
+	}
+	firstLine, _, _ := bytes.Cut(trimmed, []byte("\n"))
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(string(firstLine))), "name,") {
+		return CustomTargetFormatCSV
+	}
+	return CustomTargetFormatYAML
+}
+
+func decodeCustomTargetJSON(data []byte) ([]CustomTarget, error) {
+	data = bytes.TrimSpace(data)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var targets []CustomTarget
+	if len(data) > 0 && data[0] == '{' {
+		var document struct {
+			Targets []CustomTarget `json:"targets"`
+		}
+		if err := decoder.Decode(&document); err != nil {
+			return nil, err
+		}
+		targets = document.Targets
+	} else if err := decoder.Decode(&targets); err != nil {
+		return nil, err
+	}
+	if err := ensureTCPTargetJSONEOF(decoder); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+func decodeCustomTargetYAML(data []byte) ([]CustomTarget, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+	if root.Content[0].Kind == yaml.MappingNode {
+		var document struct {
+			Targets []CustomTarget `yaml:"targets"`
+		}
+		if err := decodeStrictYAML(data, &document); err != nil {
+			return nil, err
+		}
+		return document.Targets, nil
+	}
+	var targets []CustomTarget
+	if err := decodeStrictYAML(data, &targets); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+func decodeStrictYAML(data []byte, value any) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(value); err != nil {
+		return err
+	}
+	var extra any
+	if err := decoder.Decode(&extra); err != io.EOF {
+		if err == nil {
+			return errors.New("target file contains more than one YAML document")
+		}
+		return err
+	}
+	return nil
+}
+
+func decodeCustomTargetCSV(data []byte) ([]CustomTarget, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = len(customTargetCSVHeader)
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	for index, column := range customTargetCSVHeader {
+		if strings.ToLower(strings.TrimSpace(header[index])) != column {
+			return nil, fmt.Errorf("CSV header must be %s", strings.Join(customTargetCSVHeader, ","))
+		}
+	}
+	var targets []CustomTarget
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return targets, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		target := CustomTarget{Name: record[0], Host: record[1], Protocol: record[3], Category: record[4]}
+		if portText := strings.TrimSpace(record[2]); portText != "" {
+			target.Port, err = strconv.Atoi(portText)
+			if err != nil {
+				line, _ := reader.FieldPos(2)
+				return nil, fmt.Errorf("line %d: invalid port %q", line, portText)
+			}
+		}
+		target.Tags = strings.Split(record[5], ";")
+		targets = append(targets, target)
+	}
+}
+
+// normalizeCustomTarget applies the TCP registry rules to the host, name and
+// port, then fills protocol defaults. ICMP targets have no port.
+func normalizeCustomTarget(target CustomTarget) (CustomTarget, error) {
+	target.Protocol = strings.ToLower(strings.TrimSpace(target.Protocol))
+	if target.Protocol == "" {
+		target.Protocol = ProtocolTCP
+	}
+	switch target.Protocol {
+	case ProtocolICMP:
+		if target.Port != 0 {
+			return target, errors.New("icmp targets do not take a port")
+		}
+	case ProtocolHTTP:
+		if target.Port == 0 {
+			target.Port = 80
+		}
+	case ProtocolTCP, ProtocolTLS:
+	default:
+		return target, fmt.Errorf("unsupported protocol %q (want icmp, tcp, http or tls)", target.Protocol)
+	}
+	normalized, ok := normalizeTCPTarget(TCPTarget{Name: target.Name, Host: target.Host, Port: target.Port, Category: target.Category})
+	if !ok {
+		return target, fmt.Errorf("invalid name, host or port for %q", strings.TrimSpace(target.Host))
+	}
+	target.Name, target.Host, target.Category = normalized.Name, strings.Trim(normalized.Host, "[]"), normalized.Category
+	if target.Protocol != ProtocolICMP {
+		target.Port = normalized.Port
+	}
+	tags := make([]string, 0, len(target.Tags))
+	for _, tag := range target.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	target.Tags = nil
+	if len(tags) > 0 {
+		target.Tags = tags
+	}
+	return target, nil
+}
+
+// TCPTarget converts the entry for the TCP handshake probe.
+func (target CustomTarget) TCPTarget() TCPTarget {
+	return TCPTarget{Name: target.Name, Host: target.Host, Port: target.Port, Category: target.Category, Source: "custom"}
+}
+
+// Website converts an http or tls entry for the website probe. Default ports
+// are omitted from the URL.
+func (target CustomTarget) Website() Website {
+	scheme, defaultPort := "https", 443
+	if target.Protocol == ProtocolHTTP {
+		scheme, defaultPort = "http", 80
+	}
+	host := target.Host
+	if target.Port != 0 && target.Port != defaultPort {
+		host = net.JoinHostPort(target.Host, strconv.Itoa(target.Port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return Website{Name: target.Name, URL: scheme + "://" + host, Category: target.Category}
+}
+
+// CustomTargetsByProtocol returns the entries using any of protocols, in file
+// order.
+func CustomTargetsByProtocol(targets []CustomTarget, protocols ...string) []CustomTarget {
+	var result []CustomTarget
+	for _, target := range targets {
+		for _, protocol := range protocols {
+			if target.Protocol == protocol {
+				result = append(result, target)
+				break
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,99 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCustomTargetsAcceptsEveryFormat(t *testing.T) {
+	for name, input := range map[string]struct {
+		data   string
+		format CustomTargetFormat
+	}{
+		"json list":   {`[{"name":"API","host":"API.Example.com.","port":8443,"protocol":"tcp","category":"internal","tags":["prod"," "]},{"name":"Gateway","host":"10.0.0.1","protocol":"icmp"}]`, CustomTargetFormatJSON},
+		"json object": {`{"targets":[{"name":"API","host":"api.example.com","port":8443,"category":"internal","tags":["prod"]},{"name":"Gateway","host":"10.0.0.1","protocol":"ICMP"}]}`, CustomTargetFormatAuto},
+		"yaml":        {"targets:\n  - name: API\n    host: api.example.com\n    port: 8443\n    category: internal\n    tags: [prod]\n  - name: Gateway\n    host: 10.0.0.1\n    protocol: icmp\n", CustomTargetFormatYAML},
+		"csv":         {"name,host,port,protocol,category,tags\n# comment\nAPI,api.example.com,8443,tcp,internal,prod\nGateway,10.0.0.1,,icmp,,\n", CustomTargetFormatAuto},
+	} {
+		targets, err := DecodeCustomTargets([]byte(input.data), input.format)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(targets) != 2 {
+			t.Fatalf("%s: unexpected targets %+v", name, targets)
+		}
+		api, gateway := targets[0], targets[1]
+		if api.Name != "API" || api.Host != "api.example.com" || api.Port != 8443 || api.Protocol != ProtocolTCP || api.Category != "internal" || strings.Join(api.Tags, ",") != "prod" {
+			t.Fatalf("%s: unexpected API target %+v", name, api)
+		}
+		if gateway.Protocol != ProtocolICMP || gateway.Port != 0 || gateway.Host != "10.0.0.1" {
+			t.Fatalf("%s: unexpected gateway target %+v", name, gateway)
+		}
+	}
+}
+
+func TestDecodeCustomTargetsRejectsInvalidEntries(t *testing.T) {
+	for name, data := range map[string]string{
+		"bad host":       `[{"name":"x","host":"bad host"}]`,
+		"missing name":   `[{"host":"example.com"}]`,
+		"bad port":       `[{"name":"x","host":"example.com","port":70000}]`,
+		"bad protocol":   `[{"name":"x","host":"example.com","protocol":"udp"}]`,
+		"icmp with port": `[{"name":"x","host":"example.com","port":80,"protocol":"icmp"}]`,
+		"unknown field":  `[{"name":"x","host":"example.com","weight":1}]`,
+		"empty":          `[]`,
+	} {
+		if _, err := DecodeCustomTargets([]byte(data), CustomTargetFormatJSON); err == nil {
+			t.Fatalf("%s: invalid target file was accepted", name)
+		}
+	}
+	if _, err := DecodeCustomTargets([]byte("- name: x\n  host: example.com\n  weight: 1\n"), CustomTargetFormatYAML); err == nil {
+		t.Fatal("YAML unknown field was accepted")
+	}
+	if _, err := DecodeCustomTargets([]byte("host,name,port,protocol,category,tags\nexample.com,x,,,,\n"), CustomTargetFormatCSV); err == nil {
+		t.Fatal("CSV with reordered header was accepted")
+	}
+	_, err := DecodeCustomTargets([]byte(`[{"name":"ok","host":"example.com"},{"name":"x","host":"-bad"}]`), CustomTargetFormatJSON)
+	if err == nil || !strings.Contains(err.Error(), "target 2") {
+		t.Fatalf("error does not name the entry: %v", err)
+	}
+}
+
+func TestCustomTargetDefaultsAndConversions(t *testing.T) {
+	targets, err := DecodeCustomTargets([]byte(`[
+		{"name":"Site","host":"example.com","protocol":"http"},
+		{"name":"Site TLS","host":"example.com","protocol":"tls","port":8443},
+		{"name":"Duplicate","host":"EXAMPLE.com","protocol":"tls","port":8443},
+		{"name":"Raw","host":"example.com"}
+	]`), CustomTargetFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 3 {
+		t.Fatalf("duplicate was not removed: %+v", targets)
+	}
+	if got := targets[0].Website().URL; targets[0].Port != 80 || got != "http://example.com" {
+		t.Fatalf("http target: port %d URL %q", targets[0].Port, got)
+	}
+	if got := targets[1].Website().URL; got != "https://example.com:8443" {
+		t.Fatalf("tls URL = %q", got)
+	}
+	if tcp := targets[2].TCPTarget(); tcp.Port != 443 || tcp.Source != "custom" {
+		t.Fatalf("tcp target = %+v", tcp)
+	}
+	if got := CustomTargetsByProtocol(targets, ProtocolTCP, ProtocolTLS); len(got) != 2 || got[0].Name != "Site TLS" {
+		t.Fatalf("protocol selection = %+v", got)
+	}
+}
+
+func TestLoadCustomTargetFileUsesExtension(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.yml")
+	if err := os.WriteFile(path, []byte("- name: API\n  host: api.example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	targets, err := LoadCustomTargetFile(path)
+	if err != nil || len(targets) != 1 || targets[0].Port != 443 {
+		t.Fatalf("LoadCustomTargetFile = %+v, %v", targets, err)
+	}
+}
@@ -0,0 +1,220 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Notifier types of a daemon config. Webhooks receive the alert as JSON,
+// Slack-compatible endpoints a {"text": ...} message and Telegram bot URLs
+// a sendMessage call.
+const (
+	NotifierWebhook  = "webhook"
+	NotifierSlack    = "slack"
+	NotifierTelegram = "telegram"
+)
+
+// NotifierTypes lists the accepted notifier types in documentation order.
+var NotifierTypes = []string{NotifierWebhook, NotifierSlack, NotifierTelegram}
+
+// DaemonConfig is the file read by "pingtest daemon": scheduled jobs, the
+// alert rules evaluated after every run and where notifications go.
+type DaemonConfig struct {
+	Jobs      []DaemonJob `json:"jobs" yaml:"jobs"`
+	Alerts    []AlertRule `json:"alerts,omitempty" yaml:"alerts,omitempty"`
+	Notifiers []Notifier  `json:"notifiers,omitempty" yaml:"notifiers,omitempty"`
+}
+
+// DaemonJob runs its steps, which take the same fields as plan steps, on a
+// cron schedule.
+type DaemonJob struct {
+	Name     string     `json:"name" yaml:"name"`
+	Schedule string     `json:"schedule" yaml:"schedule"`
+	Language string     `json:"language,omitempty" yaml:"language,omitempty"`
+	Steps    []PlanStep `json:"steps" yaml:"steps"`
+}
+
+// AlertRule fires when its condition, written like a -fail-if assertion,
+// holds for For consecutive runs of the matching jobs, and resolves after
+// ResolveAfter consecutive runs in which it does not. Both default to 1.
+type AlertRule struct {
+	Name         string   `json:"name" yaml:"name"`
+	Job          string   `json:"job,omitempty" yaml:"job,omitempty"`
+	When         string   `json:"when" yaml:"when"`
+	For          int      `json:"for,omitempty" yaml:"for,omitempty"`
+	ResolveAfter int      `json:"resolve_after,omitempty" yaml:"resolve_after,omitempty"`
+	Notify       []string `json:"notify" yaml:"notify"`
+}
+
+// Notifier is a named notification endpoint. ChatID is required for
+// Telegram, whose URL is the bot API base such as
+// https://api.telegram.org/bot<token>.
+type Notifier struct {
+	Name   string `json:"name" yaml:"name"`
+	Type   string `json:"type" yaml:"type"`
+	URL    string `json:"url" yaml:"url"`
+	ChatID string `json:"chat_id,omitempty" yaml:"chat_id,omitempty"`
+}
+
+// Plan returns the job's steps as a plan named after the job.
+func (job DaemonJob) Plan() Plan {
+	return Plan{Name: job.Name, Language: job.Language, Steps: job.Steps}
+}
+
+// Condition parses the rule's condition.
+func (rule AlertRule) Condition() (Assertion, error) {
+	return ParseAssertion(rule.When)
+}
+
+// Threshold returns For with its default applied.
+func (rule AlertRule) Threshold() int {
+	return max(rule.For, 1)
+}
+
+// ResolveThreshold returns ResolveAfter with its default applied.
+func (rule AlertRule) ResolveThreshold() int {
+	return max(rule.ResolveAfter, 1)
+}
+
+// LoadDaemonConfig reads a JSON or YAML daemon config. Relative targets
+// paths of job steps are resolved against the config's directory.
+func LoadDaemonConfig(path string) (DaemonConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return DaemonConfig{}, fmt.Errorf("open daemon config: %w", err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, customTargetFileLimit+1))
+	if err != nil {
+		return DaemonConfig{}, fmt.Errorf("read daemon config: %w", err)
+	}
+	if len(data) > customTargetFileLimit {
+		return DaemonConfig{}, errors.New("daemon config is larger than 4 MiB")
+	}
+	format := customTargetFormatForPath(path)
+	if format == CustomTargetFormatCSV {
+		return DaemonConfig{}, errors.New("daemon configs must be JSON or YAML")
+	}
+	config, err := DecodeDaemonConfig(data, format)
+	if err != nil {
+		return DaemonConfig{}, err
+	}
+	dir := filepath.Dir(path)
+	for _, job := range config.Jobs {
+		for index := range job.Steps {
+			if targets := job.Steps[index].Targets; targets != "" && !filepath.IsAbs(targets) {
+				job.Steps[index].Targets = filepath.Join(dir, targets)
+			}
+		}
+	}
+	return config, nil
+}
+
+// DecodeDaemonConfig parses a daemon config strictly and validates it.
+func DecodeDaemonConfig(data []byte, format CustomTargetFormat) (DaemonConfig, error) {
+	if format == CustomTargetFormatAuto {
+		format = CustomTargetFormatYAML
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+			format = CustomTargetFormatJSON
+		}
+	}
+	var config DaemonConfig
+	switch format {
+	case CustomTargetFormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&config); err != nil {
+			return DaemonConfig{}, fmt.Errorf("decode json daemon config: %w", err)
+		}
+		if err := ensureTCPTargetJSONEOF(decoder); err != nil {
+			return DaemonConfig{}, fmt.Errorf("decode json daemon config: %w", err)
+		}
+	case CustomTargetFormatYAML:
+		if err := decodeStrictYAML(data, &config); err != nil {
+			return DaemonConfig{}, fmt.Errorf("decode yaml daemon config: %w", err)
+		}
+	default:
+		return DaemonConfig{}, fmt.Errorf("unsupported daemon config format %q", format)
+	}
+	if err := config.Validate(); err != nil {
+		return DaemonConfig{}, err
+	}
+	return config, nil
+}
+
+// Validate checks names, schedules, plans, conditions and notifier
+// references without touching the network or files.
+func (config DaemonConfig) Validate() error {
+	if len(config.Jobs) == 0 {
+		return errors.New("daemon config has no jobs")
+	}
+	var jobs, notifiers, alerts []string
+	for index, job := range config.Jobs {
+		name := strings.TrimSpace(job.Name)
+		if name == "" || slices.Contains(jobs, name) {
+			return fmt.Errorf("job %d: name is empty or duplicated", index+1)
+		}
+		jobs = append(jobs, name)
+		if _, err := ParseSchedule(job.Schedule); err != nil {
+			return fmt.Errorf("job %s: %w", name, err)
+		}
+		if err := job.Plan().Validate(); err != nil {
+			return fmt.Errorf("job %s: %w", name, err)
+		}
+		for step, planStep := range job.Steps {
+			if len(planStep.Output) > 0 {
+				return fmt.Errorf("job %s: step %d: daemon jobs do not write output", name, step+1)
+			}
+		}
+	}
+	for index, notifier := range config.Notifiers {
+		name := strings.TrimSpace(notifier.Name)
+		if name == "" || slices.Contains(notifiers, name) {
+			return fmt.Errorf("notifier %d: name is empty or duplicated", index+1)
+		}
+		notifiers = append(notifiers, name)
+		if !slices.Contains(NotifierTypes, notifier.Type) {
+			return fmt.Errorf("notifier %s: unknown type %q (want %s)", name, notifier.Type, strings.Join(NotifierTypes, ", "))
+		}
+		parsed, err := url.Parse(notifier.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("notifier %s: url must be an http or https URL", name)
+		}
+		if notifier.Type == NotifierTelegram && strings.TrimSpace(notifier.ChatID) == "" {
+			return fmt.Errorf("notifier %s: telegram needs chat_id", name)
+		}
+	}
+	for index, rule := range config.Alerts {
+		name := strings.TrimSpace(rule.Name)
+		if name == "" || slices.Contains(alerts, name) {
+			return fmt.Errorf("alert %d: name is empty or duplicated", index+1)
+		}
+		alerts = append(alerts, name)
+		if rule.Job != "" && !slices.Contains(jobs, rule.Job) {
+			return fmt.Errorf("alert %s: unknown job %q", name, rule.Job)
+		}
+		if _, err := rule.Condition(); err != nil {
+			return fmt.Errorf("alert %s: %w", name, err)
+		}
+		if rule.For < 0 || rule.ResolveAfter < 0 {
+			return fmt.Errorf("alert %s: for and resolve_after must not be negative", name)
+		}
+		if len(rule.Notify) == 0 {
+			return fmt.Errorf("alert %s: notify is empty", name)
+		}
+		for _, notifier := range rule.Notify {
+			if !slices.Contains(notifiers, notifier) {
+				return fmt.Errorf("alert %s: unknown notifier %q", name, notifier)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testDaemonConfig = `jobs:
+  - name: core
+    schedule: "*/5 * * * *"
+    steps:
+      - mode: tcp
+        targets: targets.yaml
+alerts:
+  - name: high-loss
+    job: core
+    when: loss>10 category=ai
+    for: 3
+    notify: [ops, chat]
+notifiers:
+  - name: ops
+    type: webhook
+    url: http://127.0.0.1:9/hook
+  - name: chat
+    type: telegram
+    url: https://api.telegram.org/bot123:abc
+    chat_id: "-100"
+`
+
+func TestLoadDaemonConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daemon.yaml")
+	if err := os.WriteFile(path, []byte(testDaemonConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadDaemonConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.Jobs[0].Plan().Steps[0].Targets; got != filepath.Join(filepath.Dir(path), "targets.yaml") {
+		t.Fatalf("targets path = %q", got)
+	}
+	rule := config.Alerts[0]
+	if rule.Threshold() != 3 || rule.ResolveThreshold() != 1 || len(config.Notifiers) != 2 {
+		t.Fatalf("config = %+v", config)
+	}
+}
+
+func TestDecodeDaemonConfigRejectsInvalidConfigs(t *testing.T) {
+	for name, replace := range map[string][2]string{
+		"schedule":         {`"*/5 * * * *"`, `"every minute"`},
+		"mode":             {"mode: tcp", "mode: udp"},
+		"condition":        {"loss>10", "jitter>10"},
+		"unknown job":      {"job: core", "job: other"},
+		"unknown notifier": {"[ops, chat]", "[ops, pager]"},
+		"notifier type":    {"type: webhook", "type: email"},
+		"notifier url":     {"http://127.0.0.1:9/hook", "ftp://127.0.0.1/hook"},
+		"telegram chat":    {`chat_id: "-100"`, `chat_id: ""`},
+		"negative for":     {"for: 3", "for: -1"},
+		"output":           {"targets: targets.yaml", "output: [out.json]"},
+		"unknown field":    {"for: 3", "for: 3\n    every: 1"},
+	} {
+		data := strings.Replace(testDaemonConfig, replace[0], replace[1], 1)
+		if _, err := DecodeDaemonConfig([]byte(data), CustomTargetFormatAuto); err == nil {
+			t.Errorf("%s: config accepted", name)
+		}
+	}
+	if _, err := DecodeDaemonConfig([]byte(`{"jobs":[]}`), CustomTargetFormatAuto); err == nil {
+		t.Fatal("empty job list accepted")
+	}
+}
@@ -4,6 +4,8 @@ import "time"
 
 const PingTestVersion = "v0.0.25"
 
+// EnableLoger、MaxConcurrency 与下列数据源只配置 pt 包级函数使用的默认实例，
+// 需要在同一进程中运行多个互不影响的实例时请使用 pt.Client
 var EnableLoger = false
 var MaxConcurrency = 30 // 并发量
 var (
@@ -26,11 +28,19 @@ var (
 type Server struct {
 	Name       string
 	IP         string
+	Port       int // TCP 回退端口，0 表示不支持回退
 	Avg        time.Duration
 	Tested     bool   // 标记是否已经测试过
 	SourceType string // 记录来源类型
+	Protocol   string // 产生延迟数据的协议: icmp 或 tcp
 }
 
+// 延迟数据来源协议
+const (
+	ProtocolICMP = "icmp"
+	ProtocolTCP  = "tcp"
+)
+
 // PingSort controls the legacy domestic latency table ordering.
 type PingSort string
 
@@ -0,0 +1,201 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 测试计划可使用的单项测试模式；china 与 global 本身由内置计划组合而成
+const (
+	ModeOri     = "ori"
+	ModeTGDC    = "tgdc"
+	ModeMTProto = "mtproto"
+	ModeWeb     = "web"
+	ModeTCP     = "tcp"
+)
+
+// PlanModes lists the modes a plan step may run, in documentation order.
+var PlanModes = []string{ModeOri, ModeTGDC, ModeMTProto, ModeWeb, ModeTCP}
+
+// Output formats accepted by -format and plan steps. Text is the historical
+// fixed-width layout; the others are meant for scripts and reports.
+const (
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatNDJSON   = "ndjson"
+	FormatCSV      = "csv"
+	FormatMarkdown = "markdown"
+)
+
+// OutputFormats lists the accepted output formats in documentation order.
+var OutputFormats = []string{FormatText, FormatJSON, FormatNDJSON, FormatCSV, FormatMarkdown}
+
+// PlanOutputStdout is the default output sink of a step; "-" is accepted as
+// an alias. Any other sink is a file path.
+const PlanOutputStdout = "stdout"
+
+// Plan is an ordered list of test steps loaded from a -plan file.
+type Plan struct {
+	Name     string     `json:"name,omitempty" yaml:"name,omitempty"`
+	Language string     `json:"language,omitempty" yaml:"language,omitempty"`
+	Steps    []PlanStep `json:"steps" yaml:"steps"`
+}
+
+// PlanStep describes one mode run. Zero values inherit the command-line
+// flags, so a plan only needs to state what differs from the defaults.
+type PlanStep struct {
+	Name        string   `json:"name,omitempty" yaml:"name,omitempty"`
+	Mode        string   `json:"mode" yaml:"mode"`
+	Target      string   `json:"target,omitempty" yaml:"target,omitempty"`
+	Ports       string   `json:"ports,omitempty" yaml:"ports,omitempty"`
+	Targets     string   `json:"targets,omitempty" yaml:"targets,omitempty"`
+	Attempts    int      `json:"attempts,omitempty" yaml:"attempts,omitempty"`
+	Timeout     string   `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	Concurrency int      `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+	PingScope   string   `json:"ping_scope,omitempty" yaml:"ping_scope,omitempty"`
+	PingSort    string   `json:"ping_sort,omitempty" yaml:"ping_sort,omitempty"`
+	TCPSort     string   `json:"tcp_sort,omitempty" yaml:"tcp_sort,omitempty"`
+	TGKind      string   `json:"tg_kind,omitempty" yaml:"tg_kind,omitempty"`
+	TGIP        string   `json:"tg_ip,omitempty" yaml:"tg_ip,omitempty"`
+	Format      string   `json:"format,omitempty" yaml:"format,omitempty"`
+	Output      []string `json:"output,omitempty" yaml:"output,omitempty"`
+}
+
+// TimeoutDuration parses Timeout; an empty value returns zero.
+func (step PlanStep) TimeoutDuration() (time.Duration, error) {
+	if strings.TrimSpace(step.Timeout) == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(strings.TrimSpace(step.Timeout))
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", step.Timeout)
+	}
+	return timeout, nil
+}
+
+// LoadPlanFile reads a JSON or YAML plan. Relative targets paths are resolved
+// against the plan's directory so a plan can ship next to its target files.
+func LoadPlanFile(path string) (Plan, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Plan{}, fmt.Errorf("open plan file: %w", err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, customTargetFileLimit+1))
+	if err != nil {
+		return Plan{}, fmt.Errorf("read plan file: %w", err)
+	}
+	if len(data) > customTargetFileLimit {
+		return Plan{}, errors.New("plan file is larger than 4 MiB")
+	}
+	format := customTargetFormatForPath(path)
+	if format == CustomTargetFormatCSV {
+		return Plan{}, errors.New("plan files must be JSON or YAML")
+	}
+	plan, err := DecodePlan(data, format)
+	if err != nil {
+		return Plan{}, err
+	}
+	dir := filepath.Dir(path)
+	for index := range plan.Steps {
+		if targets := plan.Steps[index].Targets; targets != "" && !filepath.IsAbs(targets) {
+			plan.Steps[index].Targets = filepath.Join(dir, targets)
+		}
+	}
+	return plan, nil
+}
+
+// DecodePlan parses a plan strictly and checks its structure: at least one
+// step, a known mode on every step, and well-formed numbers and durations.
+// Mode-specific knobs are checked when the plan is resolved for a run.
+func DecodePlan(data []byte, format CustomTargetFormat) (Plan, error) {
+	if format == CustomTargetFormatAuto {
+		format = CustomTargetFormatYAML
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+			format = CustomTargetFormatJSON
+		}
+	}
+	var plan Plan
+	switch format {
+	case CustomTargetFormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&plan); err != nil {
+			return Plan{}, fmt.Errorf("decode json plan: %w", err)
+		}
+		if err := ensureTCPTargetJSONEOF(decoder); err != nil {
+			return Plan{}, fmt.Errorf("decode json plan: %w", err)
+		}
+	case CustomTargetFormatYAML:
+		if err := decodeStrictYAML(data, &plan); err != nil {
+			return Plan{}, fmt.Errorf("decode yaml plan: %w", err)
+		}
+	default:
+		return Plan{}, fmt.Errorf("unsupported plan format %q", format)
+	}
+	if err := plan.Validate(); err != nil {
+		return Plan{}, err
+	}
+	return plan, nil
+}
+
+// Validate checks the plan structure without touching the network or files.
+func (plan Plan) Validate() error {
+	if len(plan.Steps) == 0 {
+		return errors.New("plan has no steps")
+	}
+	for index, step := range plan.Steps {
+		if err := step.validate(); err != nil {
+			return fmt.Errorf("step %d: %w", index+1, err)
+		}
+	}
+	return nil
+}
+
+func (step PlanStep) validate() error {
+	if !slices.Contains(PlanModes, strings.ToLower(strings.TrimSpace(step.Mode))) {
+		return fmt.Errorf("unknown mode %q (want %s)", step.Mode, strings.Join(PlanModes, ", "))
+	}
+	if step.Attempts < 0 || step.Concurrency < 0 {
+		return errors.New("attempts and concurrency must not be negative")
+	}
+	if _, err := step.TimeoutDuration(); err != nil {
+		return err
+	}
+	if format := strings.ToLower(strings.TrimSpace(step.Format)); format != "" && !slices.Contains(OutputFormats, format) {
+		return fmt.Errorf("unknown format %q (want %s)", step.Format, strings.Join(OutputFormats, ", "))
+	}
+	for _, sink := range step.Output {
+		if strings.TrimSpace(sink) == "" {
+			return errors.New("output sink is empty")
+		}
+	}
+	return nil
+}
+
+// OutputSinks returns the step's sinks with "-" folded into stdout and the
+// stdout default applied.
+func (step PlanStep) OutputSinks() []string {
+	if len(step.Output) == 0 {
+		return []string{PlanOutputStdout}
+	}
+	sinks := make([]string, 0, len(step.Output))
+	for _, sink := range step.Output {
+		sink = strings.TrimSpace(sink)
+		if sink == "-" {
+			sink = PlanOutputStdout
+		}
+		if !slices.Contains(sinks, sink) {
+			sinks = append(sinks, sink)
+		}
+	}
+	return sinks
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodePlanAcceptsYAMLAndJSON(t *testing.T) {
+	yamlPlan := "name: new-vps\nlanguage: en\nsteps:\n  - mode: tcp\n    attempts: 5\n    timeout: 2s\n    output: [stdout, tcp.txt]\n  - name: telegram\n    mode: tgdc\n    tg_kind: all\n"
+	jsonPlan := `{"name":"new-vps","language":"en","steps":[{"mode":"tcp","attempts":5,"timeout":"2s","output":["-","tcp.txt"]},{"name":"telegram","mode":"tgdc","tg_kind":"all"}]}`
+	for name, input := range map[string]string{"yaml": yamlPlan, "json": jsonPlan} {
+		plan, err := DecodePlan([]byte(input), CustomTargetFormatAuto)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if plan.Name != "new-vps" || plan.Language != "en" || len(plan.Steps) != 2 {
+			t.Fatalf("%s: unexpected plan %+v", name, plan)
+		}
+		timeout, err := plan.Steps[0].TimeoutDuration()
+		if err != nil || timeout != 2*time.Second || plan.Steps[0].Attempts != 5 {
+			t.Fatalf("%s: unexpected tcp step %+v", name, plan.Steps[0])
+		}
+		if got := strings.Join(plan.Steps[0].OutputSinks(), ","); got != "stdout,tcp.txt" {
+			t.Fatalf("%s: sinks = %q", name, got)
+		}
+		if got := strings.Join(plan.Steps[1].OutputSinks(), ","); got != PlanOutputStdout || plan.Steps[1].TGKind != "all" {
+			t.Fatalf("%s: unexpected telegram step %+v", name, plan.Steps[1])
+		}
+	}
+}
+
+func TestDecodePlanRejectsInvalidSteps(t *testing.T) {
+	for name, input := range map[string]string{
+		"no steps":      `{"steps":[]}`,
+		"unknown mode":  `{"steps":[{"mode":"china"}]}`,
+		"bad timeout":   `{"steps":[{"mode":"tcp","timeout":"soon"}]}`,
+		"negative":      `{"steps":[{"mode":"tcp","attempts":-1}]}`,
+		"unknown field": `{"steps":[{"mode":"tcp","retries":2}]}`,
+		"empty sink":    `{"steps":[{"mode":"tcp","output":[" "]}]}`,
+		"bad format":    `{"steps":[{"mode":"tcp","format":"xml"}]}`,
+	} {
+		if _, err := DecodePlan([]byte(input), CustomTargetFormatJSON); err == nil {
+			t.Fatalf("%s: invalid plan was accepted", name)
+		}
+	}
+	_, err := DecodePlan([]byte("steps:\n  - mode: tcp\n  - mode: ping\n"), CustomTargetFormatYAML)
+	if err == nil || !strings.Contains(err.Error(), "step 2") {
+		t.Fatalf("error does not name the step: %v", err)
+	}
+}
+
+func TestLoadPlanFileResolvesTargetsNextToPlan(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plan.yaml")
+	if err := os.WriteFile(path, []byte("steps:\n  - mode: tcp\n    targets: internal.csv\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := LoadPlanFile(path)
+	if err != nil || plan.Steps[0].Targets != filepath.Join(dir, "internal.csv") {
+		t.Fatalf("LoadPlanFile = %+v, %v", plan, err)
+	}
+}
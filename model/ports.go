@@ -0,0 +1,111 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// MaxPortTargets caps how many host and port combinations one -ports run may
+// expand to, so a typo such as 1-65535 does not turn into a port scan.
+const MaxPortTargets = 1024
+
+// wellKnownTCPServices labels common ports in multi-port output.
+var wellKnownTCPServices = map[int]string{
+	20: "ftp-data", 21: "ftp", 22: "ssh", 23: "telnet", 25: "smtp", 53: "dns",
+	80: "http", 110: "pop3", 111: "rpcbind", 123: "ntp", 143: "imap", 179: "bgp",
+	389: "ldap", 443: "https", 445: "smb", 465: "smtps", 514: "syslog", 587: "submission",
+	636: "ldaps", 853: "dns-over-tls", 873: "rsync", 993: "imaps", 995: "pop3s",
+	1080: "socks", 1194: "openvpn", 1433: "mssql", 1521: "oracle", 1723: "pptp",
+	1883: "mqtt", 2049: "nfs", 2375: "docker", 2376: "docker-tls", 3000: "http-dev",
+	3128: "squid", 3306: "mysql", 3389: "rdp", 5060: "sip", 5222: "xmpp",
+	5432: "postgresql", 5672: "amqp", 5900: "vnc", 6379: "redis", 6443: "kubernetes",
+	8000: "http-alt", 8080: "http-proxy", 8443: "https-alt", 8888: "http-alt",
+	9000: "http-alt", 9090: "prometheus", 9200: "elasticsearch", 11211: "memcached",
+	25565: "minecraft", 27017: "mongodb",
+}
+
+// TCPServiceName returns the well-known service label for port, or "" when
+// the port has none.
+func TCPServiceName(port int) string {
+	return wellKnownTCPServices[port]
+}
+
+// ParsePortList parses a comma-separated list of ports and inclusive ranges
+// such as "22,80,443,8000-8100". Duplicates are removed and the first
+// occurrence keeps its position.
+func ParsePortList(spec string) ([]int, error) {
+	var ports []int
+	seen := make(map[int]struct{})
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(field, "-")
+		start, err := parsePort(first)
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if isRange {
+			if end, err = parsePort(last); err != nil {
+				return nil, err
+			}
+			if end < start {
+				return nil, fmt.Errorf("invalid port range %q", field)
+			}
+		}
+		for port := start; port <= end; port++ {
+			if _, exists := seen[port]; exists {
+				continue
+			}
+			if len(ports) == MaxPortTargets {
+				return nil, fmt.Errorf("port list expands to more than %d ports", MaxPortTargets)
+			}
+			seen[port] = struct{}{}
+			ports = append(ports, port)
+		}
+	}
+	if len(ports) == 0 {
+		return nil, errors.New("port list is empty")
+	}
+	return ports, nil
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", strings.TrimSpace(value))
+	}
+	return port, nil
+}
+
+// ExpandTCPPortTargets returns one target per host and port, hosts first.
+// Each target is named host:port and carries the service label as its
+// category so JSON consumers see the same label as the text table.
+func ExpandTCPPortTargets(hosts []string, ports []int) ([]TCPTarget, error) {
+	if len(hosts)*len(ports) > MaxPortTargets {
+		return nil, fmt.Errorf("%d hosts and %d ports expand to more than %d targets", len(hosts), len(ports), MaxPortTargets)
+	}
+	targets := make([]TCPTarget, 0, len(hosts)*len(ports))
+	for _, host := range hosts {
+		for _, port := range ports {
+			target, ok := normalizeTCPTarget(TCPTarget{
+				Name:     net.JoinHostPort(host, strconv.Itoa(port)),
+				Host:     host,
+				Port:     port,
+				Category: TCPServiceName(port),
+				Source:   "cli",
+			})
+			if !ok {
+				return nil, fmt.Errorf("invalid TCP host %q", host)
+			}
+			target.Host = strings.Trim(target.Host, "[]")
+			targets = append(targets, target)
+		}
+	}
+	return targets, nil
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParsePortListExpandsRangesAndDeduplicates(t *testing.T) {
+	ports, err := ParsePortList("443, 22,80,443,8000-8003,22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(ports); got != "[443 22 80 8000 8001 8002 8003]" {
+		t.Fatalf("ParsePortList = %s", got)
+	}
+	for _, spec := range []string{"", "0", "65536", "80-79", "http", "1-2000"} {
+		if _, err := ParsePortList(spec); err == nil {
+			t.Fatalf("ParsePortList(%q) accepted an invalid list", spec)
+		}
+	}
+}
+
+func TestExpandTCPPortTargetsLabelsServices(t *testing.T) {
+	targets, err := ExpandTCPPortTargets([]string{"Example.com", "2001:db8::1"}, []int{22, 8081})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 4 {
+		t.Fatalf("unexpected targets: %+v", targets)
+	}
+	if first := targets[0]; first.Host != "example.com" || first.Port != 22 || first.Category != "ssh" || first.Name != "Example.com:22" {
+		t.Fatalf("first target = %+v", first)
+	}
+	if last := targets[3]; last.Host != "2001:db8::1" || last.Name != "[2001:db8::1]:8081" || last.Category != "" {
+		t.Fatalf("last target = %+v", last)
+	}
+	if _, err := ExpandTCPPortTargets([]string{"bad host"}, []int{22}); err == nil {
+		t.Fatal("invalid host was accepted")
+	}
+	if _, err := ExpandTCPPortTargets([]string{"a.test", "b.test"}, make([]int, MaxPortTargets)); err == nil {
+		t.Fatal("oversized expansion was accepted")
+	}
+}
@@ -0,0 +1,170 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression: five fields (minute, hour, day of
+// month, month, day of week), one of the @hourly style shortcuts, or
+// "@every <duration>". Times are evaluated in the location of the time
+// passed to Next.
+type Schedule struct {
+	every                          time.Duration
+	minute, hour, day, month, week uint64
+	dayRestricted, weekRestricted  bool
+}
+
+var scheduleShortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	weekNames  = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// ParseSchedule parses a cron expression. Fields accept *, lists, ranges
+// and steps ("*/5", "1-5", "mon-fri", "0,30"); months and weekdays also
+// accept three-letter names and 7 means Sunday. As in cron, a job whose
+// day of month and day of week are both restricted runs when either
+// matches.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if every, found := strings.CutPrefix(spec, "@every "); found {
+		interval, err := time.ParseDuration(strings.TrimSpace(every))
+		if err != nil || interval < time.Second {
+			return Schedule{}, fmt.Errorf("schedule %q: @every needs a duration of at least 1s", spec)
+		}
+		return Schedule{every: interval}, nil
+	}
+	if expanded, ok := scheduleShortcuts[spec]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return Schedule{}, fmt.Errorf("schedule %q: want 5 fields (minute hour day month weekday) or @every <duration>", spec)
+	}
+	var schedule Schedule
+	var err error
+	if schedule.minute, err = parseScheduleField(fields[0], 0, 59, nil); err != nil {
+		return Schedule{}, fmt.Errorf("schedule %q: minute: %w", spec, err)
+	}
+	if schedule.hour, err = parseScheduleField(fields[1], 0, 23, nil); err != nil {
+		return Schedule{}, fmt.Errorf("schedule %q: hour: %w", spec, err)
+	}
+	if schedule.day, err = parseScheduleField(fields[2], 1, 31, nil); err != nil {
+		return Schedule{}, fmt.Errorf("schedule %q: day of month: %w", spec, err)
+	}
+	if schedule.month, err = parseScheduleField(fields[3], 1, 12, monthNames); err != nil {
+		return Schedule{}, fmt.Errorf("schedule %q: month: %w", spec, err)
+	}
+	if schedule.week, err = parseScheduleField(fields[4], 0, 7, weekNames); err != nil {
+		return Schedule{}, fmt.Errorf("schedule %q: day of week: %w", spec, err)
+	}
+	if schedule.week&(1<<7) != 0 {
+		schedule.week |= 1
+	}
+	schedule.dayRestricted = fields[2] != "*" && !strings.HasPrefix(fields[2], "*/")
+	schedule.weekRestricted = fields[4] != "*" && !strings.HasPrefix(fields[4], "*/")
+	// 2000-2004 includes a leap day, so only impossible dates stay unmatched.
+	if schedule.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return Schedule{}, fmt.Errorf("schedule %q never fires", spec)
+	}
+	return schedule, nil
+}
+
+// parseScheduleField returns a bit set of the values a field allows. names,
+// when given, map to values starting at low.
+func parseScheduleField(field string, low, high int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			parsed, err := strconv.Atoi(stepPart)
+			if err != nil || parsed < 1 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+			step = parsed
+		}
+		first, last := low, high
+		if rangePart != "*" {
+			start, end, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if first, err = scheduleValue(start, low, high, names); err != nil {
+				return 0, err
+			}
+			last = first
+			if isRange {
+				if last, err = scheduleValue(end, low, high, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				last = high
+			}
+			if last < first {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		}
+		for value := first; value <= last; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+func scheduleValue(value string, low, high int, names []string) (int, error) {
+	for index, name := range names {
+		if value == name {
+			return low + index, nil
+		}
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < low || number > high {
+		return 0, fmt.Errorf("value %q out of range %d-%d", value, low, high)
+	}
+	return number, nil
+}
+
+// Next returns the first activation strictly after after. A cron schedule
+// that never fires, such as "0 0 30 2 *", returns the zero time.
+func (schedule Schedule) Next(after time.Time) time.Time {
+	if schedule.every > 0 {
+		return after.Add(schedule.every)
+	}
+	next := after.Truncate(time.Minute).Add(time.Minute)
+	limit := next.AddDate(5, 0, 0)
+	for next.Before(limit) {
+		switch {
+		case schedule.month&(1<<int(next.Month())) == 0:
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+		case !schedule.dayMatches(next):
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+		case schedule.hour&(1<<next.Hour()) == 0:
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, next.Location())
+		case schedule.minute&(1<<next.Minute()) == 0:
+			next = next.Add(time.Minute)
+		default:
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+func (schedule Schedule) dayMatches(value time.Time) bool {
+	day := schedule.day&(1<<value.Day()) != 0
+	week := schedule.week&(1<<int(value.Weekday())) != 0
+	if schedule.dayRestricted && schedule.weekRestricted {
+		return day || week
+	}
+	return day && week
+}
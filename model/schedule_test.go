@@ -0,0 +1,42 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	after := time.Date(2026, 10, 19, 10, 7, 30, 0, time.UTC) // a Monday
+	for spec, want := range map[string]string{
+		"*/5 * * * *":        "2026-10-19 10:10",
+		"0 * * * *":          "2026-10-19 11:00",
+		"@hourly":            "2026-10-19 11:00",
+		"30 2 * * *":         "2026-10-20 02:30",
+		"0 9 * * mon-fri":    "2026-10-20 09:00",
+		"0 0 * * 7":          "2026-10-25 00:00",
+		"0 0 1 jan *":        "2027-01-01 00:00",
+		"0 0 29 2 *":         "2028-02-29 00:00",
+		"0 0 13 * fri":       "2026-10-23 00:00",
+		"15,45 8-10/2 * * *": "2026-10-19 10:15",
+	} {
+		schedule, err := ParseSchedule(spec)
+		if err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+		if got := schedule.Next(after).Format("2006-01-02 15:04"); got != want {
+			t.Errorf("%s: next = %s, want %s", spec, got, want)
+		}
+	}
+	every, err := ParseSchedule("@every 90s")
+	if err != nil || !every.Next(after).Equal(after.Add(90*time.Second)) {
+		t.Fatalf("@every next = %v, %v", every.Next(after), err)
+	}
+}
+
+func TestParseScheduleRejectsInvalidSpecs(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "0 0 30 2 *", "* * * foo *", "@every 10ms", "@every soon"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
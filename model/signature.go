@@ -0,0 +1,71 @@
+package model
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// registryPublicKeys are the base64 ed25519 keys whose signatures over a
+// registry manifest are trusted. Keep the retiring key listed until every
+// published manifest has been signed by its successor.
+var registryPublicKeys = []string{
+	"Gil2I+R9q6+6Pg3YbgLgPJFuD2jEeJ29cxUykl/YwwE=",
+}
+
+// TrustedRegistryKeys returns the public keys remote registry manifests must
+// be signed with when their source names no keys of its own.
+func TrustedRegistryKeys() []ed25519.PublicKey {
+	return mustParseRegistryPublicKeys(registryPublicKeys)
+}
+
+// SignRegistryManifest returns the detached signature file for manifest: the
+// base64 ed25519 signature followed by a newline.
+func SignRegistryManifest(manifest []byte, key ed25519.PrivateKey) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifest)) + "\n")
+}
+
+// VerifyRegistrySignature checks a detached signature file against manifest
+// and accepts it when any of keys produced it.
+func VerifyRegistrySignature(manifest, signature []byte, keys []ed25519.PublicKey) error {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil || len(decoded) != ed25519.SignatureSize {
+		return errors.New("manifest signature is malformed")
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, manifest, decoded) {
+			return nil
+		}
+	}
+	return errors.New("manifest signature is not from a trusted key")
+}
+
+// ParseRegistrySigningKey decodes a base64 ed25519 seed or private key.
+func ParseRegistrySigningKey(data []byte) (ed25519.PrivateKey, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, errors.New("signing key is not base64")
+	}
+	switch len(decoded) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(decoded), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(decoded), nil
+	default:
+		return nil, fmt.Errorf("signing key has %d bytes; want a %d-byte seed or %d-byte private key", len(decoded), ed25519.SeedSize, ed25519.PrivateKeySize)
+	}
+}
+
+func mustParseRegistryPublicKeys(encoded []string) []ed25519.PublicKey {
+	keys := make([]ed25519.PublicKey, 0, len(encoded))
+	for _, value := range encoded {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(decoded) != ed25519.PublicKeySize {
+			panic("invalid registry public key " + value)
+		}
+		keys = append(keys, ed25519.PublicKey(decoded))
+	}
+	return keys
+}
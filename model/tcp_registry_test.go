@@ -2,30 +2,62 @@ package model
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 	"time"
 )
 
+// testRegistryKey returns a fresh key pair; sources trust its public key
+// through TrustedKeys.
+func testRegistryKey(t *testing.T) ([]ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []ed25519.PublicKey{publicKey}, privateKey
+}
+
+func testTCPTargetManifest(t *testing.T, snapshot []byte) (TCPTargetManifest, []byte) {
+	t.Helper()
+	hash := sha256.Sum256(snapshot)
+	manifest := TCPTargetManifest{Schema: TCPTargetRegistrySchema, File: "tcp-targets.json", Count: 1, SHA256: hex.EncodeToString(hash[:]), GeneratedAt: time.Now().UTC().Format(time.RFC3339)}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifest, data
+}
+
 func TestLoadTCPTargetRegistryFallsBackAcrossSources(t *testing.T) {
-	valid := `[{"name":"Fixture","host":"fixture.test","port":443,"source":"fixture"}]`
+	trusted, key := testRegistryKey(t)
+	valid := []byte(`[{"name":"Fixture","host":"fixture.test","port":443,"source":"fixture"}]`)
+	_, manifestData := testTCPTargetManifest(t, valid)
 	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		if request.URL.Path == "/bad" {
+		switch request.URL.Path {
+		case "/bad":
 			http.Error(writer, "bad", http.StatusBadGateway)
-			return
+		case "/manifest":
+			_, _ = writer.Write(manifestData)
+		case "/manifest.sig":
+			_, _ = writer.Write(SignRegistryManifest(manifestData, key))
+		default:
+			_, _ = writer.Write(valid)
 		}
-		_, _ = writer.Write([]byte(valid))
 	}))
 	defer server.Close()
 
 	loaded, err := LoadTCPTargetRegistry(context.Background(), server.Client(), []TCPTargetRegistrySource{
-		{Name: "cdn", URL: server.URL + "/bad"},
-		{Name: "raw", URL: server.URL + "/good"},
+		{Name: "cdn", URL: server.URL + "/bad", ManifestURL: server.URL + "/manifest", TrustedKeys: trusted},
+		{Name: "raw", URL: server.URL + "/good", ManifestURL: server.URL + "/manifest", TrustedKeys: trusted},
 	}, 1)
 	if err != nil {
 		t.Fatal(err)
@@ -36,26 +68,30 @@ func TestLoadTCPTargetRegistryFallsBackAcrossSources(t *testing.T) {
 }
 
 func TestLoadTCPTargetRegistryRejectsBadManifestAndUsesNextSource(t *testing.T) {
+	trusted, key := testRegistryKey(t)
 	valid := []byte(`[{"name":"Fixture","host":"fixture.test","port":443,"source":"fixture"}]`)
-	hash := sha256.Sum256(valid)
-	manifest := TCPTargetManifest{Schema: TCPTargetRegistrySchema, File: "tcp-targets.json", Count: 1, SHA256: hex.EncodeToString(hash[:]), GeneratedAt: time.Now().UTC().Format(time.RFC3339)}
-	manifestData, _ := json.Marshal(manifest)
+	manifest, manifestData := testTCPTargetManifest(t, valid)
+	bad := manifest
+	bad.SHA256 = strings.Repeat("0", 64)
+	badData, _ := json.Marshal(bad)
 	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		switch request.URL.Path {
 		case "/cdn-manifest":
-			bad := manifest
-			bad.SHA256 = strings.Repeat("0", 64)
-			_ = json.NewEncoder(writer).Encode(bad)
+			_, _ = writer.Write(badData)
+		case "/cdn-manifest.sig":
+			_, _ = writer.Write(SignRegistryManifest(badData, key))
 		case "/raw-manifest":
 			_, _ = writer.Write(manifestData)
+		case "/raw-manifest.sig":
+			_, _ = writer.Write(SignRegistryManifest(manifestData, key))
 		default:
 			_, _ = writer.Write(valid)
 		}
 	}))
 	defer server.Close()
 	loaded, err := LoadTCPTargetRegistry(context.Background(), server.Client(), []TCPTargetRegistrySource{
-		{Name: "cdn", URL: server.URL + "/cdn-data", ManifestURL: server.URL + "/cdn-manifest"},
-		{Name: "raw", URL: server.URL + "/raw-data", ManifestURL: server.URL + "/raw-manifest"},
+		{Name: "cdn", URL: server.URL + "/cdn-data", ManifestURL: server.URL + "/cdn-manifest", TrustedKeys: trusted},
+		{Name: "raw", URL: server.URL + "/raw-data", ManifestURL: server.URL + "/raw-manifest", TrustedKeys: trusted},
 	}, 1)
 	if err != nil || loaded.Source != "raw" || !loaded.Fallback {
 		t.Fatalf("unexpected manifest fallback: %+v, %v", loaded, err)
@@ -65,6 +101,77 @@ func TestLoadTCPTargetRegistryRejectsBadManifestAndUsesNextSource(t *testing.T)
 	}
 }
 
+func TestLoadTCPTargetRegistryRejectsUnsignedAndUntrustedManifests(t *testing.T) {
+	trusted, _ := testRegistryKey(t)
+	_, attacker, _ := ed25519.GenerateKey(rand.Reader)
+	// A compromised mirror can publish a matching snapshot, manifest and
+	// signature, but not one made with a trusted key.
+	forged := []byte(`[{"name":"Forged","host":"forged.test","port":443}]`)
+	_, manifestData := testTCPTargetManifest(t, forged)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/manifest":
+			_, _ = writer.Write(manifestData)
+		case "/forged.sig":
+			_, _ = writer.Write(SignRegistryManifest(manifestData, attacker))
+		case "/data":
+			_, _ = writer.Write(forged)
+		default:
+			http.NotFound(writer, request)
+		}
+	}))
+	defer server.Close()
+	loaded, err := LoadTCPTargetRegistry(context.Background(), server.Client(), []TCPTargetRegistrySource{
+		{Name: "cdn", URL: server.URL + "/data", ManifestURL: server.URL + "/manifest", SignatureURL: server.URL + "/forged.sig", TrustedKeys: trusted},
+		{Name: "raw", URL: server.URL + "/data", ManifestURL: server.URL + "/manifest", TrustedKeys: trusted},
+		{Name: "raw", URL: server.URL + "/data", TrustedKeys: trusted},
+	}, 1)
+	if err != nil || loaded.Source != "embedded" {
+		t.Fatalf("forged registry accepted: %+v, %v", loaded, err)
+	}
+}
+
+func TestLoadTCPTargetRegistryRejectsManifestsOlderThanEmbedded(t *testing.T) {
+	trusted, key := testRegistryKey(t)
+	embedded, err := validateTCPTargetManifest(embeddedTCPTargetManifest, embeddedTCPTargets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated, _ := time.Parse(time.RFC3339, embedded.GeneratedAt)
+	// A validly signed pair from before the embedded snapshot is a rollback.
+	stale := []byte(`[{"name":"Stale","host":"stale.test","port":443}]`)
+	manifest, _ := testTCPTargetManifest(t, stale)
+	manifest.GeneratedAt = generated.Add(-time.Hour).Format(time.RFC3339)
+	manifestData, _ := json.Marshal(manifest)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/manifest":
+			_, _ = writer.Write(manifestData)
+		case "/manifest.sig":
+			_, _ = writer.Write(SignRegistryManifest(manifestData, key))
+		default:
+			_, _ = writer.Write(stale)
+		}
+	}))
+	defer server.Close()
+	loaded, err := LoadTCPTargetRegistry(context.Background(), server.Client(), []TCPTargetRegistrySource{
+		{Name: "cdn", URL: server.URL + "/data", ManifestURL: server.URL + "/manifest", TrustedKeys: trusted},
+	}, 1)
+	if err != nil || loaded.Source != "embedded" {
+		t.Fatalf("stale registry accepted: %+v, %v", loaded, err)
+	}
+}
+
+func TestEmbeddedTCPTargetManifestIsSigned(t *testing.T) {
+	signature, err := os.ReadFile("snapshot/manifest.json.sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyRegistrySignature(embeddedTCPTargetManifest, signature, TrustedRegistryKeys()); err != nil {
+		t.Fatalf("published manifest signature: %v", err)
+	}
+}
+
 func TestLoadTCPTargetRegistryUsesEmbeddedFallback(t *testing.T) {
 	loaded, err := LoadTCPTargetRegistry(context.Background(), nil, nil, 10)
 	if err != nil {
@@ -2,6 +2,7 @@ package model
 
 import (
 	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
 	_ "embed"
 	"encoding/hex"
@@ -30,6 +31,10 @@ const (
 	TCPTargetManifestRawURL      = "https://raw.githubusercontent.com/oneclickvirt/pingtest/main/model/snapshot/manifest.json"
 	TCPTargetRegistryCDNURL      = "https://cdn.spiritlhl.net/" + TCPTargetRegistryRawURL
 	TCPTargetManifestRegistryURL = "https://cdn.spiritlhl.net/" + TCPTargetManifestRawURL
+	// The detached ed25519 signature over the manifest is published next to
+	// it; see SignRegistryManifest.
+	TCPTargetSignatureRawURL      = TCPTargetManifestRawURL + ".sig"
+	TCPTargetSignatureRegistryURL = TCPTargetManifestRegistryURL + ".sig"
 )
 
 // TCPTarget describes an endpoint used by the TCP handshake probe.
@@ -41,10 +46,15 @@ type TCPTarget struct {
 	Source   string `json:"source,omitempty"`
 }
 
+// TCPTargetRegistrySource is a remote registry. Its manifest must carry a
+// signature from one of TrustedKeys, which default to TrustedRegistryKeys;
+// SignatureURL defaults to ManifestURL with ".sig" appended.
 type TCPTargetRegistrySource struct {
-	Name        string
-	URL         string
-	ManifestURL string
+	Name         string
+	URL          string
+	ManifestURL  string
+	SignatureURL string
+	TrustedKeys  []ed25519.PublicKey
 }
 
 type TCPTargetRegistryLoadResult struct {
@@ -71,8 +81,8 @@ type RegistryMetadata struct {
 
 func DefaultTCPTargetRegistrySources() []TCPTargetRegistrySource {
 	return []TCPTargetRegistrySource{
-		{Name: "cdn", URL: TCPTargetRegistryCDNURL, ManifestURL: TCPTargetManifestRegistryURL},
-		{Name: "raw", URL: TCPTargetRegistryRawURL, ManifestURL: TCPTargetManifestRawURL},
+		{Name: "cdn", URL: TCPTargetRegistryCDNURL, ManifestURL: TCPTargetManifestRegistryURL, SignatureURL: TCPTargetSignatureRegistryURL},
+		{Name: "raw", URL: TCPTargetRegistryRawURL, ManifestURL: TCPTargetManifestRawURL, SignatureURL: TCPTargetSignatureRawURL},
 	}
 }
 
@@ -170,9 +180,6 @@ func LoadTCPTargetRegistry(ctx context.Context, client *http.Client, sources []T
 			lastErr = fmt.Errorf("validate %s TCP target registry: %w", tcpRegistrySourceLabel(source.Name), err)
 			continue
 		}
-		if metadata.Count == 0 {
-			metadata = tcpTargetRegistryMetadata(data, targets)
-		}
 		return TCPTargetRegistryLoadResult{Targets: targets, Source: source.Name, Fallback: index > 0, Metadata: metadata}, nil
 	}
 	metadata, err := validateTCPTargetManifest(embeddedTCPTargetManifest, embeddedTCPTargets)
@@ -189,27 +196,96 @@ func LoadTCPTargetRegistry(ctx context.Context, client *http.Client, sources []T
 	return TCPTargetRegistryLoadResult{}, fmt.Errorf("%w; embedded fallback: %v", lastErr, err)
 }
 
+// loadTCPTargetSnapshot loads a signed remote snapshot; see
+// loadSignedRegistry.
 func loadTCPTargetSnapshot(ctx context.Context, client *http.Client, source TCPTargetRegistrySource) ([]byte, RegistryMetadata, error) {
-	if source.ManifestURL == "" {
-		data, err := fetchTCPTargetRegistry(ctx, client, source.URL)
-		return data, RegistryMetadata{}, err
-	}
-	manifestData, err := fetchTCPTargetRegistry(ctx, client, source.ManifestURL)
+	return loadSignedRegistry(ctx, client, signedRegistry{
+		url: source.URL, manifestURL: source.ManifestURL, signatureURL: source.SignatureURL, trusted: source.TrustedKeys,
+		userAgent: "oneclickvirt-pingtest/tcp-target-registry-v1", kind: "TCP target",
+		validate: validateTCPTargetManifest, embeddedManifest: embeddedTCPTargetManifest, embeddedSnapshot: embeddedTCPTargets,
+	})
+}
+
+// signedRegistry is one remote registry source with the checks its
+// manifest must pass.
+type signedRegistry struct {
+	url, manifestURL, signatureURL string
+	// trusted defaults to TrustedRegistryKeys.
+	trusted   []ed25519.PublicKey
+	userAgent string
+	kind      string
+	validate  func(manifest, snapshot []byte) (RegistryMetadata, error)
+	// embeddedManifest and embeddedSnapshot are compiled into the binary.
+	embeddedManifest, embeddedSnapshot []byte
+}
+
+// loadSignedRegistry accepts a remote snapshot only when its manifest is
+// signed by a trusted key, matches the snapshot and is not older than the
+// embedded one, so a mirror serving both files can neither substitute its
+// own nor replay an earlier signed pair. The signature defaults to the
+// manifest URL with ".sig" appended.
+func loadSignedRegistry(ctx context.Context, client *http.Client, registry signedRegistry) ([]byte, RegistryMetadata, error) {
+	if registry.manifestURL == "" {
+		return nil, RegistryMetadata{}, errors.New("manifest URL is required")
+	}
+	signatureURL := registry.signatureURL
+	if signatureURL == "" {
+		signatureURL = registry.manifestURL + ".sig"
+	}
+	manifestData, err := fetchRegistry(ctx, client, registry.manifestURL, registry.userAgent)
 	if err != nil {
 		return nil, RegistryMetadata{}, fmt.Errorf("load manifest: %w", err)
 	}
-	data, err := fetchTCPTargetRegistry(ctx, client, source.URL)
+	signature, err := fetchRegistry(ctx, client, signatureURL, registry.userAgent)
+	if err != nil {
+		return nil, RegistryMetadata{}, fmt.Errorf("load manifest signature: %w", err)
+	}
+	trusted := registry.trusted
+	if trusted == nil {
+		trusted = TrustedRegistryKeys()
+	}
+	if err := VerifyRegistrySignature(manifestData, signature, trusted); err != nil {
+		return nil, RegistryMetadata{}, err
+	}
+	data, err := fetchRegistry(ctx, client, registry.url, registry.userAgent)
 	if err != nil {
 		return nil, RegistryMetadata{}, err
 	}
-	metadata, err := validateTCPTargetManifest(manifestData, data)
+	metadata, err := registry.validate(manifestData, data)
 	if err != nil {
 		return nil, RegistryMetadata{}, err
 	}
+	embedded, err := registry.validate(registry.embeddedManifest, registry.embeddedSnapshot)
+	if err != nil {
+		return nil, RegistryMetadata{}, fmt.Errorf("validate embedded %s manifest: %w", registry.kind, err)
+	}
+	if err := checkRegistryFreshness(metadata, embedded); err != nil {
+		return nil, RegistryMetadata{}, err
+	}
 	return data, metadata, nil
 }
 
+// checkRegistryFreshness rejects a remote manifest generated before the
+// embedded one. Both were validated, so their generated_at parse.
+func checkRegistryFreshness(remote, embedded RegistryMetadata) error {
+	remoteTime, _ := time.Parse(time.RFC3339, remote.GeneratedAt)
+	embeddedTime, _ := time.Parse(time.RFC3339, embedded.GeneratedAt)
+	if remoteTime.Before(embeddedTime) {
+		return fmt.Errorf("manifest generated_at %s is older than the embedded %s", remote.GeneratedAt, embedded.GeneratedAt)
+	}
+	return nil
+}
+
 func validateTCPTargetManifest(data, snapshot []byte) (RegistryMetadata, error) {
+	return validateRegistryManifest(data, snapshot, TCPTargetRegistrySchema, "tcp-targets.json", func(snapshot []byte, minimum int) (int, error) {
+		targets, err := decodeTCPTargetRegistry(snapshot, minimum)
+		return len(targets), err
+	})
+}
+
+// validateRegistryManifest checks a registry manifest against its snapshot.
+// decode returns the number of valid entries that survive normalization.
+func validateRegistryManifest(data, snapshot []byte, schema, file string, decode func([]byte, int) (int, error)) (RegistryMetadata, error) {
 	var manifest TCPTargetManifest
 	decoder := json.NewDecoder(strings.NewReader(string(data)))
 	decoder.DisallowUnknownFields()
@@ -219,7 +295,7 @@ func validateTCPTargetManifest(data, snapshot []byte) (RegistryMetadata, error)
 	if err := ensureTCPTargetJSONEOF(decoder); err != nil {
 		return RegistryMetadata{}, err
 	}
-	if manifest.Schema != TCPTargetRegistrySchema || manifest.File != "tcp-targets.json" || manifest.Count < 1 {
+	if manifest.Schema != schema || manifest.File != file || manifest.Count < 1 {
 		return RegistryMetadata{}, errors.New("manifest schema, file, or count is invalid")
 	}
 	if _, err := time.Parse(time.RFC3339, manifest.GeneratedAt); err != nil {
@@ -229,22 +305,23 @@ func validateTCPTargetManifest(data, snapshot []byte) (RegistryMetadata, error)
 	if !strings.EqualFold(manifest.SHA256, hex.EncodeToString(hash[:])) {
 		return RegistryMetadata{}, errors.New("manifest SHA-256 does not match snapshot")
 	}
-	targets, err := decodeTCPTargetRegistry(snapshot, manifest.Count)
+	count, err := decode(snapshot, manifest.Count)
 	if err != nil {
 		return RegistryMetadata{}, fmt.Errorf("manifest count validation failed: %w", err)
 	}
-	if len(targets) != manifest.Count {
-		return RegistryMetadata{}, fmt.Errorf("manifest count %d does not match snapshot count %d", manifest.Count, len(targets))
+	if count != manifest.Count {
+		return RegistryMetadata{}, fmt.Errorf("manifest count %d does not match snapshot count %d", manifest.Count, count)
 	}
 	return RegistryMetadata{Schema: manifest.Schema, Count: manifest.Count, SHA256: strings.ToLower(manifest.SHA256), GeneratedAt: manifest.GeneratedAt}, nil
 }
 
-func tcpTargetRegistryMetadata(snapshot []byte, targets []TCPTarget) RegistryMetadata {
-	hash := sha256.Sum256(snapshot)
-	return RegistryMetadata{Schema: TCPTargetRegistrySchema, Count: len(targets), SHA256: hex.EncodeToString(hash[:])}
+func fetchTCPTargetRegistry(ctx context.Context, client *http.Client, endpoint string) ([]byte, error) {
+	return fetchRegistry(ctx, client, endpoint, "oneclickvirt-pingtest/tcp-target-registry-v1")
 }
 
-func fetchTCPTargetRegistry(ctx context.Context, client *http.Client, endpoint string) ([]byte, error) {
+// fetchRegistry downloads a registry document without exposing the source URL
+// in returned errors.
+func fetchRegistry(ctx context.Context, client *http.Client, endpoint, userAgent string) ([]byte, error) {
 	parsed, err := url.Parse(endpoint)
 	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
 		return nil, errors.New("invalid registry URL")
@@ -254,7 +331,7 @@ func fetchTCPTargetRegistry(ctx context.Context, client *http.Client, endpoint s
 		return nil, errors.New("create registry request failed")
 	}
 	request.Header.Set("Accept", "application/json")
-	request.Header.Set("User-Agent", "oneclickvirt-pingtest/tcp-target-registry-v1")
+	request.Header.Set("User-Agent", userAgent)
 	response, err := client.Do(request)
 	if err != nil {
 		if ctxErr := ctx.Err(); ctxErr != nil {
@@ -295,14 +372,8 @@ func decodeTCPTargetRegistry(data []byte, minimum int) ([]TCPTarget, error) {
 	seen := make(map[string]struct{}, len(input))
 	targets := make([]TCPTarget, 0, len(input))
 	for _, target := range input {
-		target.Name = strings.TrimSpace(target.Name)
-		target.Host = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(target.Host), "."))
-		target.Category = strings.TrimSpace(target.Category)
-		target.Source = strings.TrimSpace(target.Source)
-		if target.Port == 0 {
-			target.Port = 443
-		}
-		if target.Name == "" || !validTCPTargetHost(target.Host) || target.Port < 1 || target.Port > 65535 {
+		target, ok := normalizeTCPTarget(target)
+		if !ok {
 			continue
 		}
 		key := targetKey(target)
@@ -318,6 +389,22 @@ func decodeTCPTargetRegistry(data []byte, minimum int) ([]TCPTarget, error) {
 	return targets, nil
 }
 
+// normalizeTCPTarget trims and lowercases a registry entry, defaults the port
+// to 443 and reports whether the result is a valid probe target.
+func normalizeTCPTarget(target TCPTarget) (TCPTarget, bool) {
+	target.Name = strings.TrimSpace(target.Name)
+	target.Host = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(target.Host), "."))
+	target.Category = strings.TrimSpace(target.Category)
+	target.Source = strings.TrimSpace(target.Source)
+	if target.Port == 0 {
+		target.Port = 443
+	}
+	if target.Name == "" || !validTCPTargetHost(target.Host) || target.Port < 1 || target.Port > 65535 {
+		return target, false
+	}
+	return target, true
+}
+
 func validTCPTargetHost(host string) bool {
 	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
 		return true
@@ -392,6 +479,22 @@ func websiteTarget(website Website) (TCPTarget, bool) {
 	}, true
 }
 
+// TargetKey returns the host:port key registries deduplicate targets on. The
+// host is lower-cased and loses its trailing dot, so results from different
+// runs or sources align on the same endpoint.
+func TargetKey(target TCPTarget) string {
+	return targetKey(target)
+}
+
+// WebsiteTargetKey is TargetKey for a website URL. URLs without a usable
+// host are keyed on their trimmed, lower-cased text.
+func WebsiteTargetKey(websiteURL string) string {
+	if target, ok := websiteTarget(Website{URL: websiteURL}); ok {
+		return targetKey(target)
+	}
+	return strings.ToLower(strings.TrimSpace(websiteURL))
+}
+
 func targetKey(target TCPTarget) string {
 	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(target.Host), ".")) + ":" + strconv.Itoa(target.Port)
 }
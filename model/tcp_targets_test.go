@@ -50,3 +50,18 @@ func TestAllTCPTargetsReturnsCopy(t *testing.T) {
 		t.Fatal("registry returned shared mutable state")
 	}
 }
+
+func TestTargetKeysNormalizeHostAndPort(t *testing.T) {
+	if key := TargetKey(TCPTarget{Host: " Example.COM. ", Port: 443}); key != "example.com:443" {
+		t.Fatalf("TargetKey = %q", key)
+	}
+	for input, want := range map[string]string{
+		"https://WWW.Example.com./path": "www.example.com:443",
+		"https://example.com:8443":      "example.com:8443",
+		" not a url ":                   "not a url",
+	} {
+		if key := WebsiteTargetKey(input); key != want {
+			t.Errorf("WebsiteTargetKey(%q) = %q, want %q", input, key, want)
+		}
+	}
+}
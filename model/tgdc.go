@@ -1,53 +1,296 @@
 package model
 
-import "time"
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed snapshot/telegram-dcs.json
+var embeddedTelegramDCs []byte
+
+//go:embed snapshot/telegram-manifest.json
+var embeddedTelegramDCManifest []byte
+
+const (
+	TelegramDCRegistrySchema      = "pingtest.telegram-dcs/v1"
+	TelegramDCRegistryRawURL      = "https://raw.githubusercontent.com/oneclickvirt/pingtest/main/model/snapshot/telegram-dcs.json"
+	TelegramDCManifestRawURL      = "https://raw.githubusercontent.com/oneclickvirt/pingtest/main/model/snapshot/telegram-manifest.json"
+	TelegramDCRegistryCDNURL      = "https://cdn.spiritlhl.net/" + TelegramDCRegistryRawURL
+	TelegramDCManifestRegistryURL = "https://cdn.spiritlhl.net/" + TelegramDCManifestRawURL
+	// The manifest is signed like the TCP target manifest.
+	TelegramDCSignatureRawURL      = TelegramDCManifestRawURL + ".sig"
+	TelegramDCSignatureRegistryURL = TelegramDCManifestRegistryURL + ".sig"
+)
+
+// Telegram 数据中心类别
+const (
+	TelegramDCKindProduction = "production"
+	TelegramDCKindMedia      = "media"
+	TelegramDCKindCDN        = "cdn"
+	TelegramDCKindTest       = "test"
+)
 
 // TelegramDC Telegram 数据中心配置
 type TelegramDC struct {
-	ID       int           // DC ID
-	Name     string        // DC 名称
-	Location string        // 地理位置
-	IP       string        // IP 地址
-	Avg      time.Duration // 平均延迟
-	Tested   bool          // 是否已测试
-}
-
-// TelegramDataCenters 定义 Telegram 的 5 个数据中心
-// 参考 https://github.com/OctoGramApp/octogramapp.github.io
-var TelegramDataCenters = []TelegramDC{
-	{
-		ID:       1,
-		Name:     "TG-DC1",
-		Location: "MIA USA",
-		IP:       "149.154.175.50",
-		Tested:   false,
-	},
-	{
-		ID:       2,
-		Name:     "TG-DC2",
-		Location: "AMS NL",
-		IP:       "149.154.167.50",
-		Tested:   false,
-	},
-	{
-		ID:       3,
-		Name:     "TG-DC3",
-		Location: "MIA USA",
-		IP:       "149.154.175.100",
-		Tested:   false,
-	},
-	{
-		ID:       4,
-		Name:     "TG-DC4",
-		Location: "AMS NL",
-		IP:       "149.154.167.91",
-		Tested:   false,
-	},
-	{
-		ID:       5,
-		Name:     "TG-DC5",
-		Location: "Singapore",
-		IP:       "91.108.56.100",
-		Tested:   false,
-	},
+	ID        int           `json:"id"`         // DC ID
+	Name      string        `json:"name"`       // DC 名称
+	Location  string        `json:"location"`   // 地理位置
+	IP        string        `json:"ip"`         // IP 地址
+	IPVersion string        `json:"ip_version"` // ipv4 或 ipv6
+	Kind      string        `json:"kind"`       // production、media、cdn 或 test
+	Avg       time.Duration `json:"-"`          // 平均延迟
+	Tested    bool          `json:"-"`          // 是否已测试
+	Protocol  string        `json:"-"`          // 产生延迟数据的协议: icmp 或 tcp
+}
+
+// TelegramDataCenters 定义默认测试的 Telegram 生产数据中心（IPv4）
+// 完整列表见 AllTelegramDataCenters，参考 https://github.com/OctoGramApp/octogramapp.github.io
+var TelegramDataCenters = FilterTelegramDataCenters(AllTelegramDataCenters(), DefaultTelegramDCFilter())
+
+// TelegramDCFilter selects registry entries. Empty fields match everything.
+type TelegramDCFilter struct {
+	Kinds      []string
+	IPVersions []string
+	IDs        []int
+}
+
+// TelegramDCRegistrySource is a remote registry, verified like a
+// TCPTargetRegistrySource.
+type TelegramDCRegistrySource struct {
+	Name         string
+	URL          string
+	ManifestURL  string
+	SignatureURL string
+	TrustedKeys  []ed25519.PublicKey
+}
+
+type TelegramDCRegistryLoadResult struct {
+	DataCenters []TelegramDC
+	Source      string
+	Fallback    bool
+	Metadata    RegistryMetadata
+}
+
+// DefaultTelegramDCFilter matches the five production IPv4 data centers that
+// the tgdc mode has always tested.
+func DefaultTelegramDCFilter() TelegramDCFilter {
+	return TelegramDCFilter{Kinds: []string{TelegramDCKindProduction}, IPVersions: []string{"ipv4"}}
+}
+
+// ParseTelegramDCFilter parses comma-separated kinds and IP versions. "all"
+// or an empty value leaves that dimension unfiltered; v4/v6 are accepted as
+// shorthands for ipv4/ipv6.
+func ParseTelegramDCFilter(kinds, ipVersions string) (TelegramDCFilter, error) {
+	var filter TelegramDCFilter
+	for _, kind := range splitFilterList(kinds) {
+		switch kind {
+		case TelegramDCKindProduction, TelegramDCKindMedia, TelegramDCKindCDN, TelegramDCKindTest:
+			filter.Kinds = append(filter.Kinds, kind)
+		default:
+			return TelegramDCFilter{}, fmt.Errorf("unknown Telegram DC kind %q", kind)
+		}
+	}
+	for _, version := range splitFilterList(ipVersions) {
+		switch version {
+		case "ipv4", "v4", "4":
+			filter.IPVersions = append(filter.IPVersions, "ipv4")
+		case "ipv6", "v6", "6":
+			filter.IPVersions = append(filter.IPVersions, "ipv6")
+		default:
+			return TelegramDCFilter{}, fmt.Errorf("unknown Telegram DC IP version %q", version)
+		}
+	}
+	return filter, nil
+}
+
+func splitFilterList(value string) []string {
+	var values []string
+	for _, field := range strings.Split(strings.ToLower(value), ",") {
+		field = strings.TrimSpace(field)
+		if field == "all" {
+			return nil
+		}
+		if field != "" {
+			values = append(values, field)
+		}
+	}
+	return values
+}
+
+// Matches reports whether dc satisfies every non-empty filter dimension.
+func (filter TelegramDCFilter) Matches(dc TelegramDC) bool {
+	if len(filter.Kinds) > 0 && !slices.Contains(filter.Kinds, dc.Kind) {
+		return false
+	}
+	if len(filter.IPVersions) > 0 && !slices.Contains(filter.IPVersions, dc.IPVersion) {
+		return false
+	}
+	if len(filter.IDs) > 0 && !slices.Contains(filter.IDs, dc.ID) {
+		return false
+	}
+	return true
+}
+
+// FilterTelegramDataCenters returns a copy of the entries matching filter.
+func FilterTelegramDataCenters(dcs []TelegramDC, filter TelegramDCFilter) []TelegramDC {
+	result := make([]TelegramDC, 0, len(dcs))
+	for _, dc := range dcs {
+		if filter.Matches(dc) {
+			result = append(result, dc)
+		}
+	}
+	return result
+}
+
+// AllTelegramDataCenters returns a copy of the embedded registry covering
+// production, media, CDN and test DCs over IPv4 and IPv6.
+func AllTelegramDataCenters() []TelegramDC {
+	dcs, _ := decodeTelegramDCRegistry(embeddedTelegramDCs, 1)
+	return dcs
+}
+
+func DefaultTelegramDCRegistrySources() []TelegramDCRegistrySource {
+	return []TelegramDCRegistrySource{
+		{Name: "cdn", URL: TelegramDCRegistryCDNURL, ManifestURL: TelegramDCManifestRegistryURL, SignatureURL: TelegramDCSignatureRegistryURL},
+		{Name: "raw", URL: TelegramDCRegistryRawURL, ManifestURL: TelegramDCManifestRawURL, SignatureURL: TelegramDCSignatureRawURL},
+	}
+}
+
+// LoadTelegramDCRegistry resolves the remote registry in source order and
+// falls back to the embedded snapshot, mirroring LoadTCPTargetRegistry.
+func LoadTelegramDCRegistry(ctx context.Context, client *http.Client, sources []TelegramDCRegistrySource, minimum int) (TelegramDCRegistryLoadResult, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 8 * time.Second}
+	}
+	if minimum < 1 {
+		minimum = 1
+	}
+	var lastErr error
+	for index, source := range sources {
+		data, metadata, err := loadTelegramDCSnapshot(ctx, client, source)
+		if err != nil {
+			lastErr = fmt.Errorf("load %s Telegram DC registry: %w", tcpRegistrySourceLabel(source.Name), err)
+			continue
+		}
+		dcs, err := decodeTelegramDCRegistry(data, minimum)
+		if err != nil {
+			lastErr = fmt.Errorf("validate %s Telegram DC registry: %w", tcpRegistrySourceLabel(source.Name), err)
+			continue
+		}
+		return TelegramDCRegistryLoadResult{DataCenters: dcs, Source: source.Name, Fallback: index > 0, Metadata: metadata}, nil
+	}
+	metadata, err := validateTelegramDCManifest(embeddedTelegramDCManifest, embeddedTelegramDCs)
+	if err != nil {
+		return TelegramDCRegistryLoadResult{}, fmt.Errorf("validate embedded Telegram DC manifest: %w", err)
+	}
+	dcs, err := decodeTelegramDCRegistry(embeddedTelegramDCs, minimum)
+	if err == nil {
+		return TelegramDCRegistryLoadResult{DataCenters: dcs, Source: "embedded", Fallback: true, Metadata: metadata}, nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no Telegram DC registry sources configured")
+	}
+	return TelegramDCRegistryLoadResult{}, fmt.Errorf("%w; embedded fallback: %v", lastErr, err)
+}
+
+// loadTelegramDCSnapshot loads a signed remote snapshot; see
+// loadSignedRegistry.
+func loadTelegramDCSnapshot(ctx context.Context, client *http.Client, source TelegramDCRegistrySource) ([]byte, RegistryMetadata, error) {
+	return loadSignedRegistry(ctx, client, signedRegistry{
+		url: source.URL, manifestURL: source.ManifestURL, signatureURL: source.SignatureURL, trusted: source.TrustedKeys,
+		userAgent: "oneclickvirt-pingtest/telegram-dc-registry-v1", kind: "Telegram DC",
+		validate: validateTelegramDCManifest, embeddedManifest: embeddedTelegramDCManifest, embeddedSnapshot: embeddedTelegramDCs,
+	})
+}
+
+func validateTelegramDCManifest(data, snapshot []byte) (RegistryMetadata, error) {
+	return validateRegistryManifest(data, snapshot, TelegramDCRegistrySchema, "telegram-dcs.json", func(snapshot []byte, minimum int) (int, error) {
+		dcs, err := decodeTelegramDCRegistry(snapshot, minimum)
+		return len(dcs), err
+	})
+}
+
+// EmbeddedTelegramDCRegistryMetadata describes the snapshot compiled into
+// the binary, which backs the default production list.
+func EmbeddedTelegramDCRegistryMetadata() RegistryMetadata {
+	if metadata, err := validateTelegramDCManifest(embeddedTelegramDCManifest, embeddedTelegramDCs); err == nil {
+		return metadata
+	}
+	return telegramDCRegistryMetadata(embeddedTelegramDCs, AllTelegramDataCenters())
+}
+
+func telegramDCRegistryMetadata(snapshot []byte, dcs []TelegramDC) RegistryMetadata {
+	hash := sha256.Sum256(snapshot)
+	return RegistryMetadata{Schema: TelegramDCRegistrySchema, Count: len(dcs), SHA256: hex.EncodeToString(hash[:])}
+}
+
+// decodeTelegramDCRegistry applies the same strictness as the TCP registry:
+// unknown fields and trailing JSON are rejected, invalid entries are dropped
+// and duplicates of kind plus address keep the first entry.
+func decodeTelegramDCRegistry(data []byte, minimum int) ([]TelegramDC, error) {
+	var input []TelegramDC
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil {
+		return nil, err
+	}
+	if err := ensureTCPTargetJSONEOF(decoder); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{}, len(input))
+	dcs := make([]TelegramDC, 0, len(input))
+	for _, dc := range input {
+		dc.Name = strings.TrimSpace(dc.Name)
+		dc.Location = strings.TrimSpace(dc.Location)
+		dc.Kind = strings.ToLower(strings.TrimSpace(dc.Kind))
+		dc.IPVersion = strings.ToLower(strings.TrimSpace(dc.IPVersion))
+		dc.Avg, dc.Tested, dc.Protocol = 0, false, ""
+		ip := net.ParseIP(strings.TrimSpace(dc.IP))
+		if dc.ID < 1 || ip == nil {
+			continue
+		}
+		dc.IP = ip.String()
+		version := "ipv6"
+		if ip.To4() != nil {
+			version = "ipv4"
+		}
+		if dc.IPVersion != "" && dc.IPVersion != version {
+			continue
+		}
+		dc.IPVersion = version
+		if dc.Kind == "" {
+			dc.Kind = TelegramDCKindProduction
+		}
+		if dc.Kind != TelegramDCKindProduction && dc.Kind != TelegramDCKindMedia && dc.Kind != TelegramDCKindCDN && dc.Kind != TelegramDCKindTest {
+			continue
+		}
+		if dc.Name == "" {
+			dc.Name = "TG-DC" + strconv.Itoa(dc.ID)
+		}
+		key := dc.Kind + "/" + dc.IP
+		if _, exists := seen[key]; exists {
+			continue
+		}
+		seen[key] = struct{}{}
+		dcs = append(dcs, dc)
+	}
+	if len(dcs) < minimum {
+		return nil, fmt.Errorf("Telegram DC registry has %d valid entries; require at least %d", len(dcs), minimum)
+	}
+	return dcs, nil
 }
@@ -0,0 +1,153 @@
+package model
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTelegramDataCentersKeepsDefaultProductionIPv4List(t *testing.T) {
+	want := map[int]string{1: "149.154.175.50", 2: "149.154.167.50", 3: "149.154.175.100", 4: "149.154.167.91", 5: "91.108.56.100"}
+	if len(TelegramDataCenters) != len(want) {
+		t.Fatalf("default Telegram DC count = %d, want %d", len(TelegramDataCenters), len(want))
+	}
+	for _, dc := range TelegramDataCenters {
+		if want[dc.ID] != dc.IP || dc.Kind != TelegramDCKindProduction || dc.IPVersion != "ipv4" {
+			t.Fatalf("unexpected default DC: %+v", dc)
+		}
+	}
+}
+
+func TestAllTelegramDataCentersCoversIPv6MediaCDNAndTest(t *testing.T) {
+	counts := make(map[string]int)
+	for _, dc := range AllTelegramDataCenters() {
+		counts[dc.Kind]++
+		counts[dc.IPVersion]++
+	}
+	for _, key := range []string{TelegramDCKindProduction, TelegramDCKindMedia, TelegramDCKindCDN, TelegramDCKindTest, "ipv4", "ipv6"} {
+		if counts[key] == 0 {
+			t.Errorf("Telegram DC registry has no %q entries: %v", key, counts)
+		}
+	}
+}
+
+func TestParseTelegramDCFilterSelectsSubset(t *testing.T) {
+	filter, err := ParseTelegramDCFilter("test", "v6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	selected := FilterTelegramDataCenters(AllTelegramDataCenters(), filter)
+	if len(selected) == 0 {
+		t.Fatal("filter selected no entries")
+	}
+	for _, dc := range selected {
+		if dc.Kind != TelegramDCKindTest || dc.IPVersion != "ipv6" {
+			t.Fatalf("filter returned %+v", dc)
+		}
+	}
+	if all, err := ParseTelegramDCFilter("all", "all"); err != nil || len(FilterTelegramDataCenters(AllTelegramDataCenters(), all)) != len(AllTelegramDataCenters()) {
+		t.Fatalf("all filter did not match every entry: %+v, %v", all, err)
+	}
+	if _, err := ParseTelegramDCFilter("edge", ""); err == nil {
+		t.Fatal("unknown kind unexpectedly accepted")
+	}
+}
+
+func TestLoadTelegramDCRegistryValidatesRemoteManifest(t *testing.T) {
+	trusted, key := testRegistryKey(t)
+	valid := []byte(`[{"id":7,"name":"Fixture","location":"Lab","ip":"2001:db8::7","kind":"test"}]`)
+	hash := sha256.Sum256(valid)
+	manifest := TCPTargetManifest{Schema: TelegramDCRegistrySchema, File: "telegram-dcs.json", Count: 1, SHA256: hex.EncodeToString(hash[:]), GeneratedAt: time.Now().UTC().Format(time.RFC3339)}
+	manifestData, _ := json.Marshal(manifest)
+	bad := manifest
+	bad.Schema = TCPTargetRegistrySchema
+	badData, _ := json.Marshal(bad)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/bad-manifest":
+			_, _ = writer.Write(badData)
+		case "/bad-manifest.sig":
+			_, _ = writer.Write(SignRegistryManifest(badData, key))
+		case "/manifest":
+			_, _ = writer.Write(manifestData)
+		case "/manifest.sig":
+			_, _ = writer.Write(SignRegistryManifest(manifestData, key))
+		default:
+			_, _ = writer.Write(valid)
+		}
+	}))
+	defer server.Close()
+	loaded, err := LoadTelegramDCRegistry(context.Background(), server.Client(), []TelegramDCRegistrySource{
+		{Name: "cdn", URL: server.URL + "/data", ManifestURL: server.URL + "/bad-manifest", TrustedKeys: trusted},
+		{Name: "raw", URL: server.URL + "/data", ManifestURL: server.URL + "/manifest", TrustedKeys: trusted},
+	}, 1)
+	if err != nil || loaded.Source != "raw" || !loaded.Fallback || len(loaded.DataCenters) != 1 {
+		t.Fatalf("unexpected Telegram registry load: %+v, %v", loaded, err)
+	}
+	if dc := loaded.DataCenters[0]; dc.IPVersion != "ipv6" || dc.Kind != TelegramDCKindTest || loaded.Metadata.Schema != TelegramDCRegistrySchema {
+		t.Fatalf("unexpected Telegram registry entry: %+v %+v", dc, loaded.Metadata)
+	}
+}
+
+func TestLoadTelegramDCRegistryRejectsUnsignedAndUntrustedManifests(t *testing.T) {
+	trusted, _ := testRegistryKey(t)
+	_, attacker, _ := ed25519.GenerateKey(rand.Reader)
+	forged := []byte(`[{"id":7,"name":"Forged","ip":"192.0.2.7"}]`)
+	hash := sha256.Sum256(forged)
+	manifestData, _ := json.Marshal(TCPTargetManifest{Schema: TelegramDCRegistrySchema, File: "telegram-dcs.json", Count: 1, SHA256: hex.EncodeToString(hash[:]), GeneratedAt: time.Now().UTC().Format(time.RFC3339)})
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/manifest":
+			_, _ = writer.Write(manifestData)
+		case "/manifest.sig":
+			_, _ = writer.Write(SignRegistryManifest(manifestData, attacker))
+		default:
+			_, _ = writer.Write(forged)
+		}
+	}))
+	defer server.Close()
+	loaded, err := LoadTelegramDCRegistry(context.Background(), server.Client(), []TelegramDCRegistrySource{
+		{Name: "cdn", URL: server.URL + "/data", ManifestURL: server.URL + "/manifest", TrustedKeys: trusted},
+		{Name: "raw", URL: server.URL + "/data", TrustedKeys: trusted},
+	}, 1)
+	if err != nil || loaded.Source != "embedded" {
+		t.Fatalf("forged Telegram registry accepted: %+v, %v", loaded, err)
+	}
+}
+
+func TestEmbeddedTelegramDCManifestIsSigned(t *testing.T) {
+	signature, err := os.ReadFile("snapshot/telegram-manifest.json.sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyRegistrySignature(embeddedTelegramDCManifest, signature, TrustedRegistryKeys()); err != nil {
+		t.Fatalf("published Telegram manifest signature: %v", err)
+	}
+}
+
+func TestLoadTelegramDCRegistryUsesEmbeddedFallback(t *testing.T) {
+	loaded, err := LoadTelegramDCRegistry(context.Background(), nil, nil, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Source != "embedded" || len(loaded.DataCenters) != len(AllTelegramDataCenters()) || loaded.Metadata.Count != len(loaded.DataCenters) || loaded.Metadata.GeneratedAt == "" {
+		t.Fatalf("unexpected embedded Telegram registry: %+v", loaded)
+	}
+}
+
+func TestDecodeTelegramDCRegistryRejectsDriftAndMismatchedVersion(t *testing.T) {
+	if _, err := decodeTelegramDCRegistry([]byte(`[{"id":1,"ip":"192.0.2.1","port":443}]`), 1); err == nil {
+		t.Fatal("field drift unexpectedly accepted")
+	}
+	if _, err := decodeTelegramDCRegistry([]byte(`[{"id":1,"ip":"192.0.2.1","ip_version":"ipv6"}]`), 1); err == nil {
+		t.Fatal("mismatched IP version unexpectedly accepted")
+	}
+}
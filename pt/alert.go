@@ -0,0 +1,182 @@
+package pt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+// Alert notification states.
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// AlertNotification announces that an alert rule started or stopped firing
+// for a job. Webhooks receive it as JSON.
+type AlertNotification struct {
+	Alert     string    `json:"alert"`
+	Status    string    `json:"status"`
+	Job       string    `json:"job"`
+	Condition string    `json:"condition"`
+	Targets   []string  `json:"targets,omitempty"`
+	Runs      int       `json:"runs"`
+	StartsAt  time.Time `json:"starts_at"`
+	Time      time.Time `json:"time"`
+	Host      string    `json:"host"`
+	// Notify names the notifiers of the rule.
+	Notify []string `json:"-"`
+}
+
+// alertState counts the consecutive runs on either side of a rule's
+// condition for one job.
+type alertState struct {
+	firing    bool
+	violating int
+	passing   int
+	since     time.Time
+}
+
+type trackedAlert struct {
+	rule      model.AlertRule
+	condition model.Assertion
+	states    map[string]*alertState
+}
+
+// AlertTracker applies alert rules with hysteresis: a rule fires after For
+// consecutive violating runs and resolves after ResolveAfter consecutive
+// clean runs, so a single bad sample neither pages nor clears an alert.
+// State is kept per job and is safe for concurrent jobs.
+type AlertTracker struct {
+	mu     sync.Mutex
+	alerts []trackedAlert
+}
+
+// NewAlertTracker parses the conditions of rules.
+func NewAlertTracker(rules []model.AlertRule) (*AlertTracker, error) {
+	tracker := &AlertTracker{}
+	for _, rule := range rules {
+		condition, err := rule.Condition()
+		if err != nil {
+			return nil, fmt.Errorf("alert %s: %w", rule.Name, err)
+		}
+		tracker.alerts = append(tracker.alerts, trackedAlert{rule: rule, condition: condition, states: map[string]*alertState{}})
+	}
+	return tracker, nil
+}
+
+// Evaluate records one finished run of job and returns the notifications of
+// the rules whose state changed.
+func (tracker *AlertTracker) Evaluate(job string, sections []ReportSection, now time.Time) []AlertNotification {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	host := CurrentHostInfo().Hostname
+	var notifications []AlertNotification
+	for _, alert := range tracker.alerts {
+		if alert.rule.Job != "" && alert.rule.Job != job {
+			continue
+		}
+		state := alert.states[job]
+		if state == nil {
+			state = &alertState{}
+			alert.states[job] = state
+		}
+		notification := AlertNotification{
+			Alert: alert.rule.Name, Job: job, Condition: alert.condition.Text, Time: now, Host: host, Notify: alert.rule.Notify,
+		}
+		failures := CheckAssertions(sections, []model.Assertion{alert.condition}, 0)
+		if len(failures) > 0 {
+			state.violating++
+			state.passing = 0
+			if state.firing || state.violating < alert.rule.Threshold() {
+				continue
+			}
+			state.firing, state.since = true, now
+			notification.Status, notification.Targets, notification.Runs = AlertFiring, failures[0].Targets, state.violating
+		} else {
+			state.passing++
+			state.violating = 0
+			if !state.firing || state.passing < alert.rule.ResolveThreshold() {
+				continue
+			}
+			state.firing = false
+			notification.Status, notification.Runs = AlertResolved, state.passing
+		}
+		notification.StartsAt = state.since
+		notifications = append(notifications, notification)
+	}
+	return notifications
+}
+
+// FormatAlert renders a notification as a short chat message listing at
+// most ten targets.
+func FormatAlert(notification AlertNotification) string {
+	var output strings.Builder
+	fmt.Fprintf(&output, "[%s] %s (job %s on %s)\n", strings.ToUpper(notification.Status), notification.Alert, notification.Job, notification.Host)
+	if notification.Status == AlertFiring {
+		fmt.Fprintf(&output, "%s for %d consecutive runs\n", notification.Condition, notification.Runs)
+		for index, target := range notification.Targets {
+			if index == 10 {
+				fmt.Fprintf(&output, "  ... +%d\n", len(notification.Targets)-index)
+				break
+			}
+			output.WriteString("  " + target + "\n")
+		}
+	} else {
+		fmt.Fprintf(&output, "%s cleared for %d runs, firing since %s", notification.Condition, notification.Runs, notification.StartsAt.UTC().Format(time.RFC3339))
+	}
+	return strings.TrimSpace(output.String())
+}
+
+// SendAlert delivers a notification to one notifier: the JSON notification
+// for webhooks, a {"text"} message for Slack-compatible endpoints and a
+// sendMessage call for Telegram bots. A non-2xx answer is an error. Errors
+// never include the URL, which often embeds a token.
+func SendAlert(ctx context.Context, client *http.Client, notifier model.Notifier, notification AlertNotification) error {
+	endpoint := notifier.URL
+	var body any = notification
+	switch notifier.Type {
+	case model.NotifierSlack:
+		body = map[string]string{"text": FormatAlert(notification)}
+	case model.NotifierTelegram:
+		endpoint = strings.TrimSuffix(endpoint, "/")
+		if !strings.HasSuffix(endpoint, "/sendMessage") {
+			endpoint += "/sendMessage"
+		}
+		body = map[string]any{"chat_id": notifier.ChatID, "text": FormatAlert(notification), "disable_web_page_preview": true}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s notifier %s: invalid url", notifier.Type, notifier.Name)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "pingtest/"+model.PingTestVersion)
+	response, err := client.Do(request)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("%s notifier %s: %w", notifier.Type, notifier.Name, err)
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s notifier %s: HTTP %d", notifier.Type, notifier.Name, response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package pt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+func alertSections(loss float64) []ReportSection {
+	return []ReportSection{{Mode: model.ModeTCP, TCP: []TCPResult{{
+		Target: model.TCPTarget{Name: "OpenAI", Host: "api.openai.com", Port: 443, Category: "ai"}, Attempts: 10, Successful: 10 - int(loss/10), LossPercent: loss,
+	}}}}
+}
+
+func TestAlertTrackerAppliesHysteresis(t *testing.T) {
+	tracker, err := NewAlertTracker([]model.AlertRule{
+		{Name: "high-loss", Job: "core", When: "loss>10", For: 3, ResolveAfter: 2, Notify: []string{"ops"}},
+		{Name: "other-job", Job: "edge", When: "loss>0", Notify: []string{"ops"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	var states []string
+	for index, loss := range []float64{20, 20, 0, 20, 20, 20, 30, 0, 20, 0, 0} {
+		notifications := tracker.Evaluate("core", alertSections(loss), start.Add(time.Duration(index)*time.Minute))
+		state := "-"
+		if len(notifications) > 1 {
+			t.Fatalf("run %d: %d notifications", index, len(notifications))
+		}
+		if len(notifications) == 1 {
+			notification := notifications[0]
+			state = notification.Status
+			if notification.Alert != "high-loss" || notification.Job != "core" || notification.Notify[0] != "ops" {
+				t.Fatalf("run %d: notification = %+v", index, notification)
+			}
+			if notification.Status == AlertFiring && (notification.Runs != 3 || len(notification.Targets) != 1 || !strings.Contains(notification.Targets[0], "loss=20.0%")) {
+				t.Fatalf("firing = %+v", notification)
+			}
+			if notification.Status == AlertResolved && !notification.StartsAt.Equal(start.Add(5*time.Minute)) {
+				t.Fatalf("resolved = %+v", notification)
+			}
+		}
+		states = append(states, state)
+	}
+	if got := strings.Join(states, ","); got != "-,-,-,-,-,firing,-,-,-,-,resolved" {
+		t.Fatalf("states = %s", got)
+	}
+}
+
+func TestSendAlertFormatsEachNotifierType(t *testing.T) {
+	bodies := map[string]map[string]any{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s: bad request: %v", r.URL.Path, err)
+		}
+		bodies[r.URL.Path] = body
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+	notification := AlertNotification{
+		Alert: "high-loss", Status: AlertFiring, Job: "core", Condition: "loss>10", Runs: 3, Host: "vps",
+		Targets: []string{"OpenAI (api.openai.com:443) loss=20.0%"},
+	}
+	for _, notifier := range []model.Notifier{
+		{Name: "hook", Type: model.NotifierWebhook, URL: server.URL + "/hook"},
+		{Name: "slack", Type: model.NotifierSlack, URL: server.URL + "/slack"},
+		{Name: "tg", Type: model.NotifierTelegram, URL: server.URL + "/bot123:secret", ChatID: "-100"},
+	} {
+		if err := SendAlert(context.Background(), server.Client(), notifier, notification); err != nil {
+			t.Fatalf("%s: %v", notifier.Name, err)
+		}
+	}
+	if hook := bodies["/hook"]; hook["alert"] != "high-loss" || hook["status"] != AlertFiring || hook["runs"] != float64(3) {
+		t.Fatalf("webhook body = %v", hook)
+	}
+	text := "[FIRING] high-loss (job core on vps)\nloss>10 for 3 consecutive runs\n  OpenAI (api.openai.com:443) loss=20.0%"
+	if slack := bodies["/slack"]; slack["text"] != text {
+		t.Fatalf("slack body = %v", slack)
+	}
+	if telegram := bodies["/bot123:secret/sendMessage"]; telegram["chat_id"] != "-100" || telegram["text"] != text {
+		t.Fatalf("telegram body = %v", bodies)
+	}
+	err := SendAlert(context.Background(), server.Client(), model.Notifier{Name: "broken", Type: model.NotifierWebhook, URL: server.URL + "/broken"}, notification)
+	if err == nil || err.Error() != "webhook notifier broken: HTTP 502" {
+		t.Fatalf("error = %v", err)
+	}
+	server.Close()
+	err = SendAlert(context.Background(), http.DefaultClient, model.Notifier{Name: "tg", Type: model.NotifierTelegram, URL: server.URL + "/bot123:secret", ChatID: "1"}, notification)
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("unreachable error = %v, want one without the URL", err)
+	}
+}
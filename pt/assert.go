@@ -0,0 +1,169 @@
+package pt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+// AssertionFailure is one failed check: a -fail-if assertion with the
+// targets that violated it, or the overall success rate check.
+type AssertionFailure struct {
+	Assertion string   `json:"assertion"`
+	Targets   []string `json:"targets,omitempty"`
+}
+
+// assertionTarget is the per-target view assertions are evaluated against.
+// Latency values are only meaningful when answered is set.
+type assertionTarget struct {
+	label      string
+	fields     map[string]string
+	loss       float64
+	answered   bool
+	latency    map[string]time.Duration
+	attempts   int
+	successful int
+}
+
+func assertionTargets(section ReportSection) []assertionTarget {
+	var targets []assertionTarget
+	fields := func(name, host, category string) map[string]string {
+		return map[string]string{"mode": section.Mode, "category": category, "name": name, "host": host}
+	}
+	switch section.Mode {
+	case model.ModeTCP:
+		for _, result := range section.TCP {
+			endpoint := result.Target.Host + ":" + strconv.Itoa(result.Target.Port)
+			targets = append(targets, assertionTarget{
+				label: result.Target.Name + " (" + endpoint + ")", fields: fields(result.Target.Name, result.Target.Host, result.Target.Category),
+				loss: result.LossPercent, answered: result.Successful > 0,
+				latency: map[string]time.Duration{
+					model.AssertMin: result.Min, model.AssertMean: result.Mean, model.AssertP50: result.P50,
+					model.AssertP95: result.P95, model.AssertMax: result.Max,
+				},
+				attempts: result.Attempts, successful: result.Successful,
+			})
+		}
+	case model.ModeMTProto:
+		for _, result := range section.MTProto {
+			usable := 0
+			for _, endpoint := range result.Endpoints {
+				if endpoint.Usable {
+					usable++
+				}
+			}
+			target := assertionTarget{
+				label: result.Name + " " + result.Location + " (" + result.Host + ")", fields: fields(result.Name, result.Host, ""),
+				loss: 100, answered: result.Usable, attempts: len(result.Endpoints), successful: usable,
+				latency: sameLatency(result.Response),
+			}
+			if len(result.Endpoints) > 0 {
+				target.loss = float64(len(result.Endpoints)-usable) / float64(len(result.Endpoints)) * 100
+			}
+			targets = append(targets, target)
+		}
+	default:
+		for _, result := range section.Latency {
+			target := assertionTarget{
+				label: result.Name + " (" + result.Host + ")", fields: fields(result.Name, result.Host, result.Category),
+				loss: 100, attempts: 1, latency: sameLatency(result.Latency),
+			}
+			if result.Status == LatencyOK {
+				target.loss, target.answered, target.successful = 0, true, 1
+			}
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// sameLatency fills every latency metric with the single value that latency
+// results carry.
+func sameLatency(value time.Duration) map[string]time.Duration {
+	latency := map[string]time.Duration{}
+	for _, metric := range []string{model.AssertMin, model.AssertMean, model.AssertP50, model.AssertP95, model.AssertMax} {
+		latency[metric] = value
+	}
+	return latency
+}
+
+// CheckAssertions evaluates assertions against the results of a run. A
+// latency assertion skips targets that never answered; use a loss or success
+// assertion to catch those. minSuccessRate, when positive, requires the
+// share of successful attempts across every target to reach that percentage,
+// and fails when nothing was measured at all.
+func CheckAssertions(sections []ReportSection, assertions []model.Assertion, minSuccessRate float64) []AssertionFailure {
+	var targets []assertionTarget
+	for _, section := range sections {
+		targets = append(targets, assertionTargets(section)...)
+	}
+	var failures []AssertionFailure
+	for _, assertion := range assertions {
+		failure := AssertionFailure{Assertion: assertion.Text}
+		for _, target := range targets {
+			if !assertion.Matches(target.fields) {
+				continue
+			}
+			var value float64
+			var display string
+			switch assertion.Metric {
+			case model.AssertLoss:
+				value = target.loss
+				display = strconv.FormatFloat(value, 'f', 1, 64) + "%"
+			case model.AssertSuccess:
+				value = 100 - target.loss
+				display = strconv.FormatFloat(value, 'f', 1, 64) + "%"
+			default:
+				if !target.answered {
+					continue
+				}
+				value = float64(target.latency[assertion.Metric]) / float64(time.Millisecond)
+				display = strconv.FormatFloat(value, 'f', 2, 64) + "ms"
+			}
+			if assertion.Compare(value) {
+				failure.Targets = append(failure.Targets, target.label+" "+assertion.Metric+"="+display)
+			}
+		}
+		if len(failure.Targets) > 0 {
+			failures = append(failures, failure)
+		}
+	}
+	if minSuccessRate > 0 {
+		attempts, successful := 0, 0
+		for _, target := range targets {
+			attempts += target.attempts
+			successful += target.successful
+		}
+		rate := 0.0
+		if attempts > 0 {
+			rate = float64(successful) / float64(attempts) * 100
+		}
+		if attempts == 0 || rate < minSuccessRate {
+			failures = append(failures, AssertionFailure{Assertion: fmt.Sprintf(
+				"success rate %s%% < %s%% (%d/%d)",
+				strconv.FormatFloat(rate, 'f', 1, 64), strconv.FormatFloat(minSuccessRate, 'f', -1, 64), successful, attempts,
+			)})
+		}
+	}
+	return failures
+}
+
+// FormatAssertionFailures lists failures one per line with at most limit
+// targets each; limit <= 0 lists every target.
+func FormatAssertionFailures(failures []AssertionFailure, limit int) string {
+	var output strings.Builder
+	for _, failure := range failures {
+		output.WriteString(failure.Assertion + "\n")
+		for index, target := range failure.Targets {
+			if limit > 0 && index == limit {
+				fmt.Fprintf(&output, "  ... +%d\n", len(failure.Targets)-limit)
+				break
+			}
+			output.WriteString("  " + target + "\n")
+		}
+	}
+	return strings.TrimSuffix(output.String(), "\n")
+}
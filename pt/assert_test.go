@@ -0,0 +1,59 @@
+package pt
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+func mustAssertion(t *testing.T, text string) model.Assertion {
+	t.Helper()
+	assertion, err := model.ParseAssertion(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return assertion
+}
+
+func TestCheckAssertionsFiltersTargetsAndSkipsUnansweredLatency(t *testing.T) {
+	sections := []ReportSection{
+		{Mode: model.ModeTCP, TCP: []TCPResult{
+			{Target: model.TCPTarget{Name: "OpenAI", Host: "api.openai.com", Port: 443, Category: "ai"}, Attempts: 4, Successful: 4, P95: 250 * time.Millisecond},
+			{Target: model.TCPTarget{Name: "GitHub", Host: "github.com", Port: 443, Category: "dev"}, Attempts: 4, Successful: 3, LossPercent: 25, P95: 300 * time.Millisecond},
+			{Target: model.TCPTarget{Name: "Claude", Host: "claude.ai", Port: 443, Category: "ai"}, Attempts: 4, LossPercent: 100},
+		}},
+		{Mode: model.ModeWeb, Latency: []LatencyResult{{Name: "Example", Host: "https://example.test", Category: "dev", Status: LatencyOK, Latency: 20 * time.Millisecond}}},
+	}
+	failures := CheckAssertions(sections, []model.Assertion{
+		mustAssertion(t, "p95>200ms category=ai"),
+		mustAssertion(t, "loss>5 mode=tcp"),
+		mustAssertion(t, "p50>1s"),
+	}, 0)
+	if len(failures) != 2 {
+		t.Fatalf("failures = %+v", failures)
+	}
+	if len(failures[0].Targets) != 1 || !strings.HasPrefix(failures[0].Targets[0], "OpenAI (api.openai.com:443) p95=250.00ms") {
+		t.Fatalf("p95 failure = %+v", failures[0])
+	}
+	if len(failures[1].Targets) != 2 {
+		t.Fatalf("loss failure = %+v", failures[1])
+	}
+
+	// 8 of 13 attempts succeeded: 61.5%.
+	if failures := CheckAssertions(sections, nil, 60); len(failures) != 0 {
+		t.Fatalf("60%% success rate failed: %+v", failures)
+	}
+	failures = CheckAssertions(sections, nil, 90)
+	if len(failures) != 1 || failures[0].Assertion != "success rate 61.5% < 90% (8/13)" {
+		t.Fatalf("success rate failure = %+v", failures)
+	}
+	if failures := CheckAssertions(nil, nil, 1); len(failures) != 1 {
+		t.Fatalf("empty run passed the success rate check: %+v", failures)
+	}
+	text := FormatAssertionFailures([]AssertionFailure{{Assertion: "loss>5", Targets: []string{"a", "b", "c"}}}, 2)
+	if text != "loss>5\n  a\n  b\n  ... +1" {
+		t.Fatalf("formatted failures = %q", text)
+	}
+}
@@ -0,0 +1,224 @@
+package pt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultCacheMaxAge is how long a downloaded target list is used without
+// asking the server whether it changed.
+const DefaultCacheMaxAge = 24 * time.Hour
+
+// ErrOffline is returned for a request that is not cached while the cache
+// is offline.
+var ErrOffline = errors.New("offline: not in cache")
+
+// DiskCache is an http.RoundTripper keeping GET responses on disk. A copy
+// younger than MaxAge is served without a request; an older one is
+// revalidated with If-None-Match and If-Modified-Since and served again on
+// 304 or when the server cannot be reached. Offline serves only cached
+// copies, of any age.
+type DiskCache struct {
+	Dir     string
+	MaxAge  time.Duration
+	Offline bool
+	// Transport performs the requests; nil uses http.DefaultTransport.
+	Transport http.RoundTripper
+	Now       func() time.Time
+
+	mu     sync.Mutex
+	hits   int
+	oldest time.Time
+}
+
+// CacheInfo describes how a run used the cache: Hits counts responses
+// served from disk and Age is the age of the oldest one.
+type CacheInfo struct {
+	Dir     string        `json:"dir"`
+	Offline bool          `json:"offline"`
+	Hits    int           `json:"hits"`
+	Age     time.Duration `json:"age,omitempty"`
+}
+
+// cacheEntry is the file stored per URL.
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Downloaded   time.Time `json:"downloaded"`
+	Validated    time.Time `json:"validated"`
+	Body         []byte    `json:"body"`
+}
+
+// DefaultCacheDir returns pingtest's directory under the user cache
+// directory, which honors XDG_CACHE_HOME.
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pingtest"), nil
+}
+
+// NewDiskCache returns a cache in dir. A negative maxAge is treated as 0,
+// which revalidates every request.
+func NewDiskCache(dir string, maxAge time.Duration, offline bool) *DiskCache {
+	return &DiskCache{Dir: dir, MaxAge: max(maxAge, 0), Offline: offline}
+}
+
+// HTTPClient returns a client with timeout whose requests go through the
+// cache. A nil cache returns a plain client.
+func (cache *DiskCache) HTTPClient(timeout time.Duration) *http.Client {
+	if cache == nil {
+		return &http.Client{Timeout: timeout}
+	}
+	return &http.Client{Timeout: timeout, Transport: cache}
+}
+
+// Info reports the use of this cache so far, so a run that needs its own
+// hit count uses its own DiskCache; nil for a nil cache or an online cache
+// that served nothing.
+func (cache *DiskCache) Info() *CacheInfo {
+	if cache == nil {
+		return nil
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.hits == 0 && !cache.Offline {
+		return nil
+	}
+	info := &CacheInfo{Dir: cache.Dir, Offline: cache.Offline, Hits: cache.hits}
+	if !cache.oldest.IsZero() {
+		info.Age = max(cache.now().Sub(cache.oldest), 0).Round(time.Second)
+	}
+	return info
+}
+
+func (cache *DiskCache) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Method != http.MethodGet {
+		return cache.transport().RoundTrip(request)
+	}
+	key := request.URL.String()
+	entry, cached := cache.load(key)
+	if cache.Offline {
+		if !cached {
+			return nil, ErrOffline
+		}
+		return cache.serve(request, entry), nil
+	}
+	if cached && cache.now().Sub(entry.Validated) < cache.MaxAge {
+		return cache.serve(request, entry), nil
+	}
+	conditional := request
+	if cached {
+		conditional = request.Clone(request.Context())
+		if entry.ETag != "" {
+			conditional.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			conditional.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+	response, err := cache.transport().RoundTrip(conditional)
+	if err != nil {
+		// A stale copy beats no data, unless the caller gave up.
+		if cached && request.Context().Err() == nil {
+			return cache.serve(request, entry), nil
+		}
+		return nil, err
+	}
+	switch {
+	case response.StatusCode == http.StatusNotModified && cached:
+		response.Body.Close()
+		entry.Validated = cache.now()
+		cache.store(entry)
+		return cache.serve(request, entry), nil
+	case response.StatusCode != http.StatusOK:
+		return response, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxFetchSize))
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	now := cache.now()
+	cache.store(cacheEntry{
+		URL: key, ETag: response.Header.Get("ETag"), LastModified: response.Header.Get("Last-Modified"),
+		Downloaded: now, Validated: now, Body: body,
+	})
+	response.Body = io.NopCloser(bytes.NewReader(body))
+	return response, nil
+}
+
+// serve answers request from entry and records the hit.
+func (cache *DiskCache) serve(request *http.Request, entry cacheEntry) *http.Response {
+	cache.mu.Lock()
+	cache.hits++
+	if cache.oldest.IsZero() || entry.Downloaded.Before(cache.oldest) {
+		cache.oldest = entry.Downloaded
+	}
+	cache.mu.Unlock()
+	header := http.Header{}
+	header.Set("Age", strconv.Itoa(int(max(cache.now().Sub(entry.Downloaded), 0)/time.Second)))
+	return &http.Response{
+		Status: "200 OK", StatusCode: http.StatusOK, Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+		Header: header, Body: io.NopCloser(bytes.NewReader(entry.Body)), ContentLength: int64(len(entry.Body)),
+		Request: request,
+	}
+}
+
+func (cache *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(cache.Dir, hex.EncodeToString(sum[:16])+".json")
+}
+
+func (cache *DiskCache) load(key string) (cacheEntry, bool) {
+	var entry cacheEntry
+	data, err := os.ReadFile(cache.path(key))
+	if err != nil || json.Unmarshal(data, &entry) != nil || entry.URL != key {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// store writes entry atomically; a cache that cannot be written only costs
+// the next run a download.
+func (cache *DiskCache) store(entry cacheEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil || os.MkdirAll(cache.Dir, 0o755) != nil {
+		return
+	}
+	temporary, err := os.CreateTemp(cache.Dir, ".entry-*")
+	if err != nil {
+		return
+	}
+	_, writeErr := temporary.Write(data)
+	closeErr := temporary.Close()
+	if writeErr != nil || closeErr != nil || os.Rename(temporary.Name(), cache.path(entry.URL)) != nil {
+		_ = os.Remove(temporary.Name())
+	}
+}
+
+func (cache *DiskCache) transport() http.RoundTripper {
+	if cache.Transport != nil {
+		return cache.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (cache *DiskCache) now() time.Time {
+	if cache.Now != nil {
+		return cache.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,90 @@
+package pt
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func cacheGet(t *testing.T, client *http.Client, url string) (string, error) {
+	t.Helper()
+	response, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", response.StatusCode)
+	}
+	return string(body), err
+}
+
+func TestDiskCacheServesFreshCopiesAndRevalidatesStaleOnes(t *testing.T) {
+	var requests, revalidations atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			revalidations.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, "nodes")
+	}))
+	defer server.Close()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewDiskCache(t.TempDir(), time.Hour, false)
+	cache.Now = func() time.Time { return now }
+	client := cache.HTTPClient(time.Second)
+
+	for range 2 {
+		if body, err := cacheGet(t, client, server.URL+"/nodes.json"); err != nil || body != "nodes" {
+			t.Fatalf("Get = %q, %v", body, err)
+		}
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("fresh copy caused %d requests", requests.Load())
+	}
+	now = now.Add(2 * time.Hour)
+	if body, err := cacheGet(t, client, server.URL+"/nodes.json"); err != nil || body != "nodes" || revalidations.Load() != 1 {
+		t.Fatalf("stale Get = %q, %v after %d revalidations", body, err, revalidations.Load())
+	}
+	info := cache.Info()
+	if info == nil || info.Hits != 2 || info.Age != 2*time.Hour {
+		t.Fatalf("Info = %+v", info)
+	}
+
+	server.Close()
+	now = now.Add(2 * time.Hour)
+	if body, err := cacheGet(t, client, server.URL+"/nodes.json"); err != nil || body != "nodes" {
+		t.Fatalf("unreachable server: Get = %q, %v, want the stale copy", body, err)
+	}
+}
+
+func TestDiskCacheOfflineUsesOnlyCachedCopies(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		io.WriteString(w, "list")
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	if _, err := cacheGet(t, NewDiskCache(dir, 0, false).HTTPClient(time.Second), server.URL+"/a.csv"); err != nil {
+		t.Fatal(err)
+	}
+	offline := NewDiskCache(dir, 0, true).HTTPClient(time.Second)
+	if body, err := cacheGet(t, offline, server.URL+"/a.csv"); err != nil || body != "list" {
+		t.Fatalf("offline cached Get = %q, %v", body, err)
+	}
+	if _, err := offline.Get(server.URL + "/b.csv"); !errors.Is(err, ErrOffline) {
+		t.Fatalf("offline uncached Get error = %v, want ErrOffline", err)
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("offline cache made %d requests", requests.Load()-1)
+	}
+}
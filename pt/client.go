@@ -0,0 +1,186 @@
+package pt
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	. "github.com/oneclickvirt/defaultset"
+	"github.com/oneclickvirt/pingtest/model"
+	"go.uber.org/zap"
+)
+
+// defaultConcurrency bounds the pings per operator when Client.Concurrency
+// is unset.
+const defaultConcurrency = 30
+
+// Client runs the ping, Telegram DC and website tests with its own logger,
+// concurrency, data sources, HTTP client, rate limit and target caches, so
+// several instances can share one process. The zero value is ready to use.
+// The package-level functions run on a default client configured by
+// model.EnableLoger, model.MaxConcurrency and the model source variables;
+// it has no rate limit and no cache.
+type Client struct {
+	// Logger receives diagnostic messages; nil disables logging.
+	Logger *zap.Logger
+	// Concurrency bounds the parallel pings per operator; 0 uses 30.
+	Concurrency int
+	// Sources locates the mirrors and target lists; zero fields use
+	// DefaultSources.
+	Sources Sources
+	// HTTPClient performs the website tests; nil uses a client with a 10s
+	// timeout following up to 10 redirects.
+	HTTPClient *http.Client
+	// Fetcher downloads the target lists; nil races the mirrors of Sources
+	// with a MirrorFetcher that keeps their health for the client's lifetime
+	// and rejects answers that are not target lists. The built-in mirrors
+	// must also pass MirrorCheckEndpoint before they are used.
+	Fetcher Fetcher
+	// Cache, when set, keeps the downloaded target lists and registries on
+	// disk; see DiskCache.
+	Cache *DiskCache
+	// RateLimiter, when set, gives every probe of the client's tests a
+	// token; clients sharing one limiter share its budget. Nil is unlimited.
+	RateLimiter *RateLimiter
+
+	fetcherMutex     sync.Mutex
+	mirrorFetcher    *MirrorFetcher
+	fetcherCache     *DiskCache
+	icmpTargetsMutex sync.Mutex
+	icmpTargets      []model.IcmpTarget
+	icmpTargetsReady bool
+	legacy           bool
+}
+
+// Sources are the download mirrors and the paths of the domestic target
+// lists, which are fetched through the first mirror that answers.
+type Sources struct {
+	Mirrors     []string
+	IcmpTargets string
+	NetCMCC     string
+	NetCT       string
+	NetCU       string
+	CnCMCC      string
+	CnCT        string
+	CnCU        string
+}
+
+// DefaultSources returns the sources configured in the model package.
+func DefaultSources() Sources {
+	return Sources{
+		Mirrors:     append([]string(nil), model.CdnList...),
+		IcmpTargets: model.IcmpTargets,
+		NetCMCC:     model.NetCMCC, NetCT: model.NetCT, NetCU: model.NetCU,
+		CnCMCC: model.CnCMCC, CnCT: model.CnCT, CnCU: model.CnCU,
+	}
+}
+
+// NewClient returns a client with the default sources and concurrency and
+// no logger.
+func NewClient() *Client {
+	return &Client{Concurrency: defaultConcurrency, Sources: DefaultSources()}
+}
+
+// defaultClient backs the package-level functions. It reads the model
+// globals on every use, so existing callers that set them keep working.
+var defaultClient = NewDefaultClient()
+
+// NewDefaultClient returns a client configured like the one behind the
+// package-level functions: it reads model.EnableLoger, model.MaxConcurrency
+// and the model source variables on every use. Callers set its RateLimiter
+// and Cache to give one run its own limit and cache.
+func NewDefaultClient() *Client {
+	return &Client{legacy: true}
+}
+
+var defaultLoggerOnce sync.Once
+
+func (client *Client) logger() *zap.Logger {
+	if !client.legacy {
+		return client.Logger
+	}
+	if !model.EnableLoger {
+		return nil
+	}
+	defaultLoggerOnce.Do(func() {
+		if Logger == nil {
+			InitLogger()
+		}
+	})
+	return Logger
+}
+
+func (client *Client) logError(msg string) {
+	if logger := client.logger(); logger != nil {
+		logger.Info(msg)
+	}
+}
+
+// syncLogger flushes the client's logger.
+func (client *Client) syncLogger() {
+	if logger := client.logger(); logger != nil {
+		_ = logger.Sync()
+	}
+}
+
+func (client *Client) concurrency() int {
+	if client.legacy && model.MaxConcurrency > 0 {
+		return model.MaxConcurrency
+	}
+	if client.Concurrency > 0 {
+		return client.Concurrency
+	}
+	return defaultConcurrency
+}
+
+func (client *Client) sources() Sources {
+	if client.legacy {
+		return DefaultSources()
+	}
+	sources, defaults := client.Sources, DefaultSources()
+	if len(sources.Mirrors) == 0 {
+		sources.Mirrors = defaults.Mirrors
+	}
+	for _, field := range []struct{ value, fallback *string }{
+		{&sources.IcmpTargets, &defaults.IcmpTargets},
+		{&sources.NetCMCC, &defaults.NetCMCC}, {&sources.NetCT, &defaults.NetCT}, {&sources.NetCU, &defaults.NetCU},
+		{&sources.CnCMCC, &defaults.CnCMCC}, {&sources.CnCT, &defaults.CnCT}, {&sources.CnCU, &defaults.CnCU},
+	} {
+		if *field.value == "" {
+			*field.value = *field.fallback
+		}
+	}
+	return sources
+}
+
+func (client *Client) fetcher() Fetcher {
+	if client.Fetcher != nil {
+		return client.Fetcher
+	}
+	mirrors, cache := client.sources().Mirrors, client.Cache
+	client.fetcherMutex.Lock()
+	defer client.fetcherMutex.Unlock()
+	if client.mirrorFetcher == nil || client.fetcherCache != cache || !slices.Equal(client.mirrorFetcher.Mirrors, mirrors) {
+		client.mirrorFetcher = NewMirrorFetcher(mirrors)
+		client.mirrorFetcher.Validate = validateTargetList
+		if len(client.Sources.Mirrors) == 0 {
+			// Only the built-in mirrors are known to serve the check file.
+			client.mirrorFetcher.Check = MirrorCheckEndpoint
+		}
+		if cache != nil {
+			client.mirrorFetcher.Client = cache.HTTPClient(10 * time.Second)
+		}
+		client.fetcherCache = cache
+	}
+	return client.mirrorFetcher
+}
+
+// registryHTTPClient is the client the registry loaders use: nil, which
+// they default, unless a cache is configured.
+func registryHTTPClient(cache *DiskCache) *http.Client {
+	if cache == nil {
+		return nil
+	}
+	return cache.HTTPClient(8 * time.Second)
+}
@@ -0,0 +1,85 @@
+package pt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+// icmpTargetMirror serves a nodes.json with one China Mobile target in
+// province.
+func icmpTargetMirror(t *testing.T, province, ip string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, `[{"province":%q,"isp_code":"cm","ip_version":"v4","ips":%q}]`, province, ip)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientsKeepSeparateSourcesAndCaches(t *testing.T) {
+	first := &Client{Sources: Sources{Mirrors: []string{icmpTargetMirror(t, "北京市", "192.0.2.1").URL + "/"}, IcmpTargets: "nodes.json"}}
+	second := &Client{Sources: Sources{Mirrors: []string{icmpTargetMirror(t, "上海市", "192.0.2.2").URL + "/"}, IcmpTargets: "nodes.json"}}
+	firstServers, secondServers := first.getIcmpServers("cm"), second.getIcmpServers("cm")
+	if len(firstServers) != 1 || firstServers[0].Name != "移动北京" || firstServers[0].IP != "192.0.2.1" {
+		t.Fatalf("first client servers = %+v", firstServers)
+	}
+	if len(secondServers) != 1 || secondServers[0].Name != "移动上海" || secondServers[0].IP != "192.0.2.2" {
+		t.Fatalf("second client servers = %+v", secondServers)
+	}
+	if !first.icmpTargetsReady || defaultClient.icmpTargetsReady {
+		t.Fatal("client cache leaked into the default client")
+	}
+}
+
+func TestClientDefaults(t *testing.T) {
+	var client Client
+	if client.logger() != nil || client.concurrency() != defaultConcurrency {
+		t.Fatalf("zero client logger %v, concurrency %d", client.logger(), client.concurrency())
+	}
+	sources := (&Client{Sources: Sources{NetCT: "ct.csv"}}).sources()
+	if sources.NetCT != "ct.csv" || sources.NetCU != model.NetCU || len(sources.Mirrors) != len(model.CdnList) {
+		t.Fatalf("sources = %+v", sources)
+	}
+	previous := model.MaxConcurrency
+	model.MaxConcurrency = 7
+	defer func() { model.MaxConcurrency = previous }()
+	if defaultClient.concurrency() != 7 {
+		t.Fatalf("default client concurrency = %d, want model.MaxConcurrency", defaultClient.concurrency())
+	}
+}
+
+// failingTransport counts the requests it refuses.
+type failingTransport struct{ requests atomic.Int32 }
+
+func (transport *failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	transport.requests.Add(1)
+	return nil, errors.New("unreachable")
+}
+
+func TestClientLoadsRegistryThroughItsCache(t *testing.T) {
+	transport := &failingTransport{}
+	cache := NewDiskCache(t.TempDir(), time.Hour, false)
+	cache.Transport = transport
+	client := &Client{Cache: cache}
+	results, loaded, err := client.RunLoadedTCPRegistry(context.Background(), TCPProbeConfig{
+		Attempts: 1,
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			return nil, errors.New("offline")
+		},
+	})
+	if err != nil || !loaded.Fallback || len(results) != len(loaded.Targets) {
+		t.Fatalf("RunLoadedTCPRegistry = %d results, %+v, %v", len(results), loaded, err)
+	}
+	if transport.requests.Load() == 0 {
+		t.Fatal("the registry was not requested through the client's cache")
+	}
+}
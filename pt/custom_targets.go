@@ -0,0 +1,80 @@
+package pt
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+// CustomPingTest pings the icmp entries of a -targets file and renders them in
+// the same fixed-width layout as the international Ping test. ICMP entries
+// carry no port, so there is no TCP fallback.
+func (client *Client) CustomPingTest(targets []model.CustomTarget, options PingOptions) string {
+	defer func() {
+		if r := recover(); r != nil {
+			client.logError(fmt.Sprintf("CustomPingTest panic 恢复: %v", r))
+		}
+	}()
+	return formatPingServers(client.processWithLimitedConcurrency(context.Background(), customPingServers(targets), nil, nil), options.Sort, options.Language)
+}
+
+// CustomPingTest runs Client.CustomPingTest on the default client.
+func CustomPingTest(targets []model.CustomTarget, options PingOptions) string {
+	return defaultClient.CustomPingTest(targets, options)
+}
+
+func customPingServers(targets []model.CustomTarget) []*model.Server {
+	servers := make([]*model.Server, 0, len(targets))
+	for _, target := range model.CustomTargetsByProtocol(targets, model.ProtocolICMP) {
+		servers = append(servers, &model.Server{Name: target.Name, IP: target.Host, Port: target.Port})
+	}
+	return servers
+}
+
+// CustomWebsiteTest runs the website test against the http and tls entries of
+// a -targets file.
+func (client *Client) CustomWebsiteTest(targets []model.CustomTarget) string {
+	return client.WebsiteTestWithTargets(customWebsites(targets))
+}
+
+// CustomWebsiteTest runs Client.CustomWebsiteTest on the default client.
+func CustomWebsiteTest(targets []model.CustomTarget) string {
+	return defaultClient.CustomWebsiteTest(targets)
+}
+
+func customWebsites(targets []model.CustomTarget) []model.Website {
+	selected := model.CustomTargetsByProtocol(targets, model.ProtocolHTTP, model.ProtocolTLS)
+	websites := make([]model.Website, 0, len(selected))
+	for _, target := range selected {
+		websites = append(websites, target.Website())
+	}
+	return websites
+}
+
+// CustomTCPTargets returns the tcp and tls entries of a -targets file as TCP
+// handshake targets; a TLS endpoint always accepts a plain TCP handshake.
+func CustomTCPTargets(targets []model.CustomTarget) []model.TCPTarget {
+	selected := model.CustomTargetsByProtocol(targets, model.ProtocolTCP, model.ProtocolTLS)
+	result := make([]model.TCPTarget, 0, len(selected))
+	for _, target := range selected {
+		result = append(result, target.TCPTarget())
+	}
+	return result
+}
+
+// CustomICMPTargets converts the icmp entries of a -targets file for
+// RunICMPProbes.
+func CustomICMPTargets(targets []model.CustomTarget) []ICMPTarget {
+	selected := model.CustomTargetsByProtocol(targets, model.ProtocolICMP)
+	result := make([]ICMPTarget, 0, len(selected))
+	for _, target := range selected {
+		result = append(result, ICMPTarget{ID: "custom-" + target.Host, Name: target.Name, Host: target.Host})
+	}
+	return result
+}
+
+// RunCustomTCPProbes probes the tcp and tls entries of a -targets file.
+func RunCustomTCPProbes(ctx context.Context, targets []model.CustomTarget, config TCPProbeConfig) []TCPResult {
+	return RunTCPProbes(ctx, CustomTCPTargets(targets), config)
+}
@@ -0,0 +1,71 @@
+package pt
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+func TestCustomPingKeepsSameNamedTargetsWithDifferentHosts(t *testing.T) {
+	targets, err := model.DecodeCustomTargets([]byte(`[
+		{"name":"edge","host":"127.0.0.1","protocol":"icmp"},
+		{"name":"edge","host":"127.0.0.2","protocol":"icmp"}
+	]`), model.CustomTargetFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := (&Client{}).CustomPingResults(context.Background(), targets, PingOptions{}, nil)
+	hosts := map[string]bool{}
+	for _, result := range results {
+		hosts[result.Host] = true
+	}
+	if len(results) != 2 || !hosts["127.0.0.1"] || !hosts["127.0.0.2"] {
+		t.Fatalf("results = %+v, want one per host", results)
+	}
+}
+
+func TestCustomResultsStopWhenContextEnds(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { requests.Add(1) }))
+	defer server.Close()
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+	targets, err := model.DecodeCustomTargets([]byte(fmt.Sprintf(`[
+		{"name":"site","host":"127.0.0.1","port":%d,"protocol":"http"},
+		{"name":"node","host":"192.0.2.1","protocol":"icmp"}
+	]`, port)), model.CustomTargetFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	started := time.Now()
+	websites := (&Client{}).CustomWebsiteResults(ctx, targets, nil)
+	pings := (&Client{}).CustomPingResults(ctx, targets, PingOptions{}, nil)
+	if len(websites) != 1 || websites[0].Status == LatencyOK || len(pings) != 1 || pings[0].Status == LatencyOK {
+		t.Fatalf("websites = %+v, pings = %+v", websites, pings)
+	}
+	if requests.Load() != 0 || time.Since(started) > time.Second {
+		t.Fatalf("canceled run sent %d requests in %v", requests.Load(), time.Since(started))
+	}
+}
+
+func TestInternationalServersAreDeduplicated(t *testing.T) {
+	servers := icmpTargetServers([]ICMPTarget{
+		{Name: "Cloudflare", Host: "1.1.1.1"},
+		{Name: "Cloudflare", Host: "1.0.0.1"},
+		{Name: "Google", Host: "8.8.8.8"},
+	})
+	if len(servers) != 2 {
+		t.Fatalf("servers = %+v, want one per name", servers)
+	}
+	if got := len(internationalServers()); got != len(InternationalICMPTargets()) {
+		t.Fatalf("international servers = %d, want %d", got, len(InternationalICMPTargets()))
+	}
+}
@@ -0,0 +1,420 @@
+package pt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+// Target diff states.
+const (
+	DiffUnchanged  = "unchanged"
+	DiffRegression = "regression"
+	DiffImproved   = "improved"
+	DiffAdded      = "added"
+	DiffRemoved    = "removed"
+)
+
+// DiffThresholds decide when a change counts as a regression or an
+// improvement. A latency change must exceed both its absolute threshold and
+// LatencyPercent of the old value, so small jitter on fast targets and
+// relative noise on slow targets are both ignored.
+type DiffThresholds struct {
+	// LossPercent is the loss increase in percentage points.
+	LossPercent    float64
+	P50            time.Duration
+	P95            time.Duration
+	LatencyPercent float64
+}
+
+// DefaultDiffThresholds returns the thresholds used by the diff command.
+func DefaultDiffThresholds() DiffThresholds {
+	return DiffThresholds{LossPercent: 5, P50: 20 * time.Millisecond, P95: 50 * time.Millisecond, LatencyPercent: 20}
+}
+
+// DiffMetrics is the comparable part of one target's result. Latencies are
+// zero when nothing answered.
+type DiffMetrics struct {
+	LossPercent float64        `json:"loss_percent"`
+	P50         time.Duration  `json:"p50"`
+	P95         time.Duration  `json:"p95"`
+	Errors      map[string]int `json:"errors,omitempty"`
+}
+
+func (metrics DiffMetrics) answered() bool {
+	return metrics.LossPercent < 100
+}
+
+// TargetDiff compares one target across two runs. Old is nil for added
+// targets and New for removed ones.
+type TargetDiff struct {
+	Mode      string        `json:"mode"`
+	Key       string        `json:"key"`
+	Name      string        `json:"name"`
+	State     string        `json:"state"`
+	Old       *DiffMetrics  `json:"old,omitempty"`
+	New       *DiffMetrics  `json:"new,omitempty"`
+	LossDelta float64       `json:"loss_delta"`
+	P50Delta  time.Duration `json:"p50_delta"`
+	P95Delta  time.Duration `json:"p95_delta"`
+	NewErrors []string      `json:"new_errors,omitempty"`
+	Reasons   []string      `json:"reasons,omitempty"`
+}
+
+// RunDiff is the comparison of two runs, ordered by mode and key.
+type RunDiff struct {
+	OldStartedAt time.Time      `json:"old_started_at"`
+	NewStartedAt time.Time      `json:"new_started_at"`
+	Thresholds   DiffThresholds `json:"thresholds"`
+	Targets      []TargetDiff   `json:"targets"`
+	Regressions  int            `json:"regressions"`
+	Improvements int            `json:"improvements"`
+}
+
+// LoadResultFile reads a saved run: a -format json result envelope, a
+// history record, or the bare TCP result array written by -json before the
+// envelope existed.
+func LoadResultFile(path string) (ResultEnvelope, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ResultEnvelope{}, err
+	}
+	return DecodeResultEnvelope(data)
+}
+
+// DecodeResultEnvelope is LoadResultFile for data already in memory.
+func DecodeResultEnvelope(data []byte) (ResultEnvelope, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		var results []TCPResult
+		if err := json.Unmarshal(data, &results); err != nil {
+			return ResultEnvelope{}, err
+		}
+		return ResultEnvelope{Results: EnvelopeResults{TCP: results}}, nil
+	}
+	var record HistoryRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return ResultEnvelope{}, err
+	}
+	if record.Schema == "" {
+		return ResultEnvelope{}, errors.New("not a pingtest result: missing schema")
+	}
+	if record.Schema != ResultSchemaVersion {
+		return ResultEnvelope{}, fmt.Errorf("unsupported result schema %q", record.Schema)
+	}
+	return record.ResultEnvelope, nil
+}
+
+type diffTarget struct {
+	mode, key, name string
+	metrics         DiffMetrics
+}
+
+// diffTargets flattens an envelope into comparable targets keyed by mode and
+// a stable identity: ISP and province for ori, name, kind and address family
+// for tgdc, DC id and address family for mtproto, and the normalized host:port
+// for web and tcp.
+func diffTargets(envelope ResultEnvelope) []diffTarget {
+	var targets []diffTarget
+	latency := func(mode string, results []LatencyResult, key func(LatencyResult) string) {
+		for _, result := range results {
+			metrics := DiffMetrics{LossPercent: 100}
+			if result.Status == LatencyOK {
+				metrics = DiffMetrics{P50: result.Latency, P95: result.Latency}
+			}
+			name := result.Name
+			if result.Location != "" {
+				name += " " + result.Location
+			}
+			targets = append(targets, diffTarget{mode: mode, key: key(result), name: name, metrics: metrics})
+		}
+	}
+	latency(model.ModeOri, envelope.Results.Ori, func(result LatencyResult) string {
+		return strings.ToLower(strings.TrimSpace(result.Name))
+	})
+	latency(model.ModeTGDC, envelope.Results.TGDC, func(result LatencyResult) string {
+		return strings.ToLower(strings.TrimSpace(result.Name)) + "/" + result.Category + "/" + addressFamily(result.Host)
+	})
+	for _, result := range envelope.Results.MTProto {
+		metrics := DiffMetrics{LossPercent: 100, Errors: map[string]int{}}
+		usable := 0
+		for _, endpoint := range result.Endpoints {
+			if endpoint.Usable {
+				usable++
+			} else if endpoint.ErrorClass != "" {
+				metrics.Errors[endpoint.ErrorClass]++
+			}
+		}
+		if len(result.Endpoints) > 0 {
+			metrics.LossPercent = float64(len(result.Endpoints)-usable) / float64(len(result.Endpoints)) * 100
+		}
+		if result.Usable {
+			metrics.P50, metrics.P95 = result.Response, result.Response
+		}
+		targets = append(targets, diffTarget{
+			mode: model.ModeMTProto, key: "dc" + strconv.Itoa(result.DCID) + "/" + addressFamily(result.Host),
+			name: strings.TrimSpace(result.Name + " " + result.Location), metrics: metrics,
+		})
+	}
+	latency(model.ModeWeb, envelope.Results.Web, func(result LatencyResult) string {
+		return model.WebsiteTargetKey(result.Host)
+	})
+	for _, result := range envelope.Results.TCP {
+		metrics := DiffMetrics{LossPercent: result.LossPercent, Errors: result.ErrorCounts}
+		if result.Successful > 0 {
+			metrics.P50, metrics.P95 = result.P50, result.P95
+		} else {
+			metrics.LossPercent = 100
+		}
+		name := result.Target.Name
+		if name == "" {
+			name = result.Target.Host
+		}
+		targets = append(targets, diffTarget{mode: model.ModeTCP, key: model.TargetKey(result.Target), name: name, metrics: metrics})
+	}
+	return targets
+}
+
+func addressFamily(host string) string {
+	if strings.Contains(host, ":") {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// DiffRuns aligns the targets of two runs and reports per-target deltas.
+// Targets present in only one run are listed as added or removed and never
+// count as regressions.
+func DiffRuns(old, new ResultEnvelope, thresholds DiffThresholds) RunDiff {
+	diff := RunDiff{OldStartedAt: old.StartedAt, NewStartedAt: new.StartedAt, Thresholds: thresholds}
+	index := map[string]int{}
+	for _, target := range diffTargets(old) {
+		id := target.mode + "\x00" + target.key
+		if _, exists := index[id]; exists {
+			continue
+		}
+		metrics := target.metrics
+		index[id] = len(diff.Targets)
+		diff.Targets = append(diff.Targets, TargetDiff{Mode: target.mode, Key: target.key, Name: target.name, State: DiffRemoved, Old: &metrics})
+	}
+	for _, target := range diffTargets(new) {
+		id := target.mode + "\x00" + target.key
+		metrics := target.metrics
+		position, exists := index[id]
+		if !exists {
+			index[id] = len(diff.Targets)
+			diff.Targets = append(diff.Targets, TargetDiff{Mode: target.mode, Key: target.key, Name: target.name, State: DiffAdded, New: &metrics})
+			continue
+		}
+		entry := &diff.Targets[position]
+		if entry.New != nil {
+			continue
+		}
+		entry.Name, entry.New = target.name, &metrics
+		compareTarget(entry, thresholds)
+		switch entry.State {
+		case DiffRegression:
+			diff.Regressions++
+		case DiffImproved:
+			diff.Improvements++
+		}
+	}
+	modeOrder := map[string]int{}
+	for position, mode := range model.PlanModes {
+		modeOrder[mode] = position
+	}
+	sort.SliceStable(diff.Targets, func(i, j int) bool {
+		if diff.Targets[i].Mode != diff.Targets[j].Mode {
+			return modeOrder[diff.Targets[i].Mode] < modeOrder[diff.Targets[j].Mode]
+		}
+		return diff.Targets[i].Key < diff.Targets[j].Key
+	})
+	return diff
+}
+
+func compareTarget(entry *TargetDiff, thresholds DiffThresholds) {
+	old, new := entry.Old, entry.New
+	entry.State = DiffUnchanged
+	entry.LossDelta = new.LossPercent - old.LossPercent
+	if old.answered() && new.answered() {
+		entry.P50Delta = new.P50 - old.P50
+		entry.P95Delta = new.P95 - old.P95
+	}
+	for class, count := range new.Errors {
+		if count > 0 && old.Errors[class] == 0 {
+			entry.NewErrors = append(entry.NewErrors, class)
+		}
+	}
+	sort.Strings(entry.NewErrors)
+	worse, better := false, false
+	switch {
+	case old.answered() && !new.answered():
+		entry.Reasons = append(entry.Reasons, "unreachable")
+		worse = true
+	case !old.answered() && new.answered():
+		better = true
+	case entry.LossDelta > thresholds.LossPercent:
+		entry.Reasons = append(entry.Reasons, "loss")
+		worse = true
+	case entry.LossDelta < -thresholds.LossPercent:
+		better = true
+	}
+	latencyChanged := func(delta, before, threshold time.Duration) int {
+		limit := max(threshold, time.Duration(float64(before)*thresholds.LatencyPercent/100))
+		switch {
+		case delta > limit:
+			return 1
+		case delta < -limit:
+			return -1
+		}
+		return 0
+	}
+	if old.answered() && new.answered() {
+		for _, check := range []struct {
+			name               string
+			delta, before, abs time.Duration
+		}{
+			{"p50", entry.P50Delta, old.P50, thresholds.P50},
+			{"p95", entry.P95Delta, old.P95, thresholds.P95},
+		} {
+			switch latencyChanged(check.delta, check.before, check.abs) {
+			case 1:
+				entry.Reasons = append(entry.Reasons, check.name)
+				worse = true
+			case -1:
+				better = true
+			}
+		}
+	}
+	if len(entry.NewErrors) > 0 {
+		entry.Reasons = append(entry.Reasons, "errors")
+		worse = true
+	}
+	switch {
+	case worse:
+		entry.State = DiffRegression
+	case better:
+		entry.State = DiffImproved
+	}
+}
+
+// Changed returns the targets whose state is not unchanged.
+func (diff RunDiff) Changed() []TargetDiff {
+	var changed []TargetDiff
+	for _, target := range diff.Targets {
+		if target.State != DiffUnchanged {
+			changed = append(changed, target)
+		}
+	}
+	return changed
+}
+
+// DiffTable flattens targets for the Markdown format: one row per target
+// with old and new values and their deltas.
+func DiffTable(targets []TargetDiff) Table {
+	table := Table{Header: []string{"mode", "target", "loss_percent", "p50_ms", "p95_ms", "new_errors", "state"}}
+	for _, target := range targets {
+		table.Rows = append(table.Rows, []string{
+			target.Mode, target.Name + " (" + target.Key + ")",
+			diffLossCell(target), diffLatencyCell(target, func(metrics *DiffMetrics) time.Duration { return metrics.P50 }, target.P50Delta),
+			diffLatencyCell(target, func(metrics *DiffMetrics) time.Duration { return metrics.P95 }, target.P95Delta),
+			strings.Join(target.NewErrors, ";"), diffStateCell(target),
+		})
+	}
+	return table
+}
+
+// FormatRunDiff renders targets as a fixed-width text table followed by a
+// one-line summary of the whole diff.
+func FormatRunDiff(diff RunDiff, targets []TargetDiff, language string) string {
+	english := strings.EqualFold(strings.TrimSpace(language), "en")
+	header := []string{"模式", "目标", "丢包", "P50 ms", "P95 ms", "新错误", "状态"}
+	summary := "对比 %d 个目标: 退化 %d · 改善 %d · 新增 %d · 移除 %d"
+	if english {
+		header = []string{"Mode", "Target", "Loss", "P50 ms", "P95 ms", "New errors", "State"}
+		summary = "Compared %d targets: %d regressed · %d improved · %d added · %d removed"
+	}
+	table := DiffTable(targets)
+	rows := append([][]string{header}, table.Rows...)
+	widths := make([]int, len(header))
+	for _, row := range rows {
+		for index, cell := range row {
+			widths[index] = max(widths[index], runewidth.StringWidth(reportCell(cell)))
+		}
+	}
+	var output strings.Builder
+	if len(targets) > 0 {
+		for _, row := range rows {
+			line := ""
+			for index, cell := range row {
+				cell = reportCell(cell)
+				line += cell + strings.Repeat(" ", widths[index]-runewidth.StringWidth(cell)+2)
+			}
+			output.WriteString(strings.TrimRight(line, " ") + "\n")
+		}
+	}
+	added, removed := 0, 0
+	for _, target := range diff.Targets {
+		switch target.State {
+		case DiffAdded:
+			added++
+		case DiffRemoved:
+			removed++
+		}
+	}
+	fmt.Fprintf(&output, summary, len(diff.Targets), diff.Regressions, diff.Improvements, added, removed)
+	return output.String()
+}
+
+func diffLossCell(target TargetDiff) string {
+	switch {
+	case target.Old == nil:
+		return strconv.FormatFloat(target.New.LossPercent, 'f', 1, 64)
+	case target.New == nil:
+		return strconv.FormatFloat(target.Old.LossPercent, 'f', 1, 64)
+	}
+	return strconv.FormatFloat(target.Old.LossPercent, 'f', 1, 64) + "→" + strconv.FormatFloat(target.New.LossPercent, 'f', 1, 64) +
+		" (" + signedFloat(target.LossDelta, 1) + ")"
+}
+
+func diffLatencyCell(target TargetDiff, value func(*DiffMetrics) time.Duration, delta time.Duration) string {
+	cell := func(metrics *DiffMetrics) string {
+		if metrics == nil || !metrics.answered() {
+			return "-"
+		}
+		return tableMilliseconds(value(metrics))
+	}
+	switch {
+	case target.Old == nil:
+		return cell(target.New)
+	case target.New == nil:
+		return cell(target.Old)
+	case !target.Old.answered() || !target.New.answered():
+		return cell(target.Old) + "→" + cell(target.New)
+	}
+	return cell(target.Old) + "→" + cell(target.New) + " (" + signedFloat(float64(delta)/float64(time.Millisecond), 2) + ")"
+}
+
+func diffStateCell(target TargetDiff) string {
+	if len(target.Reasons) == 0 {
+		return target.State
+	}
+	return target.State + ": " + strings.Join(target.Reasons, ",")
+}
+
+func signedFloat(value float64, precision int) string {
+	text := strconv.FormatFloat(value, 'f', precision, 64)
+	if value >= 0 {
+		return "+" + text
+	}
+	return text
+}
@@ -0,0 +1,88 @@
+package pt
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+func diffTCPResult(host string, successful int, p50, p95 time.Duration, errors map[string]int) TCPResult {
+	return TCPResult{
+		Target: model.TCPTarget{Name: host, Host: host, Port: 443}, Attempts: 4, Successful: successful,
+		LossPercent: float64(4-successful) / 4 * 100, P50: p50, P95: p95, ErrorCounts: errors,
+	}
+}
+
+func TestDiffRunsAlignsTargetsAndFlagsRegressions(t *testing.T) {
+	old := ResultEnvelope{Results: EnvelopeResults{
+		Ori: []LatencyResult{{Name: "北京电信", Status: LatencyOK, Latency: 30 * time.Millisecond}},
+		Web: []LatencyResult{{Name: "Example", Host: "https://Example.test/", Status: LatencyOK, Latency: 10 * time.Millisecond}},
+		TCP: []TCPResult{
+			diffTCPResult("slow.test", 4, 10*time.Millisecond, 20*time.Millisecond, nil),
+			diffTCPResult("lossy.test", 4, 10*time.Millisecond, 20*time.Millisecond, nil),
+			diffTCPResult("faster.test", 4, 200*time.Millisecond, 300*time.Millisecond, nil),
+			diffTCPResult("gone.test", 4, time.Millisecond, time.Millisecond, nil),
+			diffTCPResult("jitter.test", 4, 100*time.Millisecond, 150*time.Millisecond, nil),
+		},
+	}}
+	new := ResultEnvelope{Results: EnvelopeResults{
+		Ori: []LatencyResult{{Name: "北京电信 ", Status: LatencyFailed}},
+		Web: []LatencyResult{{Name: "Example", Host: "https://example.test./other", Status: LatencyOK, Latency: 11 * time.Millisecond}},
+		TCP: []TCPResult{
+			diffTCPResult("SLOW.test.", 4, 60*time.Millisecond, 20*time.Millisecond, nil),
+			diffTCPResult("lossy.test", 3, 10*time.Millisecond, 20*time.Millisecond, map[string]int{TCPErrorTimeout: 1}),
+			diffTCPResult("faster.test", 4, 100*time.Millisecond, 150*time.Millisecond, nil),
+			diffTCPResult("jitter.test", 4, 115*time.Millisecond, 190*time.Millisecond, nil),
+			diffTCPResult("new.test", 4, time.Millisecond, time.Millisecond, nil),
+		},
+	}}
+	diff := DiffRuns(old, new, DefaultDiffThresholds())
+	states := map[string]TargetDiff{}
+	for _, target := range diff.Targets {
+		states[target.Mode+"/"+target.Key] = target
+	}
+	for key, want := range map[string]string{
+		"ori/北京电信":             DiffRegression + ":unreachable",
+		"web/example.test:443": DiffUnchanged + ":",
+		"tcp/slow.test:443":    DiffRegression + ":p50",
+		"tcp/lossy.test:443":   DiffRegression + ":loss,errors",
+		"tcp/faster.test:443":  DiffImproved + ":",
+		"tcp/jitter.test:443":  DiffUnchanged + ":",
+		"tcp/gone.test:443":    DiffRemoved + ":",
+		"tcp/new.test:443":     DiffAdded + ":",
+	} {
+		got := states[key].State + ":" + strings.Join(states[key].Reasons, ",")
+		if got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if diff.Regressions != 3 || diff.Improvements != 1 || len(diff.Targets) != 8 || diff.Targets[0].Mode != model.ModeOri {
+		t.Fatalf("unexpected diff summary: %+v", diff)
+	}
+	if lossy := states["tcp/lossy.test:443"]; lossy.LossDelta != 25 || strings.Join(lossy.NewErrors, ",") != TCPErrorTimeout {
+		t.Fatalf("lossy deltas = %+v", lossy)
+	}
+	text := FormatRunDiff(diff, diff.Changed(), "en")
+	for _, value := range []string{"0.0→25.0 (+25.0)", "10.00→60.00 (+50.00)", "regression: loss,errors", "3 regressed · 1 improved · 1 added · 1 removed"} {
+		if !strings.Contains(text, value) {
+			t.Errorf("diff text %q does not contain %q", text, value)
+		}
+	}
+	if strings.Contains(text, "jitter.test") {
+		t.Errorf("unchanged target listed: %q", text)
+	}
+}
+
+func TestDecodeResultEnvelopeAcceptsLegacyTCPArrays(t *testing.T) {
+	envelope, err := DecodeResultEnvelope([]byte(` [{"target":{"host":"a.test","port":443},"attempts":1,"successful":1}]`))
+	if err != nil || len(envelope.Results.TCP) != 1 {
+		t.Fatalf("legacy array = %+v, %v", envelope, err)
+	}
+	for _, data := range []string{`{"results":{}}`, `{"schema":"pingtest.result/v9"}`, `nope`} {
+		if _, err := DecodeResultEnvelope([]byte(data)); err == nil {
+			t.Errorf("%s decoded without error", data)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package pt
+
+import (
+	"os"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+// ResultSchemaVersion identifies the layout of ResultEnvelope. It changes
+// only when a field is removed or changes meaning.
+const ResultSchemaVersion = "pingtest.result/v1"
+
+// Registry names reported in RegistryInfo.
+const (
+	RegistryTCP      = "tcp"
+	RegistryTelegram = "telegram"
+)
+
+// RegistryInfo records which copy of a target registry a run used: a remote
+// source name or "embedded", whether that was a fallback, and the manifest
+// metadata of the snapshot.
+type RegistryInfo struct {
+	Name     string                 `json:"name"`
+	Source   string                 `json:"source"`
+	Fallback bool                   `json:"fallback"`
+	Metadata model.RegistryMetadata `json:"metadata"`
+}
+
+// HostInfo describes the machine that ran the probes. Kernel is empty where
+// the platform does not report a release string.
+type HostInfo struct {
+	Hostname string `json:"hostname,omitempty"`
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	Kernel   string `json:"kernel,omitempty"`
+}
+
+// CurrentHostInfo returns the HostInfo of the running process.
+func CurrentHostInfo() HostInfo {
+	hostname, _ := os.Hostname()
+	return HostInfo{Hostname: hostname, OS: runtime.GOOS, Arch: runtime.GOARCH, Kernel: kernelRelease()}
+}
+
+// RunConfig is the effective configuration of a run after flags, plan
+// overrides and defaults were applied. Modes lists every step in run order.
+type RunConfig struct {
+	Modes       []string      `json:"modes"`
+	Language    string        `json:"language"`
+	Attempts    int           `json:"attempts"`
+	Timeout     time.Duration `json:"timeout"`
+	Concurrency int           `json:"concurrency"`
+	PingScope   string        `json:"ping_scope"`
+	PingSort    string        `json:"ping_sort"`
+	TCPSort     string        `json:"tcp_sort"`
+	// Rate and RateBurst are the -rate limit in effect; zero means unlimited.
+	Rate      float64 `json:"rate,omitempty"`
+	RateBurst int     `json:"rate_burst,omitempty"`
+}
+
+// EnvelopeResults holds one array per mode. Several steps of the same mode
+// append to the same array.
+type EnvelopeResults struct {
+	Ori     []LatencyResult         `json:"ori,omitempty"`
+	TGDC    []LatencyResult         `json:"tgdc,omitempty"`
+	MTProto []TelegramMTProtoResult `json:"mtproto,omitempty"`
+	Web     []LatencyResult         `json:"web,omitempty"`
+	TCP     []TCPResult             `json:"tcp,omitempty"`
+}
+
+// ResultEnvelope is the JSON document written for -format json. It carries
+// enough provenance to compare runs: the binary version, when and where it
+// ran, with which settings, against which registry snapshots and how old the
+// cached target lists were.
+type ResultEnvelope struct {
+	Schema     string          `json:"schema"`
+	Version    string          `json:"version"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	Host       HostInfo        `json:"host"`
+	Config     RunConfig       `json:"config"`
+	Registries []RegistryInfo  `json:"registries"`
+	Cache      *CacheInfo      `json:"cache,omitempty"`
+	Results    EnvelopeResults `json:"results"`
+}
+
+// NewResultEnvelope starts an envelope for a run that begins now.
+func NewResultEnvelope(config RunConfig, started time.Time) *ResultEnvelope {
+	if config.Modes == nil {
+		config.Modes = []string{}
+	}
+	return &ResultEnvelope{
+		Schema:     ResultSchemaVersion,
+		Version:    model.PingTestVersion,
+		StartedAt:  started,
+		Host:       CurrentHostInfo(),
+		Config:     config,
+		Registries: []RegistryInfo{},
+	}
+}
+
+// Add appends a step's results and registries. A registry already reported
+// by an earlier step is kept once.
+func (envelope *ResultEnvelope) Add(section ReportSection) {
+	envelope.Config.Modes = append(envelope.Config.Modes, section.Mode)
+	for _, registry := range section.Registries {
+		if !slices.Contains(envelope.Registries, registry) {
+			envelope.Registries = append(envelope.Registries, registry)
+		}
+	}
+	switch section.Mode {
+	case model.ModeOri:
+		envelope.Results.Ori = append(envelope.Results.Ori, section.Latency...)
+	case model.ModeTGDC:
+		envelope.Results.TGDC = append(envelope.Results.TGDC, section.Latency...)
+	case model.ModeMTProto:
+		envelope.Results.MTProto = append(envelope.Results.MTProto, section.MTProto...)
+	case model.ModeWeb:
+		envelope.Results.Web = append(envelope.Results.Web, section.Latency...)
+	case model.ModeTCP:
+		envelope.Results.TCP = append(envelope.Results.TCP, section.TCP...)
+	}
+}
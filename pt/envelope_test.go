@@ -0,0 +1,41 @@
+package pt
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+func TestResultEnvelopeAddGroupsByModeAndDedupesRegistries(t *testing.T) {
+	registry := RegistryInfo{Name: RegistryTelegram, Source: "embedded", Metadata: model.RegistryMetadata{Count: 5}}
+	envelope := NewResultEnvelope(RunConfig{Language: "zh"}, time.Unix(0, 0))
+	envelope.Add(ReportSection{Mode: model.ModeTGDC, Latency: []LatencyResult{{Name: "TG-DC1"}}, Registries: []RegistryInfo{registry}})
+	envelope.Add(ReportSection{Mode: model.ModeMTProto, MTProto: []TelegramMTProtoResult{{DCID: 1}}, Registries: []RegistryInfo{registry}})
+	envelope.Add(ReportSection{Mode: model.ModeTGDC, Latency: []LatencyResult{{Name: "TG-DC2"}}})
+	if envelope.Schema != ResultSchemaVersion || envelope.Version != model.PingTestVersion {
+		t.Fatalf("unexpected envelope header: %+v", envelope)
+	}
+	if len(envelope.Config.Modes) != 3 || len(envelope.Results.TGDC) != 2 || len(envelope.Results.MTProto) != 1 || envelope.Results.Ori != nil {
+		t.Fatalf("unexpected grouping: %+v %+v", envelope.Config.Modes, envelope.Results)
+	}
+	if len(envelope.Registries) != 1 {
+		t.Fatalf("registries = %+v", envelope.Registries)
+	}
+	if envelope.Host.OS != runtime.GOOS || envelope.Host.Arch != runtime.GOARCH {
+		t.Fatalf("host = %+v", envelope.Host)
+	}
+}
+
+func TestTelegramDataCentersReportEmbeddedRegistry(t *testing.T) {
+	var reported []RegistryInfo
+	dcs := defaultClient.telegramDataCentersForOptions(context.Background(), TelegramDCOptions{OnRegistry: func(info RegistryInfo) { reported = append(reported, info) }})
+	if len(dcs) == 0 || len(reported) != 1 {
+		t.Fatalf("dcs=%d reported=%+v", len(dcs), reported)
+	}
+	if info := reported[0]; info.Name != RegistryTelegram || info.Source != "embedded" || info.Metadata.Schema != model.TelegramDCRegistrySchema || info.Metadata.SHA256 == "" {
+		t.Fatalf("unexpected registry info: %+v", info)
+	}
+}
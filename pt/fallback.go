@@ -0,0 +1,109 @@
+package pt
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+const (
+	// TelegramFallbackPort is open on every Telegram DC even when ICMP is filtered.
+	TelegramFallbackPort = 443
+	// speedtestNetDefaultPort is the port speedtest.net servers listen on when
+	// the CSV record does not carry an explicit host:port.
+	speedtestNetDefaultPort = 8080
+)
+
+// tcpFallbackConfig mirrors the legacy ICMP budget: three attempts with the
+// same per-attempt timeout, paced by the client's RateLimiter.
+func (client *Client) tcpFallbackConfig() TCPProbeConfig {
+	return TCPProbeConfig{Attempts: pingCount, Timeout: timeout, RateLimiter: client.RateLimiter}
+}
+
+// tcpFallbackLatency measures the mean TCP handshake time to host:port. It
+// reports false when the port is unknown or no handshake succeeded.
+func tcpFallbackLatency(ctx context.Context, host string, port int, config TCPProbeConfig) (time.Duration, bool) {
+	if strings.TrimSpace(host) == "" || port < 1 || port > 65535 {
+		return 0, false
+	}
+	result, err := RunTCPProbe(ctx, model.TCPTarget{Name: host, Host: host, Port: port}, config)
+	if err != nil || result.Successful == 0 {
+		return 0, false
+	}
+	return result.Mean, true
+}
+
+// applyServerTCPFallback retries a server that got no ICMP reply with TCP on
+// its speedtest port and records which protocol produced Avg.
+func applyServerTCPFallback(ctx context.Context, server *model.Server, config TCPProbeConfig) {
+	if server.Tested && server.Avg > 0 {
+		server.Protocol = model.ProtocolICMP
+		return
+	}
+	latency, ok := tcpFallbackLatency(ctx, server.IP, server.Port, config)
+	if !ok {
+		return
+	}
+	server.Avg, server.Tested, server.Protocol = latency, true, model.ProtocolTCP
+}
+
+// inheritServerFallback gives winner, kept over loser for the same ISP and
+// province, loser's TCP fallback port when both are the same host and winner
+// has none. icmp_targets entries carry no port; another host's port says
+// nothing about winner, so it is never borrowed.
+func inheritServerFallback(winner, loser *model.Server) {
+	if winner == loser || winner.Port != 0 || loser.Port == 0 || !strings.EqualFold(strings.TrimSpace(winner.IP), strings.TrimSpace(loser.IP)) {
+		return
+	}
+	winner.Port = loser.Port
+}
+
+// applyTelegramTCPFallback retries a DC that got no ICMP reply with TCP 443.
+func applyTelegramTCPFallback(ctx context.Context, dc *model.TelegramDC, config TCPProbeConfig) {
+	if dc.Tested && dc.Avg > 0 {
+		dc.Protocol = model.ProtocolICMP
+		return
+	}
+	latency, ok := tcpFallbackLatency(ctx, dc.IP, TelegramFallbackPort, config)
+	if !ok {
+		return
+	}
+	dc.Avg, dc.Tested, dc.Protocol = latency, true, model.ProtocolTCP
+}
+
+// speedtestPort extracts the port from a speedtest "host:port" field and
+// falls back to defaultPort when the field has none.
+func speedtestPort(value string, defaultPort int) int {
+	_, portText, err := net.SplitHostPort(strings.TrimSpace(value))
+	if err != nil {
+		return defaultPort
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 65535 {
+		return defaultPort
+	}
+	return port
+}
+
+// formatLatencyCell renders the fixed-width latency column. ICMP values keep
+// the historical "%4d | " layout; TCP fallback values replace the padding
+// space with a T marker so the column width never changes.
+func formatLatencyCell(avg time.Duration, protocol string) string {
+	marker := " "
+	if protocol == model.ProtocolTCP {
+		marker = "T"
+	}
+	return fmt.Sprintf("%4d%s| ", avg.Milliseconds(), marker)
+}
+
+func latencyFallbackLegend(language string) string {
+	if strings.EqualFold(strings.TrimSpace(language), "en") {
+		return "T = no ICMP reply, TCP handshake latency shown"
+	}
+	return "T = ICMP 无响应，显示 TCP 握手延迟"
+}
@@ -0,0 +1,109 @@
+package pt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+func TestApplyServerTCPFallbackUsesSpeedtestPort(t *testing.T) {
+	var dialed string
+	config := TCPProbeConfig{Attempts: 1, DialContext: func(_ context.Context, _, address string) (net.Conn, error) {
+		dialed = address
+		client, server := net.Pipe()
+		_ = server.Close()
+		return client, nil
+	}}
+	server := &model.Server{Name: "电信上海", IP: "192.0.2.10", Port: 8080}
+	applyServerTCPFallback(context.Background(), server, config)
+	if dialed != "192.0.2.10:8080" || !server.Tested || server.Protocol != model.ProtocolTCP {
+		t.Fatalf("TCP fallback not applied: dialed=%q server=%+v", dialed, server)
+	}
+
+	answered := &model.Server{Name: "联通北京", IP: "192.0.2.11", Port: 8080, Tested: true, Avg: 20 * time.Millisecond}
+	dialed = ""
+	applyServerTCPFallback(context.Background(), answered, config)
+	if dialed != "" || answered.Protocol != model.ProtocolICMP || answered.Avg != 20*time.Millisecond {
+		t.Fatalf("ICMP result was replaced: dialed=%q server=%+v", dialed, answered)
+	}
+
+	noPort := &model.Server{Name: "移动广东", IP: "192.0.2.12"}
+	applyServerTCPFallback(context.Background(), noPort, config)
+	if dialed != "" || noPort.Tested || noPort.Protocol != "" {
+		t.Fatalf("fallback ran without a known port: dialed=%q server=%+v", dialed, noPort)
+	}
+}
+
+func TestApplyTelegramTCPFallbackUses443AndKeepsFailures(t *testing.T) {
+	var dialed string
+	dc := &model.TelegramDC{Name: "TG-DC1", IP: "149.154.175.50"}
+	applyTelegramTCPFallback(context.Background(), dc, TCPProbeConfig{Attempts: 2, DialContext: func(_ context.Context, _, address string) (net.Conn, error) {
+		dialed = address
+		return nil, errors.New("filtered")
+	}})
+	if dialed != "149.154.175.50:443" || dc.Tested || dc.Protocol != "" {
+		t.Fatalf("failed fallback marked DC as tested: dialed=%q dc=%+v", dialed, dc)
+	}
+}
+
+func TestSpeedtestPortParsesHostPortFields(t *testing.T) {
+	for value, want := range map[string]int{"speed.example:8081": 8081, "[2001:db8::1]:5060": 5060, "CN": 8080, "host:0": 8080} {
+		if got := speedtestPort(value, 8080); got != want {
+			t.Errorf("speedtestPort(%q) = %d, want %d", value, got, want)
+		}
+	}
+}
+
+func TestFormatPingServersMarksTCPFallback(t *testing.T) {
+	icmpOnly := formatPingServers([]*model.Server{{Name: "电信上海", Avg: 25 * time.Millisecond, Protocol: model.ProtocolICMP}}, model.PingSortLatency, "zh")
+	if !strings.HasSuffix(icmpOnly, "  25 | ") || strings.Contains(icmpOnly, "TCP") {
+		t.Fatalf("ICMP-only layout changed: %q", icmpOnly)
+	}
+	mixed := formatPingServers([]*model.Server{
+		{Name: "电信上海", Avg: 25 * time.Millisecond, Protocol: model.ProtocolICMP},
+		{Name: "电信北京", Avg: 31 * time.Millisecond, Protocol: model.ProtocolTCP},
+	}, model.PingSortLatency, "en")
+	lines := strings.Split(mixed, "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "  31T| ") || !strings.Contains(lines[1], "TCP handshake") {
+		t.Fatalf("TCP fallback was not marked: %q", mixed)
+	}
+	if len(formatLatencyCell(time.Millisecond, model.ProtocolICMP)) != len(formatLatencyCell(time.Millisecond, model.ProtocolTCP)) {
+		t.Fatal("TCP marker changed the latency column width")
+	}
+}
+
+func TestICMPSourcedServerFallsBackOnlyToItsOwnHost(t *testing.T) {
+	servers := preprocessServers([]*model.Server{
+		{Name: "电信上海", IP: "192.0.2.20", Port: 8080, SourceType: "net"},
+		{Name: "电信上海", IP: "192.0.2.21", SourceType: "icmp"},
+		{Name: "电信上海", IP: "192.0.2.21", Port: 5060, SourceType: "cn"},
+		{Name: "联通北京", IP: "192.0.2.30", Port: 8080, SourceType: "net"},
+		{Name: "联通北京", IP: "192.0.2.31", SourceType: "icmp"},
+	})
+	byName := map[string]*model.Server{}
+	for _, server := range servers {
+		byName[server.Name] = server
+	}
+	if shanghai := byName["电信上海"]; shanghai.SourceType != "icmp" || shanghai.Port != 5060 {
+		t.Fatalf("上海 = %+v, want the icmp node with its own host's speedtest port", shanghai)
+	}
+	if beijing := byName["联通北京"]; beijing.SourceType != "icmp" || beijing.Port != 0 {
+		t.Fatalf("北京 = %+v, want no fallback borrowed from another host", beijing)
+	}
+	var dialed string
+	applyServerTCPFallback(context.Background(), byName["电信上海"], TCPProbeConfig{Attempts: 1, DialContext: func(_ context.Context, _, address string) (net.Conn, error) {
+		dialed = address
+		client, server := net.Pipe()
+		_ = server.Close()
+		return client, nil
+	}})
+	result := serverLatencyResult(byName["电信上海"])
+	if dialed != "192.0.2.21:5060" || result.Protocol != model.ProtocolTCP || result.Host != "192.0.2.21" || result.Port != 5060 {
+		t.Fatalf("fallback dialed %q, result %+v", dialed, result)
+	}
+}
@@ -0,0 +1,206 @@
+package pt
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxFetchSize bounds a downloaded target list.
+const maxFetchSize = 16 << 20
+
+// MirrorCheckEndpoint is served by every built-in mirror with a body
+// containing "success".
+const MirrorCheckEndpoint = "https://raw.githubusercontent.com/spiritLHLS/ecs/main/back/test"
+
+// Fetcher downloads a target list. endpoint is the upstream URL, which
+// mirror-based fetchers append to each mirror prefix.
+type Fetcher interface {
+	Fetch(ctx context.Context, endpoint string) ([]byte, error)
+}
+
+// MirrorFetcher requests endpoint from every usable mirror at once and
+// returns the first successful answer, canceling the others. A mirror that
+// failed is skipped by later fetches until every mirror has failed, so a
+// dead mirror costs at most one request per fetcher.
+type MirrorFetcher struct {
+	Mirrors []string
+	// Client performs the requests; nil uses a client with a 10s timeout.
+	Client *http.Client
+	// Check, when set, is requested from a mirror before its first answer
+	// may win, and the mirror is used only if the response contains
+	// "success". A captive portal answering 200 to everything fails it.
+	Check string
+	// Validate, when set, rejects a body that is not the requested list,
+	// such as an error page served with status 200. A rejected answer
+	// counts as a failure of its mirror.
+	Validate func(endpoint string, body []byte) error
+
+	mu       sync.Mutex
+	failed   map[string]bool
+	verified map[string]bool
+}
+
+// NewMirrorFetcher returns a fetcher racing mirrors.
+func NewMirrorFetcher(mirrors []string) *MirrorFetcher {
+	return &MirrorFetcher{Mirrors: append([]string(nil), mirrors...)}
+}
+
+// Fetch returns the body of the first mirror answering 200, or the joined
+// errors of all mirrors. It gives up when ctx ends.
+func (fetcher *MirrorFetcher) Fetch(ctx context.Context, endpoint string) ([]byte, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	mirrors := fetcher.candidates()
+	if len(mirrors) == 0 {
+		return nil, errors.New("no mirrors configured")
+	}
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type answer struct {
+		mirror string
+		body   []byte
+		err    error
+	}
+	answers := make(chan answer, len(mirrors))
+	for _, mirror := range mirrors {
+		go func() {
+			body, err := fetcher.fetchFrom(raceCtx, mirror, endpoint)
+			// A request cut short by the winner or the caller says nothing
+			// about the mirror.
+			if raceCtx.Err() == nil {
+				fetcher.mark(mirror, err == nil)
+			}
+			answers <- answer{mirror: mirror, body: body, err: err}
+		}()
+	}
+	errs := make([]error, 0, len(mirrors))
+	for range mirrors {
+		answer := <-answers
+		if answer.err == nil {
+			return answer.body, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", answer.mirror, answer.err))
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.Join(errs...)
+}
+
+// candidates returns the mirrors that have not failed yet, or all of them
+// once none is left.
+func (fetcher *MirrorFetcher) candidates() []string {
+	fetcher.mu.Lock()
+	defer fetcher.mu.Unlock()
+	healthy := make([]string, 0, len(fetcher.Mirrors))
+	for _, mirror := range fetcher.Mirrors {
+		if !fetcher.failed[mirror] {
+			healthy = append(healthy, mirror)
+		}
+	}
+	if len(healthy) == 0 {
+		return slices.Clone(fetcher.Mirrors)
+	}
+	return healthy
+}
+
+// fetchFrom requests endpoint from one mirror, checking the mirror first if
+// it has not passed Check yet and validating the answer.
+func (fetcher *MirrorFetcher) fetchFrom(ctx context.Context, mirror, endpoint string) ([]byte, error) {
+	if fetcher.Check != "" && !fetcher.isVerified(mirror) {
+		body, err := fetcher.get(ctx, mirror+fetcher.Check)
+		if err != nil {
+			return nil, fmt.Errorf("mirror check: %w", err)
+		}
+		if !bytes.Contains(body, []byte("success")) {
+			return nil, errors.New("mirror check: unexpected response")
+		}
+		fetcher.mu.Lock()
+		if fetcher.verified == nil {
+			fetcher.verified = make(map[string]bool)
+		}
+		fetcher.verified[mirror] = true
+		fetcher.mu.Unlock()
+	}
+	body, err := fetcher.get(ctx, mirror+endpoint)
+	if err == nil && fetcher.Validate != nil {
+		if err = fetcher.Validate(endpoint, body); err != nil {
+			return nil, fmt.Errorf("invalid response: %w", err)
+		}
+	}
+	return body, err
+}
+
+func (fetcher *MirrorFetcher) isVerified(mirror string) bool {
+	fetcher.mu.Lock()
+	defer fetcher.mu.Unlock()
+	return fetcher.verified[mirror]
+}
+
+// validateTargetList rejects answers that cannot be the JSON or CSV list
+// requested, most often an HTML page from a portal or a broken mirror.
+func validateTargetList(endpoint string, body []byte) error {
+	trimmed := bytes.TrimSpace(body)
+	if bytes.HasPrefix(trimmed, []byte("<")) {
+		return errors.New("HTML page instead of a target list")
+	}
+	switch {
+	case strings.HasSuffix(endpoint, ".json"):
+		if !json.Valid(trimmed) {
+			return errors.New("not JSON")
+		}
+	case strings.HasSuffix(endpoint, ".csv"):
+		records, err := csv.NewReader(bytes.NewReader(trimmed)).ReadAll()
+		if err != nil || len(records) < 2 {
+			return errors.New("not a CSV list")
+		}
+	}
+	return nil
+}
+
+func (fetcher *MirrorFetcher) mark(mirror string, healthy bool) {
+	fetcher.mu.Lock()
+	defer fetcher.mu.Unlock()
+	if fetcher.failed == nil {
+		fetcher.failed = make(map[string]bool)
+	}
+	fetcher.failed[mirror] = !healthy
+}
+
+func (fetcher *MirrorFetcher) get(ctx context.Context, url string) ([]byte, error) {
+	client := fetcher.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", response.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxFetchSize))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return nil, errors.New("empty response")
+	}
+	return body, nil
+}
@@ -0,0 +1,130 @@
+package pt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMirrorFetcherRacesMirrorsAndSkipsFailedOnes(t *testing.T) {
+	var brokenHits atomic.Int32
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		brokenHits.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Answer after the broken mirror failed, so its failure is recorded.
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprint(w, "list from "+r.URL.Path)
+	}))
+	defer fast.Close()
+
+	fetcher := NewMirrorFetcher([]string{broken.URL + "/", slow.URL + "/", fast.URL + "/"})
+	for range 2 {
+		started := time.Now()
+		body, err := fetcher.Fetch(context.Background(), "nodes.json")
+		if err != nil || string(body) != "list from /nodes.json" {
+			t.Fatalf("Fetch = %q, %v", body, err)
+		}
+		if elapsed := time.Since(started); elapsed > 2*time.Second {
+			t.Fatalf("Fetch waited %s for the slow mirror", elapsed)
+		}
+	}
+	if got := brokenHits.Load(); got != 1 {
+		t.Fatalf("failed mirror was requested %d times, want once", got)
+	}
+	if fetcher.failed[slow.URL+"/"] || !fetcher.failed[broken.URL+"/"] {
+		t.Fatalf("mirror health = %v, want only the broken mirror failed", fetcher.failed)
+	}
+}
+
+func TestMirrorFetcherRespectsDeadline(t *testing.T) {
+	hang := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) { <-r.Context().Done() }))
+	defer hang.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := NewMirrorFetcher([]string{hang.URL + "/"}).Fetch(ctx, "x"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Fetch error = %v, want deadline exceeded", err)
+	}
+}
+
+type staticFetcher map[string]string
+
+func (fetcher staticFetcher) Fetch(_ context.Context, endpoint string) ([]byte, error) {
+	if body, ok := fetcher[endpoint]; ok {
+		return []byte(body), nil
+	}
+	return nil, errors.New("not found")
+}
+
+func TestClientUsesInjectedFetcher(t *testing.T) {
+	client := &Client{
+		Sources: Sources{IcmpTargets: "nodes.json"},
+		Fetcher: staticFetcher{"nodes.json": `[{"province":"广东省","isp_code":"cu","ip_version":"v4","ips":"192.0.2.9"}]`},
+	}
+	if servers := client.getIcmpServers("cu"); len(servers) != 1 || servers[0].Name != "联通广东" {
+		t.Fatalf("servers = %+v", servers)
+	}
+	if client.getData("missing.csv") != "" {
+		t.Fatal("failed fetch returned data")
+	}
+}
+
+func TestMirrorFetcherSkipsMirrorsFailingCheckOrValidation(t *testing.T) {
+	// The portal answers everything at once with an HTML page; the real
+	// mirror is slower but serves the check file and the list.
+	portal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "<html>login</html>")
+	}))
+	defer portal.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		if r.URL.Path == "/check" {
+			fmt.Fprint(w, "success")
+			return
+		}
+		fmt.Fprint(w, `[{"province":"北京市"}]`)
+	}))
+	defer mirror.Close()
+
+	for name, fetcher := range map[string]*MirrorFetcher{
+		"check":    {Mirrors: []string{portal.URL + "/", mirror.URL + "/"}, Check: "check"},
+		"validate": {Mirrors: []string{portal.URL + "/", mirror.URL + "/"}, Validate: validateTargetList},
+	} {
+		body, err := fetcher.Fetch(context.Background(), "nodes.json")
+		if err != nil || string(body) != `[{"province":"北京市"}]` {
+			t.Fatalf("%s: Fetch = %q, %v", name, body, err)
+		}
+		if !fetcher.failed[portal.URL+"/"] {
+			t.Fatalf("%s: portal not marked failed: %v", name, fetcher.failed)
+		}
+	}
+}
+
+func TestValidateTargetList(t *testing.T) {
+	for endpoint, body := range map[string]string{
+		"nodes.json": "not json",
+		"ct.csv":     "only one line",
+		"any":        "  <!DOCTYPE html>",
+	} {
+		if validateTargetList(endpoint, []byte(body)) == nil {
+			t.Errorf("validateTargetList(%q, %q) accepted", endpoint, body)
+		}
+	}
+	if err := validateTargetList("ct.csv", []byte("id,name\n1,a\n")); err != nil {
+		t.Fatal(err)
+	}
+}
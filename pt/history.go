@@ -0,0 +1,251 @@
+package pt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+// HistoryIDLayout formats the start time of a run into its history ID. Runs
+// started in the same second get a "-2", "-3", ... suffix.
+const HistoryIDLayout = "20060102-150405"
+
+// HistoryRecord is one line of the history store: the full result envelope
+// of a run plus the ID it was saved under.
+type HistoryRecord struct {
+	ID string `json:"id"`
+	ResultEnvelope
+}
+
+// Counts returns how many targets the run measured and how many of them
+// never answered.
+func (record HistoryRecord) Counts() (total, failed int) {
+	for _, section := range record.Sections() {
+		switch section.Mode {
+		case model.ModeTCP:
+			for _, result := range section.TCP {
+				if result.Successful == 0 {
+					failed++
+				}
+			}
+			total += len(section.TCP)
+		case model.ModeMTProto:
+			for _, result := range section.MTProto {
+				if !result.Usable {
+					failed++
+				}
+			}
+			total += len(section.MTProto)
+		default:
+			for _, result := range section.Latency {
+				if result.Status != LatencyOK {
+					failed++
+				}
+			}
+			total += len(section.Latency)
+		}
+	}
+	return total, failed
+}
+
+// Sections splits the envelope back into one section per mode with results,
+// in the fixed ori, tgdc, mtproto, web, tcp order.
+func (envelope ResultEnvelope) Sections() []ReportSection {
+	var sections []ReportSection
+	if len(envelope.Results.Ori) > 0 {
+		sections = append(sections, ReportSection{Mode: model.ModeOri, Latency: envelope.Results.Ori})
+	}
+	if len(envelope.Results.TGDC) > 0 {
+		sections = append(sections, ReportSection{Mode: model.ModeTGDC, Latency: envelope.Results.TGDC})
+	}
+	if len(envelope.Results.MTProto) > 0 {
+		sections = append(sections, ReportSection{Mode: model.ModeMTProto, MTProto: envelope.Results.MTProto})
+	}
+	if len(envelope.Results.Web) > 0 {
+		sections = append(sections, ReportSection{Mode: model.ModeWeb, Latency: envelope.Results.Web})
+	}
+	if len(envelope.Results.TCP) > 0 {
+		sections = append(sections, ReportSection{Mode: model.ModeTCP, TCP: envelope.Results.TCP})
+	}
+	return sections
+}
+
+// HistoryRetention bounds the history store. Zero values keep everything.
+type HistoryRetention struct {
+	MaxRuns int
+	MaxAge  time.Duration
+}
+
+// HistoryStore is an append-only JSONL file with one HistoryRecord per line,
+// oldest first. It assumes a single writer at a time.
+type HistoryStore struct {
+	Path string
+}
+
+// DefaultHistoryPath returns history.jsonl under the pingtest directory of
+// the user's data directory: $XDG_DATA_HOME, %LocalAppData% on Windows,
+// ~/Library/Application Support on macOS and ~/.local/share elsewhere.
+func DefaultHistoryPath() (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		switch runtime.GOOS {
+		case "windows":
+			dir = os.Getenv("LocalAppData")
+			if dir == "" {
+				config, err := os.UserConfigDir()
+				if err != nil {
+					return "", err
+				}
+				dir = config
+			}
+		case "darwin", "ios":
+			config, err := os.UserConfigDir()
+			if err != nil {
+				return "", err
+			}
+			dir = config
+		default:
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			dir = filepath.Join(home, ".local", "share")
+		}
+	}
+	return filepath.Join(dir, "pingtest", "history.jsonl"), nil
+}
+
+// List returns every saved run, oldest first. A missing store is empty.
+func (store HistoryStore) List() ([]HistoryRecord, error) {
+	file, err := os.Open(store.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var records []HistoryRecord
+	decoder := json.NewDecoder(file)
+	for {
+		var record HistoryRecord
+		if err := decoder.Decode(&record); errors.Is(err, io.EOF) {
+			return records, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("history record %d: %w", len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+}
+
+// Get returns the run saved under id. The ID "latest" selects the most
+// recent run.
+func (store HistoryStore) Get(id string) (HistoryRecord, error) {
+	records, err := store.List()
+	if err != nil {
+		return HistoryRecord{}, err
+	}
+	if id == "latest" && len(records) > 0 {
+		return records[len(records)-1], nil
+	}
+	for _, record := range records {
+		if record.ID == id {
+			return record, nil
+		}
+	}
+	return HistoryRecord{}, fmt.Errorf("history record %q not found", id)
+}
+
+// Append saves a finished run and returns it with its new ID.
+func (store HistoryStore) Append(envelope ResultEnvelope) (HistoryRecord, error) {
+	records, err := store.List()
+	if err != nil {
+		return HistoryRecord{}, err
+	}
+	record := HistoryRecord{ID: nextHistoryID(records, envelope.StartedAt), ResultEnvelope: envelope}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return HistoryRecord{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(store.Path), 0o755); err != nil {
+		return HistoryRecord{}, err
+	}
+	file, err := os.OpenFile(store.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return HistoryRecord{}, err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		_ = file.Close()
+		return HistoryRecord{}, err
+	}
+	return record, file.Close()
+}
+
+// Prune drops runs that started before now minus MaxAge and then the oldest
+// runs beyond MaxRuns. The store is rewritten through a temporary file so an
+// interrupted prune keeps the previous contents. It returns how many runs
+// were removed.
+func (store HistoryStore) Prune(retention HistoryRetention, now time.Time) (int, error) {
+	records, err := store.List()
+	if err != nil {
+		return 0, err
+	}
+	kept := records
+	if retention.MaxAge > 0 {
+		cutoff := now.Add(-retention.MaxAge)
+		kept = kept[:0:0]
+		for _, record := range records {
+			if !record.StartedAt.Before(cutoff) {
+				kept = append(kept, record)
+			}
+		}
+	}
+	if retention.MaxRuns > 0 && len(kept) > retention.MaxRuns {
+		kept = kept[len(kept)-retention.MaxRuns:]
+	}
+	removed := len(records) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+	temporary, err := os.CreateTemp(filepath.Dir(store.Path), ".history-*")
+	if err != nil {
+		return 0, err
+	}
+	temporaryName := temporary.Name()
+	defer os.Remove(temporaryName)
+	encoder := json.NewEncoder(temporary)
+	for _, record := range kept {
+		if err := encoder.Encode(record); err != nil {
+			_ = temporary.Close()
+			return 0, err
+		}
+	}
+	if err := temporary.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(temporaryName, store.Path); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+func nextHistoryID(records []HistoryRecord, started time.Time) string {
+	base := started.UTC().Format(HistoryIDLayout)
+	used := map[string]bool{}
+	for _, record := range records {
+		used[record.ID] = true
+	}
+	id := base
+	for suffix := 2; used[id]; suffix++ {
+		id = base + "-" + strconv.Itoa(suffix)
+	}
+	return id
+}
@@ -0,0 +1,74 @@
+package pt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+func TestHistoryStoreAppendListGetAndPrune(t *testing.T) {
+	store := HistoryStore{Path: filepath.Join(t.TempDir(), "nested", "history.jsonl")}
+	if records, err := store.List(); err != nil || records != nil {
+		t.Fatalf("missing store = %v, %v", records, err)
+	}
+	started := time.Date(2026, 3, 1, 8, 30, 0, 0, time.UTC)
+	var ids []string
+	for index, offset := range []time.Duration{0, 0, 48 * time.Hour} {
+		envelope := NewResultEnvelope(RunConfig{Language: "zh"}, started.Add(offset))
+		envelope.Add(ReportSection{Mode: model.ModeTCP, TCP: []TCPResult{{Target: model.TCPTarget{Host: "example.test", Port: 443}, Attempts: 3, Successful: index}}})
+		record, err := store.Append(*envelope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, record.ID)
+	}
+	if ids[0] != "20260301-083000" || ids[1] != "20260301-083000-2" || ids[2] != "20260303-083000" {
+		t.Fatalf("ids = %v", ids)
+	}
+	record, err := store.Get(ids[1])
+	if err != nil || len(record.Results.TCP) != 1 || record.Results.TCP[0].Successful != 1 || record.Schema != ResultSchemaVersion {
+		t.Fatalf("get = %+v, %v", record, err)
+	}
+	if total, failed := record.Counts(); total != 1 || failed != 0 {
+		t.Fatalf("counts = %d/%d", total, failed)
+	}
+	if latest, err := store.Get("latest"); err != nil || latest.ID != ids[2] {
+		t.Fatalf("latest = %q, %v", latest.ID, err)
+	}
+	if _, err := store.Get("missing"); err == nil {
+		t.Fatal("missing ID returned no error")
+	}
+	removed, err := store.Prune(HistoryRetention{MaxAge: 24 * time.Hour}, started.Add(49*time.Hour))
+	if err != nil || removed != 2 {
+		t.Fatalf("prune by age removed %d, %v", removed, err)
+	}
+	records, err := store.List()
+	if err != nil || len(records) != 1 || records[0].ID != ids[2] {
+		t.Fatalf("records after prune = %+v, %v", records, err)
+	}
+	if removed, err := store.Prune(HistoryRetention{MaxRuns: 1}, started); err != nil || removed != 0 {
+		t.Fatalf("no-op prune removed %d, %v", removed, err)
+	}
+}
+
+func TestHistoryStoreRejectsCorruptLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	if err := os.WriteFile(path, []byte("{\"id\":\"a\"}\nnot json\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (HistoryStore{Path: path}).List(); err == nil {
+		t.Fatal("corrupt history returned no error")
+	}
+}
+
+func TestDefaultHistoryPathHonorsXDGDataHome(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dir)
+	path, err := DefaultHistoryPath()
+	if err != nil || path != filepath.Join(dir, "pingtest", "history.jsonl") {
+		t.Fatalf("path = %q, %v", path, err)
+	}
+}
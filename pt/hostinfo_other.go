@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly,!windows
+
+package pt
+
+// kernelRelease 在无法获取内核版本的平台上返回空字符串
+func kernelRelease() string {
+	return ""
+}
@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package pt
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// kernelRelease 返回 Windows 内核版本号
+func kernelRelease() string {
+	version := windows.RtlGetVersion()
+	return fmt.Sprintf("%d.%d.%d", version.MajorVersion, version.MinorVersion, version.BuildNumber)
+}
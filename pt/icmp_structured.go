@@ -6,10 +6,7 @@ import (
 	"math"
 	"sort"
 	"strings"
-	"sync"
 	"time"
-
-	probing "github.com/prometheus-community/pro-bing"
 )
 
 type ICMPTarget struct {
@@ -34,10 +31,18 @@ type ICMPResult struct {
 }
 
 type ICMPProbeConfig struct {
-	Count       int
+	Count int
+	// Timeout bounds each echo request.
 	Timeout     time.Duration
 	Concurrency int
 	Probe       func(context.Context, ICMPTarget, int, time.Duration) ICMPResult
+	// RateLimiter, when set, gives every echo request a token; share one
+	// limiter between runs to give them one budget. Nil is unlimited.
+	RateLimiter *RateLimiter
+	// OnProgress, when set, receives a ProgressEvent when a target starts,
+	// after every echo request, when a target finishes and when the run ends.
+	// A custom Probe reports no replies. Calls are serialized.
+	OnProgress func(ProgressEvent)
 }
 
 func RunICMPProbes(ctx context.Context, targets []ICMPTarget, config ICMPProbeConfig) []ICMPResult {
@@ -53,88 +58,79 @@ func RunICMPProbes(ctx context.Context, targets []ICMPTarget, config ICMPProbeCo
 	if config.Concurrency <= 0 {
 		config.Concurrency = 8
 	}
-	if config.Probe == nil {
-		config.Probe = probeICMPTarget
-	}
+	reporter := newProgressReporter(config.OnProgress, len(targets), nil)
+	defer reporter.done()
 	results := make([]ICMPResult, len(targets))
 	if err := ctx.Err(); err != nil {
 		markPendingICMP(results, targets, config.Count, err)
 		return results
 	}
-	jobs := make(chan int)
-	workers := min(config.Concurrency, len(targets))
-	var wait sync.WaitGroup
-	wait.Add(workers)
-	for range workers {
-		go func() {
-			defer wait.Done()
-			for index := range jobs {
-				if err := ctx.Err(); err != nil {
-					results[index] = icmpContextResult(targets[index], config.Count, err)
-					continue
-				}
-				results[index] = config.Probe(ctx, targets[index], config.Count, config.Timeout)
-			}
-		}()
-	}
-	for index := range targets {
-		select {
-		case jobs <- index:
-		case <-ctx.Done():
-			close(jobs)
-			wait.Wait()
-			markPendingICMP(results, targets, config.Count, ctx.Err())
-			return results
+	run := TCPProbeConfig{Attempts: config.Count, Timeout: config.Timeout, Concurrency: config.Concurrency, RateLimiter: config.RateLimiter}.withDefaults()
+	runBounded(ctx, len(targets), config.Concurrency, func(index int) {
+		target := targets[index]
+		switch {
+		case ctx.Err() != nil:
+			reporter.start(index, target.Name, target.Host)
+			results[index] = icmpContextResult(target, config.Count, ctx.Err())
+		case config.Probe != nil:
+			reporter.start(index, target.Name, target.Host)
+			results[index] = config.Probe(ctx, target, config.Count, config.Timeout)
+		default:
+			results[index] = probeICMPTarget(ctx, target, run, index, reporter)
 		}
+		reporter.finish(index, target.Name, target.Host, results[index])
+	})
+	if err := ctx.Err(); err != nil {
+		markPendingICMP(results, targets, config.Count, err)
 	}
-	close(jobs)
-	wait.Wait()
 	return results
 }
 
-func probeICMPTarget(ctx context.Context, target ICMPTarget, count int, timeout time.Duration) ICMPResult {
-	result := ICMPResult{Target: target, Status: "unavailable", Sent: count, LossPercent: 100}
-	if err := ctx.Err(); err != nil {
-		return icmpContextResult(target, count, err)
-	}
+// probeICMPTarget measures target with the RunProbes loop, one echo request
+// per attempt, and reports it as target index of a run.
+func probeICMPTarget(ctx context.Context, target ICMPTarget, config TCPProbeConfig, index int, reporter *progressReporter) ICMPResult {
 	if strings.TrimSpace(target.Host) == "" {
-		result.Error = "missing host"
-		return result
-	}
-	probeCtx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
-	pinger, err := probing.NewPinger(target.Host)
-	if err != nil {
-		result.Error = err.Error()
-		return result
-	}
-	pinger.Count = count
-	pinger.Timeout = timeout
-	pinger.SetPrivileged(false)
+		reporter.start(index, target.Name, target.Host)
+		return ICMPResult{Target: target, Status: "unavailable", Sent: config.Attempts, LossPercent: 100, Error: "missing host"}
+	}
+	prober := ICMPProber{Timeout: config.Timeout}
 	if strings.EqualFold(target.IPVersion, "ipv4") {
-		pinger.SetNetwork("ip4")
+		prober.Network = "ip4"
 	} else if strings.EqualFold(target.IPVersion, "ipv6") {
-		pinger.SetNetwork("ip6")
-	}
-	if err := pinger.RunWithContext(probeCtx); err != nil && probeCtx.Err() != nil {
-		result.Status = icmpContextStatus(probeCtx.Err())
-		result.Error = probeCtx.Err().Error()
-		return result
-	} else if err != nil {
-		result.Error = err.Error()
-		return result
-	}
-	statistics := pinger.Statistics()
-	result.Sent, result.Received = statistics.PacketsSent, statistics.PacketsRecv
-	result.LossPercent = statistics.PacketLoss
-	result.Min, result.Max, result.Mean = statistics.MinRtt, statistics.MaxRtt, statistics.AvgRtt
-	rtts := append([]time.Duration(nil), statistics.Rtts...)
+		prober.Network = "ip6"
+	}
+	probe := probeTarget(ctx, prober, ProbeTarget{Name: target.Name, Host: target.Host}, config, index, reporter)
+	if probe.Successful == 0 && ctx.Err() != nil {
+		return icmpContextResult(target, config.Attempts, ctx.Err())
+	}
+	result := ICMPResult{
+		Target: target, Status: "unavailable", Sent: probe.Attempts, Received: probe.Successful,
+		LossPercent: probe.LossPercent, Min: probe.Min, Max: probe.Max, Mean: probe.Mean,
+	}
+	rtts := make([]time.Duration, 0, probe.Successful)
+	for _, sample := range probe.Samples {
+		if sample.Success {
+			rtts = append(rtts, sample.Duration)
+		}
+	}
 	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
 	result.P50, result.P95 = durationPercentile(rtts, .50), durationPercentile(rtts, .95)
-	if result.Received > 0 {
+	switch probe.Status {
+	case ProbeStatusOK:
 		result.Status = "ok"
-		if result.Received < result.Sent {
-			result.Status = "partial"
+	case ProbeStatusPartial:
+		result.Status = "partial"
+	default:
+		// Lost replies are reported by LossPercent alone, as ping does.
+		var classes []string
+		for class := range probe.ErrorCounts {
+			if class != TCPErrorTimeout {
+				classes = append(classes, class)
+			}
+		}
+		if len(classes) > 0 {
+			sort.Strings(classes)
+			result.Error = "echo requests failed: " + strings.Join(classes, ", ")
 		}
 	}
 	return result
@@ -0,0 +1,324 @@
+package pt
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+// MetricsBuckets are the upper bounds, in seconds, of the latency histograms.
+var MetricsBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+type metricsHistogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+func (histogram *metricsHistogram) observe(value time.Duration) {
+	if histogram.buckets == nil {
+		histogram.buckets = make([]uint64, len(MetricsBuckets))
+	}
+	seconds := value.Seconds()
+	for index, bound := range MetricsBuckets {
+		if seconds <= bound {
+			histogram.buckets[index]++
+		}
+	}
+	histogram.sum += seconds
+	histogram.count++
+}
+
+// metricsSeries accumulates one target across runs. Counters only grow;
+// loss and up describe the most recent run.
+type metricsSeries struct {
+	labels    [][2]string
+	latency   metricsHistogram
+	attempts  uint64
+	successes uint64
+	failures  map[string]uint64
+	loss      float64
+	up        bool
+}
+
+type metricsRun struct {
+	count    uint64
+	last     time.Time
+	duration time.Duration
+}
+
+// MetricsCollector turns repeated TCP and ICMP runs into Prometheus metrics:
+// per-target latency histograms, attempt and error-class counters, and the
+// loss and reachability of the latest run. It is safe for concurrent use.
+type MetricsCollector struct {
+	mu   sync.Mutex
+	tcp  map[string]*metricsSeries
+	icmp map[string]*metricsSeries
+	runs map[string]*metricsRun
+}
+
+// NewMetricsCollector returns an empty collector.
+func NewMetricsCollector() *MetricsCollector {
+	return &MetricsCollector{tcp: map[string]*metricsSeries{}, icmp: map[string]*metricsSeries{}, runs: map[string]*metricsRun{}}
+}
+
+func (collector *MetricsCollector) series(set map[string]*metricsSeries, labels [][2]string) *metricsSeries {
+	key := formatMetricLabels(labels)
+	series := set[key]
+	if series == nil {
+		series = &metricsSeries{labels: labels, failures: map[string]uint64{}}
+		set[key] = series
+	}
+	return series
+}
+
+func (collector *MetricsCollector) recordRun(module string, started time.Time, duration time.Duration) {
+	run := collector.runs[module]
+	if run == nil {
+		run = &metricsRun{}
+		collector.runs[module] = run
+	}
+	run.count++
+	run.last, run.duration = started, duration
+}
+
+// ObserveTCP records one run of RunTCPProbes. Every successful handshake
+// lands in the latency histogram and every failed one in its error class.
+func (collector *MetricsCollector) ObserveTCP(results []TCPResult, started time.Time, duration time.Duration) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	for _, result := range results {
+		series := collector.series(collector.tcp, tcpMetricLabels(result.Target))
+		for _, sample := range result.Samples {
+			series.attempts++
+			if sample.Success {
+				series.successes++
+				series.latency.observe(sample.Duration)
+			} else {
+				series.failures[sample.ErrorClass]++
+			}
+		}
+		series.loss, series.up = result.LossPercent/100, result.Successful > 0
+	}
+	collector.recordRun(model.ModeTCP, started, duration)
+}
+
+// ObserveICMP records one run of RunICMPProbes. ICMP results carry no
+// per-packet samples, so the histogram receives each target's mean RTT and
+// the failure counter the status of targets that did not fully answer.
+func (collector *MetricsCollector) ObserveICMP(results []ICMPResult, started time.Time, duration time.Duration) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	for _, result := range results {
+		series := collector.series(collector.icmp, icmpMetricLabels(result.Target))
+		series.attempts += uint64(max(result.Sent, 0))
+		series.successes += uint64(max(result.Received, 0))
+		if result.Received > 0 {
+			series.latency.observe(result.Mean)
+		}
+		if result.Status != "ok" {
+			series.failures[result.Status]++
+		}
+		series.loss, series.up = result.LossPercent/100, result.Received > 0
+	}
+	collector.recordRun("icmp", started, duration)
+}
+
+// WritePrometheus writes every metric in the Prometheus text exposition
+// format, with series sorted by label set.
+func (collector *MetricsCollector) WritePrometheus(w io.Writer) error {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	var output metricsWriter
+	output.header("pingtest_build_info", "gauge", "Version of the running pingtest binary.")
+	output.sample("pingtest_build_info", [][2]string{{"version", model.PingTestVersion}}, 1)
+	modules := make([]string, 0, len(collector.runs))
+	for module := range collector.runs {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	output.header("pingtest_runs_total", "counter", "Completed periodic runs.")
+	for _, module := range modules {
+		output.sample("pingtest_runs_total", [][2]string{{"module", module}}, float64(collector.runs[module].count))
+	}
+	output.header("pingtest_last_run_timestamp_seconds", "gauge", "Start time of the latest periodic run.")
+	for _, module := range modules {
+		output.sample("pingtest_last_run_timestamp_seconds", [][2]string{{"module", module}}, float64(collector.runs[module].last.UnixNano())/1e9)
+	}
+	output.header("pingtest_last_run_duration_seconds", "gauge", "Duration of the latest periodic run.")
+	for _, module := range modules {
+		output.sample("pingtest_last_run_duration_seconds", [][2]string{{"module", module}}, collector.runs[module].duration.Seconds())
+	}
+	output.seriesSet("pingtest_tcp", "TCP handshake", "handshake attempts", "error_class", collector.tcp)
+	output.seriesSet("pingtest_icmp", "ICMP round trip (per-run mean)", "echo requests", "status", collector.icmp)
+	_, err := w.Write(output.Bytes())
+	return err
+}
+
+// WriteTCPProbeMetrics writes the result of one on-demand TCP probe in the
+// style of blackbox_exporter: probe_success plus handshake details.
+func WriteTCPProbeMetrics(w io.Writer, result TCPResult, duration time.Duration) error {
+	var output metricsWriter
+	writeProbeCommon(&output, result.Successful > 0, duration, result.LossPercent/100)
+	output.header("probe_tcp_attempts", "gauge", "Handshake attempts made.")
+	output.sample("probe_tcp_attempts", nil, float64(result.Attempts))
+	output.header("probe_tcp_successful", "gauge", "Handshakes that completed.")
+	output.sample("probe_tcp_successful", nil, float64(result.Successful))
+	if result.Successful > 0 {
+		for _, quantile := range []struct {
+			name  string
+			value time.Duration
+		}{{"min", result.Min}, {"mean", result.Mean}, {"p50", result.P50}, {"p95", result.P95}, {"max", result.Max}} {
+			name := "probe_tcp_connect_" + quantile.name + "_seconds"
+			output.header(name, "gauge", "Handshake latency "+quantile.name+" across attempts.")
+			output.sample(name, nil, quantile.value.Seconds())
+		}
+	}
+	output.header("probe_failed_due_to", "gauge", "Failed attempts by error class.")
+	for _, class := range sortedKeys(result.ErrorCounts) {
+		output.sample("probe_failed_due_to", [][2]string{{"error_class", class}}, float64(result.ErrorCounts[class]))
+	}
+	_, err := w.Write(output.Bytes())
+	return err
+}
+
+// WriteICMPProbeMetrics is WriteTCPProbeMetrics for an ICMP echo probe.
+func WriteICMPProbeMetrics(w io.Writer, result ICMPResult, duration time.Duration) error {
+	var output metricsWriter
+	writeProbeCommon(&output, result.Received > 0, duration, result.LossPercent/100)
+	output.header("probe_icmp_packets_sent", "gauge", "Echo requests sent.")
+	output.sample("probe_icmp_packets_sent", nil, float64(result.Sent))
+	output.header("probe_icmp_packets_received", "gauge", "Echo replies received.")
+	output.sample("probe_icmp_packets_received", nil, float64(result.Received))
+	if result.Received > 0 {
+		for _, quantile := range []struct {
+			name  string
+			value time.Duration
+		}{{"min", result.Min}, {"mean", result.Mean}, {"p50", result.P50}, {"p95", result.P95}, {"max", result.Max}} {
+			name := "probe_icmp_rtt_" + quantile.name + "_seconds"
+			output.header(name, "gauge", "Round trip time "+quantile.name+" across echo replies.")
+			output.sample(name, nil, quantile.value.Seconds())
+		}
+	}
+	_, err := w.Write(output.Bytes())
+	return err
+}
+
+func writeProbeCommon(output *metricsWriter, success bool, duration time.Duration, loss float64) {
+	output.header("probe_success", "gauge", "Whether the probe reached the target.")
+	output.sample("probe_success", nil, metricBool(success))
+	output.header("probe_duration_seconds", "gauge", "How long the probe took to complete.")
+	output.sample("probe_duration_seconds", nil, duration.Seconds())
+	output.header("probe_loss_ratio", "gauge", "Share of attempts that failed.")
+	output.sample("probe_loss_ratio", nil, loss)
+}
+
+func tcpMetricLabels(target model.TCPTarget) [][2]string {
+	return [][2]string{{"name", target.Name}, {"host", target.Host}, {"port", strconv.Itoa(target.Port)}, {"category", target.Category}}
+}
+
+func icmpMetricLabels(target ICMPTarget) [][2]string {
+	return [][2]string{{"id", target.ID}, {"name", target.Name}, {"host", target.Host}}
+}
+
+// metricsWriter builds a text exposition document.
+type metricsWriter struct {
+	bytes.Buffer
+}
+
+func (output *metricsWriter) header(name, kind, help string) {
+	output.WriteString("# HELP " + name + " " + help + "\n# TYPE " + name + " " + kind + "\n")
+}
+
+func (output *metricsWriter) sample(name string, labels [][2]string, value float64) {
+	output.WriteString(name + formatMetricLabels(labels) + " " + formatMetricValue(value) + "\n")
+}
+
+// seriesSet writes the histogram, counters and gauges of one probe family.
+func (output *metricsWriter) seriesSet(prefix, latencyHelp, attemptHelp, failureLabel string, set map[string]*metricsSeries) {
+	keys := sortedKeys(set)
+	output.header(prefix+"_latency_seconds", "histogram", latencyHelp+" latency.")
+	for _, key := range keys {
+		series := set[key]
+		for index, bound := range MetricsBuckets {
+			count := uint64(0)
+			if series.latency.buckets != nil {
+				count = series.latency.buckets[index]
+			}
+			output.sample(prefix+"_latency_seconds_bucket", withMetricLabel(series.labels, "le", formatMetricValue(bound)), float64(count))
+		}
+		output.sample(prefix+"_latency_seconds_bucket", withMetricLabel(series.labels, "le", "+Inf"), float64(series.latency.count))
+		output.sample(prefix+"_latency_seconds_sum", series.labels, series.latency.sum)
+		output.sample(prefix+"_latency_seconds_count", series.labels, float64(series.latency.count))
+	}
+	output.header(prefix+"_attempts_total", "counter", "Total "+attemptHelp+".")
+	for _, key := range keys {
+		output.sample(prefix+"_attempts_total", set[key].labels, float64(set[key].attempts))
+	}
+	output.header(prefix+"_successes_total", "counter", "Successful "+attemptHelp+".")
+	for _, key := range keys {
+		output.sample(prefix+"_successes_total", set[key].labels, float64(set[key].successes))
+	}
+	output.header(prefix+"_failures_total", "counter", "Failures by "+strings.ReplaceAll(failureLabel, "_", " ")+".")
+	for _, key := range keys {
+		series := set[key]
+		for _, class := range sortedKeys(series.failures) {
+			output.sample(prefix+"_failures_total", withMetricLabel(series.labels, failureLabel, class), float64(series.failures[class]))
+		}
+	}
+	output.header(prefix+"_loss_ratio", "gauge", "Share of failed attempts in the latest run.")
+	for _, key := range keys {
+		output.sample(prefix+"_loss_ratio", set[key].labels, set[key].loss)
+	}
+	output.header(prefix+"_up", "gauge", "Whether the target answered in the latest run.")
+	for _, key := range keys {
+		output.sample(prefix+"_up", set[key].labels, metricBool(set[key].up))
+	}
+}
+
+func withMetricLabel(labels [][2]string, name, value string) [][2]string {
+	return append(append([][2]string(nil), labels...), [2]string{name, value})
+}
+
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatMetricLabels(labels [][2]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels))
+	for _, label := range labels {
+		parts = append(parts, label[0]+`="`+metricLabelEscaper.Replace(label[1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatMetricValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func metricBool(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
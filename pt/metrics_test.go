@@ -0,0 +1,72 @@
+package pt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+func TestMetricsCollectorAccumulatesTCPAndICMPRuns(t *testing.T) {
+	collector := NewMetricsCollector()
+	target := model.TCPTarget{Name: `Say "hi"`, Host: "example.test", Port: 443, Category: "dev"}
+	result := TCPResult{Target: target, Attempts: 3, Successful: 2, LossPercent: 100.0 / 3, Samples: []TCPSample{
+		{Attempt: 1, Duration: 8 * time.Millisecond, Success: true},
+		{Attempt: 2, Duration: 30 * time.Millisecond, Success: true},
+		{Attempt: 3, ErrorClass: TCPErrorTimeout},
+	}}
+	started := time.Unix(1700000000, 0)
+	collector.ObserveTCP([]TCPResult{result}, started, 2*time.Second)
+	collector.ObserveTCP([]TCPResult{result}, started, time.Second)
+	collector.ObserveICMP([]ICMPResult{{Target: ICMPTarget{ID: "google", Name: "Google", Host: "8.8.8.8"}, Status: "partial", Sent: 3, Received: 2, LossPercent: 100.0 / 3, Mean: 20 * time.Millisecond}}, started, time.Second)
+	var output bytes.Buffer
+	if err := collector.WritePrometheus(&output); err != nil {
+		t.Fatal(err)
+	}
+	labels := `name="Say \"hi\"",host="example.test",port="443",category="dev"`
+	for _, line := range []string{
+		"# TYPE pingtest_tcp_latency_seconds histogram",
+		`pingtest_tcp_latency_seconds_bucket{` + labels + `,le="0.01"} 2`,
+		`pingtest_tcp_latency_seconds_bucket{` + labels + `,le="0.05"} 4`,
+		`pingtest_tcp_latency_seconds_bucket{` + labels + `,le="+Inf"} 4`,
+		`pingtest_tcp_latency_seconds_count{` + labels + `} 4`,
+		`pingtest_tcp_attempts_total{` + labels + `} 6`,
+		`pingtest_tcp_failures_total{` + labels + `,error_class="timeout"} 2`,
+		`pingtest_tcp_up{` + labels + `} 1`,
+		`pingtest_runs_total{module="tcp"} 2`,
+		`pingtest_last_run_duration_seconds{module="tcp"} 1`,
+		`pingtest_last_run_timestamp_seconds{module="icmp"} 1.7e+09`,
+		`pingtest_icmp_failures_total{id="google",name="Google",host="8.8.8.8",status="partial"} 1`,
+		`pingtest_icmp_successes_total{id="google",name="Google",host="8.8.8.8"} 2`,
+		`pingtest_build_info{version="` + model.PingTestVersion + `"} 1`,
+	} {
+		if !strings.Contains(output.String(), line+"\n") {
+			t.Errorf("metrics do not contain %q", line)
+		}
+	}
+}
+
+func TestWriteProbeMetricsReportsFailures(t *testing.T) {
+	var output bytes.Buffer
+	result := TCPResult{Target: model.TCPTarget{Host: "down.test", Port: 443}, Attempts: 2, LossPercent: 100, ErrorCounts: map[string]int{TCPErrorRefused: 2}}
+	if err := WriteTCPProbeMetrics(&output, result, 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"probe_success 0", "probe_duration_seconds 1.5", "probe_loss_ratio 1", `probe_failed_due_to{error_class="refused"} 2`} {
+		if !strings.Contains(output.String(), line+"\n") {
+			t.Errorf("probe metrics %q do not contain %q", output.String(), line)
+		}
+	}
+	if strings.Contains(output.String(), "probe_tcp_connect_") {
+		t.Errorf("failed probe reported latency: %q", output.String())
+	}
+	output.Reset()
+	if err := WriteICMPProbeMetrics(&output, ICMPResult{Status: "ok", Sent: 1, Received: 1, P95: 5 * time.Millisecond}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "probe_success 1\n") || !strings.Contains(output.String(), "probe_icmp_rtt_p95_seconds 0.005\n") {
+		t.Errorf("icmp probe metrics = %q", output.String())
+	}
+}
@@ -0,0 +1,302 @@
+package pt
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Probe result states, shared by every Prober.
+const (
+	ProbeStatusOK       = "ok"
+	ProbeStatusPartial  = "partial"
+	ProbeStatusFailed   = "failed"
+	ProbeStatusCanceled = "canceled"
+)
+
+// ProbeErrorHTTP classifies an HTTP answer with a 5xx status. The other
+// error classes are the TCPError constants, which every Prober uses.
+const ProbeErrorHTTP = "http"
+
+// Prober performs single measurements of one protocol. Probe makes one
+// attempt within ctx, which carries the attempt timeout; RunProbes adds
+// attempts, pacing, rate limiting, bounded parallelism, cancellation and
+// progress, so a new probe kind only implements these two methods.
+type Prober interface {
+	// Kind names the protocol, such as "tcp" or "icmp".
+	Kind() string
+	Probe(ctx context.Context, target ProbeTarget) ProbeSample
+}
+
+// ProbeTarget is a target of any Prober. Host-based probers use Host and
+// Port; HTTP uses URL.
+type ProbeTarget struct {
+	Name     string `json:"name"`
+	Host     string `json:"host,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Category string `json:"category,omitempty"`
+}
+
+// Address returns host:port, or the bare host when Port is unset.
+func (target ProbeTarget) Address() string {
+	if target.Port == 0 {
+		return target.Host
+	}
+	return net.JoinHostPort(target.Host, strconv.Itoa(target.Port))
+}
+
+// label is how progress events and logs name the target.
+func (target ProbeTarget) label() string {
+	if target.URL != "" {
+		return target.URL
+	}
+	return target.Address()
+}
+
+// ProbeSample is one attempt. Duration is zero for failed attempts.
+type ProbeSample struct {
+	Attempt    int           `json:"attempt"`
+	Duration   time.Duration `json:"duration"`
+	Success    bool          `json:"success"`
+	ErrorClass string        `json:"error_class,omitempty"`
+}
+
+// ProbeResult aggregates the attempts on one target with the same metrics
+// as TCPResult.
+type ProbeResult struct {
+	Target             ProbeTarget    `json:"target"`
+	Kind               string         `json:"kind"`
+	Status             string         `json:"status"`
+	Attempts           int            `json:"attempts"`
+	Successful         int            `json:"successful"`
+	Failed             int            `json:"failed"`
+	SuccessRatePercent float64        `json:"success_rate_percent"`
+	LossPercent        float64        `json:"loss_percent"`
+	Min                time.Duration  `json:"min"`
+	Max                time.Duration  `json:"max"`
+	Mean               time.Duration  `json:"mean"`
+	P50                time.Duration  `json:"p50"`
+	P95                time.Duration  `json:"p95"`
+	Samples            []ProbeSample  `json:"samples"`
+	ErrorCounts        map[string]int `json:"error_counts,omitempty"`
+}
+
+// ProbeConfig controls RunProbes. Zero values use the TCP defaults.
+type ProbeConfig struct {
+	Attempts    int
+	Timeout     time.Duration
+	Concurrency int
+	// Interval and Jitter space the attempts on one target as in
+	// TCPProbeConfig; Random defaults to math/rand/v2.Float64.
+	Interval time.Duration
+	Jitter   time.Duration
+	Random   func() float64
+	// RateLimiter, when set, gives every attempt a token; share one
+	// limiter between runs to give them one budget. Nil is unlimited.
+	RateLimiter *RateLimiter
+	// OnResult, when set, receives each result as soon as its attempts
+	// finished. Calls are serialized.
+	OnResult func(ProbeResult)
+	// OnProgress, when set, receives the run's ProgressEvents. Calls are
+	// serialized.
+	OnProgress func(ProgressEvent)
+}
+
+// RunProbes measures every target with prober in bounded parallelism and
+// returns the results in target order. Each attempt takes a token from
+// config.RateLimiter; attempts left when ctx ends are recorded as canceled.
+// RunTCPProbes, RunICMPProbes and the legacy text runners measure their
+// targets with the same loop.
+func RunProbes(ctx context.Context, prober Prober, targets []ProbeTarget, config ProbeConfig) []ProbeResult {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	run := TCPProbeConfig{
+		Attempts: config.Attempts, Timeout: config.Timeout, Concurrency: config.Concurrency,
+		Interval: config.Interval, Jitter: config.Jitter, Random: config.Random, RateLimiter: config.RateLimiter,
+	}.withDefaults()
+	reporter := newProgressReporter(config.OnProgress, len(targets), nil)
+	defer reporter.done()
+	results := make([]ProbeResult, len(targets))
+	var completed sync.Mutex
+	dispatched := runBounded(ctx, len(targets), run.Concurrency, func(index int) {
+		target := targets[index]
+		results[index] = probeTarget(ctx, prober, target, run, index, reporter)
+		reporter.finish(index, target.Name, target.label(), results[index])
+		if config.OnResult != nil {
+			completed.Lock()
+			config.OnResult(results[index])
+			completed.Unlock()
+		}
+	})
+	for index := dispatched; index < len(targets); index++ {
+		results[index] = canceledProbeResult(ctx, prober.Kind(), targets[index], run.Attempts)
+	}
+	return results
+}
+
+// canceledProbeResult is the result of a target left undispatched when ctx
+// ended: every attempt carries the context's error class.
+func canceledProbeResult(ctx context.Context, kind string, target ProbeTarget, attempts int) ProbeResult {
+	result := newProbeResult(kind, target, attempts)
+	for attempt := 1; attempt <= attempts; attempt++ {
+		result.record(ProbeSample{Attempt: attempt, ErrorClass: classifyTCPError(ctx.Err())})
+	}
+	result.finish()
+	return result
+}
+
+// runBounded hands the indexes below count in order to at most workers
+// goroutines running measure, stops handing them out once ctx ends, and
+// returns how many it handed out after every measure returned.
+func runBounded(ctx context.Context, count, workers int, measure func(index int)) int {
+	jobs := make(chan int)
+	workers = min(workers, count)
+	var workerWG sync.WaitGroup
+	workerWG.Add(workers)
+	for range workers {
+		go func() {
+			defer workerWG.Done()
+			for index := range jobs {
+				measure(index)
+			}
+		}()
+	}
+	dispatched := 0
+dispatch:
+	for index := range count {
+		select {
+		case jobs <- index:
+			dispatched++
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	workerWG.Wait()
+	return dispatched
+}
+
+// probeTarget runs config.Attempts paced, rate-limited attempts on target
+// and reports them as target index of a run; the caller reports the finish
+// with its own result type.
+func probeTarget(ctx context.Context, prober Prober, target ProbeTarget, config TCPProbeConfig, index int, reporter *progressReporter) ProbeResult {
+	result := newProbeResult(prober.Kind(), target, config.Attempts)
+	reporter.start(index, target.Name, target.label())
+	for attempt := 1; attempt <= config.Attempts; attempt++ {
+		if attempt > 1 {
+			sleepContext(ctx, config.attemptDelay())
+		}
+		sample := probeAttempt(ctx, prober, target, config.Timeout, config.RateLimiter)
+		sample.Attempt = attempt
+		result.record(sample)
+		reporter.sample(index, target.Name, target.label(), sample.progress())
+	}
+	result.finish()
+	return result
+}
+
+// probeAttempt takes a token from limiter and runs one attempt with timeout.
+func probeAttempt(ctx context.Context, prober Prober, target ProbeTarget, timeout time.Duration, limiter *RateLimiter) ProbeSample {
+	err := limiter.Wait(ctx)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return ProbeSample{ErrorClass: classifyTCPError(err)}
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	sample := prober.Probe(attemptCtx, target)
+	if !sample.Success && sample.ErrorClass == "" {
+		sample.ErrorClass = TCPErrorUnknown
+	}
+	return sample
+}
+
+// measureAverage runs the legacy text runners' attempts on target through
+// RunProbes, so they are paced by the client's RateLimiter, bounded by
+// timeout each and stop once ctx ends, and returns the mean of the
+// successful ones. onSample, when set, receives every attempt.
+func (client *Client) measureAverage(ctx context.Context, prober Prober, target ProbeTarget, attempts int, timeout time.Duration, onSample func(ProgressSample)) (time.Duration, bool) {
+	kind := strings.ToUpper(prober.Kind())
+	result := RunProbes(ctx, prober, []ProbeTarget{target}, ProbeConfig{
+		Attempts: attempts, Timeout: timeout, Concurrency: 1, RateLimiter: client.RateLimiter,
+		OnProgress: func(event ProgressEvent) {
+			if event.Kind != ProgressSampleRecorded {
+				return
+			}
+			sample := *event.Sample
+			if sample.Success {
+				client.logError(fmt.Sprintf("%s %s 成功 (尝试 %d/%d): %dms", kind, target.Name, sample.Attempt, attempts, sample.Duration.Milliseconds()))
+			} else {
+				client.logError(fmt.Sprintf("%s %s 失败 (尝试 %d/%d): %s", kind, target.Name, sample.Attempt, attempts, sample.Error))
+			}
+			if onSample != nil {
+				onSample(sample)
+			}
+		},
+	})[0]
+	return result.Mean, result.Successful > 0
+}
+
+func newProbeResult(kind string, target ProbeTarget, attempts int) ProbeResult {
+	return ProbeResult{
+		Target: target, Kind: kind, Attempts: attempts,
+		Samples: make([]ProbeSample, 0, attempts), ErrorCounts: make(map[string]int),
+	}
+}
+
+func (result *ProbeResult) record(sample ProbeSample) {
+	result.Samples = append(result.Samples, sample)
+	if sample.Success {
+		result.Successful++
+		return
+	}
+	result.Failed++
+	result.ErrorCounts[sample.ErrorClass]++
+}
+
+func (result *ProbeResult) finish() {
+	if result.Attempts > 0 {
+		result.SuccessRatePercent = float64(result.Successful) * 100 / float64(result.Attempts)
+		result.LossPercent = float64(result.Failed) * 100 / float64(result.Attempts)
+	}
+	switch {
+	case result.Failed == 0:
+		result.Status = ProbeStatusOK
+	case result.Successful > 0:
+		result.Status = ProbeStatusPartial
+	case result.ErrorCounts[TCPErrorCanceled] == result.Failed:
+		result.Status = ProbeStatusCanceled
+	default:
+		result.Status = ProbeStatusFailed
+	}
+	latencies := make([]time.Duration, 0, result.Successful)
+	for _, sample := range result.Samples {
+		if sample.Success {
+			latencies = append(latencies, sample.Duration)
+		}
+	}
+	if len(latencies) == 0 {
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	result.Min, result.Max = latencies[0], latencies[len(latencies)-1]
+	result.Mean = total / time.Duration(len(latencies))
+	result.P50, result.P95 = percentile(latencies, .50), percentile(latencies, .95)
+}
+
+func (sample ProbeSample) progress() ProgressSample {
+	return ProgressSample{Attempt: sample.Attempt, Duration: sample.Duration, Success: sample.Success, Error: sample.ErrorClass}
+}
@@ -0,0 +1,123 @@
+package pt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProber answers from a map of per-host latencies; hosts missing from
+// it time out.
+type fakeProber struct {
+	latencies map[string]time.Duration
+	mu        sync.Mutex
+	calls     int
+}
+
+func (*fakeProber) Kind() string { return "fake" }
+
+func (prober *fakeProber) Probe(_ context.Context, target ProbeTarget) ProbeSample {
+	prober.mu.Lock()
+	prober.calls++
+	prober.mu.Unlock()
+	if latency, ok := prober.latencies[target.Host]; ok {
+		return ProbeSample{Duration: latency, Success: true}
+	}
+	return ProbeSample{ErrorClass: TCPErrorTimeout}
+}
+
+func TestRunProbesKeepsOrderAndReportsProgress(t *testing.T) {
+	prober := &fakeProber{latencies: map[string]time.Duration{"a.test": 10 * time.Millisecond, "c.test": 30 * time.Millisecond}}
+	targets := []ProbeTarget{{Name: "a", Host: "a.test"}, {Name: "b", Host: "b.test"}, {Name: "c", Host: "c.test", Port: 443}}
+	var kinds []ProgressKind
+	var streamed []string
+	results := RunProbes(context.Background(), prober, targets, ProbeConfig{
+		Attempts: 2, Concurrency: 3,
+		OnResult:   func(result ProbeResult) { streamed = append(streamed, result.Target.Name) },
+		OnProgress: func(event ProgressEvent) { kinds = append(kinds, event.Kind) },
+	})
+	if len(results) != 3 || results[0].Target.Name != "a" || results[1].Target.Name != "b" || results[2].Target.Name != "c" {
+		t.Fatalf("results out of target order: %+v", results)
+	}
+	if results[0].Status != ProbeStatusOK || results[0].Mean != 10*time.Millisecond || results[0].Kind != "fake" {
+		t.Fatalf("a = %+v", results[0])
+	}
+	if results[1].Status != ProbeStatusFailed || results[1].ErrorCounts[TCPErrorTimeout] != 2 || results[1].LossPercent != 100 {
+		t.Fatalf("b = %+v", results[1])
+	}
+	if len(streamed) != 3 || len(kinds) != 3*4+1 || kinds[len(kinds)-1] != ProgressRunFinished {
+		t.Fatalf("streamed %v, progress %v", streamed, kinds)
+	}
+	payload, err := json.Marshal(results[2])
+	if err != nil || !strings.Contains(string(payload), `"status":"ok"`) || !strings.Contains(string(payload), `"port":443`) {
+		t.Fatalf("json = %s, %v", payload, err)
+	}
+}
+
+func TestRunProbesMarksCanceledTargets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	prober := &fakeProber{}
+	results := RunProbes(ctx, prober, []ProbeTarget{{Name: "a", Host: "a.test"}, {Name: "b", Host: "b.test"}}, ProbeConfig{Attempts: 2, Concurrency: 1})
+	for _, result := range results {
+		if result.Status != ProbeStatusCanceled || result.ErrorCounts[TCPErrorCanceled] != 2 || len(result.Samples) != 2 {
+			t.Fatalf("result = %+v", result)
+		}
+	}
+	if prober.calls != 0 {
+		t.Fatalf("canceled run probed %d times", prober.calls)
+	}
+}
+
+// hangingProber answers only when the attempt's context ends.
+type hangingProber struct{}
+
+func (hangingProber) Kind() string { return "hang" }
+
+func (hangingProber) Probe(ctx context.Context, _ ProbeTarget) ProbeSample {
+	<-ctx.Done()
+	return ProbeSample{ErrorClass: classifyTCPError(ctx.Err())}
+}
+
+func TestMeasureAverageBoundsEachAttemptWithTheTimeout(t *testing.T) {
+	var samples []ProgressSample
+	started := time.Now()
+	average, ok := (&Client{}).measureAverage(context.Background(), hangingProber{}, ProbeTarget{Name: "a", Host: "a.test"}, 2, 20*time.Millisecond, func(sample ProgressSample) {
+		samples = append(samples, sample)
+	})
+	if ok || average != 0 || time.Since(started) > 2*time.Second {
+		t.Fatalf("measureAverage = %v, %v after %v", average, ok, time.Since(started))
+	}
+	if len(samples) != 2 || samples[0].Error != TCPErrorTimeout || samples[1].Attempt != 2 {
+		t.Fatalf("samples = %+v", samples)
+	}
+}
+
+func TestHTTPProberTreatsServerErrorsAsFailures(t *testing.T) {
+	status := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(status) }))
+	defer server.Close()
+	target := ProbeTarget{Name: "site", URL: server.URL}
+	if sample := (HTTPProber{}).Probe(context.Background(), target); !sample.Success {
+		t.Fatalf("404 sample = %+v, want reachable", sample)
+	}
+	status = http.StatusBadGateway
+	if sample := (HTTPProber{}).Probe(context.Background(), target); sample.Success || sample.ErrorClass != ProbeErrorHTTP {
+		t.Fatalf("502 sample = %+v", sample)
+	}
+}
+
+func TestParsePingTime(t *testing.T) {
+	output := "PING 1.1.1.1 (1.1.1.1) 56(84) bytes of data.\n64 bytes from 1.1.1.1: icmp_seq=1 ttl=57 time=12.4 ms\n"
+	if got, err := parsePingTime(output); err != nil || got != 12400*time.Microsecond {
+		t.Fatalf("parsePingTime = %v, %v", got, err)
+	}
+	if _, err := parsePingTime("Request timeout for icmp_seq 0"); err == nil {
+		t.Fatal("output without time= parsed")
+	}
+}
@@ -0,0 +1,178 @@
+package pt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+	probing "github.com/prometheus-community/pro-bing"
+)
+
+// TCPProber measures the TCP connect time to Host:Port.
+type TCPProber struct {
+	DialContext TCPDialFunc
+	Now         func() time.Time
+}
+
+func (TCPProber) Kind() string { return model.ProtocolTCP }
+
+func (prober TCPProber) Probe(ctx context.Context, target ProbeTarget) ProbeSample {
+	return prober.dial(ctx, target.Address())
+}
+
+func (prober TCPProber) dial(ctx context.Context, address string) ProbeSample {
+	dial, now := prober.DialContext, prober.Now
+	if dial == nil {
+		dial = DefaultTCPProbeConfig().DialContext
+	}
+	if now == nil {
+		now = time.Now
+	}
+	started := now()
+	conn, err := dial(ctx, "tcp", address)
+	elapsed := now().Sub(started)
+	if err != nil {
+		return ProbeSample{ErrorClass: classifyTCPError(err)}
+	}
+	if conn != nil {
+		_ = conn.Close()
+	}
+	return ProbeSample{Duration: max(elapsed, 0), Success: true}
+}
+
+// ICMPProber sends one echo request per attempt. Timeout applies when ctx
+// has no deadline; Network, "ip4" or "ip6", pins the address family.
+type ICMPProber struct {
+	Timeout    time.Duration
+	Privileged bool
+	Network    string
+}
+
+func (ICMPProber) Kind() string { return model.ProtocolICMP }
+
+func (prober ICMPProber) Probe(ctx context.Context, target ProbeTarget) ProbeSample {
+	pinger, err := probing.NewPinger(target.Host)
+	if err != nil {
+		return ProbeSample{ErrorClass: classifyTCPError(err)}
+	}
+	pinger.Count = 1
+	pinger.Timeout = probeTimeout(ctx, prober.Timeout)
+	pinger.SetPrivileged(prober.Privileged)
+	if prober.Network != "" {
+		pinger.SetNetwork(prober.Network)
+	}
+	if err := pinger.RunWithContext(ctx); err != nil {
+		return ProbeSample{ErrorClass: classifyTCPError(err)}
+	}
+	if statistics := pinger.Statistics(); statistics.PacketsRecv > 0 {
+		return ProbeSample{Duration: statistics.AvgRtt, Success: true}
+	}
+	return ProbeSample{ErrorClass: TCPErrorTimeout}
+}
+
+// HTTPProber measures a GET of URL. Any status below 500 counts as
+// reachable; a nil Client follows up to 10 redirects within 10s.
+type HTTPProber struct {
+	Client *http.Client
+}
+
+func (HTTPProber) Kind() string { return model.ProtocolHTTP }
+
+func (prober HTTPProber) Probe(ctx context.Context, target ProbeTarget) ProbeSample {
+	client := prober.Client
+	if client == nil {
+		client = defaultHTTPProbeClient()
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target.URL, nil)
+	if err != nil {
+		return ProbeSample{ErrorClass: TCPErrorUnknown}
+	}
+	start := time.Now()
+	response, err := client.Do(request)
+	duration := time.Since(start)
+	if err != nil {
+		return ProbeSample{ErrorClass: classifyTCPError(err)}
+	}
+	response.Body.Close()
+	if response.StatusCode >= 500 {
+		return ProbeSample{ErrorClass: ProbeErrorHTTP}
+	}
+	return ProbeSample{Duration: duration, Success: true}
+}
+
+func defaultHTTPProbeClient() *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// 允许重定向，但不超过10次
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+}
+
+// SystemPingProber runs the system ping command once per attempt, through
+// sudo when Sudo is set.
+type SystemPingProber struct {
+	Sudo    bool
+	Timeout time.Duration
+}
+
+func (SystemPingProber) Kind() string { return "ping" }
+
+func (prober SystemPingProber) Probe(ctx context.Context, target ProbeTarget) ProbeSample {
+	wait := strconv.Itoa(max(int(probeTimeout(ctx, prober.Timeout).Round(time.Second)/time.Second), 1))
+	args := []string{"ping", "-c1", "-W" + wait, target.Host}
+	if prober.Sudo {
+		args = append([]string{"sudo"}, args...)
+	}
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if ctx.Err() != nil {
+		return ProbeSample{ErrorClass: classifyTCPError(ctx.Err())}
+	}
+	if err != nil {
+		return ProbeSample{ErrorClass: TCPErrorTimeout}
+	}
+	duration, err := parsePingTime(string(output))
+	if err != nil {
+		return ProbeSample{ErrorClass: TCPErrorUnknown}
+	}
+	return ProbeSample{Duration: duration, Success: true}
+}
+
+// parsePingTime extracts the round trip of the first "time=" reply line.
+func parsePingTime(output string) (time.Duration, error) {
+	for _, line := range strings.Split(output, "\n") {
+		_, value, found := strings.Cut(line, "time=")
+		if !found {
+			continue
+		}
+		value, _, _ = strings.Cut(strings.TrimSpace(value), " ")
+		milliseconds, err := strconv.ParseFloat(strings.TrimSuffix(value, "ms"), 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(milliseconds * float64(time.Millisecond)), nil
+	}
+	return 0, errors.New("ping output has no time=")
+}
+
+// probeTimeout is the time left before ctx's deadline, or fallback (3s when
+// unset) for a context without one.
+func probeTimeout(ctx context.Context, fallback time.Duration) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return max(time.Until(deadline), time.Millisecond)
+	}
+	if fallback <= 0 {
+		return timeout
+	}
+	return fallback
+}
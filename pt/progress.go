@@ -0,0 +1,135 @@
+package pt
+
+import (
+	"sync"
+	"time"
+)
+
+// ProgressKind identifies a ProgressEvent.
+type ProgressKind string
+
+// Progress event kinds in the order a run emits them. Every target that
+// starts also finishes, canceled targets included; run_finished comes last
+// and exactly once per run.
+const (
+	ProgressTargetStarted  ProgressKind = "target_started"
+	ProgressSampleRecorded ProgressKind = "sample_recorded"
+	ProgressTargetFinished ProgressKind = "target_finished"
+	ProgressRunFinished    ProgressKind = "run_finished"
+)
+
+// ProgressSample is one measurement within a target: a TCP handshake, an
+// ICMP echo reply, an HTTP request or an MTProto endpoint. Port is set for
+// MTProto endpoints, whose samples are one per port.
+type ProgressSample struct {
+	Attempt  int           `json:"attempt"`
+	Port     int           `json:"port,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Success  bool          `json:"success"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// ProgressEvent reports how far a probe run got. Index is the target's
+// position for the TCP, ICMP and MTProto runners and its start order for the
+// ping, Telegram DC and website runners. Total counts the targets known so
+// far; the domestic ping runner learns it per carrier group. Result holds
+// the TCPResult, ICMPResult, TelegramMTProtoResult or LatencyResult of a
+// finished target.
+type ProgressEvent struct {
+	Kind      ProgressKind    `json:"kind"`
+	Time      time.Time       `json:"time"`
+	Index     int             `json:"index"`
+	Total     int             `json:"total"`
+	Completed int             `json:"completed"`
+	Target    string          `json:"target,omitempty"`
+	Host      string          `json:"host,omitempty"`
+	Sample    *ProgressSample `json:"sample,omitempty"`
+	Result    any             `json:"result,omitempty"`
+}
+
+// ProgressChannel adapts a channel to an OnProgress observer. Sends block,
+// so the channel needs a reader or enough buffer; the caller closes it after
+// the run returned.
+func ProgressChannel(events chan<- ProgressEvent) func(ProgressEvent) {
+	return func(event ProgressEvent) { events <- event }
+}
+
+// progressReporter serializes a run's events and fills in the counters. A
+// nil reporter drops every event, so runners call it unconditionally.
+type progressReporter struct {
+	mu        sync.Mutex
+	observe   func(ProgressEvent)
+	now       func() time.Time
+	total     int
+	started   int
+	completed int
+}
+
+func newProgressReporter(observe func(ProgressEvent), total int, now func() time.Time) *progressReporter {
+	if observe == nil {
+		return nil
+	}
+	if now == nil {
+		now = time.Now
+	}
+	return &progressReporter{observe: observe, now: now, total: total}
+}
+
+// plan adds targets discovered after the run started.
+func (reporter *progressReporter) plan(targets int) {
+	if reporter == nil {
+		return
+	}
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+	reporter.total += targets
+}
+
+// start reports a target and returns its index: index when it is not
+// negative, the start order otherwise.
+func (reporter *progressReporter) start(index int, target, host string) int {
+	if reporter == nil {
+		return index
+	}
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+	if index < 0 {
+		index = reporter.started
+	}
+	reporter.started++
+	reporter.emitLocked(ProgressEvent{Kind: ProgressTargetStarted, Index: index, Target: target, Host: host})
+	return index
+}
+
+func (reporter *progressReporter) sample(index int, target, host string, sample ProgressSample) {
+	if reporter == nil {
+		return
+	}
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+	reporter.emitLocked(ProgressEvent{Kind: ProgressSampleRecorded, Index: index, Target: target, Host: host, Sample: &sample})
+}
+
+func (reporter *progressReporter) finish(index int, target, host string, result any) {
+	if reporter == nil {
+		return
+	}
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+	reporter.completed++
+	reporter.emitLocked(ProgressEvent{Kind: ProgressTargetFinished, Index: index, Target: target, Host: host, Result: result})
+}
+
+func (reporter *progressReporter) done() {
+	if reporter == nil {
+		return
+	}
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+	reporter.emitLocked(ProgressEvent{Kind: ProgressRunFinished, Index: -1})
+}
+
+func (reporter *progressReporter) emitLocked(event ProgressEvent) {
+	event.Time, event.Total, event.Completed = reporter.now(), reporter.total, reporter.completed
+	reporter.observe(event)
+}
@@ -0,0 +1,105 @@
+package pt
+
+import (
+	"context"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+func progressKinds(events []ProgressEvent) string {
+	kinds := make([]string, 0, len(events))
+	for _, event := range events {
+		kinds = append(kinds, string(event.Kind))
+	}
+	return strings.Join(kinds, ",")
+}
+
+func TestRunTCPProbesReportsProgressInOrder(t *testing.T) {
+	targets := []model.TCPTarget{
+		{Name: "one", Host: "one.test", Port: 443},
+		{Name: "two", Host: "two.test", Port: 443},
+	}
+	var events []ProgressEvent
+	RunTCPProbes(context.Background(), targets, TCPProbeConfig{
+		Attempts:    2,
+		Concurrency: 1,
+		DialContext: func(_ context.Context, _, address string) (net.Conn, error) {
+			if strings.HasPrefix(address, "two.") {
+				return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+			}
+			client, server := net.Pipe()
+			_ = server.Close()
+			return client, nil
+		},
+		OnProgress: func(event ProgressEvent) { events = append(events, event) },
+	})
+	want := "target_started,sample_recorded,sample_recorded,target_finished," +
+		"target_started,sample_recorded,sample_recorded,target_finished,run_finished"
+	if got := progressKinds(events); got != want {
+		t.Fatalf("progress kinds = %s, want %s", got, want)
+	}
+	first, second, last := events[1], events[5], events[len(events)-1]
+	if first.Index != 0 || first.Target != "one" || first.Host != "one.test:443" || first.Sample.Attempt != 1 || !first.Sample.Success {
+		t.Fatalf("unexpected first sample: %+v %+v", first, first.Sample)
+	}
+	if second.Index != 1 || second.Sample.Success || second.Sample.Error != TCPErrorRefused {
+		t.Fatalf("unexpected refused sample: %+v %+v", second, second.Sample)
+	}
+	if result, ok := events[7].Result.(TCPResult); !ok || result.Target.Name != "two" || events[7].Completed != 2 {
+		t.Fatalf("unexpected finished event: %+v", events[7])
+	}
+	if last.Total != 2 || last.Completed != 2 || last.Index != -1 {
+		t.Fatalf("unexpected run_finished event: %+v", last)
+	}
+}
+
+func TestRunICMPProbesReportsProgressThroughChannel(t *testing.T) {
+	targets := []ICMPTarget{{ID: "a", Name: "A", Host: "a.test"}, {ID: "b", Name: "B", Host: "b.test"}}
+	events := make(chan ProgressEvent, 16)
+	RunICMPProbes(context.Background(), targets, ICMPProbeConfig{
+		Count: 1, Concurrency: 2,
+		Probe: func(_ context.Context, target ICMPTarget, count int, _ time.Duration) ICMPResult {
+			return ICMPResult{Target: target, Status: "ok", Sent: count, Received: count}
+		},
+		OnProgress: ProgressChannel(events),
+	})
+	close(events)
+	counts := map[ProgressKind]int{}
+	var last ProgressEvent
+	for event := range events {
+		counts[event.Kind]++
+		last = event
+	}
+	if counts[ProgressTargetStarted] != 2 || counts[ProgressTargetFinished] != 2 || counts[ProgressSampleRecorded] != 0 {
+		t.Fatalf("unexpected event counts: %v", counts)
+	}
+	if last.Kind != ProgressRunFinished || last.Completed != 2 || last.Total != 2 {
+		t.Fatalf("unexpected last event: %+v", last)
+	}
+}
+
+func TestRunTelegramMTProtoProbesReportsOneSamplePerPort(t *testing.T) {
+	var events []ProgressEvent
+	RunTelegramMTProtoProbes(context.Background(), []model.TelegramDC{{ID: 1, Name: "TG-DC1", IP: "149.154.175.53"}}, TelegramMTProtoConfig{
+		Ports: []int{443, 80}, Concurrency: 1,
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+		},
+		OnProgress: func(event ProgressEvent) { events = append(events, event) },
+	})
+	want := "target_started,sample_recorded,sample_recorded,target_finished,run_finished"
+	if got := progressKinds(events); got != want {
+		t.Fatalf("progress kinds = %s, want %s", got, want)
+	}
+	if events[1].Sample.Port != 443 || events[2].Sample.Port != 80 || events[2].Sample.Error != TCPErrorRefused {
+		t.Fatalf("unexpected port samples: %+v %+v", events[1].Sample, events[2].Sample)
+	}
+	if result, ok := events[3].Result.(TelegramMTProtoResult); !ok || result.Usable || result.DCID != 1 {
+		t.Fatalf("unexpected finished DC: %+v", events[3].Result)
+	}
+}
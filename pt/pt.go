@@ -1,18 +1,16 @@
 package pt
 
 import (
+	"context"
 	"fmt"
 	"os/exec"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/mattn/go-runewidth"
-	. "github.com/oneclickvirt/defaultset"
 	"github.com/oneclickvirt/pingtest/model"
-	probing "github.com/prometheus-community/pro-bing"
 )
 
 const (
@@ -22,50 +20,19 @@ const (
 )
 
 // pingServerByGolang 使用golang的ping库进行测试 (重复3次取平均)
-func pingServerByGolang(server *model.Server) {
-	if model.EnableLoger {
-		defer Logger.Sync()
-	}
-
-	var totalDuration time.Duration
-	successCount := 0
-
-	// 重复测试3次
-	for attempt := 0; attempt < 3; attempt++ {
-		pinger, err := probing.NewPinger(server.IP)
-		if err != nil {
-			logError(fmt.Sprintf("cannot create pinger (尝试 %d/3): %v", attempt+1, err))
-			continue
-		}
-		pinger.Count = 1 // 每次只ping一次
-		pinger.Timeout = timeout
-		err = pinger.Run()
-		if err != nil {
-			logError(fmt.Sprintf("ping failed (尝试 %d/3): %v", attempt+1, err))
-			continue
-		}
-		stats := pinger.Statistics()
-		if stats.PacketsRecv > 0 {
-			totalDuration += stats.AvgRtt
-			successCount++
-			logError(fmt.Sprintf("Ping %s 成功 (尝试 %d/3): %dms", server.Name, attempt+1, stats.AvgRtt.Milliseconds()))
-		}
-	}
-
-	if successCount > 0 {
-		server.Avg = totalDuration / time.Duration(successCount)
-		server.Tested = true
-	} else {
-		server.Avg = 0
-		server.Tested = false
-	}
+func (client *Client) pingServerByGolang(ctx context.Context, server *model.Server) {
+	defer client.syncLogger()
+	server.Avg, server.Tested = client.measureAverage(ctx, ICMPProber{Timeout: timeout}, ProbeTarget{Name: server.Name, Host: server.IP}, pingCount, timeout, nil)
 }
 
 // pingServerSimple 简化版的ping函数，不需要WaitGroup
-func pingServerSimple(server *model.Server) {
+func (client *Client) pingServerSimple(ctx context.Context, server *model.Server) {
+	if ctx.Err() != nil {
+		return
+	}
 	var cmd *exec.Cmd
 	rootPerm := hasRootPermission()
-	logError(fmt.Sprintf("Root permission check: %v", rootPerm))
+	client.logError(fmt.Sprintf("Root permission check: %v", rootPerm))
 	if rootPerm {
 		cmd = exec.Command("sudo", "ping", "-h")
 	} else {
@@ -73,76 +40,23 @@ func pingServerSimple(server *model.Server) {
 	}
 	output, err := cmd.CombinedOutput()
 	if err != nil || (!strings.Contains(string(output), "Usage") && strings.Contains(string(output), "err")) {
-		pingServerByGolang(server)
+		client.pingServerByGolang(ctx, server)
 	} else {
-		pingServerByCMD(server)
+		client.pingServerByCMD(ctx, server)
 	}
+	applyServerTCPFallback(ctx, server, client.tcpFallbackConfig())
 	if server.Tested {
-		logError(fmt.Sprintf("Ping %s (%s) 成功，延迟: %dms", server.Name, server.IP, server.Avg.Milliseconds()))
+		client.logError(fmt.Sprintf("Ping %s (%s) 成功，延迟: %dms (%s)", server.Name, server.IP, server.Avg.Milliseconds(), server.Protocol))
 	} else {
-		logError(fmt.Sprintf("Ping %s (%s) 失败", server.Name, server.IP))
+		client.logError(fmt.Sprintf("Ping %s (%s) 失败", server.Name, server.IP))
 	}
 }
 
-func pingServerByCMD(server *model.Server) {
-	if model.EnableLoger {
-		defer Logger.Sync()
-	}
-
-	var totalDuration time.Duration
-	successCount := 0
+func (client *Client) pingServerByCMD(ctx context.Context, server *model.Server) {
+	defer client.syncLogger()
 	rootPerm := hasRootPermission()
-	logError(fmt.Sprintf("Root permission check: %v", rootPerm))
-
-	// 重复测试3次
-	for attempt := 0; attempt < 3; attempt++ {
-		// 执行 ping 命令
-		var cmd *exec.Cmd
-		if rootPerm {
-			cmd = exec.Command("sudo", "ping", "-c1", "-W3", server.IP)
-		} else {
-			cmd = exec.Command("ping", "-c1", "-W3", server.IP)
-		}
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			logError(fmt.Sprintf("cannot ping (尝试 %d/3): %v", attempt+1, err))
-			continue
-		}
-		if model.EnableLoger {
-			logError(string(output))
-		}
-		// 解析输出结果
-		if !strings.Contains(string(output), "time=") {
-			logError(fmt.Sprintf("ping failed without time= (尝试 %d/3)", attempt+1))
-			continue
-		}
-		var avgTime float64
-		lines := strings.Split(string(output), "\n")
-		for _, line := range lines {
-			if strings.Contains(line, "time=") {
-				matches := strings.Split(line, "time=")
-				if len(matches) >= 2 {
-					avgTime, err = strconv.ParseFloat(strings.TrimSpace(strings.ReplaceAll(matches[1], "ms", "")), 64)
-					if err != nil {
-						logError(fmt.Sprintf("cannot parse avgTime (尝试 %d/3): %v", attempt+1, err))
-						continue
-					}
-					totalDuration += time.Duration(avgTime * float64(time.Millisecond))
-					successCount++
-					logError(fmt.Sprintf("Ping %s 成功 (尝试 %d/3): %.2fms", server.Name, attempt+1, avgTime))
-					break
-				}
-			}
-		}
-	}
-
-	if successCount > 0 {
-		server.Avg = totalDuration / time.Duration(successCount)
-		server.Tested = true
-	} else {
-		server.Avg = 0
-		server.Tested = false
-	}
+	client.logError(fmt.Sprintf("Root permission check: %v", rootPerm))
+	server.Avg, server.Tested = client.measureAverage(ctx, SystemPingProber{Sudo: rootPerm, Timeout: timeout}, ProbeTarget{Name: server.Name, Host: server.IP}, pingCount, timeout, nil)
 }
 
 // 预处理服务器列表，确保每个运营商+省份组合只有一个服务器
@@ -172,13 +86,17 @@ func preprocessServers(servers []*model.Server) []*model.Server {
 			uniqueMap[key] = server
 		} else {
 			// 如果已存在，则根据来源类型决定是否替换
-			existingType := uniqueMap[key].SourceType
+			existing := uniqueMap[key]
+			existingType := existing.SourceType
 			newType := server.SourceType
 			// 优先级: icmp > net > cn
 			if (newType == "icmp" && (existingType == "net" || existingType == "cn")) ||
 				(newType == "net" && existingType == "cn") {
 				uniqueMap[key] = server
 			}
+			// 被替换的测速节点为保留的节点提供 TCP 回退端点
+			inheritServerFallback(uniqueMap[key], existing)
+			inheritServerFallback(uniqueMap[key], server)
 		}
 	}
 	// 将去重后的服务器转换回切片
@@ -189,13 +107,16 @@ func preprocessServers(servers []*model.Server) []*model.Server {
 	return uniqueServers
 }
 
-// 使用有限并发工作池执行ping测试
-func processWithLimitedConcurrency(servers []*model.Server, concurrency int) []*model.Server {
-	// 先预处理服务器列表，确保每个运营商+省份组合只有一个服务器
-	uniqueServers := preprocessServers(servers)
+// 使用有限并发工作池执行ping测试，notify 非空时在每个服务器测试完成后立即回调，
+// reporter 非空时报告每个服务器的开始与结束，ctx 结束后尚未完成的服务器不再测试。
+// servers 不再去重：三网列表由调用方按运营商+省份去重，国际目标由 internationalServers
+// 按名称去重，自定义目标在加载时已按主机去重，
+// 同名不同主机的目标都会测试
+func (client *Client) processWithLimitedConcurrency(ctx context.Context, servers []*model.Server, notify func(*model.Server), reporter *progressReporter) []*model.Server {
+	reporter.plan(len(servers))
 	var wg sync.WaitGroup
-	sem := make(chan struct{}, concurrency)
-	for i := range uniqueServers {
+	sem := make(chan struct{}, client.concurrency())
+	for i := range servers {
 		wg.Add(1)
 		sem <- struct{}{}
 		go func(index int) {
@@ -203,12 +124,18 @@ func processWithLimitedConcurrency(servers []*model.Server, concurrency int) []*
 				<-sem
 				wg.Done()
 			}()
-			pingServerSimple(uniqueServers[index])
+			server := servers[index]
+			progress := reporter.start(-1, server.Name, server.IP)
+			client.pingServerSimple(ctx, server)
+			if notify != nil {
+				notify(server)
+			}
+			reporter.finish(progress, server.Name, server.IP, serverLatencyResult(server))
 		}(i)
 	}
 	wg.Wait()
 	var testedServers []*model.Server
-	for _, server := range uniqueServers {
+	for _, server := range servers {
 		// 所有服务器都保留，失败的标记为 9999ms
 		if !server.Tested || server.Avg.Milliseconds() == 0 {
 			server.Avg = 9999 * time.Millisecond
@@ -218,14 +145,24 @@ func processWithLimitedConcurrency(servers []*model.Server, concurrency int) []*
 	return testedServers
 }
 
+func (client *Client) PingTest() string {
+	return client.PingTestWithOptions(PingOptions{})
+}
+
+// PingTest runs Client.PingTest on the default client.
 func PingTest() string {
-	return PingTestWithOptions(PingOptions{})
+	return defaultClient.PingTest()
 }
 
 // PingTestWithSort runs the domestic latency test and orders its fixed-width
 // output by measured delay or stable platform name.
+func (client *Client) PingTestWithSort(order model.PingSort) string {
+	return client.PingTestWithOptions(PingOptions{Sort: order, Scope: model.PingScopeChina})
+}
+
+// PingTestWithSort runs Client.PingTestWithSort on the default client.
 func PingTestWithSort(order model.PingSort) string {
-	return PingTestWithOptions(PingOptions{Sort: order, Scope: model.PingScopeChina})
+	return defaultClient.PingTestWithSort(order)
 }
 
 // PingOptions controls the target family and stable output ordering.
@@ -233,42 +170,57 @@ type PingOptions struct {
 	Language string
 	Scope    model.PingScope
 	Sort     model.PingSort
+	// OnProgress, when set, receives the progress events of PingResults and
+	// CustomPingResults. Calls are serialized.
+	OnProgress func(ProgressEvent)
 }
 
 // PingTestWithOptions keeps Chinese mode on the existing domestic registries
 // and uses a small representative international set for English auto mode.
-func PingTestWithOptions(options PingOptions) string {
+func (client *Client) PingTestWithOptions(options PingOptions) string {
 	if options.Sort != model.PingSortName {
 		options.Sort = model.PingSortLatency
 	}
-	scope := options.Scope
-	if scope == "" || scope == model.PingScopeAuto {
+	if options.resolvedScope() == model.PingScopeInternational {
+		return client.pingInternationalTest(options.Sort, options.Language)
+	}
+	return client.pingDomesticTest(options.Sort, options.Language)
+}
+
+// PingTestWithOptions runs Client.PingTestWithOptions on the default client.
+func PingTestWithOptions(options PingOptions) string {
+	return defaultClient.PingTestWithOptions(options)
+}
+
+// resolvedScope maps the auto scope to international for English output and
+// to the domestic registries otherwise.
+func (options PingOptions) resolvedScope() model.PingScope {
+	if options.Scope == "" || options.Scope == model.PingScopeAuto {
 		if strings.EqualFold(strings.TrimSpace(options.Language), "en") {
-			scope = model.PingScopeInternational
-		} else {
-			scope = model.PingScopeChina
+			return model.PingScopeInternational
 		}
+		return model.PingScopeChina
 	}
-	if scope == model.PingScopeInternational {
-		return pingInternationalTest(options.Sort)
-	}
-	return pingDomesticTest(options.Sort)
+	return options.Scope
 }
 
-func pingDomesticTest(order model.PingSort) string {
+func (client *Client) pingDomesticTest(order model.PingSort, language string) string {
 	// 添加 defer recover 防止 panic
 	defer func() {
 		if r := recover(); r != nil {
-			logError(fmt.Sprintf("PingTest panic 恢复: %v", r))
+			client.logError(fmt.Sprintf("PingTest panic 恢复: %v", r))
 		}
 	}()
 
-	if model.EnableLoger {
-		InitLogger()
-	}
-	servers1 := getServers("cu")
-	servers2 := getServers("ct")
-	servers3 := getServers("cmcc")
+	return formatPingServers(client.collectDomesticServers(context.Background(), nil, nil), order, language)
+}
+
+// collectDomesticServers 并发测试三网服务器并返回全部结果
+func (client *Client) collectDomesticServers(ctx context.Context, notify func(*model.Server), reporter *progressReporter) []*model.Server {
+	// 确保每个运营商+省份组合只有一个服务器
+	servers1 := preprocessServers(client.getServers("cu"))
+	servers2 := preprocessServers(client.getServers("ct"))
+	servers3 := preprocessServers(client.getServers("cmcc"))
 	var allServers []*model.Server
 	resultChan := make(chan []*model.Server, 3)
 	var wga sync.WaitGroup
@@ -277,31 +229,31 @@ func pingDomesticTest(order model.PingSort) string {
 		defer wga.Done()
 		defer func() {
 			if r := recover(); r != nil {
-				logError(fmt.Sprintf("processWithLimitedConcurrency panic 恢复: %v", r))
+				client.logError(fmt.Sprintf("processWithLimitedConcurrency panic 恢复: %v", r))
 				resultChan <- []*model.Server{}
 			}
 		}()
-		resultChan <- processWithLimitedConcurrency(servers1, model.MaxConcurrency)
+		resultChan <- client.processWithLimitedConcurrency(ctx, servers1, notify, reporter)
 	}()
 	go func() {
 		defer wga.Done()
 		defer func() {
 			if r := recover(); r != nil {
-				logError(fmt.Sprintf("processWithLimitedConcurrency panic 恢复: %v", r))
+				client.logError(fmt.Sprintf("processWithLimitedConcurrency panic 恢复: %v", r))
 				resultChan <- []*model.Server{}
 			}
 		}()
-		resultChan <- processWithLimitedConcurrency(servers2, model.MaxConcurrency)
+		resultChan <- client.processWithLimitedConcurrency(ctx, servers2, notify, reporter)
 	}()
 	go func() {
 		defer wga.Done()
 		defer func() {
 			if r := recover(); r != nil {
-				logError(fmt.Sprintf("processWithLimitedConcurrency panic 恢复: %v", r))
+				client.logError(fmt.Sprintf("processWithLimitedConcurrency panic 恢复: %v", r))
 				resultChan <- []*model.Server{}
 			}
 		}()
-		resultChan <- processWithLimitedConcurrency(servers3, model.MaxConcurrency)
+		resultChan <- client.processWithLimitedConcurrency(ctx, servers3, notify, reporter)
 	}()
 	go func() {
 		wga.Wait()
@@ -317,19 +269,28 @@ func pingDomesticTest(order model.PingSort) string {
 		}
 		filteredServers = append(filteredServers, server)
 	}
-	allServers = filteredServers
-	return formatPingServers(allServers, order)
+	return filteredServers
+}
+
+func (client *Client) pingInternationalTest(order model.PingSort, language string) string {
+	return formatPingServers(client.processWithLimitedConcurrency(context.Background(), internationalServers(), nil, nil), order, language)
 }
 
-func pingInternationalTest(order model.PingSort) string {
-	targets := make([]*model.Server, 0, len(InternationalICMPTargets()))
-	for _, target := range InternationalICMPTargets() {
-		targets = append(targets, &model.Server{Name: target.Name, IP: target.Host})
+// internationalServers 返回去重后的国际目标，同名目标只保留一个
+func internationalServers() []*model.Server {
+	return icmpTargetServers(InternationalICMPTargets())
+}
+
+func icmpTargetServers(icmpTargets []ICMPTarget) []*model.Server {
+	targets := make([]*model.Server, 0, len(icmpTargets))
+	for _, target := range icmpTargets {
+		// 国际目标均在 443 端口提供服务，ICMP 被过滤时可回退到 TCP
+		targets = append(targets, &model.Server{Name: target.Name, IP: target.Host, Port: 443})
 	}
-	return formatPingServers(processWithLimitedConcurrency(targets, model.MaxConcurrency), order)
+	return preprocessServers(targets)
 }
 
-func formatPingServers(allServers []*model.Server, order model.PingSort) string {
+func formatPingServers(allServers []*model.Server, order model.PingSort, language string) string {
 	if order != model.PingSortName {
 		order = model.PingSortLatency
 	}
@@ -359,6 +320,7 @@ func formatPingServers(allServers []*model.Server, order model.PingSort) string
 	// 优化输出格式，按运营商分组显示
 	var currentISP string
 	var count int
+	fallback := false
 	for _, server := range allServers {
 		// 提取运营商
 		isp := "未知"
@@ -379,13 +341,16 @@ func formatPingServers(allServers []*model.Server, order model.PingSort) string
 			result += "\n"
 		}
 		count++
-		avgStr := fmt.Sprintf("%4d", server.Avg.Milliseconds())
 		name := server.Name
 		padding := 20 - runewidth.StringWidth(name)
 		if padding < 0 {
 			padding = 0
 		}
-		result += fmt.Sprintf("%s%s%4s | ", name, strings.Repeat(" ", padding), avgStr)
+		result += name + strings.Repeat(" ", padding) + formatLatencyCell(server.Avg, server.Protocol)
+		fallback = fallback || server.Protocol == model.ProtocolTCP
+	}
+	if fallback {
+		result += "\n" + latencyFallbackLegend(language)
 	}
 	return result
 }
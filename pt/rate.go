@@ -0,0 +1,94 @@
+package pt
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket shared by the runs it is passed to: each
+// ICMP echo, TCP dial, HTTP request and system ping takes one token. Up to
+// Burst tokens accumulate while idle; beyond that, callers are spaced at
+// 1/Rate.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewRateLimiter returns a limiter allowing perSecond operations per second
+// with bursts of burst. A burst below 1 defaults to perSecond rounded up. A
+// non-positive perSecond returns nil, which never waits.
+func NewRateLimiter(perSecond float64, burst int) *RateLimiter {
+	if perSecond <= 0 || math.IsNaN(perSecond) || math.IsInf(perSecond, 0) {
+		return nil
+	}
+	if burst < 1 {
+		burst = max(int(math.Ceil(perSecond)), 1)
+	}
+	return &RateLimiter{rate: perSecond, burst: burst, tokens: float64(burst), now: time.Now}
+}
+
+// Limit reports the limiter's settings; a nil limiter reports zeros, which
+// mean unlimited.
+func (limiter *RateLimiter) Limit() (perSecond float64, burst int) {
+	if limiter == nil {
+		return 0, 0
+	}
+	return limiter.rate, limiter.burst
+}
+
+// Wait blocks until a token is available or ctx is done. A nil limiter
+// returns at once.
+func (limiter *RateLimiter) Wait(ctx context.Context) error {
+	if limiter == nil {
+		return nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	delay := limiter.reserve()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		limiter.cancel()
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token and returns how long the caller must wait for it.
+// Tokens go negative while callers queue, which spaces them at 1/rate.
+func (limiter *RateLimiter) reserve() time.Duration {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	now := limiter.now()
+	if !limiter.last.IsZero() {
+		limiter.tokens = min(float64(limiter.burst), limiter.tokens+now.Sub(limiter.last).Seconds()*limiter.rate)
+	}
+	limiter.last = now
+	limiter.tokens--
+	if limiter.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-limiter.tokens / limiter.rate * float64(time.Second))
+}
+
+// cancel returns the token of a reservation that was abandoned.
+func (limiter *RateLimiter) cancel() {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.tokens = min(float64(limiter.burst), limiter.tokens+1)
+}
@@ -0,0 +1,77 @@
+package pt
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+func TestRateLimiterSpacesCallsAfterBurst(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := NewRateLimiter(10, 2)
+	limiter.now = func() time.Time { return now }
+	var delays []time.Duration
+	for range 4 {
+		delays = append(delays, limiter.reserve())
+	}
+	want := []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond}
+	for index := range want {
+		if delays[index] != want[index] {
+			t.Fatalf("delays = %v, want %v", delays, want)
+		}
+	}
+	now = now.Add(time.Second)
+	if delay := limiter.reserve(); delay != 0 {
+		t.Fatalf("delay after refill = %v, want 0", delay)
+	}
+}
+
+func TestRateLimiterDefaultsAndCancellation(t *testing.T) {
+	if limiter := NewRateLimiter(0, 5); limiter != nil || limiter.Wait(context.Background()) != nil {
+		t.Fatal("a zero rate must give a nil limiter that never waits")
+	}
+	limiter := NewRateLimiter(2.5, 0)
+	if limiter.burst != 3 {
+		t.Fatalf("default burst = %d, want 3", limiter.burst)
+	}
+	limiter = NewRateLimiter(0.001, 1)
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatalf("first token: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Wait = %v, want deadline exceeded", err)
+	}
+	if limiter.tokens < -0.01 || limiter.tokens > 0.01 {
+		t.Fatalf("abandoned token was not returned: %v", limiter.tokens)
+	}
+}
+
+func TestRateLimiterIsReportedAndPacesTCPDials(t *testing.T) {
+	limiter := NewRateLimiter(50, 1)
+	if rate, burst := limiter.Limit(); rate != 50 || burst != 1 {
+		t.Fatalf("Limit = %v, %d", rate, burst)
+	}
+	if rate, burst := (*RateLimiter)(nil).Limit(); rate != 0 || burst != 0 {
+		t.Fatalf("nil Limit = %v, %d", rate, burst)
+	}
+	started := time.Now()
+	result, err := RunTCPProbe(context.Background(), model.TCPTarget{Name: "paced", Host: "paced.test", Port: 443}, TCPProbeConfig{
+		Attempts: 4, RateLimiter: limiter,
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			client, server := net.Pipe()
+			_ = server.Close()
+			return client, nil
+		},
+	})
+	if err != nil || result.Successful != 4 {
+		t.Fatalf("unexpected result %+v, %v", result, err)
+	}
+	if elapsed := time.Since(started); elapsed < 50*time.Millisecond {
+		t.Fatalf("4 dials at 50/s with burst 1 took %v, want about 60ms", elapsed)
+	}
+}
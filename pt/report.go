@@ -0,0 +1,273 @@
+package pt
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+//go:embed templates/report.html
+var reportTemplateText string
+
+var reportTemplate = template.Must(template.New("report").Parse(reportTemplateText))
+
+// ReportSection holds the structured results of one test step. Only the
+// slice matching Mode is populated.
+type ReportSection struct {
+	Title      string
+	Mode       string
+	Latency    []LatencyResult
+	TCP        []TCPResult
+	MTProto    []TelegramMTProtoResult
+	Registries []RegistryInfo
+}
+
+// Table flattens the section for the CSV and Markdown formats.
+func (section ReportSection) Table() Table {
+	switch section.Mode {
+	case model.ModeTCP:
+		return TCPResultsTable(section.TCP)
+	case model.ModeMTProto:
+		return TelegramMTProtoResultsTable(section.MTProto)
+	default:
+		return LatencyResultsTable(section.Latency)
+	}
+}
+
+// Results returns the populated result slice for JSON encoding.
+func (section ReportSection) Results() any {
+	switch section.Mode {
+	case model.ModeTCP:
+		return section.TCP
+	case model.ModeMTProto:
+		return section.MTProto
+	default:
+		return section.Latency
+	}
+}
+
+// Report is the input of the HTML report: run metadata plus one section per
+// test step in run order.
+type Report struct {
+	Title    string
+	Version  string
+	Language string
+	Hostname string
+	Platform string
+	Started  time.Time
+	Finished time.Time
+	Sections []ReportSection
+}
+
+type reportLabels struct {
+	title, started, finished, duration, host, version, targets, ok, failed, partial string
+	modes                                                                           map[string]string
+	latency, tcp, mtproto                                                           []string
+}
+
+func reportLabelsForLanguage(language string) reportLabels {
+	if strings.EqualFold(strings.TrimSpace(language), "en") {
+		return reportLabels{
+			title: "Network test report", started: "Started", finished: "Finished", duration: "Duration",
+			host: "Host", version: "Version", targets: "Targets", ok: "OK", failed: "Failed", partial: "Lossy",
+			modes: map[string]string{
+				model.ModeOri: "Ping latency", model.ModeTGDC: "Telegram data centers", model.ModeMTProto: "Telegram MTProto",
+				model.ModeWeb: "Websites", model.ModeTCP: "TCP handshakes",
+			},
+			latency: []string{"Name", "Host", "Category", "Protocol", "Latency"},
+			tcp:     []string{"Name", "Endpoint", "State", "Success/Attempts", "Loss", "Avg", "P95", "Latency"},
+			mtproto: []string{"DC", "Location", "Host", "Ports", "Connect/Response", "Latency"},
+		}
+	}
+	return reportLabels{
+		title: "网络测试报告", started: "开始时间", finished: "结束时间", duration: "耗时",
+		host: "主机", version: "版本", targets: "目标", ok: "成功", failed: "失败", partial: "丢包",
+		modes: map[string]string{
+			model.ModeOri: "三网延迟", model.ModeTGDC: "Telegram 数据中心", model.ModeMTProto: "Telegram MTProto",
+			model.ModeWeb: "网站连通性", model.ModeTCP: "TCP 握手",
+		},
+		latency: []string{"名称", "主机", "分类", "协议", "延迟"},
+		tcp:     []string{"平台", "端点", "状态", "成功/尝试", "丢包", "Avg", "P95", "延迟"},
+		mtproto: []string{"DC", "位置", "主机", "端口", "连接/响应", "延迟"},
+	}
+}
+
+type reportView struct {
+	Lang     string
+	Title    string
+	Meta     [][2]string
+	Sections []reportSectionView
+}
+
+type reportSectionView struct {
+	Title   string
+	Summary string
+	Columns []string
+	Rows    []reportRowView
+}
+
+// reportRowView is one table row. The last column is drawn as a bar scaled to
+// the slowest row of the section; Class marks loss (some failed attempts) and
+// down (nothing answered) rows.
+type reportRowView struct {
+	Cells    []string
+	Bar      string
+	BarLabel string
+	Class    string
+}
+
+// WriteHTMLReport renders report as a single self-contained HTML page with
+// inline styles and no external assets.
+func WriteHTMLReport(w io.Writer, report Report) error {
+	return reportTemplate.Execute(w, buildReportView(report))
+}
+
+func buildReportView(report Report) reportView {
+	labels := reportLabelsForLanguage(report.Language)
+	view := reportView{Lang: "zh-CN", Title: report.Title}
+	if strings.EqualFold(strings.TrimSpace(report.Language), "en") {
+		view.Lang = "en"
+	}
+	if view.Title == "" {
+		view.Title = labels.title
+	}
+	if report.Hostname != "" || report.Platform != "" {
+		view.Meta = append(view.Meta, [2]string{labels.host, strings.TrimSpace(report.Hostname + " " + report.Platform)})
+	}
+	if report.Version != "" {
+		view.Meta = append(view.Meta, [2]string{labels.version, report.Version})
+	}
+	if !report.Started.IsZero() {
+		view.Meta = append(view.Meta, [2]string{labels.started, report.Started.Format(time.RFC3339)})
+	}
+	if !report.Finished.IsZero() {
+		view.Meta = append(view.Meta, [2]string{labels.finished, report.Finished.Format(time.RFC3339)})
+		if !report.Started.IsZero() {
+			view.Meta = append(view.Meta, [2]string{labels.duration, report.Finished.Sub(report.Started).Round(time.Second).String()})
+		}
+	}
+	for _, section := range report.Sections {
+		view.Sections = append(view.Sections, buildReportSection(section, labels))
+	}
+	return view
+}
+
+func buildReportSection(section ReportSection, labels reportLabels) reportSectionView {
+	view := reportSectionView{Title: section.Title}
+	if view.Title == "" {
+		view.Title = labels.modes[section.Mode]
+	}
+	var latencies []time.Duration
+	var ok, partial, failed int
+	switch section.Mode {
+	case model.ModeTCP:
+		view.Columns = labels.tcp
+		for _, result := range section.TCP {
+			row := reportRowView{Cells: []string{
+				result.Target.Name,
+				result.Target.Host + ":" + strconv.Itoa(result.Target.Port),
+				TCPPortState(result),
+				fmt.Sprintf("%d/%d", result.Successful, result.Attempts),
+				fmt.Sprintf("%.1f%%", result.LossPercent),
+				formatTCPMilliseconds(result.Mean),
+				formatTCPMilliseconds(result.P95),
+			}}
+			switch {
+			case result.Successful == 0:
+				row.Class = "down"
+				failed++
+			case result.Successful < result.Attempts:
+				row.Class = "loss"
+				partial++
+			default:
+				ok++
+			}
+			if result.Successful > 0 {
+				latencies = append(latencies, result.Mean)
+			} else {
+				latencies = append(latencies, 0)
+			}
+			view.Rows = append(view.Rows, row)
+		}
+	case model.ModeMTProto:
+		view.Columns = labels.mtproto
+		for _, result := range section.MTProto {
+			ports := make([]string, 0, len(result.Endpoints))
+			usable := 0
+			for _, endpoint := range result.Endpoints {
+				ports = append(ports, strconv.Itoa(endpoint.Port)+" "+formatMTProtoEndpoint(result.Endpoints, endpoint.Port))
+				if endpoint.Usable {
+					usable++
+				}
+			}
+			row := reportRowView{Cells: []string{
+				strconv.Itoa(result.DCID), result.Location, result.Host, strings.Join(ports, ", "), "-",
+			}}
+			switch {
+			case !result.Usable:
+				row.Class = "down"
+				failed++
+			case usable < len(result.Endpoints):
+				row.Class = "loss"
+				partial++
+			default:
+				ok++
+			}
+			if result.Usable {
+				row.Cells[4] = formatTCPMilliseconds(result.Connect) + "/" + formatTCPMilliseconds(result.Response)
+				latencies = append(latencies, result.Response)
+			} else {
+				latencies = append(latencies, 0)
+			}
+			view.Rows = append(view.Rows, row)
+		}
+	default:
+		view.Columns = labels.latency
+		for _, result := range section.Latency {
+			name := result.Name
+			if result.Location != "" {
+				name += " " + result.Location
+			}
+			row := reportRowView{Cells: []string{name, result.Host, reportCell(result.Category), reportCell(result.Protocol)}}
+			if result.Status == LatencyOK {
+				ok++
+				latencies = append(latencies, result.Latency)
+			} else {
+				row.Class = "down"
+				failed++
+				latencies = append(latencies, 0)
+			}
+			view.Rows = append(view.Rows, row)
+		}
+	}
+	slowest := time.Duration(0)
+	for _, latency := range latencies {
+		slowest = max(slowest, latency)
+	}
+	for index, latency := range latencies {
+		if latency <= 0 {
+			view.Rows[index].BarLabel = labels.failed
+			continue
+		}
+		view.Rows[index].BarLabel = formatTCPMilliseconds(latency) + " ms"
+		view.Rows[index].Bar = strconv.FormatFloat(max(float64(latency)/float64(slowest)*100, 1), 'f', 1, 64) + "%"
+	}
+	view.Summary = fmt.Sprintf("%s %d · %s %d · %s %d", labels.targets, len(view.Rows), labels.ok, ok, labels.failed, failed)
+	if partial > 0 {
+		view.Summary += fmt.Sprintf(" · %s %d", labels.partial, partial)
+	}
+	return view
+}
+
+func reportCell(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,63 @@
+package pt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+func TestWriteHTMLReportRendersSectionsBarsAndLoss(t *testing.T) {
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	report := Report{
+		Language: "zh", Version: "v0.0.0-test", Hostname: "vps-1", Platform: "linux/amd64",
+		Started: started, Finished: started.Add(90 * time.Second),
+		Sections: []ReportSection{
+			{Mode: model.ModeOri, Latency: []LatencyResult{
+				{Name: "联通北京", Host: "192.0.2.1", Protocol: model.ProtocolICMP, Status: LatencyOK, Latency: 20 * time.Millisecond},
+				{Name: "<script>", Host: "192.0.2.2", Protocol: model.ProtocolICMP, Status: LatencyFailed},
+			}},
+			{Title: "内部服务", Mode: model.ModeTCP, TCP: []TCPResult{
+				{Target: model.TCPTarget{Name: "api", Host: "api.test", Port: 443}, Attempts: 4, Successful: 3, LossPercent: 25, Mean: 10 * time.Millisecond, P95: 12 * time.Millisecond},
+				{Target: model.TCPTarget{Name: "fast", Host: "fast.test", Port: 443}, Attempts: 4, Successful: 4, Mean: 5 * time.Millisecond, P95: 5 * time.Millisecond},
+			}},
+		},
+	}
+	var output bytes.Buffer
+	if err := WriteHTMLReport(&output, report); err != nil {
+		t.Fatal(err)
+	}
+	html := output.String()
+	for _, value := range []string{
+		"<title>网络测试报告</title>", "<h2>三网延迟</h2>", "<h2>内部服务</h2>", "vps-1 linux/amd64", "v0.0.0-test", "1m30s",
+		`<tr class="down">`, `<tr class="loss">`, `style="width: 100.0%"`, `style="width: 50.0%"`, "&lt;script&gt;",
+		"目标 2 · 成功 1 · 失败 0 · 丢包 1", "api.test:443", "3/4", "25.0%",
+	} {
+		if !strings.Contains(html, value) {
+			t.Errorf("report does not contain %q", value)
+		}
+	}
+	for _, forbidden := range []string{"<script>", "<link", " src=", "ZgotmplZ"} {
+		if strings.Contains(html, forbidden) {
+			t.Errorf("report contains %q", forbidden)
+		}
+	}
+}
+
+func TestWriteHTMLReportUsesEnglishLabels(t *testing.T) {
+	var output bytes.Buffer
+	err := WriteHTMLReport(&output, Report{Language: "en", Sections: []ReportSection{{Mode: model.ModeMTProto, MTProto: []TelegramMTProtoResult{{
+		DCID: 2, Location: "AMS NL", Host: "149.154.167.50",
+		Endpoints: []TelegramMTProtoEndpoint{{Port: 443, ErrorClass: TCPErrorTimeout}},
+	}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{`<html lang="en">`, "<h2>Telegram MTProto</h2>", "443 timeout", `<tr class="down">`, "Targets 1 · OK 0 · Failed 1"} {
+		if !strings.Contains(output.String(), value) {
+			t.Errorf("report does not contain %q", value)
+		}
+	}
+}
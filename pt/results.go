@@ -0,0 +1,291 @@
+package pt
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+// Latency result states.
+const (
+	LatencyOK     = "ok"
+	LatencyFailed = "failed"
+)
+
+// LatencyResult is the structured form of one row of the ori, tgdc and web
+// text grids. Latency is zero when the target never answered.
+type LatencyResult struct {
+	Name     string        `json:"name"`
+	Host     string        `json:"host"`
+	Port     int           `json:"port,omitempty"`
+	Location string        `json:"location,omitempty"`
+	Category string        `json:"category,omitempty"`
+	Protocol string        `json:"protocol,omitempty"`
+	Status   string        `json:"status"`
+	Latency  time.Duration `json:"latency"`
+}
+
+// latencyCollector gathers results from concurrent probes and forwards each
+// one to onResult as it arrives. Calls to onResult are serialized.
+type latencyCollector struct {
+	mu       sync.Mutex
+	results  []LatencyResult
+	onResult func(LatencyResult)
+}
+
+func (collector *latencyCollector) add(result LatencyResult) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	collector.results = append(collector.results, result)
+	if collector.onResult != nil {
+		collector.onResult(result)
+	}
+}
+
+// sorted orders results like the text grids: answered targets by latency
+// with failures last, or by name.
+func (collector *latencyCollector) sorted(order model.PingSort) []LatencyResult {
+	results := collector.results
+	sort.SliceStable(results, func(i, j int) bool {
+		if order != model.PingSortName && (results[i].Status == LatencyOK) != (results[j].Status == LatencyOK) {
+			return results[i].Status == LatencyOK
+		}
+		if order != model.PingSortName && results[i].Latency != results[j].Latency {
+			return results[i].Latency < results[j].Latency
+		}
+		return strings.ToLower(results[i].Name) < strings.ToLower(results[j].Name)
+	})
+	return results
+}
+
+func latencyStatus(tested bool, latency time.Duration) (string, time.Duration) {
+	if !tested {
+		return LatencyFailed, 0
+	}
+	return LatencyOK, latency
+}
+
+func serverLatencyResult(server *model.Server) LatencyResult {
+	status, latency := latencyStatus(server.Tested, server.Avg)
+	protocol := server.Protocol
+	if protocol == "" {
+		protocol = model.ProtocolICMP
+	}
+	result := LatencyResult{Name: server.Name, Host: server.IP, Protocol: protocol, Status: status, Latency: latency}
+	if protocol == model.ProtocolTCP {
+		result.Port = server.Port
+	}
+	return result
+}
+
+func telegramDCLatencyResult(dc model.TelegramDC) LatencyResult {
+	status, latency := latencyStatus(dc.Tested, dc.Avg)
+	protocol := dc.Protocol
+	if protocol == "" {
+		protocol = model.ProtocolICMP
+	}
+	return LatencyResult{
+		Name: dc.Name, Host: dc.IP, Location: dc.Location, Category: dc.Kind,
+		Protocol: protocol, Status: status, Latency: latency,
+	}
+}
+
+func websiteLatencyResult(website model.Website) LatencyResult {
+	status, latency := latencyStatus(website.Tested, website.Avg)
+	return LatencyResult{
+		Name: website.Name, Host: website.URL, Category: website.Category,
+		Protocol: model.ProtocolHTTP, Status: status, Latency: latency,
+	}
+}
+
+// PingResults measures the same targets as PingTestWithOptions and returns
+// one result per server. onResult, when set, receives each result as soon as
+// its server finished. Servers left when ctx ends are reported as failed.
+func (client *Client) PingResults(ctx context.Context, options PingOptions, onResult func(LatencyResult)) []LatencyResult {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	collector := &latencyCollector{onResult: onResult}
+	reporter := newProgressReporter(options.OnProgress, 0, nil)
+	defer reporter.done()
+	notify := func(server *model.Server) { collector.add(serverLatencyResult(server)) }
+	if options.resolvedScope() == model.PingScopeInternational {
+		client.processWithLimitedConcurrency(ctx, internationalServers(), notify, reporter)
+	} else {
+		client.collectDomesticServers(ctx, notify, reporter)
+	}
+	return collector.sorted(options.Sort)
+}
+
+// PingResults runs Client.PingResults on the default client.
+func PingResults(ctx context.Context, options PingOptions, onResult func(LatencyResult)) []LatencyResult {
+	return defaultClient.PingResults(ctx, options, onResult)
+}
+
+// CustomPingResults is PingResults for the icmp entries of a custom target
+// file.
+func (client *Client) CustomPingResults(ctx context.Context, targets []model.CustomTarget, options PingOptions, onResult func(LatencyResult)) []LatencyResult {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	collector := &latencyCollector{onResult: onResult}
+	reporter := newProgressReporter(options.OnProgress, 0, nil)
+	defer reporter.done()
+	client.processWithLimitedConcurrency(ctx, customPingServers(targets), func(server *model.Server) {
+		collector.add(serverLatencyResult(server))
+	}, reporter)
+	return collector.sorted(options.Sort)
+}
+
+// CustomPingResults runs Client.CustomPingResults on the default client.
+func CustomPingResults(ctx context.Context, targets []model.CustomTarget, options PingOptions, onResult func(LatencyResult)) []LatencyResult {
+	return defaultClient.CustomPingResults(ctx, targets, options, onResult)
+}
+
+// TelegramDCResults measures the same data centers as
+// TelegramDCTestWithOptions and returns them ordered by latency.
+func (client *Client) TelegramDCResults(ctx context.Context, options TelegramDCOptions, onResult func(LatencyResult)) []LatencyResult {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	collector := &latencyCollector{onResult: onResult}
+	reporter := newProgressReporter(options.OnProgress, 0, nil)
+	defer reporter.done()
+	client.measureTelegramDCs(ctx, options, func(dc model.TelegramDC) {
+		collector.add(telegramDCLatencyResult(dc))
+	}, reporter)
+	return collector.sorted(model.PingSortLatency)
+}
+
+// TelegramDCResults runs Client.TelegramDCResults on the default client.
+func TelegramDCResults(ctx context.Context, options TelegramDCOptions, onResult func(LatencyResult)) []LatencyResult {
+	return defaultClient.TelegramDCResults(ctx, options, onResult)
+}
+
+// TelegramMTProtoResults probes the selected data centers like
+// TelegramMTProtoTest and returns the structured results. onResult receives
+// each DC once all of its ports finished.
+func (client *Client) TelegramMTProtoResults(ctx context.Context, options TelegramDCOptions, onResult func(TelegramMTProtoResult)) []TelegramMTProtoResult {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	dcs := client.telegramDataCentersForOptions(ctx, options)
+	return RunTelegramMTProtoProbes(ctx, dcs, TelegramMTProtoConfig{OnResult: onResult, OnProgress: options.OnProgress, RateLimiter: client.RateLimiter})
+}
+
+// TelegramMTProtoResults runs Client.TelegramMTProtoResults on the default client.
+func TelegramMTProtoResults(ctx context.Context, options TelegramDCOptions, onResult func(TelegramMTProtoResult)) []TelegramMTProtoResult {
+	return defaultClient.TelegramMTProtoResults(ctx, options, onResult)
+}
+
+// WebsiteOptions controls the structured website runner.
+type WebsiteOptions struct {
+	// OnProgress, when set, receives a ProgressEvent when a website starts,
+	// after every request, when it finishes and when the run ends. Calls are
+	// serialized.
+	OnProgress func(ProgressEvent)
+}
+
+// WebsiteResults measures websites like WebsiteTestWithTargets and returns
+// them ordered by latency. Requests stop when ctx ends; the websites they
+// would have measured are reported as failed.
+func (client *Client) WebsiteResults(ctx context.Context, websites []model.Website, onResult func(LatencyResult)) []LatencyResult {
+	return client.WebsiteResultsWithOptions(ctx, websites, WebsiteOptions{}, onResult)
+}
+
+// WebsiteResults runs Client.WebsiteResults on the default client.
+func WebsiteResults(ctx context.Context, websites []model.Website, onResult func(LatencyResult)) []LatencyResult {
+	return defaultClient.WebsiteResults(ctx, websites, onResult)
+}
+
+// WebsiteResultsWithOptions is WebsiteResults with progress reporting.
+func (client *Client) WebsiteResultsWithOptions(ctx context.Context, websites []model.Website, options WebsiteOptions, onResult func(LatencyResult)) []LatencyResult {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	collector := &latencyCollector{onResult: onResult}
+	reporter := newProgressReporter(options.OnProgress, 0, nil)
+	defer reporter.done()
+	client.measureWebsites(ctx, websites, func(website model.Website) {
+		collector.add(websiteLatencyResult(website))
+	}, reporter)
+	return collector.sorted(model.PingSortLatency)
+}
+
+// WebsiteResultsWithOptions runs Client.WebsiteResultsWithOptions on the default client.
+func WebsiteResultsWithOptions(ctx context.Context, websites []model.Website, options WebsiteOptions, onResult func(LatencyResult)) []LatencyResult {
+	return defaultClient.WebsiteResultsWithOptions(ctx, websites, options, onResult)
+}
+
+// CustomWebsiteResults is WebsiteResults for the http and tls entries of a
+// custom target file.
+func (client *Client) CustomWebsiteResults(ctx context.Context, targets []model.CustomTarget, onResult func(LatencyResult)) []LatencyResult {
+	return client.WebsiteResults(ctx, customWebsites(targets), onResult)
+}
+
+// CustomWebsiteResults runs Client.CustomWebsiteResults on the default client.
+func CustomWebsiteResults(ctx context.Context, targets []model.CustomTarget, onResult func(LatencyResult)) []LatencyResult {
+	return defaultClient.CustomWebsiteResults(ctx, targets, onResult)
+}
+
+// CustomWebsiteResultsWithOptions is CustomWebsiteResults with progress
+// reporting.
+func (client *Client) CustomWebsiteResultsWithOptions(ctx context.Context, targets []model.CustomTarget, options WebsiteOptions, onResult func(LatencyResult)) []LatencyResult {
+	return client.WebsiteResultsWithOptions(ctx, customWebsites(targets), options, onResult)
+}
+
+// CustomWebsiteResultsWithOptions runs Client.CustomWebsiteResultsWithOptions
+// on the default client.
+func CustomWebsiteResultsWithOptions(ctx context.Context, targets []model.CustomTarget, options WebsiteOptions, onResult func(LatencyResult)) []LatencyResult {
+	return defaultClient.CustomWebsiteResultsWithOptions(ctx, targets, options, onResult)
+}
+
+// FormatPingResults renders structured ping results in the PingTest grid.
+func FormatPingResults(results []LatencyResult, order model.PingSort, language string) string {
+	servers := make([]*model.Server, 0, len(results))
+	for _, result := range results {
+		servers = append(servers, &model.Server{
+			Name: result.Name, IP: result.Host, Avg: legacyLatency(result),
+			Tested: result.Status == LatencyOK, Protocol: result.Protocol,
+		})
+	}
+	return formatPingServers(servers, order, language)
+}
+
+// FormatTelegramDCResults renders structured Telegram DC results in the
+// TelegramDCTest grid.
+func FormatTelegramDCResults(results []LatencyResult, language string) string {
+	datacenters := make([]model.TelegramDC, 0, len(results))
+	for _, result := range results {
+		datacenters = append(datacenters, model.TelegramDC{
+			Name: result.Name, Location: result.Location, IP: result.Host, Kind: result.Category,
+			Avg: legacyLatency(result), Tested: result.Status == LatencyOK, Protocol: result.Protocol,
+		})
+	}
+	return formatTelegramDCs(datacenters, language)
+}
+
+// FormatWebsiteResults renders structured website results in the
+// WebsiteTest grid.
+func FormatWebsiteResults(results []LatencyResult) string {
+	websites := make([]model.Website, 0, len(results))
+	for _, result := range results {
+		websites = append(websites, model.Website{
+			Name: result.Name, URL: result.Host, Category: result.Category,
+			Avg: legacyLatency(result), Tested: result.Status == LatencyOK,
+		})
+	}
+	return formatWebsites(websites)
+}
+
+// legacyLatency maps failures to the 9999ms marker of the text grids.
+func legacyLatency(result LatencyResult) time.Duration {
+	if result.Status != LatencyOK || result.Latency.Milliseconds() == 0 {
+		return 9999 * time.Millisecond
+	}
+	return result.Latency
+}
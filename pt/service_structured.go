@@ -26,13 +26,32 @@ func RunInternationalICMPProbes(ctx context.Context, config ICMPProbeConfig) []I
 // structured ICMP format. The returned slice does not share mutable state with
 // the legacy formatter.
 func TelegramICMPTargets() []ICMPTarget {
-	targets := make([]ICMPTarget, 0, len(model.TelegramDataCenters))
-	for _, dc := range model.TelegramDataCenters {
+	return TelegramICMPTargetsFor(model.TelegramDataCenters)
+}
+
+// TelegramICMPTargetsFor converts any subset of the Telegram DC registry, for
+// example one selected with model.FilterTelegramDataCenters. IDs of the
+// default production IPv4 entries stay "telegram-dc-N"; other kinds and IPv6
+// addresses get a suffix so every ID remains unique.
+func TelegramICMPTargetsFor(dcs []model.TelegramDC) []ICMPTarget {
+	targets := make([]ICMPTarget, 0, len(dcs))
+	for _, dc := range dcs {
+		id := "telegram-dc-" + strconv.Itoa(dc.ID)
+		if dc.Kind != "" && dc.Kind != model.TelegramDCKindProduction {
+			id += "-" + dc.Kind
+		}
+		version := dc.IPVersion
+		if version == "" {
+			version = "ipv4"
+		}
+		if version != "ipv4" {
+			id += "-" + version
+		}
 		targets = append(targets, ICMPTarget{
-			ID:        "telegram-dc-" + strconv.Itoa(dc.ID),
+			ID:        id,
 			Name:      dc.Name + " " + dc.Location,
 			Host:      dc.IP,
-			IPVersion: "ipv4",
+			IPVersion: version,
 		})
 	}
 	return targets
@@ -7,6 +7,8 @@ import (
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
 )
 
 func TestRunTelegramICMPProbesUsesStructuredRunner(t *testing.T) {
@@ -60,3 +62,24 @@ func TestInternationalICMPTargetsExcludeChinaAndRemainRepresentative(t *testing.
 		}
 	}
 }
+
+func TestTelegramICMPTargetsForKeepsIDsUniqueAcrossKinds(t *testing.T) {
+	targets := TelegramICMPTargetsFor(model.AllTelegramDataCenters())
+	seen := make(map[string]struct{}, len(targets))
+	ipv6 := 0
+	for _, target := range targets {
+		if _, exists := seen[target.ID]; exists {
+			t.Fatalf("duplicate Telegram ICMP target ID %q", target.ID)
+		}
+		seen[target.ID] = struct{}{}
+		if target.IPVersion == "ipv6" {
+			ipv6++
+		}
+	}
+	if ipv6 == 0 {
+		t.Fatal("no IPv6 Telegram ICMP targets")
+	}
+	if _, exists := seen["telegram-dc-1"]; !exists {
+		t.Fatal("default production ID changed")
+	}
+}
@@ -0,0 +1,165 @@
+package pt
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// Table is the flat form of a result list shared by the CSV and Markdown
+// output formats. Durations are milliseconds and empty cells mean the value
+// was not measured.
+type Table struct {
+	Header []string
+	Rows   [][]string
+}
+
+// LatencyResultsTable flattens ori, tgdc and web results.
+func LatencyResultsTable(results []LatencyResult) Table {
+	table := Table{Header: []string{"name", "host", "port", "location", "category", "protocol", "status", "latency_ms"}}
+	for _, result := range results {
+		latency := ""
+		if result.Status == LatencyOK {
+			latency = tableMilliseconds(result.Latency)
+		}
+		table.Rows = append(table.Rows, []string{
+			result.Name, result.Host, tablePort(result.Port), result.Location, result.Category,
+			result.Protocol, result.Status, latency,
+		})
+	}
+	return table
+}
+
+// TCPResultsTable flattens TCP results, one row per endpoint with its port
+// state and handshake statistics.
+func TCPResultsTable(results []TCPResult) Table {
+	table := Table{Header: []string{
+		"name", "host", "port", "category", "state", "attempts", "successful", "loss_percent",
+		"min_ms", "mean_ms", "p50_ms", "p95_ms", "max_ms", "errors",
+	}}
+	for _, result := range results {
+		measured := func(value time.Duration) string {
+			if result.Successful == 0 {
+				return ""
+			}
+			return tableMilliseconds(value)
+		}
+		table.Rows = append(table.Rows, []string{
+			result.Target.Name, result.Target.Host, tablePort(result.Target.Port), result.Target.Category,
+			TCPPortState(result), strconv.Itoa(result.Attempts), strconv.Itoa(result.Successful),
+			strconv.FormatFloat(result.LossPercent, 'f', 1, 64),
+			measured(result.Min), measured(result.Mean), measured(result.P50), measured(result.P95), measured(result.Max),
+			tableErrorCounts(result.ErrorCounts),
+		})
+	}
+	return table
+}
+
+// TelegramMTProtoResultsTable flattens MTProto results, one row per DC and
+// port.
+func TelegramMTProtoResultsTable(results []TelegramMTProtoResult) Table {
+	table := Table{Header: []string{"dc_id", "name", "location", "host", "port", "usable", "connect_ms", "response_ms", "error"}}
+	for _, result := range results {
+		for _, endpoint := range result.Endpoints {
+			connect, response := "", ""
+			if endpoint.Connect > 0 {
+				connect = tableMilliseconds(endpoint.Connect)
+			}
+			if endpoint.Usable {
+				response = tableMilliseconds(endpoint.Response)
+			}
+			table.Rows = append(table.Rows, []string{
+				strconv.Itoa(result.DCID), result.Name, result.Location, result.Host, tablePort(endpoint.Port),
+				strconv.FormatBool(endpoint.Usable), connect, response, endpoint.ErrorClass,
+			})
+		}
+	}
+	return table
+}
+
+// CSV renders the table as RFC 4180 CSV with a header row.
+func (table Table) CSV() (string, error) {
+	var output bytes.Buffer
+	writer := csv.NewWriter(&output)
+	if err := writer.Write(table.Header); err != nil {
+		return "", err
+	}
+	if err := writer.WriteAll(table.Rows); err != nil {
+		return "", err
+	}
+	return output.String(), nil
+}
+
+// Markdown renders the table as a GitHub-flavored Markdown table padded to
+// column width so it also reads well as plain text. Empty cells become "-".
+func (table Table) Markdown() string {
+	rows := make([][]string, 0, len(table.Rows)+1)
+	rows = append(rows, table.Header)
+	for _, row := range table.Rows {
+		cells := make([]string, len(table.Header))
+		for index := range cells {
+			cells[index] = "-"
+			if index < len(row) && row[index] != "" {
+				cells[index] = strings.ReplaceAll(row[index], "|", `\|`)
+			}
+		}
+		rows = append(rows, cells)
+	}
+	widths := make([]int, len(table.Header))
+	for _, row := range rows {
+		for index, cell := range row {
+			widths[index] = max(widths[index], runewidth.StringWidth(cell), 3)
+		}
+	}
+	var output strings.Builder
+	writeRow := func(cells []string) {
+		output.WriteString("|")
+		for index, cell := range cells {
+			output.WriteString(" " + cell + strings.Repeat(" ", widths[index]-runewidth.StringWidth(cell)) + " |")
+		}
+		output.WriteByte('\n')
+	}
+	writeRow(rows[0])
+	separator := make([]string, len(widths))
+	for index, width := range widths {
+		separator[index] = strings.Repeat("-", width)
+	}
+	writeRow(separator)
+	for _, row := range rows[1:] {
+		writeRow(row)
+	}
+	return output.String()
+}
+
+func tableMilliseconds(value time.Duration) string {
+	return strconv.FormatFloat(float64(value)/float64(time.Millisecond), 'f', 2, 64)
+}
+
+func tablePort(port int) string {
+	if port == 0 {
+		return ""
+	}
+	return strconv.Itoa(port)
+}
+
+// tableErrorCounts renders error classes as "class=count" pairs in a stable
+// order, separated by semicolons like the tags column of target files.
+func tableErrorCounts(counts map[string]int) string {
+	classes := make([]string, 0, len(counts))
+	for class, count := range counts {
+		if count > 0 {
+			classes = append(classes, class)
+		}
+	}
+	sort.Strings(classes)
+	for index, class := range classes {
+		classes[index] = fmt.Sprintf("%s=%d", class, counts[class])
+	}
+	return strings.Join(classes, ";")
+}
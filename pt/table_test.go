@@ -0,0 +1,67 @@
+package pt
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+func TestTCPResultsTableFlattensStatesAndErrors(t *testing.T) {
+	table := TCPResultsTable([]TCPResult{
+		{Target: model.TCPTarget{Name: "open", Host: "open.test", Port: 443, Category: "https"}, Attempts: 2, Successful: 2, Min: time.Millisecond, Mean: 1500 * time.Microsecond, P50: time.Millisecond, P95: 2 * time.Millisecond, Max: 2 * time.Millisecond},
+		{Target: model.TCPTarget{Name: "closed", Host: "closed.test", Port: 22}, Attempts: 2, Failed: 2, LossPercent: 100, ErrorCounts: map[string]int{TCPErrorTimeout: 1, TCPErrorRefused: 1}},
+	})
+	if len(table.Rows) != 2 || len(table.Rows[0]) != len(table.Header) {
+		t.Fatalf("unexpected table shape: %+v", table)
+	}
+	if got := strings.Join(table.Rows[0], ","); got != "open,open.test,443,https,open,2,2,0.0,1.00,1.50,1.00,2.00,2.00," {
+		t.Fatalf("open row = %q", got)
+	}
+	if got := strings.Join(table.Rows[1], ","); got != "closed,closed.test,22,,refused,2,0,100.0,,,,,,refused=1;timeout=1" {
+		t.Fatalf("closed row = %q", got)
+	}
+}
+
+func TestTableCSVQuotesAndKeepsHeader(t *testing.T) {
+	text, err := LatencyResultsTable([]LatencyResult{
+		{Name: "Example, Inc", Host: "https://example.test", Category: "dev", Protocol: model.ProtocolHTTP, Status: LatencyOK, Latency: 12345 * time.Microsecond},
+		{Name: "down", Host: "192.0.2.1", Protocol: model.ProtocolICMP, Status: LatencyFailed},
+	}).CSV()
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(strings.NewReader(text)).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV does not parse: %v: %q", err, text)
+	}
+	if len(records) != 3 || records[0][0] != "name" || records[1][0] != "Example, Inc" || records[1][7] != "12.35" || records[2][6] != LatencyFailed || records[2][7] != "" {
+		t.Fatalf("unexpected CSV records: %q", records)
+	}
+}
+
+func TestTableMarkdownPadsEscapesAndFillsEmptyCells(t *testing.T) {
+	markdown := Table{Header: []string{"name", "value"}, Rows: [][]string{{"a|b", ""}, {"长名称", "1"}}}.Markdown()
+	lines := strings.Split(strings.TrimSuffix(markdown, "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("markdown lines = %q", lines)
+	}
+	if lines[1] != "| ------ | ----- |" || lines[2] != `| a\|b   | -     |` || lines[3] != "| 长名称 | 1     |" {
+		t.Fatalf("unexpected markdown table:\n%s", markdown)
+	}
+}
+
+func TestTelegramMTProtoResultsTableHasOneRowPerPort(t *testing.T) {
+	table := TelegramMTProtoResultsTable([]TelegramMTProtoResult{{
+		DCID: 2, Name: "TG-DC2", Location: "AMS NL", Host: "149.154.167.50",
+		Endpoints: []TelegramMTProtoEndpoint{
+			{Port: 443, Connect: time.Millisecond, Response: 2 * time.Millisecond, Usable: true},
+			{Port: 80, ErrorClass: TCPErrorRefused},
+		},
+	}})
+	if len(table.Rows) != 2 || strings.Join(table.Rows[0][4:8], ",") != "443,true,1.00,2.00" || table.Rows[1][8] != TCPErrorRefused {
+		t.Fatalf("unexpected MTProto table: %+v", table.Rows)
+	}
+}
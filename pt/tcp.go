@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"math/rand/v2"
 	"net"
 	"sort"
 	"strconv"
@@ -37,6 +38,31 @@ type TCPProbeConfig struct {
 	Concurrency int
 	DialContext TCPDialFunc
 	Now         func() time.Time
+	// Interval is the pause between two attempts on the same target, plus a
+	// random extra of up to Jitter.
+	Interval time.Duration
+	Jitter   time.Duration
+	// Interleave makes RunTCPProbes send one attempt per target per round,
+	// each round in a new random order, instead of finishing every attempt
+	// of a target before moving on. A transient spike then lands on a few
+	// samples of many targets rather than every sample of one.
+	Interleave bool
+	// Random returns values in [0, 1) for the jitter and the round order. It
+	// must be safe for concurrent use; the default is math/rand/v2.Float64.
+	Random func() float64
+	// RateLimiter, when set, gives every handshake a token; share one
+	// limiter between runs to give them one budget. Nil is unlimited.
+	RateLimiter *RateLimiter
+	// OnResult, when set, receives each endpoint result as soon as its probes
+	// finished. Calls are serialized.
+	OnResult func(TCPResult)
+	// OnRegistry, when set, receives the registry RunLoadedTCPRegistry
+	// resolved before probing.
+	OnRegistry func(RegistryInfo)
+	// OnProgress, when set, receives a ProgressEvent when a target starts,
+	// after every handshake, when a target finishes and when the run ends.
+	// Calls are serialized.
+	OnProgress func(ProgressEvent)
 }
 
 // TCPSample records one connection attempt. Duration is zero for failed
@@ -73,6 +99,7 @@ func DefaultTCPProbeConfig() TCPProbeConfig {
 		Concurrency: 16,
 		DialContext: (&net.Dialer{}).DialContext,
 		Now:         time.Now,
+		Random:      rand.Float64,
 	}
 }
 
@@ -93,9 +120,31 @@ func (config TCPProbeConfig) withDefaults() TCPProbeConfig {
 	if config.Now == nil {
 		config.Now = defaults.Now
 	}
+	if config.Random == nil {
+		config.Random = defaults.Random
+	}
+	config.Interval, config.Jitter = max(config.Interval, 0), max(config.Jitter, 0)
 	return config
 }
 
+// attemptDelay returns the pause before the next attempt on a target.
+func (config TCPProbeConfig) attemptDelay() time.Duration {
+	return config.Interval + time.Duration(config.Random()*float64(config.Jitter))
+}
+
+// sleepContext waits for delay or until ctx is done.
+func sleepContext(ctx context.Context, delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
 // RunTCPProbe performs repeated TCP handshakes against target. It records
 // failed attempts and their stable error classes rather than returning early,
 // allowing callers to render partial results. An invalid target is returned
@@ -104,92 +153,101 @@ func RunTCPProbe(ctx context.Context, target model.TCPTarget, config TCPProbeCon
 	if ctx == nil {
 		ctx = context.Background()
 	}
+	if err := validateTCPTarget(target); err != nil {
+		return TCPResult{}, err
+	}
+	config = config.withDefaults()
+	reporter := newProgressReporter(config.OnProgress, 1, config.Now)
+	defer reporter.done()
+	return probeTCPTarget(ctx, target, config, 0, reporter), nil
+}
+
+// probeTCPTarget measures a valid target with the RunProbes loop and
+// reports it as target index of a run.
+func probeTCPTarget(ctx context.Context, target model.TCPTarget, config TCPProbeConfig, index int, reporter *progressReporter) TCPResult {
+	probe := ProbeTarget{Name: target.Name, Host: target.Host, Port: target.Port}
+	result := tcpResultFrom(target, probeTarget(ctx, config.prober(), probe, config, index, reporter))
+	reporter.finish(index, target.Name, probe.Address(), result)
+	return result
+}
+
+// prober is the TCPProber dialing with the config's DialContext and clock.
+func (config TCPProbeConfig) prober() TCPProber {
+	return TCPProber{DialContext: config.DialContext, Now: config.Now}
+}
+
+// tcpResultFrom converts the RunProbes result of target.
+func tcpResultFrom(target model.TCPTarget, result ProbeResult) TCPResult {
+	converted := TCPResult{
+		Target: target, Attempts: result.Attempts, Successful: result.Successful, Failed: result.Failed,
+		SuccessRatePercent: result.SuccessRatePercent, LossPercent: result.LossPercent,
+		Min: result.Min, Max: result.Max, Mean: result.Mean, P50: result.P50, P95: result.P95,
+		Samples: make([]TCPSample, len(result.Samples)), ErrorCounts: result.ErrorCounts,
+	}
+	for index, sample := range result.Samples {
+		converted.Samples[index] = TCPSample(sample)
+	}
+	return converted
+}
+
+// validateTCPTarget rejects targets no dial could reach.
+func validateTCPTarget(target model.TCPTarget) error {
 	if strings.TrimSpace(target.Host) == "" {
-		return TCPResult{}, errors.New("tcp target host is empty")
+		return errors.New("tcp target host is empty")
 	}
 	if target.Port < 1 || target.Port > 65535 {
-		return TCPResult{}, fmt.Errorf("tcp target port %d is invalid", target.Port)
+		return fmt.Errorf("tcp target port %d is invalid", target.Port)
 	}
-	config = config.withDefaults()
-	result := TCPResult{
-		Target:      target,
-		Attempts:    config.Attempts,
-		Samples:     make([]TCPSample, 0, config.Attempts),
-		ErrorCounts: make(map[string]int),
-	}
-	address := net.JoinHostPort(target.Host, fmt.Sprintf("%d", target.Port))
-	for attempt := 1; attempt <= config.Attempts; attempt++ {
-		if err := ctx.Err(); err != nil {
-			result.recordFailure(attempt, classifyTCPError(err))
-			for remaining := attempt + 1; remaining <= config.Attempts; remaining++ {
-				result.recordFailure(remaining, TCPErrorCanceled)
-			}
-			break
-		}
-		attemptCtx, cancel := context.WithTimeout(ctx, config.Timeout)
-		started := config.Now()
-		conn, err := config.DialContext(attemptCtx, "tcp", address)
-		elapsed := config.Now().Sub(started)
-		cancel()
-		if err != nil {
-			result.recordFailure(attempt, classifyTCPError(err))
-			continue
-		}
-		if conn != nil {
-			_ = conn.Close()
-		}
-		if elapsed < 0 {
-			elapsed = 0
-		}
-		result.recordSuccess(attempt, elapsed)
-	}
-	result.finish()
-	return result, nil
+	return nil
+}
+
+// invalidTCPResult is the result RunTCPProbes reports for a target that
+// failed validation.
+func invalidTCPResult(target model.TCPTarget, config TCPProbeConfig, index int, reporter *progressReporter) TCPResult {
+	result := TCPResult{Target: target, Attempts: config.Attempts, ErrorCounts: map[string]int{TCPErrorUnknown: 1}}
+	address := net.JoinHostPort(target.Host, strconv.Itoa(target.Port))
+	reporter.start(index, target.Name, address)
+	reporter.finish(index, target.Name, address, result)
+	return result
 }
 
 // RunTCPProbes runs targets in bounded parallelism while preserving target
 // order in the returned slice. A failed individual target is represented in
 // its TCPResult; only invalid target errors are returned by RunTCPProbe and
-// therefore do not abort the complete batch.
+// therefore do not abort the complete batch. Each target runs the RunProbes
+// loop with a TCPProber, and targets left when ctx ends are recorded as
+// canceled. With Interleave set, attempts
+// rather than targets are the unit of work; see runInterleavedTCPProbes.
 func RunTCPProbes(ctx context.Context, targets []model.TCPTarget, config TCPProbeConfig) []TCPResult {
 	if ctx == nil {
 		ctx = context.Background()
 	}
 	config = config.withDefaults()
+	reporter := newProgressReporter(config.OnProgress, len(targets), config.Now)
+	defer reporter.done()
+	if config.Interleave {
+		return runInterleavedTCPProbes(ctx, targets, config, reporter)
+	}
 	results := make([]TCPResult, len(targets))
-	jobs := make(chan int)
-	workers := config.Concurrency
-	if workers > len(targets) {
-		workers = len(targets)
-	}
-	if workers == 0 {
-		return results
-	}
-	var workerWG sync.WaitGroup
-	workerWG.Add(workers)
-	for worker := 0; worker < workers; worker++ {
-		go func() {
-			defer workerWG.Done()
-			for index := range jobs {
-				result, err := RunTCPProbe(ctx, targets[index], config)
-				if err != nil {
-					result = TCPResult{Target: targets[index], Attempts: config.Attempts, ErrorCounts: map[string]int{TCPErrorUnknown: 1}}
-				}
-				results[index] = result
-			}
-		}()
-	}
-	for index := range targets {
-		select {
-		case jobs <- index:
-		case <-ctx.Done():
-			close(jobs)
-			workerWG.Wait()
-			return results
+	var completed sync.Mutex
+	dispatched := runBounded(ctx, len(targets), config.Concurrency, func(index int) {
+		target := targets[index]
+		if err := validateTCPTarget(target); err != nil {
+			results[index] = invalidTCPResult(target, config, index, reporter)
+		} else {
+			results[index] = probeTCPTarget(ctx, target, config, index, reporter)
+		}
+		if config.OnResult != nil {
+			completed.Lock()
+			config.OnResult(results[index])
+			completed.Unlock()
 		}
+	})
+	for index := dispatched; index < len(targets); index++ {
+		target := targets[index]
+		probe := ProbeTarget{Name: target.Name, Host: target.Host, Port: target.Port}
+		results[index] = tcpResultFrom(target, canceledProbeResult(ctx, model.ProtocolTCP, probe, config.Attempts))
 	}
-	close(jobs)
-	workerWG.Wait()
 	return results
 }
 
@@ -201,16 +259,28 @@ func RunTCPRegistry(ctx context.Context, config TCPProbeConfig) []TCPResult {
 }
 
 // RunLoadedTCPRegistry resolves the repository-owned remote registry with an
-// embedded fallback before probing it. The load result lets API callers report
-// the actual data source without parsing target metadata.
-func RunLoadedTCPRegistry(ctx context.Context, config TCPProbeConfig) ([]TCPResult, model.TCPTargetRegistryLoadResult, error) {
-	loaded, err := model.LoadMergedTCPTargets(ctx, nil, model.DefaultTCPTargetRegistrySources(), 10)
+// embedded fallback, through the client's cache, before probing it; a config
+// without a RateLimiter uses the client's. The load result lets API callers
+// report the actual data source without parsing target metadata.
+func (client *Client) RunLoadedTCPRegistry(ctx context.Context, config TCPProbeConfig) ([]TCPResult, model.TCPTargetRegistryLoadResult, error) {
+	loaded, err := model.LoadMergedTCPTargets(ctx, registryHTTPClient(client.Cache), model.DefaultTCPTargetRegistrySources(), 10)
 	if err != nil {
 		return nil, model.TCPTargetRegistryLoadResult{}, err
 	}
+	if config.RateLimiter == nil {
+		config.RateLimiter = client.RateLimiter
+	}
+	if config.OnRegistry != nil {
+		config.OnRegistry(RegistryInfo{Name: RegistryTCP, Source: loaded.Source, Fallback: loaded.Fallback, Metadata: loaded.Metadata})
+	}
 	return RunTCPProbes(ctx, loaded.Targets, config), loaded, nil
 }
 
+// RunLoadedTCPRegistry runs Client.RunLoadedTCPRegistry on the default client.
+func RunLoadedTCPRegistry(ctx context.Context, config TCPProbeConfig) ([]TCPResult, model.TCPTargetRegistryLoadResult, error) {
+	return defaultClient.RunLoadedTCPRegistry(ctx, config)
+}
+
 type TCPTextFormat string
 
 const (
@@ -0,0 +1,111 @@
+package pt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+// Port states reported by the multi-port TCP check, in tcping terms: open
+// accepted at least one handshake, refused answered with RST, filtered never
+// answered before the timeout.
+const (
+	TCPPortOpen     = "open"
+	TCPPortRefused  = "refused"
+	TCPPortFiltered = "filtered"
+	TCPPortDNS      = "dns"
+	TCPPortError    = "error"
+)
+
+// TCPPortState classifies one endpoint result. A single successful handshake
+// makes a port open; otherwise the most decisive failure wins, refused before
+// timeout, because one RST proves the host is reachable.
+func TCPPortState(result TCPResult) string {
+	if result.Successful > 0 {
+		return TCPPortOpen
+	}
+	classes := classifyTCPResult(result)
+	switch {
+	case classes.Refused > 0:
+		return TCPPortRefused
+	case classes.Timeout > 0:
+		return TCPPortFiltered
+	case classes.DNS > 0:
+		return TCPPortDNS
+	default:
+		return TCPPortError
+	}
+}
+
+// FormatTCPPortResults renders a tcping-style port table: one row per host and
+// port in probe order, with the service label, state and handshake latency.
+func FormatTCPPortResults(results []TCPResult, language string) string {
+	english := strings.EqualFold(strings.TrimSpace(language), "en")
+	labels := tcpLabelsForLanguage(language)
+	if len(results) == 0 {
+		return labels.noTargets
+	}
+	headings := []string{"主机", "端口", "服务", "状态", labels.successAttempts, "Min", "Avg", "Max"}
+	states := map[string]string{TCPPortOpen: "开放", TCPPortRefused: "拒绝", TCPPortFiltered: "过滤", TCPPortDNS: "DNS失败", TCPPortError: "错误"}
+	if english {
+		headings[0], headings[1], headings[2], headings[3] = "Host", "Port", "Service", "State"
+		states = map[string]string{TCPPortOpen: "open", TCPPortRefused: "refused", TCPPortFiltered: "filtered", TCPPortDNS: "dns", TCPPortError: "error"}
+	}
+	counts := make(map[string]int, len(states))
+	widths := make([]int, len(headings))
+	for index, heading := range headings {
+		widths[index] = runewidth.StringWidth(heading)
+		if index >= 5 {
+			widths[index] = max(widths[index], 3)
+		}
+	}
+	rows := make([][]string, 0, len(results))
+	for _, result := range results {
+		state := TCPPortState(result)
+		counts[state]++
+		service := result.Target.Category
+		if service == "" {
+			service = "-"
+		}
+		cells := []string{
+			result.Target.Host,
+			strconv.Itoa(result.Target.Port),
+			service,
+			states[state],
+			fmt.Sprintf("%d/%d", result.Successful, result.Attempts),
+			formatTCPMilliseconds(result.Min),
+			formatTCPMilliseconds(result.Mean),
+			formatTCPMilliseconds(result.Max),
+		}
+		for index, cell := range cells {
+			widths[index] = max(widths[index], runewidth.StringWidth(cell))
+		}
+		rows = append(rows, cells)
+	}
+	var output strings.Builder
+	fmt.Fprintf(&output, "%s %s:%d", labels.summary, labels.targets, len(results))
+	for _, state := range []string{TCPPortOpen, TCPPortRefused, TCPPortFiltered, TCPPortDNS, TCPPortError} {
+		if counts[state] > 0 || state == TCPPortOpen {
+			fmt.Fprintf(&output, "  %s:%d", states[state], counts[state])
+		}
+	}
+	output.WriteByte('\n')
+	writeTCPTableRow(&output, headings, widths)
+	for _, row := range rows {
+		writeTCPTableRow(&output, row, widths)
+	}
+	return trimTCPOutput(output.String())
+}
+
+// TCPPortTargetsForHosts expands hosts and a -ports specification into TCP
+// targets labelled with well-known service names.
+func TCPPortTargetsForHosts(hosts []string, spec string) ([]model.TCPTarget, error) {
+	ports, err := model.ParsePortList(spec)
+	if err != nil {
+		return nil, err
+	}
+	return model.ExpandTCPPortTargets(hosts, ports)
+}
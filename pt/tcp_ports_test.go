@@ -0,0 +1,40 @@
+package pt
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+func TestTCPPortStatePrefersDecisiveOutcomes(t *testing.T) {
+	for want, result := range map[string]TCPResult{
+		TCPPortOpen:     {Successful: 1, ErrorCounts: map[string]int{TCPErrorTimeout: 2}},
+		TCPPortRefused:  {ErrorCounts: map[string]int{TCPErrorTimeout: 2, TCPErrorRefused: 1}},
+		TCPPortFiltered: {ErrorCounts: map[string]int{TCPErrorTimeout: 3}},
+		TCPPortDNS:      {ErrorCounts: map[string]int{TCPErrorDNS: 3}},
+		TCPPortError:    {ErrorCounts: map[string]int{TCPErrorNetwork: 3}},
+	} {
+		if got := TCPPortState(result); got != want {
+			t.Fatalf("TCPPortState(%+v) = %q, want %q", result, got, want)
+		}
+	}
+}
+
+func TestFormatTCPPortResultsSummarizesStates(t *testing.T) {
+	results := []TCPResult{
+		{Target: model.TCPTarget{Host: "example.test", Port: 22, Category: "ssh"}, Attempts: 2, Successful: 2, Min: time.Millisecond, Mean: 2 * time.Millisecond, Max: 3 * time.Millisecond},
+		{Target: model.TCPTarget{Host: "example.test", Port: 23, Category: "telnet"}, Attempts: 2, ErrorCounts: map[string]int{TCPErrorRefused: 2}},
+		{Target: model.TCPTarget{Host: "example.test", Port: 8081}, Attempts: 2, ErrorCounts: map[string]int{TCPErrorTimeout: 2}},
+	}
+	output := FormatTCPPortResults(results, "en")
+	for _, want := range []string{"Summary Targets:3  open:1  refused:1  filtered:1", "Service", "ssh", "2/2", "telnet", "refused", "8081", "filtered", "2.0"} {
+		if !strings.Contains(output, want) {
+			t.Fatalf("port output missing %q:\n%s", want, output)
+		}
+	}
+	if !strings.Contains(FormatTCPPortResults(results, "zh"), "开放:1") {
+		t.Fatalf("Chinese port output is missing state labels")
+	}
+}
@@ -0,0 +1,130 @@
+package pt
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+// interleavedTarget is the state of one target in an interleaved run. Its
+// slot holds the earliest start of the next attempt and is empty while an
+// attempt is in flight, so attempts on one target never overlap and stay
+// Interval apart even when another worker picks up the next one.
+type interleavedTarget struct {
+	target   ProbeTarget
+	slot     chan time.Time
+	result   TCPResult
+	started  bool
+	recorded int
+}
+
+// runInterleavedTCPProbes schedules attempts instead of targets: round r
+// holds attempt r of every valid target in a random order, and the workers
+// take attempts round by round. A target finishes, and reaches OnResult,
+// once all its attempts are recorded. Attempts not dispatched before ctx
+// ends are recorded with the context's error class.
+func runInterleavedTCPProbes(ctx context.Context, targets []model.TCPTarget, config TCPProbeConfig, reporter *progressReporter) []TCPResult {
+	results := make([]TCPResult, len(targets))
+	states := make([]*interleavedTarget, len(targets))
+	valid := make([]int, 0, len(targets))
+	var completed sync.Mutex
+	deliver := func(index int, result TCPResult) {
+		results[index] = result
+		if config.OnResult != nil {
+			completed.Lock()
+			config.OnResult(result)
+			completed.Unlock()
+		}
+	}
+	for index, target := range targets {
+		if err := validateTCPTarget(target); err != nil {
+			deliver(index, invalidTCPResult(target, config, index, reporter))
+			continue
+		}
+		state := &interleavedTarget{
+			target: ProbeTarget{Name: target.Name, Host: target.Host, Port: target.Port},
+			slot:   make(chan time.Time, 1),
+			result: TCPResult{
+				Target: target, Attempts: config.Attempts,
+				Samples: make([]TCPSample, 0, config.Attempts), ErrorCounts: make(map[string]int),
+			},
+		}
+		state.slot <- time.Time{}
+		states[index] = state
+		valid = append(valid, index)
+	}
+	workers := min(config.Concurrency, len(valid))
+	if workers == 0 {
+		return results
+	}
+	// record stores one attempt; the caller owns the target's slot.
+	record := func(index, attempt int, elapsed time.Duration, errorClass string) {
+		state := states[index]
+		name := state.result.Target.Name
+		if !state.started {
+			state.started = true
+			reporter.start(index, name, state.target.Address())
+		}
+		if errorClass != "" {
+			state.result.recordFailure(attempt, errorClass)
+		} else {
+			state.result.recordSuccess(attempt, elapsed)
+		}
+		sample := state.result.Samples[len(state.result.Samples)-1]
+		reporter.sample(index, name, state.target.Address(), ProgressSample{Attempt: attempt, Duration: sample.Duration, Success: sample.Success, Error: sample.ErrorClass})
+		if state.recorded++; state.recorded == config.Attempts {
+			sort.SliceStable(state.result.Samples, func(i, j int) bool { return state.result.Samples[i].Attempt < state.result.Samples[j].Attempt })
+			state.result.finish()
+			reporter.finish(index, name, state.target.Address(), state.result)
+			deliver(index, state.result)
+		}
+	}
+	type attemptJob struct{ index, attempt int }
+	jobs := make(chan attemptJob)
+	var workerWG sync.WaitGroup
+	workerWG.Add(workers)
+	for range workers {
+		go func() {
+			defer workerWG.Done()
+			for job := range jobs {
+				state := states[job.index]
+				notBefore := <-state.slot
+				sleepContext(ctx, notBefore.Sub(config.Now()))
+				sample := probeAttempt(ctx, config.prober(), state.target, config.Timeout, config.RateLimiter)
+				record(job.index, job.attempt, sample.Duration, sample.ErrorClass)
+				state.slot <- config.Now().Add(config.attemptDelay())
+			}
+		}()
+	}
+	dispatched := make([]int, len(targets))
+	order := make([]int, len(valid))
+dispatch:
+	for attempt := 1; attempt <= config.Attempts; attempt++ {
+		copy(order, valid)
+		for i := len(order) - 1; i > 0; i-- {
+			j := min(int(config.Random()*float64(i+1)), i)
+			order[i], order[j] = order[j], order[i]
+		}
+		for _, index := range order {
+			select {
+			case jobs <- attemptJob{index: index, attempt: attempt}:
+				dispatched[index] = attempt
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+	}
+	close(jobs)
+	workerWG.Wait()
+	if err := ctx.Err(); err != nil {
+		for _, index := range valid {
+			for attempt := dispatched[index] + 1; attempt <= config.Attempts; attempt++ {
+				record(index, attempt, 0, classifyTCPError(err))
+			}
+		}
+	}
+	return results
+}
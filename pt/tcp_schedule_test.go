@@ -0,0 +1,123 @@
+package pt
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+func TestRunTCPProbesInterleavesAttemptsInRandomOrder(t *testing.T) {
+	targets := []model.TCPTarget{
+		{Name: "a", Host: "a.test", Port: 443},
+		{Name: "b", Host: "b.test", Port: 443},
+		{Name: "c", Host: "c.test", Port: 443},
+		{Name: "bad", Host: "", Port: 443},
+	}
+	var mu sync.Mutex
+	var dialed []string
+	var streamed []string
+	results := RunTCPProbes(context.Background(), targets, TCPProbeConfig{
+		Attempts: 2, Concurrency: 1, Interleave: true,
+		// Always picking the first candidate turns a, b, c into b, c, a.
+		Random: func() float64 { return 0 },
+		DialContext: func(_ context.Context, _, address string) (net.Conn, error) {
+			mu.Lock()
+			dialed = append(dialed, strings.TrimSuffix(address, ".test:443"))
+			mu.Unlock()
+			client, server := net.Pipe()
+			_ = server.Close()
+			return client, nil
+		},
+		OnResult: func(result TCPResult) { streamed = append(streamed, result.Target.Name) },
+	})
+	if got := strings.Join(dialed, ","); got != "b,c,a,b,c,a" {
+		t.Fatalf("dial order = %s, want rounds of b,c,a", got)
+	}
+	if len(streamed) != 4 || streamed[0] != "bad" {
+		t.Fatalf("streamed %v", streamed)
+	}
+	for index, result := range results[:3] {
+		if result.Target != targets[index] || result.Successful != 2 || result.Samples[0].Attempt != 1 || result.Samples[1].Attempt != 2 {
+			t.Fatalf("result %d: %+v", index, result)
+		}
+	}
+	if results[3].ErrorCounts[TCPErrorUnknown] != 1 {
+		t.Fatalf("invalid target result: %+v", results[3])
+	}
+}
+
+func TestRunTCPProbesInterleavedRecordsCanceledAttempts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := RunTCPProbes(ctx, []model.TCPTarget{{Name: "a", Host: "a.test", Port: 443}}, TCPProbeConfig{
+		Attempts: 3, Interleave: true,
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			t.Error("dialed after cancellation")
+			return nil, context.Canceled
+		},
+	})
+	if len(results) != 1 || len(results[0].Samples) != 3 || results[0].ErrorCounts[TCPErrorCanceled] != 3 || results[0].LossPercent != 100 {
+		t.Fatalf("unexpected canceled result: %+v", results)
+	}
+}
+
+func TestRunTCPProbeWaitsIntervalPlusJitterBetweenAttempts(t *testing.T) {
+	config := TCPProbeConfig{Interval: 20 * time.Millisecond, Jitter: 10 * time.Millisecond, Random: func() float64 { return .5 }}
+	if delay := config.attemptDelay(); delay != 25*time.Millisecond {
+		t.Fatalf("attemptDelay = %v, want 25ms", delay)
+	}
+	var dials []time.Time
+	config.Attempts = 3
+	config.DialContext = func(context.Context, string, string) (net.Conn, error) {
+		dials = append(dials, time.Now())
+		client, server := net.Pipe()
+		_ = server.Close()
+		return client, nil
+	}
+	if _, err := RunTCPProbe(context.Background(), model.TCPTarget{Name: "a", Host: "a.test", Port: 443}, config); err != nil {
+		t.Fatal(err)
+	}
+	for index := 1; index < len(dials); index++ {
+		if gap := dials[index].Sub(dials[index-1]); gap < 25*time.Millisecond {
+			t.Fatalf("attempts %d and %d were %v apart, want at least 25ms", index, index+1, gap)
+		}
+	}
+}
+
+func TestRunTCPProbesInterleavedSpacingUsesInjectedClock(t *testing.T) {
+	// Every reading of the clock moves it an hour ahead, so an hour-long
+	// interval has always elapsed by the next attempt. Spacing measured
+	// with the wall clock would block the test.
+	var mu sync.Mutex
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(time.Hour)
+		return now
+	}
+	done := make(chan []TCPResult, 1)
+	go func() {
+		done <- RunTCPProbes(context.Background(), []model.TCPTarget{{Name: "a", Host: "a.test", Port: 443}}, TCPProbeConfig{
+			Attempts: 3, Interleave: true, Interval: time.Hour, Now: clock,
+			DialContext: func(context.Context, string, string) (net.Conn, error) {
+				client, server := net.Pipe()
+				_ = server.Close()
+				return client, nil
+			},
+		})
+	}()
+	select {
+	case results := <-done:
+		if results[0].Successful != 3 {
+			t.Fatalf("result = %+v", results[0])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("interleaved spacing waited on the wall clock")
+	}
+}
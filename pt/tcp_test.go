@@ -134,6 +134,28 @@ func TestRunTCPProbeHonorsCanceledContext(t *testing.T) {
 	}
 }
 
+func TestRunTCPProbesRecordsTargetsLeftByCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	targets := []model.TCPTarget{
+		{Name: "one", Host: "one.test", Port: 443},
+		{Name: "two", Host: "two.test", Port: 443},
+		{Name: "three", Host: "three.test", Port: 443},
+	}
+	results := RunTCPProbes(ctx, targets, TCPProbeConfig{
+		Attempts: 2, Concurrency: 1,
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			cancel()
+			return nil, context.Canceled
+		},
+	})
+	for index, result := range results {
+		if result.Target != targets[index] || result.Attempts != 2 || len(result.Samples) != 2 || result.ErrorCounts[TCPErrorCanceled] != 2 || result.LossPercent != 100 {
+			t.Fatalf("result %d = %+v", index, result)
+		}
+	}
+}
+
 func TestRunTCPProbesPreservesTargetOrder(t *testing.T) {
 	targets := []model.TCPTarget{
 		{Name: "one", Host: "one.test", Port: 443},
@@ -164,6 +186,27 @@ func TestRunTCPProbesPreservesTargetOrder(t *testing.T) {
 	}
 }
 
+func TestRunTCPProbesStreamsEachResult(t *testing.T) {
+	targets := []model.TCPTarget{
+		{Name: "one", Host: "one.test", Port: 443},
+		{Name: "two", Host: "two.test", Port: 443},
+	}
+	var streamed []string
+	results := RunTCPProbes(context.Background(), targets, TCPProbeConfig{
+		Attempts:    1,
+		Concurrency: 2,
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			client, server := net.Pipe()
+			_ = server.Close()
+			return client, nil
+		},
+		OnResult: func(result TCPResult) { streamed = append(streamed, result.Target.Name) },
+	})
+	if len(results) != 2 || len(streamed) != 2 || !slices.Contains(streamed, "one") || !slices.Contains(streamed, "two") {
+		t.Fatalf("streamed %v for results %+v", streamed, results)
+	}
+}
+
 func TestRunTCPRegistryUsesMergedProductionTargets(t *testing.T) {
 	targets := model.AllTCPTargets()
 	addresses := make(map[string]int, len(targets))
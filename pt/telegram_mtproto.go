@@ -0,0 +1,426 @@
+package pt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+const (
+	TelegramErrorProtocol = "protocol"
+	TelegramErrorClosed   = "closed"
+)
+
+const (
+	mtprotoIntermediateTag = 0xeeeeeeee
+	mtprotoReqPQMulti      = 0xbe7e8ef1
+	mtprotoResPQ           = 0x05162463
+	mtprotoMaxFrame        = 1 << 16
+)
+
+// DefaultTelegramMTProtoPorts lists the ports Telegram DCs accept MTProto on.
+var DefaultTelegramMTProtoPorts = []int{443, 80, 5222}
+
+// TelegramMTProtoConfig controls the MTProto reachability probe. Zero values
+// use the defaults.
+type TelegramMTProtoConfig struct {
+	Ports       []int
+	Timeout     time.Duration
+	Concurrency int
+	DialContext TCPDialFunc
+	Now         func() time.Time
+	// Nonce is injectable so tests can observe the req_pq_multi nonce.
+	Nonce func() ([16]byte, error)
+	// RateLimiter, when set, gives every port probe a token; share one
+	// limiter between runs to give them one budget. Nil is unlimited.
+	RateLimiter *RateLimiter
+	// OnResult, when set, receives each DC result as soon as all of its ports
+	// finished. Calls are serialized.
+	OnResult func(TelegramMTProtoResult)
+	// OnProgress, when set, receives a ProgressEvent when a DC's first port
+	// starts, after every port, when the DC finishes and when the run ends.
+	// Calls are serialized.
+	OnProgress func(ProgressEvent)
+}
+
+// TelegramMTProtoEndpoint records one DC address and port. Connect is the TCP
+// handshake time and Response the time from sending req_pq_multi to reading a
+// matching resPQ; both are zero when the stage did not complete.
+type TelegramMTProtoEndpoint struct {
+	Port       int           `json:"port"`
+	Connect    time.Duration `json:"connect"`
+	Response   time.Duration `json:"response"`
+	Usable     bool          `json:"usable"`
+	ErrorClass string        `json:"error_class,omitempty"`
+}
+
+// TelegramMTProtoResult is the structured result for one data center. A DC is
+// usable when at least one port answered req_pq_multi with a valid resPQ; the
+// top-level timings come from the fastest usable endpoint.
+type TelegramMTProtoResult struct {
+	DCID      int                       `json:"dc_id"`
+	Name      string                    `json:"name"`
+	Location  string                    `json:"location"`
+	Host      string                    `json:"host"`
+	Usable    bool                      `json:"usable"`
+	Connect   time.Duration             `json:"connect"`
+	Response  time.Duration             `json:"response"`
+	Endpoints []TelegramMTProtoEndpoint `json:"endpoints"`
+}
+
+// DefaultTelegramMTProtoConfig returns the standard MTProto probe settings.
+func DefaultTelegramMTProtoConfig() TelegramMTProtoConfig {
+	return TelegramMTProtoConfig{
+		Ports:       append([]int(nil), DefaultTelegramMTProtoPorts...),
+		Timeout:     5 * time.Second,
+		Concurrency: 8,
+		DialContext: (&net.Dialer{}).DialContext,
+		Now:         time.Now,
+		Nonce:       randomMTProtoNonce,
+	}
+}
+
+func (config TelegramMTProtoConfig) withDefaults() TelegramMTProtoConfig {
+	defaults := DefaultTelegramMTProtoConfig()
+	if len(config.Ports) == 0 {
+		config.Ports = defaults.Ports
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = defaults.Concurrency
+	}
+	if config.DialContext == nil {
+		config.DialContext = defaults.DialContext
+	}
+	if config.Now == nil {
+		config.Now = defaults.Now
+	}
+	if config.Nonce == nil {
+		config.Nonce = defaults.Nonce
+	}
+	return config
+}
+
+// RunTelegramMTProtoProbes opens TCP to every DC and port, sends an
+// unauthenticated req_pq_multi and waits for resPQ. Endpoints run in bounded
+// parallelism and results keep the order of dcs and config.Ports.
+func RunTelegramMTProtoProbes(ctx context.Context, dcs []model.TelegramDC, config TelegramMTProtoConfig) []TelegramMTProtoResult {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	config = config.withDefaults()
+	reporter := newProgressReporter(config.OnProgress, len(dcs), config.Now)
+	defer reporter.done()
+	results := make([]TelegramMTProtoResult, len(dcs))
+	type job struct{ dc, port int }
+	jobs := make(chan job)
+	for index, dc := range dcs {
+		results[index] = TelegramMTProtoResult{
+			DCID: dc.ID, Name: dc.Name, Location: dc.Location, Host: dc.IP,
+			Endpoints: make([]TelegramMTProtoEndpoint, len(config.Ports)),
+		}
+	}
+	remaining := make([]int, len(dcs))
+	for index := range remaining {
+		remaining[index] = len(config.Ports)
+	}
+	started := make([]bool, len(dcs))
+	var completed sync.Mutex
+	beginLocked := func(dc int) {
+		if !started[dc] {
+			started[dc] = true
+			reporter.start(dc, dcs[dc].Name, dcs[dc].IP)
+		}
+	}
+	begin := func(dc int) {
+		completed.Lock()
+		defer completed.Unlock()
+		beginLocked(dc)
+	}
+	complete := func(dc, port int) {
+		completed.Lock()
+		defer completed.Unlock()
+		beginLocked(dc)
+		endpoint := results[dc].Endpoints[port]
+		reporter.sample(dc, dcs[dc].Name, dcs[dc].IP, ProgressSample{
+			Attempt: port + 1, Port: endpoint.Port, Duration: endpoint.Response, Success: endpoint.Usable, Error: endpoint.ErrorClass,
+		})
+		if remaining[dc]--; remaining[dc] == 0 {
+			results[dc].finish()
+			if config.OnResult != nil {
+				config.OnResult(results[dc])
+			}
+			reporter.finish(dc, dcs[dc].Name, dcs[dc].IP, results[dc])
+		}
+	}
+	workers := min(config.Concurrency, len(dcs)*len(config.Ports))
+	var wait sync.WaitGroup
+	wait.Add(workers)
+	for range workers {
+		go func() {
+			defer wait.Done()
+			for current := range jobs {
+				begin(current.dc)
+				results[current.dc].Endpoints[current.port] = probeMTProtoEndpoint(ctx, dcs[current.dc].IP, config.Ports[current.port], config)
+				complete(current.dc, current.port)
+			}
+		}()
+	}
+	canceled := false
+	for dcIndex := range dcs {
+		for portIndex := range config.Ports {
+			if canceled {
+				results[dcIndex].Endpoints[portIndex] = TelegramMTProtoEndpoint{Port: config.Ports[portIndex], ErrorClass: classifyTCPError(ctx.Err())}
+				complete(dcIndex, portIndex)
+				continue
+			}
+			select {
+			case jobs <- job{dc: dcIndex, port: portIndex}:
+			case <-ctx.Done():
+				canceled = true
+				results[dcIndex].Endpoints[portIndex] = TelegramMTProtoEndpoint{Port: config.Ports[portIndex], ErrorClass: classifyTCPError(ctx.Err())}
+				complete(dcIndex, portIndex)
+			}
+		}
+	}
+	close(jobs)
+	wait.Wait()
+	return results
+}
+
+// RunTelegramDCMTProtoProbes probes the built-in Telegram DC registry.
+func RunTelegramDCMTProtoProbes(ctx context.Context, config TelegramMTProtoConfig) []TelegramMTProtoResult {
+	return RunTelegramMTProtoProbes(ctx, model.TelegramDataCenters, config)
+}
+
+func (result *TelegramMTProtoResult) finish() {
+	result.Usable, result.Connect, result.Response = false, 0, 0
+	for _, endpoint := range result.Endpoints {
+		if !endpoint.Usable {
+			continue
+		}
+		if !result.Usable || endpoint.Response < result.Response {
+			result.Connect, result.Response = endpoint.Connect, endpoint.Response
+		}
+		result.Usable = true
+	}
+}
+
+func probeMTProtoEndpoint(ctx context.Context, host string, port int, config TelegramMTProtoConfig) TelegramMTProtoEndpoint {
+	endpoint := TelegramMTProtoEndpoint{Port: port}
+	if err := ctx.Err(); err != nil {
+		endpoint.ErrorClass = classifyTCPError(err)
+		return endpoint
+	}
+	nonce, err := config.Nonce()
+	if err != nil {
+		endpoint.ErrorClass = TCPErrorUnknown
+		return endpoint
+	}
+	if err := config.RateLimiter.Wait(ctx); err != nil {
+		endpoint.ErrorClass = classifyTCPError(err)
+		return endpoint
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+	started := config.Now()
+	conn, err := config.DialContext(probeCtx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		endpoint.ErrorClass = classifyTCPError(err)
+		return endpoint
+	}
+	defer conn.Close()
+	endpoint.Connect = max(config.Now().Sub(started), 0)
+	if deadline, ok := probeCtx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(probeCtx, func() { _ = conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	sent := config.Now()
+	if _, err := conn.Write(mtprotoReqPQMultiFrame(nonce, mtprotoMessageID(sent))); err != nil {
+		endpoint.ErrorClass = classifyMTProtoError(probeCtx, err)
+		return endpoint
+	}
+	if err := readMTProtoResPQ(conn, nonce); err != nil {
+		endpoint.ErrorClass = classifyMTProtoError(probeCtx, err)
+		return endpoint
+	}
+	endpoint.Response = max(config.Now().Sub(sent), 0)
+	endpoint.Usable = true
+	return endpoint
+}
+
+// mtprotoReqPQMultiFrame encodes an unencrypted req_pq_multi message using the
+// intermediate transport, including its one-time connection tag.
+func mtprotoReqPQMultiFrame(nonce [16]byte, messageID int64) []byte {
+	payload := make([]byte, 0, 40)
+	payload = binary.LittleEndian.AppendUint64(payload, 0)
+	payload = binary.LittleEndian.AppendUint64(payload, uint64(messageID))
+	payload = binary.LittleEndian.AppendUint32(payload, 20)
+	payload = binary.LittleEndian.AppendUint32(payload, mtprotoReqPQMulti)
+	payload = append(payload, nonce[:]...)
+	frame := binary.LittleEndian.AppendUint32(make([]byte, 0, 8+len(payload)), mtprotoIntermediateTag)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+// mtprotoMessageID approximates unixtime*2^32 and keeps the value divisible by
+// four as required for client messages.
+func mtprotoMessageID(now time.Time) int64 {
+	fraction := (uint64(now.Nanosecond()) << 32) / uint64(time.Second)
+	return int64((uint64(now.Unix())<<32 | fraction) &^ 3)
+}
+
+var errMTProtoResponse = errors.New("unexpected MTProto response")
+
+func readMTProtoResPQ(reader io.Reader, nonce [16]byte) error {
+	var header [4]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return err
+	}
+	length := binary.LittleEndian.Uint32(header[:])
+	if length < 40 || length > mtprotoMaxFrame {
+		// Transport errors are a bare negative int32 such as -404.
+		return errMTProtoResponse
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint64(payload[0:8]) != 0 {
+		return errMTProtoResponse
+	}
+	if bodyLength := binary.LittleEndian.Uint32(payload[16:20]); bodyLength < 20 || int(bodyLength) > len(payload)-20 {
+		return errMTProtoResponse
+	}
+	if binary.LittleEndian.Uint32(payload[20:24]) != mtprotoResPQ || string(payload[24:40]) != string(nonce[:]) {
+		return errMTProtoResponse
+	}
+	return nil
+}
+
+func classifyMTProtoError(ctx context.Context, err error) string {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return classifyTCPError(ctxErr)
+	}
+	if errors.Is(err, errMTProtoResponse) {
+		return TelegramErrorProtocol
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return TelegramErrorClosed
+	}
+	return classifyTCPError(err)
+}
+
+func randomMTProtoNonce() ([16]byte, error) {
+	var nonce [16]byte
+	_, err := rand.Read(nonce[:])
+	return nonce, err
+}
+
+// TelegramMTProtoTest probes the selected Telegram DCs over MTProto and
+// renders one row per DC.
+func (client *Client) TelegramMTProtoTest(ctx context.Context, options TelegramDCOptions) string {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	dcs := client.telegramDataCentersForOptions(ctx, options)
+	return FormatTelegramMTProtoResults(RunTelegramMTProtoProbes(ctx, dcs, TelegramMTProtoConfig{RateLimiter: client.RateLimiter}), options.Language)
+}
+
+// TelegramMTProtoTest runs Client.TelegramMTProtoTest on the default client.
+func TelegramMTProtoTest(ctx context.Context, options TelegramDCOptions) string {
+	return defaultClient.TelegramMTProtoTest(ctx, options)
+}
+
+// FormatTelegramMTProtoResults renders one row per DC with the TCP connect and
+// MTProto response time of every probed port.
+func FormatTelegramMTProtoResults(results []TelegramMTProtoResult, language string) string {
+	english := strings.EqualFold(strings.TrimSpace(language), "en")
+	if len(results) == 0 {
+		if english {
+			return "No Telegram DCs available"
+		}
+		return "暂无可用的 Telegram 数据中心"
+	}
+	headings := []string{"DC", "位置", "状态", "TCP", "MTProto"}
+	usable, unusable := "可用", "不可用"
+	if english {
+		headings = []string{"DC", "Location", "Status", "TCP", "MTProto"}
+		usable, unusable = "usable", "unusable"
+	}
+	ports := make([]int, 0)
+	seenPorts := make(map[int]struct{})
+	for _, result := range results {
+		for _, endpoint := range result.Endpoints {
+			if _, exists := seenPorts[endpoint.Port]; !exists {
+				seenPorts[endpoint.Port] = struct{}{}
+				ports = append(ports, endpoint.Port)
+			}
+		}
+	}
+	for _, port := range ports {
+		headings = append(headings, strconv.Itoa(port))
+	}
+	rows := make([][]string, 0, len(results))
+	widths := make([]int, len(headings))
+	for index, heading := range headings {
+		widths[index] = runewidth.StringWidth(heading)
+	}
+	usableCount := 0
+	for _, result := range results {
+		status := unusable
+		if result.Usable {
+			status = usable
+			usableCount++
+		}
+		cells := []string{result.Name, result.Location, status, formatTCPMilliseconds(result.Connect), formatTCPMilliseconds(result.Response)}
+		for _, port := range ports {
+			cells = append(cells, formatMTProtoEndpoint(result.Endpoints, port))
+		}
+		for index, cell := range cells {
+			widths[index] = max(widths[index], runewidth.StringWidth(cell))
+		}
+		rows = append(rows, cells)
+	}
+	var output strings.Builder
+	if english {
+		fmt.Fprintf(&output, "Summary DCs:%d  Usable:%d\n", len(results), usableCount)
+	} else {
+		fmt.Fprintf(&output, "汇总 数据中心:%d  可用:%d\n", len(results), usableCount)
+	}
+	writeTCPTableRow(&output, headings, widths)
+	for _, row := range rows {
+		writeTCPTableRow(&output, row, widths)
+	}
+	return trimTCPOutput(output.String())
+}
+
+func formatMTProtoEndpoint(endpoints []TelegramMTProtoEndpoint, port int) string {
+	for _, endpoint := range endpoints {
+		if endpoint.Port != port {
+			continue
+		}
+		if endpoint.Usable {
+			return formatTCPMilliseconds(endpoint.Connect) + "/" + formatTCPMilliseconds(endpoint.Response)
+		}
+		if endpoint.ErrorClass != "" {
+			return endpoint.ErrorClass
+		}
+	}
+	return "-"
+}
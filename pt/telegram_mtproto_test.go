@@ -0,0 +1,165 @@
+package pt
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/oneclickvirt/pingtest/model"
+)
+
+func TestRunTelegramMTProtoProbesAgainstFakeDC(t *testing.T) {
+	address := startFakeTelegramDC(t, fakeTelegramResPQ)
+	var dialed []string
+	results := RunTelegramMTProtoProbes(context.Background(), []model.TelegramDC{{ID: 2, Name: "TG-DC2", Location: "AMS NL", IP: "149.154.167.50"}}, TelegramMTProtoConfig{
+		Ports: []int{443, 5222}, Timeout: time.Second, Concurrency: 1,
+		DialContext: func(ctx context.Context, network, target string) (net.Conn, error) {
+			dialed = append(dialed, target)
+			if strings.HasSuffix(target, ":5222") {
+				return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+			}
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		},
+	})
+	if len(results) != 1 || len(results[0].Endpoints) != 2 {
+		t.Fatalf("unexpected MTProto results: %+v", results)
+	}
+	result := results[0]
+	if !result.Usable || result.DCID != 2 || result.Host != "149.154.167.50" || result.Response <= 0 {
+		t.Fatalf("fake DC was not usable: %+v", result)
+	}
+	if !result.Endpoints[0].Usable || result.Endpoints[0].Port != 443 {
+		t.Fatalf("port 443 endpoint: %+v", result.Endpoints[0])
+	}
+	if result.Endpoints[1].Usable || result.Endpoints[1].ErrorClass != TCPErrorRefused {
+		t.Fatalf("port 5222 endpoint: %+v", result.Endpoints[1])
+	}
+	if strings.Join(dialed, ",") != "149.154.167.50:443,149.154.167.50:5222" {
+		t.Fatalf("unexpected dial order: %v", dialed)
+	}
+}
+
+func TestRunTelegramMTProtoProbesRejectsTransportErrorsAndWrongNonce(t *testing.T) {
+	for name, respond := range map[string]func([16]byte) []byte{
+		"transport error": func([16]byte) []byte {
+			return binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, 4), uint32(0xfffffe6c))
+		},
+		"wrong nonce": func([16]byte) []byte { return fakeTelegramResPQ([16]byte{9}) },
+	} {
+		address := startFakeTelegramDC(t, respond)
+		results := RunTelegramMTProtoProbes(context.Background(), []model.TelegramDC{{ID: 1, IP: "192.0.2.1"}}, TelegramMTProtoConfig{
+			Ports: []int{443}, Timeout: time.Second,
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, address)
+			},
+		})
+		endpoint := results[0].Endpoints[0]
+		if results[0].Usable || endpoint.Connect <= 0 || endpoint.ErrorClass != TelegramErrorProtocol {
+			t.Fatalf("%s: unexpected result %+v", name, results[0])
+		}
+	}
+}
+
+func TestRunTelegramMTProtoProbesStreamsFinishedDCs(t *testing.T) {
+	dcs := []model.TelegramDC{{ID: 1, Name: "TG-DC1", IP: "192.0.2.1"}, {ID: 2, Name: "TG-DC2", IP: "192.0.2.2"}}
+	var streamed []TelegramMTProtoResult
+	RunTelegramMTProtoProbes(context.Background(), dcs, TelegramMTProtoConfig{
+		Ports: []int{443, 80}, Timeout: time.Second, Concurrency: 3,
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+		},
+		OnResult: func(result TelegramMTProtoResult) { streamed = append(streamed, result) },
+	})
+	if len(streamed) != 2 {
+		t.Fatalf("streamed %d DCs, want 2", len(streamed))
+	}
+	for _, result := range streamed {
+		if result.Usable || len(result.Endpoints) != 2 || result.Endpoints[0].ErrorClass != TCPErrorRefused || result.Endpoints[1].ErrorClass != TCPErrorRefused {
+			t.Fatalf("DC streamed before all ports finished: %+v", result)
+		}
+	}
+}
+
+func TestMTProtoReqPQMultiFrameLayout(t *testing.T) {
+	nonce := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	messageID := mtprotoMessageID(time.Unix(1700000000, 500000000))
+	if messageID%4 != 0 || messageID>>32 != 1700000000 {
+		t.Fatalf("invalid message id %d", messageID)
+	}
+	frame := mtprotoReqPQMultiFrame(nonce, messageID)
+	if len(frame) != 48 || binary.LittleEndian.Uint32(frame[0:4]) != mtprotoIntermediateTag || binary.LittleEndian.Uint32(frame[4:8]) != 40 {
+		t.Fatalf("unexpected frame header: %x", frame)
+	}
+	if binary.LittleEndian.Uint32(frame[28:32]) != mtprotoReqPQMulti || string(frame[32:48]) != string(nonce[:]) {
+		t.Fatalf("unexpected req_pq_multi body: %x", frame)
+	}
+}
+
+func TestFormatTelegramMTProtoResultsShowsEveryPort(t *testing.T) {
+	output := FormatTelegramMTProtoResults([]TelegramMTProtoResult{{
+		Name: "TG-DC1", Location: "MIA USA", Usable: true, Connect: 2 * time.Millisecond, Response: 3 * time.Millisecond,
+		Endpoints: []TelegramMTProtoEndpoint{
+			{Port: 443, Usable: true, Connect: 2 * time.Millisecond, Response: 3 * time.Millisecond},
+			{Port: 80, ErrorClass: TCPErrorTimeout},
+		},
+	}}, "en")
+	for _, want := range []string{"Summary DCs:1  Usable:1", "Location", "443", "80", "TG-DC1", "2.0/3.0", "timeout"} {
+		if !strings.Contains(output, want) {
+			t.Fatalf("MTProto output missing %q:\n%s", want, output)
+		}
+	}
+}
+
+// startFakeTelegramDC serves a minimal MTProto endpoint that validates the
+// intermediate transport frame and replies with respond(nonce).
+func startFakeTelegramDC(t *testing.T, respond func([16]byte) []byte) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				header := make([]byte, 8)
+				if _, err := io.ReadFull(conn, header); err != nil || binary.LittleEndian.Uint32(header[0:4]) != mtprotoIntermediateTag {
+					return
+				}
+				payload := make([]byte, binary.LittleEndian.Uint32(header[4:8]))
+				if _, err := io.ReadFull(conn, payload); err != nil || len(payload) != 40 || binary.LittleEndian.Uint32(payload[20:24]) != mtprotoReqPQMulti {
+					return
+				}
+				var nonce [16]byte
+				copy(nonce[:], payload[24:40])
+				_, _ = conn.Write(respond(nonce))
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func fakeTelegramResPQ(nonce [16]byte) []byte {
+	body := binary.LittleEndian.AppendUint32(nil, mtprotoResPQ)
+	body = append(body, nonce[:]...)
+	body = append(body, make([]byte, 16)...)
+	body = append(body, 8, 0x17, 0xed, 0x48, 0x94, 0x1a, 0x08, 0xf9, 0x81, 0, 0, 0)
+	body = binary.LittleEndian.AppendUint32(body, 0x1cb5c415)
+	body = binary.LittleEndian.AppendUint32(body, 1)
+	body = binary.LittleEndian.AppendUint64(body, 0xc3b42b026ce86b21)
+	payload := binary.LittleEndian.AppendUint64(nil, 0)
+	payload = binary.LittleEndian.AppendUint64(payload, uint64(time.Now().Unix())<<32|1)
+	payload = binary.LittleEndian.AppendUint32(payload, uint32(len(body)))
+	payload = append(payload, body...)
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(payload))), payload...)
+}
@@ -1,126 +1,40 @@
 package pt
 
 import (
+	"context"
 	"fmt"
 	"os/exec"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/mattn/go-runewidth"
-	. "github.com/oneclickvirt/defaultset"
 	"github.com/oneclickvirt/pingtest/model"
-	probing "github.com/prometheus-community/pro-bing"
 )
 
 // pingTelegramDCByGolang 使用golang的ping库测试Telegram DC (重复3次取平均)
-func pingTelegramDCByGolang(dc *model.TelegramDC) {
-	if model.EnableLoger {
-		defer Logger.Sync()
-	}
-
-	var totalDuration time.Duration
-	successCount := 0
-
-	// 重复测试3次
-	for attempt := 0; attempt < 3; attempt++ {
-		pinger, err := probing.NewPinger(dc.IP)
-		if err != nil {
-			logError(fmt.Sprintf("无法创建 pinger (尝试 %d/3): %v", attempt+1, err))
-			continue
-		}
-		pinger.Count = 1 // 每次只ping一次
-		pinger.Timeout = timeout
-		err = pinger.Run()
-		if err != nil {
-			logError(fmt.Sprintf("ping 失败 (尝试 %d/3): %v", attempt+1, err))
-			continue
-		}
-		stats := pinger.Statistics()
-		if stats.PacketsRecv > 0 {
-			totalDuration += stats.AvgRtt
-			successCount++
-			logError(fmt.Sprintf("Ping %s 成功 (尝试 %d/3): %dms", dc.Name, attempt+1, stats.AvgRtt.Milliseconds()))
-		}
-	}
-
-	if successCount > 0 {
-		dc.Avg = totalDuration / time.Duration(successCount)
-		dc.Tested = true
-	} else {
-		dc.Avg = 0
-		dc.Tested = false
-	}
+func (client *Client) pingTelegramDCByGolang(ctx context.Context, dc *model.TelegramDC) {
+	defer client.syncLogger()
+	dc.Avg, dc.Tested = client.measureAverage(ctx, ICMPProber{Timeout: timeout}, ProbeTarget{Name: dc.Name, Host: dc.IP}, pingCount, timeout, nil)
 }
 
 // pingTelegramDCByCMD 使用系统ping命令测试Telegram DC (重复3次取平均)
-func pingTelegramDCByCMD(dc *model.TelegramDC) {
-	if model.EnableLoger {
-		defer Logger.Sync()
-	}
-
-	var totalDuration time.Duration
-	successCount := 0
+func (client *Client) pingTelegramDCByCMD(ctx context.Context, dc *model.TelegramDC) {
+	defer client.syncLogger()
 	rootPerm := hasRootPermission()
-	logError(fmt.Sprintf("Root权限检查: %v", rootPerm))
-
-	// 重复测试3次
-	for attempt := 0; attempt < 3; attempt++ {
-		var cmd *exec.Cmd
-		if rootPerm {
-			cmd = exec.Command("sudo", "ping", "-c1", "-W3", dc.IP)
-		} else {
-			cmd = exec.Command("ping", "-c1", "-W3", dc.IP)
-		}
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			logError(fmt.Sprintf("无法ping (尝试 %d/3): %v", attempt+1, err))
-			continue
-		}
-		if model.EnableLoger {
-			logError(string(output))
-		}
-		// 解析输出结果
-		if !strings.Contains(string(output), "time=") {
-			logError(fmt.Sprintf("ping 失败，未找到 time= (尝试 %d/3)", attempt+1))
-			continue
-		}
-		var avgTime float64
-		lines := strings.Split(string(output), "\n")
-		for _, line := range lines {
-			if strings.Contains(line, "time=") {
-				matches := strings.Split(line, "time=")
-				if len(matches) >= 2 {
-					avgTime, err = strconv.ParseFloat(strings.TrimSpace(strings.ReplaceAll(matches[1], "ms", "")), 64)
-					if err != nil {
-						logError(fmt.Sprintf("无法解析平均延迟 (尝试 %d/3): %v", attempt+1, err))
-						continue
-					}
-					totalDuration += time.Duration(avgTime * float64(time.Millisecond))
-					successCount++
-					logError(fmt.Sprintf("Ping %s 成功 (尝试 %d/3): %.2fms", dc.Name, attempt+1, avgTime))
-					break
-				}
-			}
-		}
-	}
-
-	if successCount > 0 {
-		dc.Avg = totalDuration / time.Duration(successCount)
-		dc.Tested = true
-	} else {
-		dc.Avg = 0
-		dc.Tested = false
-	}
+	client.logError(fmt.Sprintf("Root权限检查: %v", rootPerm))
+	dc.Avg, dc.Tested = client.measureAverage(ctx, SystemPingProber{Sudo: rootPerm, Timeout: timeout}, ProbeTarget{Name: dc.Name, Host: dc.IP}, pingCount, timeout, nil)
 }
 
 // pingTelegramDCSimple 简化版的ping函数，用于测试单个Telegram DC
-func pingTelegramDCSimple(dc *model.TelegramDC) {
+func (client *Client) pingTelegramDCSimple(ctx context.Context, dc *model.TelegramDC) {
+	if ctx.Err() != nil {
+		return
+	}
 	var cmd *exec.Cmd
 	rootPerm := hasRootPermission()
-	logError(fmt.Sprintf("Root权限检查: %v", rootPerm))
+	client.logError(fmt.Sprintf("Root权限检查: %v", rootPerm))
 	if rootPerm {
 		cmd = exec.Command("sudo", "ping", "-h")
 	} else {
@@ -128,50 +42,101 @@ func pingTelegramDCSimple(dc *model.TelegramDC) {
 	}
 	output, err := cmd.CombinedOutput()
 	if err != nil || (!strings.Contains(string(output), "Usage") && strings.Contains(string(output), "err")) {
-		pingTelegramDCByGolang(dc)
+		client.pingTelegramDCByGolang(ctx, dc)
 	} else {
-		pingTelegramDCByCMD(dc)
+		client.pingTelegramDCByCMD(ctx, dc)
 	}
+	applyTelegramTCPFallback(ctx, dc, client.tcpFallbackConfig())
 	if dc.Tested {
-		logError(fmt.Sprintf("Ping %s (%s) 成功，延迟: %dms", dc.Name, dc.IP, dc.Avg.Milliseconds()))
+		client.logError(fmt.Sprintf("Ping %s (%s) 成功，延迟: %dms (%s)", dc.Name, dc.IP, dc.Avg.Milliseconds(), dc.Protocol))
 	} else {
-		logError(fmt.Sprintf("Ping %s (%s) 失败", dc.Name, dc.IP))
+		client.logError(fmt.Sprintf("Ping %s (%s) 失败", dc.Name, dc.IP))
+	}
+}
+
+// TelegramDCOptions selects which registry entries the Telegram tests use.
+// A nil Filter keeps the built-in production IPv4 list without loading the
+// remote registry.
+type TelegramDCOptions struct {
+	Language string
+	Filter   *model.TelegramDCFilter
+	// OnRegistry, when set, receives the registry the data centers came from.
+	OnRegistry func(RegistryInfo)
+	// OnProgress, when set, receives the progress events of
+	// TelegramDCResults and TelegramMTProtoResults. Calls are serialized.
+	OnProgress func(ProgressEvent)
+}
+
+// LoadTelegramDataCenters resolves the Telegram DC registry (remote with an
+// embedded fallback) through the client's cache and returns the entries
+// matching filter.
+func (client *Client) LoadTelegramDataCenters(ctx context.Context, filter model.TelegramDCFilter) ([]model.TelegramDC, model.TelegramDCRegistryLoadResult, error) {
+	return loadTelegramDataCenters(ctx, filter, client.Cache)
+}
+
+// LoadTelegramDataCenters runs Client.LoadTelegramDataCenters on the default client.
+func LoadTelegramDataCenters(ctx context.Context, filter model.TelegramDCFilter) ([]model.TelegramDC, model.TelegramDCRegistryLoadResult, error) {
+	return defaultClient.LoadTelegramDataCenters(ctx, filter)
+}
+
+func loadTelegramDataCenters(ctx context.Context, filter model.TelegramDCFilter, cache *DiskCache) ([]model.TelegramDC, model.TelegramDCRegistryLoadResult, error) {
+	loaded, err := model.LoadTelegramDCRegistry(ctx, registryHTTPClient(cache), model.DefaultTelegramDCRegistrySources(), 1)
+	if err != nil {
+		return nil, model.TelegramDCRegistryLoadResult{}, err
+	}
+	return model.FilterTelegramDataCenters(loaded.DataCenters, filter), loaded, nil
+}
+
+func (client *Client) telegramDataCentersForOptions(ctx context.Context, options TelegramDCOptions) []model.TelegramDC {
+	report := func(info RegistryInfo) {
+		if options.OnRegistry != nil {
+			info.Name = RegistryTelegram
+			options.OnRegistry(info)
+		}
+	}
+	if options.Filter == nil {
+		report(RegistryInfo{Source: "embedded", Metadata: model.EmbeddedTelegramDCRegistryMetadata()})
+		return model.FilterTelegramDataCenters(model.TelegramDataCenters, model.TelegramDCFilter{})
+	}
+	dcs, loaded, err := client.LoadTelegramDataCenters(ctx, *options.Filter)
+	if err != nil {
+		client.logError(fmt.Sprintf("加载 Telegram 数据中心列表失败: %v", err))
+		report(RegistryInfo{Source: "embedded", Fallback: true, Metadata: model.EmbeddedTelegramDCRegistryMetadata()})
+		return model.FilterTelegramDataCenters(model.AllTelegramDataCenters(), *options.Filter)
 	}
+	report(RegistryInfo{Source: loaded.Source, Fallback: loaded.Fallback, Metadata: loaded.Metadata})
+	return dcs
 }
 
 // TelegramDCTest 测试所有Telegram数据中心
+func (client *Client) TelegramDCTest() string {
+	return client.TelegramDCTestWithOptions(TelegramDCOptions{})
+}
+
+// TelegramDCTest runs Client.TelegramDCTest on the default client.
 func TelegramDCTest() string {
+	return defaultClient.TelegramDCTest()
+}
+
+// TelegramDCTestWithOptions 测试筛选后的 Telegram 数据中心
+func (client *Client) TelegramDCTestWithOptions(options TelegramDCOptions) string {
 	// 添加 defer recover 防止 panic
 	defer func() {
 		if r := recover(); r != nil {
-			logError(fmt.Sprintf("TelegramDCTest panic 恢复: %v", r))
+			client.logError(fmt.Sprintf("TelegramDCTest panic 恢复: %v", r))
 		}
 	}()
 
-	if model.EnableLoger {
-		InitLogger()
-	}
-
-	// 复制数据中心配置，避免修改原始数据
-	datacenters := make([]model.TelegramDC, len(model.TelegramDataCenters))
-	copy(datacenters, model.TelegramDataCenters)
+	return formatTelegramDCs(client.measureTelegramDCs(context.Background(), options, nil, nil), options.Language)
+}
 
-	// 使用并发测试所有数据中心
-	var wg sync.WaitGroup
-	for i := range datacenters {
-		wg.Add(1)
-		go func(index int) {
-			defer wg.Done()
-			defer func() {
-				if r := recover(); r != nil {
-					logError(fmt.Sprintf("pingTelegramDCSimple panic 恢复: %v", r))
-				}
-			}()
-			pingTelegramDCSimple(&datacenters[index])
-		}(i)
-	}
-	wg.Wait()
+// TelegramDCTestWithOptions runs Client.TelegramDCTestWithOptions on the default client.
+func TelegramDCTestWithOptions(options TelegramDCOptions) string {
+	return defaultClient.TelegramDCTestWithOptions(options)
+}
 
+// formatTelegramDCs 按延迟排序并格式化数据中心测试结果，失败的显示为 9999ms
+func formatTelegramDCs(datacenters []model.TelegramDC, language string) string {
 	// 按延迟从小到大排序
 	sort.Slice(datacenters, func(i, j int) bool {
 		// 未测试成功的标记为 9999ms
@@ -187,6 +152,7 @@ func TelegramDCTest() string {
 	// 格式化输出结果，参考三网延迟测试的格式
 	var result string
 	count := 0
+	fallback := false
 	for _, dc := range datacenters {
 		// 每三个数据中心换行一次
 		if count > 0 && count%3 == 0 {
@@ -194,7 +160,6 @@ func TelegramDCTest() string {
 		}
 		count++
 
-		avgStr := fmt.Sprintf("%4d", dc.Avg.Milliseconds())
 		// 使用 "DC名称-位置" 作为显示名称
 		name := fmt.Sprintf("%s %s", dc.Name, dc.Location)
 		// 计算需要的填充空格，使名称列宽度为20
@@ -202,8 +167,41 @@ func TelegramDCTest() string {
 		if padding < 0 {
 			padding = 0
 		}
-		result += fmt.Sprintf("%s%s%4s | ", name, strings.Repeat(" ", padding), avgStr)
+		result += name + strings.Repeat(" ", padding) + formatLatencyCell(dc.Avg, dc.Protocol)
+		fallback = fallback || dc.Protocol == model.ProtocolTCP
+	}
+	if fallback {
+		result += "\n" + latencyFallbackLegend(language)
 	}
 
 	return result
 }
+
+// measureTelegramDCs 并发测试筛选后的数据中心，notify 非空时在每个数据中心测试完成后立即回调，
+// reporter 非空时报告每个数据中心的开始与结束
+func (client *Client) measureTelegramDCs(ctx context.Context, options TelegramDCOptions, notify func(model.TelegramDC), reporter *progressReporter) []model.TelegramDC {
+	// 复制数据中心配置，避免修改原始数据
+	datacenters := client.telegramDataCentersForOptions(ctx, options)
+	reporter.plan(len(datacenters))
+	var wg sync.WaitGroup
+	for i := range datacenters {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					client.logError(fmt.Sprintf("pingTelegramDCSimple panic 恢复: %v", r))
+				}
+			}()
+			dc := &datacenters[index]
+			progress := reporter.start(-1, dc.Name, dc.IP)
+			client.pingTelegramDCSimple(ctx, dc)
+			if notify != nil {
+				notify(*dc)
+			}
+			reporter.finish(progress, dc.Name, dc.IP, telegramDCLatencyResult(*dc))
+		}(i)
+	}
+	wg.Wait()
+	return datacenters
+}
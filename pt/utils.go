@@ -5,147 +5,31 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/imroc/req/v3"
-	. "github.com/oneclickvirt/defaultset"
 	"github.com/oneclickvirt/pingtest/model"
 )
 
-var (
-	icmpTargetsCache       []model.IcmpTarget
-	icmpTargetsMutex       sync.Mutex
-	icmpTargetsInitialized bool
-)
-
-func logError(msg string) {
-	if model.EnableLoger && Logger != nil {
-		Logger.Info(msg)
-	}
-}
-
-// checkCDN 检查单个 CDN 是否可用，参考 shell 脚本的实现
-// checkCDN 检查单个 CDN 是否可用，参考 shell 脚本的实现
-// 通过访问测试 URL 并检查响应中是否包含 "success" 来验证
-func checkCDN(cdnURL string) bool {
-	defer func() {
-		if r := recover(); r != nil {
-			logError(fmt.Sprintf("checkCDN panic 恢复: %v", r))
-		}
-	}()
-
-	client := req.C()
-	client.SetTimeout(6 * time.Second) // 与 shell 脚本的 --max-time 6 保持一致
-
-	// 测试 URL，与 shell 脚本中的 check_cdn_file 保持一致
-	testURL := cdnURL + "https://raw.githubusercontent.com/spiritLHLS/ecs/main/back/test"
-
-	resp, err := client.R().Get(testURL)
-	if err != nil {
-		logError(fmt.Sprintf("CDN 测试失败 %s: %v", cdnURL, err))
-		return false
-	}
-	if resp == nil || resp.Body == nil {
-		logError(fmt.Sprintf("CDN 测试返回空响应: %s", cdnURL))
-		return false
-	}
-	defer resp.Body.Close()
-
-	b, readErr := io.ReadAll(resp.Body)
-	if readErr != nil {
-		logError(fmt.Sprintf("读取 CDN 测试响应失败 %s: %v", cdnURL, readErr))
-		return false
-	}
-
-	bodyStr := string(b)
-	// 与 shell 脚本一致，检查响应中是否包含 "success"
-	if strings.Contains(bodyStr, "success") {
-		logError(fmt.Sprintf("CDN 可用: %s", cdnURL))
-		return true
-	}
-	logError(fmt.Sprintf("CDN 测试响应不包含 'success': %s", cdnURL))
-
-	return false
-}
-
-// getData 获取目标地址的文本内容
-func getData(endpoint string) string {
-	// 添加 defer recover 防止 panic
-	defer func() {
-		if r := recover(); r != nil {
-			logError(fmt.Sprintf("getData panic 恢复: %v", r))
-		}
-	}()
-
-	client := req.C()
-	client.SetTimeout(10 * time.Second) // 增加超时时间到10秒
-	client.R().
-		SetRetryCount(2).
-		SetRetryBackoffInterval(1*time.Second, 3*time.Second).
-		SetRetryFixedInterval(2 * time.Second)
-	if model.EnableLoger {
-		InitLogger()
-		defer Logger.Sync()
-	}
-
-	// 创建一个带超时的 context
+// getData 获取目标地址的文本内容，失败时返回空字符串
+func (client *Client) getData(endpoint string) string {
+	defer client.syncLogger()
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-
-	for _, baseUrl := range model.CdnList {
-		// 检查 context 是否已超时
-		select {
-		case <-ctx.Done():
-			logError("getData 总体超时")
-			return ""
-		default:
-		}
-
-		// 先测试 CDN 是否可用（参考 shell 脚本实现）
-		if !checkCDN(baseUrl) {
-			logError(fmt.Sprintf("CDN 不可用，跳过: %s", baseUrl))
-			time.Sleep(500 * time.Millisecond) // 与 shell 脚本的 sleep 0.5 保持一致
-			continue
-		}
-
-		url := baseUrl + endpoint
-
-		for attempt := 0; attempt < 3; attempt++ {
-			resp, err := client.R().SetContext(ctx).Get(url)
-			if err != nil {
-				logError(fmt.Sprintf("获取 %s 失败(尝试 %d/3): %v", url, attempt+1, err))
-				time.Sleep(500 * time.Millisecond)
-				continue
-			}
-			if resp == nil || resp.Body == nil {
-				logError(fmt.Sprintf("获取 %s 返回空响应(尝试 %d/3)", url, attempt+1))
-				time.Sleep(500 * time.Millisecond)
-				continue
-			}
-
-			b, readErr := io.ReadAll(resp.Body)
-			resp.Body.Close()
-			if readErr != nil {
-				logError(fmt.Sprintf("读取响应体失败 %s: %v", url, readErr))
-				time.Sleep(500 * time.Millisecond)
-				continue
-			}
-
-			bodyStr := string(b)
-			logError(fmt.Sprintf("成功从 %s 获取数据", url))
-			return bodyStr
-		}
+	body, err := client.fetcher().Fetch(ctx, endpoint)
+	if err != nil {
+		client.logError(fmt.Sprintf("获取 %s 失败: %v", endpoint, err))
+		return ""
 	}
-	logError(fmt.Sprintf("所有 CDN 尝试失败,endpoint: %s", endpoint))
-	return ""
+	client.logError(fmt.Sprintf("成功获取 %s", endpoint))
+	return string(body)
 }
 
-func resolveIP(name string) string {
+func (client *Client) resolveIP(name string) string {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	r := net.Resolver{}
@@ -156,18 +40,16 @@ func resolveIP(name string) string {
 	if len(ips) > 0 {
 		return ips[0].String()
 	}
-	logError(fmt.Sprintf("域名 %s 无法解析到IP地址", name))
+	client.logError(fmt.Sprintf("域名 %s 无法解析到IP地址", name))
 	return ""
 }
 
-func parseCSVData(data, platform, operator string) []*model.Server {
+func (client *Client) parseCSVData(data, platform, operator string) []*model.Server {
 	var servers []*model.Server
 	r := csv.NewReader(strings.NewReader(data))
 	records, err := r.ReadAll()
 	if err != nil {
-		if model.EnableLoger {
-			Logger.Info(err.Error())
-		}
+		client.logError(err.Error())
 		return servers
 	}
 	// 检查并去除 CSV 头部（header）
@@ -194,13 +76,12 @@ func parseCSVData(data, platform, operator string) []*model.Server {
 				servers = append(servers, &model.Server{
 					Name:       head + record[3],
 					IP:         record[4],
+					Port:       speedtestPort(record[5], speedtestNetDefaultPort),
 					Tested:     false,
 					SourceType: "net",
 				})
 			} else {
-				if model.EnableLoger {
-					Logger.Info(fmt.Sprintf("CSV 数据字段不足 (net): %d", len(record)))
-				}
+				client.logError(fmt.Sprintf("CSV 数据字段不足 (net): %d", len(record)))
 			}
 		}
 	} else if platform == "cn" {
@@ -210,14 +91,16 @@ func parseCSVData(data, platform, operator string) []*model.Server {
 			if len(record) >= 11 {
 				parts := strings.Split(record[5], ":")
 				if len(parts) < 2 {
-					if model.EnableLoger {
-						Logger.Info(fmt.Sprintf("record[5] 格式错误，缺少端口信息: %s", record[5]))
-					}
+					client.logError(fmt.Sprintf("record[5] 格式错误，缺少端口信息: %s", record[5]))
 					continue
 				}
 				ip = parts[0]
+				port, err := strconv.Atoi(strings.TrimSpace(parts[len(parts)-1]))
+				if err != nil || port < 1 || port > 65535 {
+					port = 0
+				}
 				if net.ParseIP(ip) == nil {
-					ip = resolveIP(ip)
+					ip = client.resolveIP(ip)
 					if ip == "" {
 						continue
 					}
@@ -229,15 +112,16 @@ func parseCSVData(data, platform, operator string) []*model.Server {
 				servers = append(servers, &model.Server{
 					Name:       name,
 					IP:         ip,
+					Port:       port,
 					Tested:     false,
 					SourceType: "cn",
 				})
 			} else {
-				logError(fmt.Sprintf("CSV 数据字段不足 (cn): %d", len(record)))
+				client.logError(fmt.Sprintf("CSV 数据字段不足 (cn): %d", len(record)))
 			}
 		}
 	}
-	logError(fmt.Sprintf("平台: %s, 运营商: %s 解析完成，获取服务器数量: %d", platform, operator, len(servers)))
+	client.logError(fmt.Sprintf("平台: %s, 运营商: %s 解析完成，获取服务器数量: %d", platform, operator, len(servers)))
 	// 对同一运营商+省份的服务器进行去重
 	return deduplicateServers(servers)
 }
@@ -276,30 +160,38 @@ func deduplicateServers(servers []*model.Server) []*model.Server {
 }
 
 // parseIcmpTargets 解析ICMP目标数据
-func parseIcmpTargets(jsonData string) []model.IcmpTarget {
+func (client *Client) parseIcmpTargets(jsonData string) []model.IcmpTarget {
 	var targets []model.IcmpTarget
 	err := json.Unmarshal([]byte(jsonData), &targets)
 	if err != nil {
-		logError(fmt.Sprintf("解析ICMP目标失败: %s", err.Error()))
+		client.logError(fmt.Sprintf("解析ICMP目标失败: %s", err.Error()))
 		return nil
 	}
 	return targets
 }
 
 // 加载ICMP目标数据，只在第一次调用时获取数据
-func loadIcmpTargets() {
-	icmpTargetsMutex.Lock()
-	defer icmpTargetsMutex.Unlock()
-	if !icmpTargetsInitialized {
-		icmpData := getData(model.IcmpTargets)
+func (client *Client) loadIcmpTargets() {
+	client.icmpTargetsMutex.Lock()
+	defer client.icmpTargetsMutex.Unlock()
+	if !client.icmpTargetsReady {
+		icmpData := client.getData(client.sources().IcmpTargets)
 		if icmpData != "" {
-			icmpTargetsCache = parseIcmpTargets(icmpData)
-			icmpTargetsInitialized = true
-			logError(fmt.Sprintf("ICMP 目标数据初始化完成，共 %d 个目标", len(icmpTargetsCache)))
+			client.icmpTargets = client.parseIcmpTargets(icmpData)
+			client.icmpTargetsReady = true
+			client.logError(fmt.Sprintf("ICMP 目标数据初始化完成，共 %d 个目标", len(client.icmpTargets)))
 		}
 	}
 }
 
+// cachedIcmpTargets 返回已加载的ICMP目标数据，必要时先加载
+func (client *Client) cachedIcmpTargets() []model.IcmpTarget {
+	client.loadIcmpTargets()
+	client.icmpTargetsMutex.Lock()
+	defer client.icmpTargetsMutex.Unlock()
+	return client.icmpTargets
+}
+
 func cleanProvince(input string) string {
 	suffixes := []string{"维吾尔自治区", "回族自治区", "自治区", "省", "市"}
 	for _, suffix := range suffixes {
@@ -312,11 +204,9 @@ func cleanProvince(input string) string {
 }
 
 // 获取ICMP目标服务器
-func getIcmpServers(operator string) []*model.Server {
+func (client *Client) getIcmpServers(operator string) []*model.Server {
 	// 确保ICMP目标数据已加载
-	if !icmpTargetsInitialized {
-		loadIcmpTargets()
-	}
+	icmpTargets := client.cachedIcmpTargets()
 	var icmpServers []*model.Server
 	// 运营商名称映射
 	ispNameMap := map[string]string{
@@ -324,10 +214,10 @@ func getIcmpServers(operator string) []*model.Server {
 		"cu": "联通",
 		"ct": "电信",
 	}
-	if len(icmpTargetsCache) > 0 {
+	if len(icmpTargets) > 0 {
 		// 使用映射确保每个省份只添加一个IP
 		provinceMap := make(map[string]bool)
-		for _, target := range icmpTargetsCache {
+		for _, target := range icmpTargets {
 			if target.IPVersion == "v4" && target.IspCode == operator {
 				// 清理省份名称
 				provinceName := cleanProvince(target.Province)
@@ -354,7 +244,7 @@ func getIcmpServers(operator string) []*model.Server {
 			}
 		}
 	}
-	logError(fmt.Sprintf("获取 ICMP 服务器完成，共 %d 个服务器", len(icmpServers)))
+	client.logError(fmt.Sprintf("获取 ICMP 服务器完成，共 %d 个服务器", len(icmpServers)))
 	return icmpServers
 }
 
@@ -375,16 +265,17 @@ func getIcmpServers(operator string) []*model.Server {
 // 	return provinceMap
 // }
 
-func getServers(operator string) []*model.Server {
+func (client *Client) getServers(operator string) []*model.Server {
 	// 添加 defer recover 防止 panic
 	defer func() {
 		if r := recover(); r != nil {
-			logError(fmt.Sprintf("getServers panic 恢复: %v, operator: %s", r, operator))
+			client.logError(fmt.Sprintf("getServers panic 恢复: %v, operator: %s", r, operator))
 		}
 	}()
 
-	netList := []string{model.NetCMCC, model.NetCT, model.NetCU}
-	cnList := []string{model.CnCMCC, model.CnCT, model.CnCU}
+	sources := client.sources()
+	netList := []string{sources.NetCMCC, sources.NetCT, sources.NetCU}
+	cnList := []string{sources.CnCMCC, sources.CnCT, sources.CnCU}
 	var servers []*model.Server
 	var wg sync.WaitGroup
 	dataCh := make(chan []*model.Server, 3)
@@ -393,14 +284,14 @@ func getServers(operator string) []*model.Server {
 		defer wg.Done()
 		defer func() {
 			if r := recover(); r != nil {
-				logError(fmt.Sprintf("fetchData panic 恢复: %v", r))
+				client.logError(fmt.Sprintf("fetchData panic 恢复: %v", r))
 				dataCh <- []*model.Server{}
 			}
 		}()
 
-		data := getData(endpoint)
+		data := client.getData(endpoint)
 		if data != "" {
-			parsedData := parseCSVData(data, dataType, operator)
+			parsedData := client.parseCSVData(data, dataType, operator)
 			dataCh <- parsedData
 		} else {
 			dataCh <- []*model.Server{}
@@ -420,14 +311,12 @@ func getServers(operator string) []*model.Server {
 		ispCode = "cu"
 		netIndex, cnIndex = 2, 2
 	default:
-		logError(fmt.Sprintf("未知的运营商: %s", operator))
+		client.logError(fmt.Sprintf("未知的运营商: %s", operator))
 		return []*model.Server{}
 	}
 
 	// 确保ICMP目标数据已加载
-	if !icmpTargetsInitialized {
-		loadIcmpTargets()
-	}
+	client.loadIcmpTargets()
 
 	// 获取ICMP服务器并放入通道
 	wg.Add(1)
@@ -435,11 +324,11 @@ func getServers(operator string) []*model.Server {
 		defer wg.Done()
 		defer func() {
 			if r := recover(); r != nil {
-				logError(fmt.Sprintf("getIcmpServers panic 恢复: %v", r))
+				client.logError(fmt.Sprintf("getIcmpServers panic 恢复: %v", r))
 				dataCh <- []*model.Server{}
 			}
 		}()
-		icmpServers := getIcmpServers(ispCode)
+		icmpServers := client.getIcmpServers(ispCode)
 		dataCh <- icmpServers
 	}()
 
@@ -469,7 +358,7 @@ func getServers(operator string) []*model.Server {
 				servers = append(servers, data...)
 			}
 		case <-timeout:
-			logError(fmt.Sprintf("getServers 超时,operator: %s", operator))
+			client.logError(fmt.Sprintf("getServers 超时,operator: %s", operator))
 			collecting = false
 		}
 	}
@@ -478,7 +367,7 @@ func getServers(operator string) []*model.Server {
 	select {
 	case <-done:
 	case <-time.After(5 * time.Second):
-		logError("等待 goroutine 清理超时")
+		client.logError("等待 goroutine 清理超时")
 	}
 
 	// 最终去重，确保每个运营商+省份组合只有一个服务器
@@ -511,6 +400,9 @@ func getServers(operator string) []*model.Server {
 				(server.SourceType == "net" && existing.SourceType == "cn") {
 				uniqueMap[key] = server
 			}
+			// 被替换的测速节点为保留的节点提供 TCP 回退端点
+			inheritServerFallback(uniqueMap[key], existing)
+			inheritServerFallback(uniqueMap[key], server)
 		}
 	}
 	// 转换回切片
@@ -529,6 +421,6 @@ func getServers(operator string) []*model.Server {
 		}
 		return province1 < province2
 	})
-	logError(fmt.Sprintf("%s 运营商获取服务器完成，共整理 %d 个服务器", operator, len(result)))
+	client.logError(fmt.Sprintf("%s 运营商获取服务器完成，共整理 %d 个服务器", operator, len(result)))
 	return result
 }
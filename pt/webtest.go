@@ -1,110 +1,56 @@
 package pt
 
 import (
+	"context"
 	"fmt"
-	"net/http"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/mattn/go-runewidth"
-	. "github.com/oneclickvirt/defaultset"
 	"github.com/oneclickvirt/pingtest/model"
 )
 
-// testWebsite 测试单个网站的连通性和响应时间
-func testWebsite(website *model.Website, attempts int) {
-	if model.EnableLoger {
-		defer Logger.Sync()
-	}
-
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			// 允许重定向，但不超过10次
-			if len(via) >= 10 {
-				return fmt.Errorf("stopped after 10 redirects")
-			}
-			return nil
-		},
-	}
-
-	var totalDuration time.Duration
-	successCount := 0
+// websiteTimeout bounds one request of the website tests, like the default
+// HTTPProber client.
+const websiteTimeout = 10 * time.Second
 
-	for i := 0; i < attempts; i++ {
-		start := time.Now()
-		resp, err := client.Get(website.URL)
-		duration := time.Since(start)
-
-		if err != nil {
-			logError(fmt.Sprintf("测试 %s 失败 (尝试 %d/%d): %v", website.Name, i+1, attempts, err))
-			continue
-		}
-
-		if resp != nil {
-			resp.Body.Close()
-			if resp.StatusCode < 500 {
-				// 只要不是5xx错误，都算成功
-				totalDuration += duration
-				successCount++
-				logError(fmt.Sprintf("测试 %s 成功 (尝试 %d/%d): %d ms, 状态码: %d",
-					website.Name, i+1, attempts, duration.Milliseconds(), resp.StatusCode))
-			} else {
-				logError(fmt.Sprintf("测试 %s 失败 (尝试 %d/%d): 服务器错误 %d",
-					website.Name, i+1, attempts, resp.StatusCode))
-			}
-		}
-	}
-
-	if successCount > 0 {
-		website.Avg = totalDuration / time.Duration(successCount)
-		website.Tested = true
-	} else {
-		website.Avg = 0
-		website.Tested = false
-	}
+// testWebsite 测试单个网站的连通性和响应时间，onSample 非空时在每次请求后回调
+func (client *Client) testWebsite(ctx context.Context, website *model.Website, attempts int, onSample func(ProgressSample)) {
+	defer client.syncLogger()
+	website.Avg, website.Tested = client.measureAverage(ctx, HTTPProber{Client: client.HTTPClient}, ProbeTarget{Name: website.Name, URL: website.URL}, attempts, websiteTimeout, onSample)
 }
 
 // WebsiteTest 测试所有网站的连通性
+func (client *Client) WebsiteTest() string {
+	return client.WebsiteTestWithTargets(model.PopularWebsites)
+}
+
+// WebsiteTest runs Client.WebsiteTest on the default client.
 func WebsiteTest() string {
+	return defaultClient.WebsiteTest()
+}
+
+// WebsiteTestWithTargets 测试指定网站列表的连通性，输出格式与 WebsiteTest 相同
+func (client *Client) WebsiteTestWithTargets(targets []model.Website) string {
 	// 添加 defer recover 防止 panic
 	defer func() {
 		if r := recover(); r != nil {
-			logError(fmt.Sprintf("WebsiteTest panic 恢复: %v", r))
+			client.logError(fmt.Sprintf("WebsiteTest panic 恢复: %v", r))
 		}
 	}()
 
-	if model.EnableLoger {
-		InitLogger()
-	}
-
-	// 复制网站配置
-	websites := make([]model.Website, len(model.PopularWebsites))
-	copy(websites, model.PopularWebsites)
-
-	// 并发测试所有网站
-	var wg sync.WaitGroup
-	// 使用信号量限制并发数
-	sem := make(chan struct{}, 10) // 最多10个并发请求
+	return formatWebsites(client.measureWebsites(context.Background(), targets, nil, nil))
+}
 
-	for i := range websites {
-		wg.Add(1)
-		sem <- struct{}{}
-		go func(index int) {
-			defer func() {
-				<-sem
-				wg.Done()
-				if r := recover(); r != nil {
-					logError(fmt.Sprintf("testWebsite panic 恢复: %v", r))
-				}
-			}()
-			testWebsite(&websites[index], 3) // 每个网站测试3次
-		}(i)
-	}
-	wg.Wait()
+// WebsiteTestWithTargets runs Client.WebsiteTestWithTargets on the default client.
+func WebsiteTestWithTargets(targets []model.Website) string {
+	return defaultClient.WebsiteTestWithTargets(targets)
+}
 
+// formatWebsites 按延迟排序并格式化网站测试结果，失败的网站显示为 9999ms
+func formatWebsites(websites []model.Website) string {
 	// 收集所有测试的网站（包括失败的，标记为 9999ms）
 	var allSites []model.Website
 	for _, site := range websites {
@@ -141,3 +87,45 @@ func WebsiteTest() string {
 
 	return result
 }
+
+// measureWebsites 并发测试网站列表，notify 非空时在每个网站测试完成后立即回调，
+// reporter 非空时报告每个网站的开始、每次请求与结束，ctx 结束后不再发出新的请求
+func (client *Client) measureWebsites(ctx context.Context, targets []model.Website, notify func(model.Website), reporter *progressReporter) []model.Website {
+	// 复制网站配置
+	websites := make([]model.Website, len(targets))
+	copy(websites, targets)
+	reporter.plan(len(websites))
+
+	// 并发测试所有网站
+	var wg sync.WaitGroup
+	// 使用信号量限制并发数
+	sem := make(chan struct{}, 10) // 最多10个并发请求
+
+	for i := range websites {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(index int) {
+			defer func() {
+				<-sem
+				wg.Done()
+				if r := recover(); r != nil {
+					client.logError(fmt.Sprintf("testWebsite panic 恢复: %v", r))
+				}
+			}()
+			website := &websites[index]
+			progress := reporter.start(-1, website.Name, website.URL)
+			var onSample func(ProgressSample)
+			if reporter != nil {
+				onSample = func(sample ProgressSample) { reporter.sample(progress, website.Name, website.URL, sample) }
+			}
+			client.testWebsite(ctx, website, 3, onSample) // 每个网站测试3次
+			if notify != nil {
+				notify(*website)
+			}
+			reporter.finish(progress, website.Name, website.URL, websiteLatencyResult(*website))
+		}(i)
+	}
+	wg.Wait()
+
+	return websites
+}